@@ -0,0 +1,202 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package alarm applies warning and critical thresholds to the sensor
+// values that the daemons publish.
+//
+// A daemon feeds each value it publishes to its Engine, which publishes
+// "<sensor>.alarm: ok|warning|critical" for sensors with a Threshold, e.g.
+// vmon.5v.sb.alarm for vmon.5v.sb.units.V, and "alarm.<daemon>" with the
+// most severe level of all of them. ledgpiod shows the most severe of the
+// alarm.<daemon> keys of its AlarmKeys on the front panel SYS LED.
+package alarm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/platinasystems/log"
+)
+
+type Level int
+
+const (
+	Ok Level = iota
+	Warning
+	Critical
+)
+
+func (l Level) String() string {
+	switch l {
+	case Ok:
+		return "ok"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return fmt.Sprint("level(", int(l), ")")
+}
+
+// ParseLevel returns the Level named s.
+func ParseLevel(s string) (Level, error) {
+	for l := Ok; l <= Critical; l++ {
+		if s == l.String() {
+			return l, nil
+		}
+	}
+	return Ok, fmt.Errorf("%q: invalid alarm level", s)
+}
+
+// Threshold is the range of a sensor. Limits that are nil aren't checked.
+// A value outside of a limit raises the alarm at once; it clears once the
+// value is back inside the limit by Hysteresis.
+type Threshold struct {
+	LowCritical  *float64 `json:"low_critical,omitempty"`
+	LowWarning   *float64 `json:"low_warning,omitempty"`
+	HighWarning  *float64 `json:"high_warning,omitempty"`
+	HighCritical *float64 `json:"high_critical,omitempty"`
+	Hysteresis   float64  `json:"hysteresis,omitempty"`
+}
+
+func (t *Threshold) level(v, h float64) Level {
+	below := func(limit *float64) bool {
+		return limit != nil && v < *limit+h
+	}
+	above := func(limit *float64) bool {
+		return limit != nil && v > *limit-h
+	}
+	switch {
+	case below(t.LowCritical), above(t.HighCritical):
+		return Critical
+	case below(t.LowWarning), above(t.HighWarning):
+		return Warning
+	}
+	return Ok
+}
+
+// Level returns the level of v for a sensor that was at prev.
+func (t *Threshold) Level(v float64, prev Level) Level {
+	l := t.level(v, 0)
+	if l >= prev {
+		return l
+	}
+	if l = t.level(v, t.Hysteresis); l > prev {
+		l = prev
+	}
+	return l
+}
+
+// Printer is the part of a redis publisher that an Engine uses.
+type Printer interface {
+	Print(a ...interface{}) (int, error)
+}
+
+// Engine tracks the alarms of one daemon.
+type Engine struct {
+	Name       string
+	Thresholds map[string]Threshold
+
+	mutex  sync.Mutex
+	pub    Printer
+	levels map[string]Level
+	worst  Level
+	first  bool
+}
+
+// New returns an Engine publishing with pub. Thresholds are keyed by the
+// published key, e.g. "vmon.5v.sb.units.V".
+func New(name string, pub Printer, thresholds map[string]Threshold) *Engine {
+	return &Engine{
+		Name:       name,
+		Thresholds: thresholds,
+		pub:        pub,
+		levels:     make(map[string]Level),
+		first:      true,
+	}
+}
+
+// Key returns the alarm key of a published sensor key.
+func Key(k string) string {
+	if i := strings.Index(k, ".units."); i > 0 {
+		k = k[:i]
+	}
+	return k + ".alarm"
+}
+
+// Update checks the value v published as k. It returns the sensor's level,
+// which is Ok for keys without a Threshold.
+func (e *Engine) Update(k string, v float64) Level {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	t, found := e.Thresholds[k]
+	if !found {
+		return Ok
+	}
+	prev, seen := e.levels[k]
+	l := t.Level(v, prev)
+	if !seen || l != prev {
+		ak := Key(k)
+		e.pub.Print(ak, ": ", l)
+		switch {
+		case l > prev:
+			log.Print(l, ": ", ak, ": ", k, " ", v)
+		case l < prev:
+			log.Print("notice: ", ak, ": ", l, ", ", k, " ", v)
+		}
+		e.levels[k] = l
+		e.publishWorst()
+	}
+	return l
+}
+
+// UpdateString is Update for values published as strings. Values that
+// aren't numbers are ignored.
+func (e *Engine) UpdateString(k, s string) Level {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return Ok
+	}
+	return e.Update(k, v)
+}
+
+// Clear forgets the sensors whose keys start with prefix, e.g. "psu1."
+// when the PSU is removed, and publishes their alarms as ok.
+func (e *Engine) Clear(prefix string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	n := len(e.levels)
+	for k := range e.levels {
+		if strings.HasPrefix(k, prefix) {
+			delete(e.levels, k)
+			e.pub.Print(Key(k), ": ", Ok)
+		}
+	}
+	if len(e.levels) != n {
+		e.publishWorst()
+	}
+}
+
+// Worst returns the most severe level of the engine's sensors.
+func (e *Engine) Worst() Level {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.worst
+}
+
+func (e *Engine) publishWorst() {
+	worst := Ok
+	for _, l := range e.levels {
+		if l > worst {
+			worst = l
+		}
+	}
+	if worst != e.worst || e.first {
+		e.pub.Print("alarm.", e.Name, ": ", worst)
+		e.worst = worst
+		e.first = false
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package alarm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type printer []string
+
+func (p *printer) Print(a ...interface{}) (int, error) {
+	s := fmt.Sprint(a...)
+	*p = append(*p, s)
+	return len(s), nil
+}
+
+func limit(v float64) *float64 { return &v }
+
+var vmon5v = Threshold{
+	LowCritical:  limit(4.5),
+	LowWarning:   limit(4.75),
+	HighWarning:  limit(5.25),
+	HighCritical: limit(5.5),
+	Hysteresis:   0.05,
+}
+
+func TestLevel(t *testing.T) {
+	l := Ok
+	for _, x := range []struct {
+		v    float64
+		want Level
+	}{
+		{5.0, Ok},
+		{5.26, Warning},
+		{5.22, Warning}, // inside by less than the hysteresis
+		{5.19, Ok},
+		{5.6, Critical},
+		{5.47, Critical},
+		{5.3, Warning},
+		{4.4, Critical},
+		{4.53, Critical},
+		{4.6, Warning},
+		{4.79, Warning},
+		{4.81, Ok},
+	} {
+		if l = vmon5v.Level(x.v, l); l != x.want {
+			t.Errorf("%v: got %v, want %v", x.v, l, x.want)
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	var p printer
+	e := New("ucd9090d", &p, map[string]Threshold{
+		"vmon.5v.sb.units.V": vmon5v,
+		"psu1.temp1.units.C": {HighWarning: limit(70)},
+	})
+	e.Update("vmon.5v.sb.units.V", 5)
+	e.Update("vmon.5v.sb.units.V", 5.01)
+	e.Update("vmon.1v0.tha.units.V", 0)
+	e.UpdateString("psu1.temp1.units.C", "71.5")
+	e.UpdateString("psu1.temp1.units.C", "n/a")
+	e.Update("vmon.5v.sb.units.V", 5.6)
+	if l := e.Worst(); l != Critical {
+		t.Errorf("worst %v", l)
+	}
+	e.Clear("psu1.")
+	e.Update("vmon.5v.sb.units.V", 5)
+	want := printer{
+		"vmon.5v.sb.alarm: ok",
+		"alarm.ucd9090d: ok",
+		"psu1.temp1.alarm: warning",
+		"alarm.ucd9090d: warning",
+		"vmon.5v.sb.alarm: critical",
+		"alarm.ucd9090d: critical",
+		"psu1.temp1.alarm: ok",
+		"vmon.5v.sb.alarm: ok",
+		"alarm.ucd9090d: ok",
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("published\n%q\nwant\n%q", p, want)
+	}
+}
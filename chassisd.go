@@ -0,0 +1,18 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package main
+
+import (
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/platform"
+)
+
+func chassisdInit() {
+	p := platform.Current()
+	for _, psu := range p.Fspd.PSU {
+		chassisd.PwronL = append(chassisd.PwronL, psu.GpioPwronL)
+		chassisd.Pwrok = append(chassisd.Pwrok, psu.GpioPwrok)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package chassis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+)
+
+const (
+	stateKey = "chassis.power.state"
+	eventKey = "chassis.last_power_event"
+	// timeout is how long to wait for an action to complete.
+	timeout = 30 * time.Second
+)
+
+type Command struct{}
+
+func (Command) String() string { return "chassis" }
+
+func (Command) Usage() string {
+	return "chassis power on|off|cycle|reset|soft|status|policy [POLICY]"
+}
+
+func (Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "control the chassis power",
+	}
+}
+
+func (Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The chassis power command requests an action of the chassisd state
+	machine and waits for it to complete, or prints the power state.
+
+	on	enable the PSUs and, if the host stays off, press its power
+		button
+	off	disable the PSUs
+	cycle	disable the PSUs for a second
+	reset	hard reset the host
+	soft	press the host's power button for an orderly shutdown
+	status	print the S0 or S5 state, the last power event and the
+		restore policy
+	policy [POLICY]
+		print or set the chassis.power.restore_policy applied
+		after an AC loss: always-on, always-off or
+		restore-previous`,
+	}
+}
+
+func (Command) Main(args ...string) error {
+	if len(args) < 2 || args[0] != "power" ||
+		(len(args) > 2 && (len(args) > 3 || args[1] != "policy")) {
+		return fmt.Errorf("usage: %s", Command{}.Usage())
+	}
+	if err := redis.IsReady(); err != nil {
+		return err
+	}
+	if len(args) == 3 {
+		_, err := redis.Hset(redis.DefaultHash, chassisd.PolicyField,
+			args[2])
+		return err
+	}
+	switch args[1] {
+	case "status":
+		return status()
+	case "policy":
+		s, err := redis.Hget(redis.DefaultHash, chassisd.PolicyField)
+		if err != nil {
+			return err
+		}
+		fmt.Println(s)
+		return nil
+	}
+	action, _, err := chassisd.ParseRequest(args[1])
+	if err != nil {
+		return err
+	}
+	last, _ := redis.Hget(redis.DefaultHash, eventKey)
+	_, err = redis.Hset(redis.DefaultHash, chassisd.Field,
+		action+" chassis")
+	if err != nil {
+		return err
+	}
+	for end := time.Now().Add(timeout); time.Now().Before(end); {
+		time.Sleep(100 * time.Millisecond)
+		s, err := redis.Hget(redis.DefaultHash, eventKey)
+		if err == nil && s != last {
+			fmt.Println(s)
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: timeout", action)
+}
+
+func status() error {
+	state, err := redis.Hget(redis.DefaultHash, stateKey)
+	if err != nil {
+		return err
+	}
+	event, _ := redis.Hget(redis.DefaultHash, eventKey)
+	policy, _ := redis.Hget(redis.DefaultHash, chassisd.PolicyField)
+	fmt.Println("state:", state)
+	fmt.Println("last power event:", event)
+	fmt.Println("restore policy:", policy)
+	return nil
+}
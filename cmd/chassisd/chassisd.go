@@ -0,0 +1,248 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package chassisd provides the chassis power state machine that every
+// power on, off, cycle and host reset goes through.
+package chassisd
+
+import (
+	"fmt"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/atsock"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/external/redis/publisher"
+	"github.com/platinasystems/goes/external/redis/rpc/args"
+	"github.com/platinasystems/goes/external/redis/rpc/reply"
+	"github.com/platinasystems/goes/lang"
+)
+
+// Field is that of the requests, "ACTION[ SOURCE]", e.g. "cycle ipmid".
+const Field = "chassis.power"
+
+var (
+	// PwronL and Pwrok are the GPIO pins of the PSUs.
+	PwronL, Pwrok []string
+)
+
+type Command struct {
+	Info
+	Init func()
+	init sync.Once
+}
+
+type Info struct {
+	rpc      *atsock.RpcServer
+	pub      *publisher.Publisher
+	machine  *Machine
+	requests chan request
+	// previous is the state recorded before this boot.
+	previous string
+	restored sync.Once
+}
+
+type request struct {
+	action, source string
+}
+
+func (*Command) String() string { return "chassisd" }
+
+func (*Command) Usage() string { return "chassisd" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "chassis power state machine",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The chassisd daemon performs the chassis power actions set to the
+	chassis.power field, one at a time in the order requested:
+
+	on	enable the PSUs and, if the host stays off, press its power
+		button
+	off	disable the PSUs
+	cycle	disable the PSUs for a second with the i2c polling stopped
+	reset	hard reset the host through BMC_TO_HOST_RST_L
+	soft	press the host's power button for an orderly shutdown
+	hold	leave the power pins to the requester, e.g. diag, for up to
+		a minute, failing other actions and not taking power
+		changes as power events
+	release	end a hold
+
+	It publishes chassis.power.state, S0 with ALL_PWR_GOOD and a PSU
+	PWROK, S5 otherwise, chassis.last_power_event, the time, action
+	and requester of the last action or unexpected power change, and
+	chassis.power.hold, the requester of a hold.
+
+	When the BMC boots after an AC loss, told apart from a reboot of the
+	BMC alone by new power off events of the ucd9090d fault log, the
+	start hook has the daemon apply chassis.power.restore_policy:
+
+	always-on		power on the host
+	always-off		power off the host
+	restore-previous	return to chassis.power.previous_state, the
+				state recorded before the loss
+
+	The policy, restore-previous by default, and the state, once it
+	has held for 5 seconds, are saved in /perm/var/chassisd.
+
+	The "chassis power" command, the psu.powercycle and host.reset
+	fields, the host watchdog, IPMI Chassis Control and the Redfish
+	ComputerSystem.Reset all request their actions through chassisd.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	err := redis.IsReady()
+	if err != nil {
+		return err
+	}
+	if c.pub, err = publisher.New(); err != nil {
+		return err
+	}
+	// the state recorded before this boot, read before Poll records
+	// that of now
+	c.previous = loadState()
+	if len(c.previous) > 0 {
+		c.pub.Print(PreviousStateKey, ": ", c.previous)
+	}
+	c.machine = &Machine{
+		Pub:    c.pub,
+		PwronL: PwronL,
+		Pwrok:  Pwrok,
+		Record: true,
+	}
+	c.machine.Poll()
+	c.requests = make(chan request, 8)
+	go func() {
+		for r := range c.requests {
+			c.machine.Do(r.action, r.source)
+		}
+	}()
+
+	if c.rpc, err = atsock.NewRpcServer("chassisd"); err != nil {
+		return err
+	}
+	rpc.Register(&c.Info)
+	err = redis.Assign(redis.DefaultHash+":chassis.", "chassisd", "Info")
+	if err != nil {
+		return err
+	}
+	// Restore waits for the policy to tell that chassisd has its fields
+	c.pub.Print(PolicyField, ": ", LoadPolicy())
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-goes.Stop:
+			return nil
+		case <-t.C:
+			c.machine.Poll()
+		}
+	}
+}
+
+// ParseRequest returns the action and source of a chassis.power value;
+// the source defaults to "hset".
+func ParseRequest(v string) (string, string, error) {
+	f := strings.Fields(v)
+	if len(f) < 1 || len(f) > 2 {
+		return "", "", fmt.Errorf("%q: not ACTION[ SOURCE]", v)
+	}
+	for _, action := range Actions {
+		if f[0] == action {
+			if len(f) == 1 {
+				return action, "hset", nil
+			}
+			return action, f[1], nil
+		}
+	}
+	return "", "", fmt.Errorf("%q: invalid action, not %s", f[0],
+		strings.Join(Actions, ", "))
+}
+
+// restore applies the restore policy, only once since the state recorded
+// before this boot is soon that of now.
+func (i *Info) restore(acLoss bool, reply *reply.Hset) error {
+	err := fmt.Errorf("power restore policy already applied")
+	i.restored.Do(func() {
+		_, err = i.machine.Restore(LoadPolicy(), i.previous, acLoss)
+		if err == nil {
+			*reply = 1
+		}
+	})
+	return err
+}
+
+// HoldPins requests a hold for source and waits until chassisd has it,
+// after the actions requested before.
+func HoldPins(source string) error {
+	_, err := redis.Hset(redis.DefaultHash, Field, Hold+" "+source)
+	if err != nil {
+		return err
+	}
+	for end := time.Now().Add(30 * time.Second); time.Now().Before(end); {
+		time.Sleep(100 * time.Millisecond)
+		s, err := redis.Hget(redis.DefaultHash, HoldKey)
+		if err == nil && s == source {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: timeout", HoldKey)
+}
+
+// ReleasePins requests the end of the hold of source.
+func ReleasePins(source string) error {
+	_, err := redis.Hset(redis.DefaultHash, Field, Release+" "+source)
+	return err
+}
+
+func (i *Info) Hset(args args.Hset, reply *reply.Hset) error {
+	switch args.Field {
+	case Field:
+	case RestoreField:
+		acLoss, err := strconv.ParseBool(string(args.Value))
+		if err != nil {
+			return err
+		}
+		return i.restore(acLoss, reply)
+	case PolicyField:
+		policy := string(args.Value)
+		if err := SavePolicy(policy); err != nil {
+			return err
+		}
+		i.pub.Print(PolicyField, ": ", policy)
+		*reply = 1
+		return nil
+	default:
+		return fmt.Errorf("cannot hset: %s", args.Field)
+	}
+	action, source, err := ParseRequest(string(args.Value))
+	if err != nil {
+		return err
+	}
+	select {
+	case i.requests <- request{action, source}:
+	default:
+		return fmt.Errorf("chassis power busy")
+	}
+	*reply = 1
+	return nil
+}
@@ -0,0 +1,497 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package chassisd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2csim"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/goes/external/redis/rpc/args"
+	"github.com/platinasystems/goes/external/redis/rpc/reply"
+)
+
+// pub records the published "KEY: VALUE" lines.
+type pub struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (p *pub) Print(a ...interface{}) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s := fmt.Sprint(a...)
+	p.lines = append(p.lines, s)
+	return len(s), nil
+}
+
+// last returns the last published value of key.
+func (p *pub) last(key string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for i := len(p.lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(p.lines[i], key+": ") {
+			return strings.TrimPrefix(p.lines[i], key+": ")
+		}
+	}
+	return ""
+}
+
+func newMachine(t *testing.T) (*Machine, *gpio.Fake, *pub) {
+	f := gpio.NewFake()
+	for _, psu := range []string{"PSU0", "PSU1"} {
+		f.Add(psu+"_PWROK", "in")
+		f.Add(psu+"_PWRON_L", "low")
+	}
+	f.Add(AllPwrGood, "in")
+	f.Add(HostRstL, "high")
+	f.Add(PwrBtnL, "high")
+	f.Add(EthxRstL, "high")
+	gpio.Default = f
+	sel.Default = &sel.Log{File: filepath.Join(t.TempDir(), "sel"),
+		Max: sel.Max}
+	p := new(pub)
+	m := &Machine{
+		Pub:     p,
+		PwronL:  []string{"PSU0_PWRON_L", "PSU1_PWRON_L"},
+		Pwrok:   []string{"PSU0_PWROK", "PSU1_PWROK"},
+		Timeout: time.Second,
+		Stop:    func() error { return nil },
+		Start:   func() error { return nil },
+	}
+	return m, f, p
+}
+
+func restore() {
+	gpio.Default = gpio.Sysfs{}
+	sel.Default = &sel.Log{File: sel.File, Max: sel.Max}
+}
+
+func TestParseRequest(t *testing.T) {
+	for _, x := range []struct {
+		v, action, source string
+		err               bool
+	}{
+		{"cycle ipmid", Cycle, "ipmid", false},
+		{"soft", Soft, "hset", false},
+		{"", "", "", true},
+		{"nmi ipmid", "", "", true},
+		{"on ipmid extra", "", "", true},
+	} {
+		action, source, err := ParseRequest(x.v)
+		if action != x.action || source != x.source ||
+			(err != nil) != x.err {
+			t.Errorf("%q: %q, %q, %v", x.v, action, source, err)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	m, f, p := newMachine(t)
+	defer restore()
+
+	if s := m.Poll(); s != S5 || p.last("chassis.power.state") != S5 {
+		t.Fatalf("initial state %s, published %q", s, p.lines)
+	}
+	if len(p.last("chassis.last_power_event")) != 0 {
+		t.Error("initial state published as an event")
+	}
+	f.Set(AllPwrGood, true)
+	if s := m.Poll(); s != S5 {
+		t.Errorf("ALL_PWR_GOOD without PWROK: %s", s)
+	}
+	f.Set("PSU1_PWROK", true)
+	if s := m.Poll(); s != S0 {
+		t.Errorf("ALL_PWR_GOOD with PWROK: %s", s)
+	}
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" power restored") {
+		t.Errorf("restored event %q", e)
+	}
+	f.Set(AllPwrGood, false)
+	m.Poll()
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" power lost") {
+		t.Errorf("lost event %q", e)
+	}
+	entries, err := sel.Default.Entries()
+	if err != nil || len(entries) != 1 ||
+		entries[0].Severity != sel.Critical {
+		t.Errorf("sel %v, %v", entries, err)
+	}
+}
+
+func TestCycle(t *testing.T) {
+	sim := i2csim.StartTest(t)
+	m, f, p := newMachine(t)
+	defer restore()
+	m.Stop, m.Start = nil, nil
+
+	if err := m.Do(Cycle, "test"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range m.PwronL {
+		ts := f.Transitions(name)
+		if len(ts) != 2 || !ts[0].Value || ts[1].Value {
+			t.Fatalf("%s: %v", name, ts)
+		}
+		if d := ts[1].Time.Sub(ts[0].Time); d < time.Second ||
+			d > 1100*time.Millisecond {
+			t.Errorf("%s held high for %v", name, d)
+		}
+	}
+	if ts := f.Transitions(EthxRstL); len(ts) != 2 {
+		t.Errorf("%s: %v", EthxRstL, ts)
+	}
+	if sim.Stopped() {
+		t.Error("i2c left stopped")
+	}
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" cycle by test") {
+		t.Errorf("event %q", e)
+	}
+}
+
+func TestActions(t *testing.T) {
+	m, f, p := newMachine(t)
+	defer restore()
+
+	// reset pulses the host reset regardless of the state
+	if err := m.Do(Reset, "test"); err != nil {
+		t.Fatal(err)
+	}
+	ts := f.Transitions(HostRstL)
+	if len(ts) != 2 || ts[0].Value || !ts[1].Value {
+		t.Fatalf("%s: %v", HostRstL, ts)
+	}
+	if d := ts[1].Time.Sub(ts[0].Time); d < 50*time.Millisecond ||
+		d > 100*time.Millisecond {
+		t.Errorf("%s pulsed for %v", HostRstL, d)
+	}
+
+	// soft is only for a host that's on
+	f.ClearTransitions()
+	m.Do(Soft, "test")
+	if ts := f.Transitions(PwrBtnL); len(ts) != 0 {
+		t.Errorf("soft in S5: %v", ts)
+	}
+	f.Set("PSU0_PWROK", true)
+	f.Set(AllPwrGood, true)
+	m.Do(Soft, "test")
+	if ts := f.Transitions(PwrBtnL); len(ts) != 2 {
+		t.Errorf("soft in S0: %v", ts)
+	}
+
+	// off raises every PWRON_L, without an unexpected power event
+	f.ClearTransitions()
+	m.Poll()
+	m.Do(Off, "ipmid")
+	for _, name := range m.PwronL {
+		if !f.Get(name) {
+			t.Errorf("off: %s low", name)
+		}
+	}
+	f.Set("PSU0_PWROK", false)
+	f.Set(AllPwrGood, false)
+	m.Poll()
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" off by ipmid") {
+		t.Errorf("off event %q", e)
+	}
+
+	// on without a host that follows fails after pressing its button
+	if err := m.Do(On, "redfishd"); err == nil {
+		t.Error("on without PWROK succeeded")
+	}
+	for _, name := range m.PwronL {
+		if f.Get(name) {
+			t.Errorf("on: %s high", name)
+		}
+	}
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" on by redfishd: no PSU power ok") {
+		t.Errorf("on event %q", e)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	m, f, p := newMachine(t)
+	defer restore()
+	m.Timeout = 50 * time.Millisecond
+	f.Set("PSU0_PWROK", true)
+	f.Set(AllPwrGood, true)
+	m.Poll()
+
+	// PWROK doesn't follow off, so a later loss is unexpected
+	m.Do(Off, "test")
+	time.Sleep(2 * m.Timeout)
+	f.Set(AllPwrGood, false)
+	m.Poll()
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" power lost") {
+		t.Errorf("loss after off timed out: event %q", e)
+	}
+}
+
+func TestHold(t *testing.T) {
+	m, f, p := newMachine(t)
+	defer restore()
+	m.HoldTimeout = 100 * time.Millisecond
+	f.Set("PSU0_PWROK", true)
+	f.Set(AllPwrGood, true)
+	m.Poll()
+
+	// while held, the pins are diag's and their changes aren't events
+	m.Do(Hold, "diag")
+	if h := p.last(HoldKey); h != "diag" {
+		t.Errorf("%s %q", HoldKey, h)
+	}
+	if err := m.Do(Reset, "test"); err == nil {
+		t.Error("reset during a hold")
+	}
+	if ts := f.Transitions(HostRstL); len(ts) != 0 {
+		t.Errorf("%s: %v", HostRstL, ts)
+	}
+	f.Set("PSU0_PWROK", false)
+	m.Poll()
+	f.Set("PSU0_PWROK", true)
+	m.Poll()
+	if e := p.last("chassis.last_power_event"); !strings.HasSuffix(e,
+		" hold by diag") {
+		t.Errorf("event %q during a hold", e)
+	}
+	m.Do(Release, "diag")
+	if err := m.Do(Reset, "test"); err != nil {
+		t.Error(err)
+	}
+
+	// a hold that isn't released expires
+	m.Do(Hold, "diag")
+	time.Sleep(2 * m.HoldTimeout)
+	m.Poll()
+	if l := p.lines[len(p.lines)-1]; l != "delete: "+HoldKey {
+		t.Errorf("last line %q, want the hold deleted", l)
+	}
+	if err := m.Do(Reset, "test"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	m, f, _ := newMachine(t)
+	defer restore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Do(Reset, "test")
+		}()
+	}
+	wg.Wait()
+	ts := f.Transitions(HostRstL)
+	if len(ts) != 6 {
+		t.Fatalf("%s: %v", HostRstL, ts)
+	}
+	for i := 0; i < len(ts); i += 2 {
+		if ts[i].Value || !ts[i+1].Value {
+			t.Errorf("overlapping resets: %v", ts)
+			break
+		}
+	}
+}
+
+func TestRestorePolicy(t *testing.T) {
+	defer func(dir string) { Dir = dir }(Dir)
+	Dir = t.TempDir()
+
+	if p := LoadPolicy(); p != DefaultPolicy {
+		t.Errorf("default policy %q", p)
+	}
+	if err := SavePolicy("sometimes"); err == nil {
+		t.Error("saved an invalid policy")
+	}
+	if err := SavePolicy(AlwaysOn); err != nil {
+		t.Fatal(err)
+	}
+	if p := LoadPolicy(); p != AlwaysOn {
+		t.Errorf("saved policy %q", p)
+	}
+
+	for _, x := range []struct {
+		policy, previous, state string
+		acLoss                  bool
+		action                  string
+	}{
+		{AlwaysOn, S5, S5, false, ""},
+		{AlwaysOn, S5, S5, true, On},
+		{AlwaysOn, S5, S0, true, ""},
+		{AlwaysOff, S0, S0, true, Off},
+		{AlwaysOff, S0, S5, true, ""},
+		{RestorePrevious, S0, S5, true, On},
+		{RestorePrevious, S5, S0, true, Off},
+		{RestorePrevious, S0, S0, true, ""},
+		{RestorePrevious, "", S5, true, ""},
+		{RestorePrevious, S0, S5, false, ""},
+	} {
+		action := RestoreAction(x.policy, x.previous, x.state, x.acLoss)
+		if action != x.action {
+			t.Errorf("%+v: %q", x, action)
+		}
+	}
+}
+
+func TestACLoss(t *testing.T) {
+	defer func(dir string) { Dir = dir }(Dir)
+	Dir = t.TempDir()
+
+	const (
+		t1 = "1970-01-01T00:10:00Z"
+		t2 = "1970-01-01T02:00:00Z"
+	)
+	for i, x := range []struct {
+		events string
+		want   bool
+	}{
+		{t1, false}, // nothing recorded
+		{t1, false}, // a reboot of the BMC alone
+		{t1 + "." + t2, true},
+		{t1 + "." + t2, false},
+		{"", false}, // a cleared log
+		{t2, true},
+	} {
+		got, err := ACLoss(x.events)
+		if err != nil || got != x.want {
+			t.Errorf("%d %q: %t, %v", i, x.events, got, err)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	defer func(dir string) { Dir = dir }(Dir)
+	Dir = t.TempDir()
+	m, f, _ := newMachine(t)
+	defer restore()
+	m.Record = true
+	m.RecordDelay = 50 * time.Millisecond
+
+	f.Set("PSU0_PWROK", true)
+	f.Set(AllPwrGood, true)
+	m.Poll()
+	if s := loadState(); s != "" {
+		t.Errorf("recorded %q before the delay", s)
+	}
+	time.Sleep(m.RecordDelay)
+	m.Poll()
+	if s := loadState(); s != S0 {
+		t.Errorf("recorded %q", s)
+	}
+
+	// the power loss of a brown-out that takes the BMC with it
+	f.Set("PSU0_PWROK", false)
+	m.Poll()
+	m.Poll()
+	if s := loadState(); s != S0 {
+		t.Errorf("brown-out recorded %q", s)
+	}
+	time.Sleep(m.RecordDelay)
+	m.Poll()
+	if s := loadState(); s != S5 {
+		t.Errorf("recorded %q", s)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	defer func(dir string) { Dir = dir }(Dir)
+	for _, x := range []struct {
+		policy, previous string
+		on               bool
+		action           string
+	}{
+		{AlwaysOn, S5, false, On},
+		{AlwaysOn, S0, true, ""},
+		{AlwaysOff, S0, true, Off},
+		{AlwaysOff, S0, false, ""},
+		{RestorePrevious, S0, false, On},
+		{RestorePrevious, S5, true, Off},
+		{RestorePrevious, S5, false, ""},
+	} {
+		Dir = t.TempDir()
+		m, f, p := newMachine(t)
+		m.Timeout = 100 * time.Millisecond
+		for _, name := range m.PwronL {
+			f.Set(name, !x.on)
+		}
+		f.Set("PSU0_PWROK", x.on)
+		f.Set(AllPwrGood, x.on)
+		m.Poll()
+		f.ClearTransitions()
+
+		action, _ := m.Restore(x.policy, x.previous, false)
+		if len(action) != 0 || len(f.Transitions()) != 0 {
+			t.Errorf("%+v: %q without an AC loss", x, action)
+		}
+		action, _ = m.Restore(x.policy, x.previous, true)
+		if action != x.action {
+			t.Errorf("%+v: %q", x, action)
+		}
+		ts := f.Transitions(m.PwronL...)
+		switch x.action {
+		case On:
+			if len(ts) == 0 || ts[0].Value {
+				t.Errorf("%+v: PWRON_L %v", x, ts)
+			}
+		case Off:
+			if len(ts) == 0 || !ts[0].Value {
+				t.Errorf("%+v: PWRON_L %v", x, ts)
+			}
+		default:
+			if len(ts) != 0 {
+				t.Errorf("%+v: PWRON_L %v", x, ts)
+			}
+		}
+		if len(x.action) > 0 && !strings.Contains(
+			p.last("chassis.last_power_event"),
+			" "+x.action+" by restore-policy") {
+			t.Errorf("%+v: event %q", x, p.last(
+				"chassis.last_power_event"))
+		}
+		restore()
+	}
+}
+
+func TestRestoreField(t *testing.T) {
+	defer func(dir string) { Dir = dir }(Dir)
+	Dir = t.TempDir()
+	m, f, _ := newMachine(t)
+	defer restore()
+	f.Set("PSU0_PWROK", true)
+	f.Set(AllPwrGood, true)
+	m.Poll()
+	i := &Info{machine: m, previous: S5}
+
+	// the start hook's AC loss restores the state of before the boot,
+	// once
+	var r reply.Hset
+	err := i.Hset(args.Hset{Field: RestoreField, Value: []byte("true")}, &r)
+	if err != nil || r != 1 {
+		t.Errorf("restore: %d, %v", r, err)
+	}
+	if ts := f.Transitions(m.PwronL...); len(ts) == 0 || !ts[0].Value {
+		t.Errorf("PWRON_L %v", ts)
+	}
+	f.ClearTransitions()
+	err = i.Hset(args.Hset{Field: RestoreField, Value: []byte("true")}, &r)
+	if err == nil || len(f.Transitions()) != 0 {
+		t.Errorf("restored again: %v", f.Transitions())
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package chassisd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/log"
+)
+
+// Actions of the chassis.power field.
+const (
+	On    = "on"
+	Off   = "off"
+	Cycle = "cycle"
+	Reset = "reset"
+	Soft  = "soft"
+	// Hold leaves the power pins to the source, e.g. diag, until
+	// Release: other actions fail and power changes aren't events.
+	Hold    = "hold"
+	Release = "release"
+)
+
+// Actions are those of the chassis.power field.
+var Actions = []string{On, Off, Cycle, Reset, Soft, Hold, Release}
+
+// HoldKey is published with the source of a hold.
+const HoldKey = "chassis.power.hold"
+
+// Power states of chassis.power.state, S0 with ALL_PWR_GOOD and a PSU's
+// PWROK, S5 otherwise.
+const (
+	S0 = "S0"
+	S5 = "S5"
+)
+
+const (
+	AllPwrGood = "ALL_PWR_GOOD"
+	HostRstL   = "BMC_TO_HOST_RST_L"
+	PwrBtnL    = "HOST_CPU_PWRBTN_L"
+	EthxRstL   = "ETHX_RST_L"
+)
+
+// softTimeout is how long the host's orderly shutdown of soft may take.
+const softTimeout = 5 * time.Minute
+
+// Printer publishes "KEY: VALUE" lines, e.g. a *publisher.Publisher.
+type Printer interface {
+	Print(a ...interface{}) (int, error)
+}
+
+// Machine is the chassis power state machine; its Do serializes the
+// actions of every requester.
+type Machine struct {
+	Pub Printer
+	// PwronL and Pwrok are the GPIO pins of the PSUs.
+	PwronL, Pwrok []string
+	// Timeout is how long on waits for the power good signals, and
+	// other than soft, how long the state of an action is expected.
+	Timeout time.Duration
+	// Stop and Start pause the i2c polling daemons during a power
+	// cycle, i2creq.Stop and i2creq.Start if nil.
+	Stop, Start func() error
+	// Record saves each new state to Dir for the restore-previous
+	// policy once it has held for RecordDelay, 5 seconds if 0, so that
+	// the power loss of an AC loss that browns out the BMC too isn't
+	// recorded.
+	Record      bool
+	RecordDelay time.Duration
+	// HoldTimeout ends a hold that wasn't released, 1 minute if 0.
+	HoldTimeout time.Duration
+
+	request sync.Mutex
+
+	mutex sync.Mutex
+	state string
+	since time.Time
+	// recorded is the state last saved.
+	recorded string
+	busy     bool
+	// expect is the state that an action leads to, so that it isn't
+	// taken as unexpected when it follows the action before expectBy.
+	expect   string
+	expectBy time.Time
+	// held is the source of a hold that lasts until heldUntil.
+	held      string
+	heldUntil time.Time
+}
+
+// State returns S0 or S5 from the power good signals.
+func (m *Machine) State() string {
+	if !pin(AllPwrGood) {
+		return S5
+	}
+	for _, name := range m.Pwrok {
+		if pin(name) {
+			return S0
+		}
+	}
+	return S5
+}
+
+// Poll publishes a change of the power state, and as the last power event
+// if it wasn't that of an action.
+func (m *Machine) Poll() string {
+	s := m.State()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.expect) > 0 && time.Now().After(m.expectBy) {
+		m.expect = ""
+	}
+	held := m.heldBy()
+	if s == m.state {
+		if len(held) == 0 {
+			m.record()
+		}
+		return s
+	}
+	prev := m.state
+	m.state = s
+	m.since = time.Now()
+	m.Pub.Print("chassis.power.state: ", s)
+	if len(prev) == 0 || m.busy || len(held) > 0 {
+		return s
+	}
+	if s == m.expect {
+		m.expect = ""
+		return s
+	}
+	if s == S5 {
+		sel.Print("chassisd", sel.Critical, "chassis.power.state",
+			"unexpected power loss")
+		m.event("power lost")
+	} else {
+		m.event("power restored")
+	}
+	return s
+}
+
+// Do performs an action for the source, e.g. "ipmid", after those already
+// in progress.
+func (m *Machine) Do(action, source string) error {
+	m.request.Lock()
+	defer m.request.Unlock()
+	m.mutex.Lock()
+	held := m.heldBy()
+	m.mutex.Unlock()
+	if len(held) > 0 && action != Hold && action != Release {
+		err := fmt.Errorf("held by %s", held)
+		log.Print("warning: chassis power ", action, " by ", source,
+			": ", err)
+		return err
+	}
+	m.setBusy(true)
+	defer m.setBusy(false)
+
+	log.Print("notice: chassis power ", action, " by ", source)
+	var err error
+	expect := ""
+	within := m.timeout()
+	switch action {
+	case On:
+		err = m.on()
+		expect = S0
+	case Off:
+		m.psus(false)
+		expect = S5
+	case Cycle:
+		err = m.cycle()
+		expect = S0
+	case Reset:
+		err = pulse(HostRstL, 50*time.Millisecond)
+	case Soft:
+		if m.State() == S0 {
+			err = pulse(PwrBtnL, 500*time.Millisecond)
+			expect = S5
+			within = softTimeout
+		}
+	case Hold:
+		m.hold(source)
+	case Release:
+		m.hold("")
+	default:
+		return fmt.Errorf("%q: invalid action, not %s", action,
+			strings.Join(Actions, ", "))
+	}
+	s := fmt.Sprint(action, " by ", source)
+	if err != nil {
+		s += ": " + err.Error()
+		expect = ""
+	}
+	m.mutex.Lock()
+	m.expect = expect
+	m.expectBy = time.Now().Add(within)
+	m.event(s)
+	m.mutex.Unlock()
+	m.Poll()
+	return err
+}
+
+// on enables the PSUs then, if the host didn't follow, presses its power
+// button.
+func (m *Machine) on() error {
+	if m.State() == S0 {
+		return nil
+	}
+	m.psus(true)
+	if !m.wait(func() bool {
+		for _, name := range m.Pwrok {
+			if pin(name) {
+				return true
+			}
+		}
+		return false
+	}) {
+		return fmt.Errorf("no PSU power ok")
+	}
+	if pin(AllPwrGood) {
+		return nil
+	}
+	if err := pulse(PwrBtnL, 500*time.Millisecond); err != nil {
+		return err
+	}
+	if !m.wait(func() bool { return pin(AllPwrGood) }) {
+		return fmt.Errorf("no power good")
+	}
+	return nil
+}
+
+// cycle turns the PSUs off for a second with the i2c polling stopped, then
+// resets the ethernet switch once they're back on.
+func (m *Machine) cycle() error {
+	stop, start := m.Stop, m.Start
+	if stop == nil {
+		stop = i2creq.Stop
+	}
+	if start == nil {
+		start = i2creq.Start
+	}
+	if err := stop(); err != nil {
+		log.Print("warning: chassis power cycle: i2c stop: ", err)
+	}
+	defer start()
+	time.Sleep(500 * time.Millisecond)
+	m.psus(false)
+	time.Sleep(1 * time.Second)
+	m.psus(true)
+	time.Sleep(1 * time.Second)
+	if _, found := gpio.FindPin(EthxRstL); !found {
+		return nil
+	}
+	return pulse(EthxRstL, 50*time.Millisecond)
+}
+
+func (m *Machine) psus(on bool) {
+	for _, name := range m.PwronL {
+		if p, found := gpio.FindPin(name); found {
+			p.SetValue(!on)
+		}
+	}
+}
+
+func (m *Machine) wait(f func() bool) bool {
+	for end := time.Now().Add(m.timeout()); ; {
+		if f() {
+			return true
+		}
+		if time.Now().After(end) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// record saves the state with m locked once it has held for RecordDelay.
+func (m *Machine) record() {
+	delay := m.RecordDelay
+	if delay == 0 {
+		delay = 5 * time.Second
+	}
+	if !m.Record || m.state == m.recorded || time.Since(m.since) < delay {
+		return
+	}
+	if err := save(stateFileName, m.state); err != nil {
+		log.Print("warning: chassis power state: ", err)
+	}
+	m.recorded = m.state
+}
+
+func (m *Machine) timeout() time.Duration {
+	if m.Timeout == 0 {
+		return 10 * time.Second
+	}
+	return m.Timeout
+}
+
+// hold starts a hold by source, or ends it if source is empty.
+func (m *Machine) hold(source string) {
+	timeout := m.HoldTimeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.held = source
+	m.heldUntil = time.Now().Add(timeout)
+	if len(source) > 0 {
+		m.Pub.Print(HoldKey, ": ", source)
+	} else {
+		m.Pub.Print("delete: ", HoldKey)
+	}
+}
+
+// heldBy returns the source of the hold with m locked, ending one that
+// timed out.
+func (m *Machine) heldBy() string {
+	if len(m.held) > 0 && time.Now().After(m.heldUntil) {
+		log.Print("warning: chassis power hold by ", m.held, " expired")
+		m.held = ""
+		m.Pub.Print("delete: ", HoldKey)
+	}
+	return m.held
+}
+
+func (m *Machine) setBusy(busy bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.busy = busy
+}
+
+// event publishes the last power event with m locked.
+func (m *Machine) event(s string) {
+	m.Pub.Print("chassis.last_power_event: ",
+		time.Now().UTC().Format(time.RFC3339), " ", s)
+}
+
+func pin(name string) bool {
+	p, found := gpio.FindPin(name)
+	if !found {
+		return false
+	}
+	v, err := p.Value()
+	return err == nil && v
+}
+
+// pulse drives an active low pin low for d.
+func pulse(name string, d time.Duration) error {
+	p, found := gpio.FindPin(name)
+	if !found {
+		return fmt.Errorf("%s: not found", name)
+	}
+	if err := p.SetValue(false); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return p.SetValue(true)
+}
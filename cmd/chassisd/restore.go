@@ -0,0 +1,202 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package chassisd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/platinasystems/goes/external/redis"
+)
+
+// Power restore policies of PolicyField, what to do with the host when the
+// BMC boots after an AC loss.
+const (
+	AlwaysOn        = "always-on"
+	AlwaysOff       = "always-off"
+	RestorePrevious = "restore-previous"
+)
+
+// Policies are those of PolicyField.
+var Policies = []string{AlwaysOn, AlwaysOff, RestorePrevious}
+
+const (
+	// PolicyField is the writable power restore policy.
+	PolicyField = "chassis.power.restore_policy"
+	// PreviousStateKey is the power state recorded before this boot.
+	PreviousStateKey = "chassis.power.previous_state"
+	// ACLossKey is published by ucd9090d, true if its fault log has a
+	// power off that wasn't there before this boot.
+	ACLossKey = "vmon.poweroff.ac_loss"
+	// RestoreField is set once by Restore to whether the BMC booted
+	// after an AC loss, for chassisd to apply the policy.
+	RestoreField = "chassis.power.restore"
+
+	DefaultPolicy = RestorePrevious
+
+	policyFileName = "policy"
+	stateFileName  = "state"
+	eventsFileName = "poweroff.events"
+)
+
+// Dir has the persistent policy, state and power off events files.
+var Dir = "/perm/var/chassisd"
+
+// LoadPolicy returns the saved policy, DefaultPolicy if none.
+func LoadPolicy() string {
+	b, err := ioutil.ReadFile(filepath.Join(Dir, policyFileName))
+	if err != nil {
+		return DefaultPolicy
+	}
+	s := strings.TrimSpace(string(b))
+	if err = validPolicy(s); err != nil {
+		return DefaultPolicy
+	}
+	return s
+}
+
+// SavePolicy persists a valid policy.
+func SavePolicy(policy string) error {
+	if err := validPolicy(policy); err != nil {
+		return err
+	}
+	return save(policyFileName, policy)
+}
+
+func validPolicy(policy string) error {
+	for _, p := range Policies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q: invalid policy, not %s", policy,
+		strings.Join(Policies, ", "))
+}
+
+// loadState returns the state recorded by the last Poll, "" if none.
+func loadState() string {
+	b, err := ioutil.ReadFile(filepath.Join(Dir, stateFileName))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// ACLoss returns whether the power off events of ucd9090d's PowerCycles,
+// '.' separated times of its fault log, has one that wasn't saved by the
+// last call, i.e. of a power loss since the last boot rather than a
+// reboot of the BMC alone. Without a saved record, it returns false.
+func ACLoss(events string) (bool, error) {
+	fn := filepath.Join(Dir, eventsFileName)
+	b, err := ioutil.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	recorded := err == nil
+	saved := strings.TrimSpace(string(b))
+	if events != saved {
+		if err = save(eventsFileName, events); err != nil {
+			return false, err
+		}
+	}
+	if !recorded || len(events) == 0 {
+		return false, nil
+	}
+	old := make(map[string]bool)
+	for _, t := range strings.Split(saved, ".") {
+		old[t] = true
+	}
+	for _, t := range strings.Split(events, ".") {
+		if !old[t] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RestoreAction returns the action of policy for the current power state,
+// "" if none. Only an AC loss has one, and restore-previous without a
+// previous state has none.
+func RestoreAction(policy, previous, state string, acLoss bool) string {
+	if !acLoss {
+		return ""
+	}
+	want := previous
+	switch policy {
+	case AlwaysOn:
+		want = S0
+	case AlwaysOff:
+		want = S5
+	}
+	switch {
+	case want == S0 && state != S0:
+		return On
+	case want == S5 && state == S0:
+		return Off
+	}
+	return ""
+}
+
+// Restore performs the action of policy for the previous state after an AC
+// loss, returning it, "" if none.
+func (m *Machine) Restore(policy, previous string, acLoss bool) (string,
+	error) {
+	action := RestoreAction(policy, previous, m.State(), acLoss)
+	if len(action) == 0 {
+		return "", nil
+	}
+	return action, m.Do(action, "restore-policy")
+}
+
+// Restore is run by the start hook. It waits up to timeout for ucd9090d
+// to tell whether the BMC booted after an AC loss and for chassisd to take
+// its fields, then has chassisd apply the restore policy with the state it
+// read before recording that of now.
+func Restore(timeout time.Duration) error {
+	end := time.Now().Add(timeout)
+	acLoss, err := waitACLoss(timeout)
+	if err != nil {
+		return err
+	}
+	for {
+		s, err := redis.Hget(redis.DefaultHash, PolicyField)
+		if err == nil && len(s) > 0 {
+			break
+		}
+		if time.Now().After(end) {
+			return fmt.Errorf("chassisd: timeout")
+		}
+		time.Sleep(time.Second)
+	}
+	_, err = redis.Hset(redis.DefaultHash, RestoreField,
+		strconv.FormatBool(acLoss))
+	return err
+}
+
+// waitACLoss waits up to timeout for ucd9090d's ACLossKey, published on its
+// first poll.
+func waitACLoss(timeout time.Duration) (bool, error) {
+	for end := time.Now().Add(timeout); ; time.Sleep(time.Second) {
+		s, err := redis.Hget(redis.DefaultHash, ACLossKey)
+		if err == nil && len(s) > 0 {
+			return s == "true", nil
+		}
+		if time.Now().After(end) {
+			return false, fmt.Errorf("%s: timeout", ACLossKey)
+		}
+	}
+}
+
+func save(name, s string) error {
+	if err := os.MkdirAll(Dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(Dir, name), []byte(s+"\n"), 0644)
+}
@@ -8,7 +8,7 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/platinasystems/eeprom"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
 )
 
 func diagHost() error {
@@ -30,6 +30,13 @@ func diagHost() error {
 	/* diagTest: HOST_TO_BMC_INT_L, BMC_TO_HOST_INT_L, BMC_TO_HOST_NMI
 	CPLD diag mode enables HOST_TO_BMC_INT_L = BMC_TO_HOST_INT_L | BMC_TO_HOST_NMI, toggle BMC driven signals and check host_to_bmc_int_l for correct state
 	*/
+	// these tests hold BMC_TO_HOST_RST_L low while reading the CPU
+	// card, which no chassisd action does, so hold chassisd off the
+	// power pins until done
+	if err := chassisd.HoldPins("diag"); err != nil {
+		return err
+	}
+	defer chassisd.ReleasePins("diag")
 	gpioSet("CPU_TO_MAIN_I2C_EN", true)
 	gpioSet("BMC_TO_HOST_RST_L", false)
 	diagI2cWriteOffsetByte(0x00, 0x77, 0x03, 0xFF)
@@ -107,20 +114,14 @@ func diagHost() error {
 
 
 
-        dev := eeprom.Device{
-                BusIndex:   0,
-                BusAddress: 0x55,
-        }
-        if err:= dev.GetInfo(); err != nil {
-                return err
-        }
-        if dev.Fields.ChassisType == TOR1 {
+        id := diagIdent()
+        if id.ChassisType == int(TOR1) {
                 if err := diagHostTor(); err != nil {
                         return err
                 }
-        } else if (dev.Fields.ChassisType == CH1) && (dev.Fields.BoardType == CH1MC) {
+        } else if (id.ChassisType == int(CH1)) && (id.BoardType == int(CH1MC)) {
                 return nil
-        } else if (dev.Fields.ChassisType == CH1) && (dev.Fields.BoardType == CH1MC) {
+        } else if (id.ChassisType == int(CH1)) && (id.BoardType == int(CH1MC)) {
                 diagHostCh1Lc()
         }
 
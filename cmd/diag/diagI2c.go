@@ -6,26 +6,17 @@ package diag
 
 import (
 	"fmt"
-	"net/rpc"
 	"time"
 
-	"github.com/platinasystems/eeprom"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/platform"
 	"github.com/platinasystems/i2c"
 	"github.com/platinasystems/log"
 )
 
-var clientA *rpc.Client
-var dialed int = 0
-var j [MAXOPS]I
-var s [MAXOPS]R
-var i = I{false, i2c.RW(0), 0, 0, b, 0, 0, 0}
-var x int
-var b = [i2c.BlockMax]byte{0}
 var chassisType, boardType uint8
 
 const (
-	MAXOPS = 30
-
 	TOR1 uint8	= 0x00
 	CH1 uint8 	= 0x01
 
@@ -36,51 +27,19 @@ const (
 
 var sd i2c.SMBusData
 
-type I struct {
-	InUse     bool
-	RW        i2c.RW
-	RegOffset uint8
-	BusSize   i2c.SMBusSize
-	Data      [i2c.BlockMax]byte
-	Bus       int
-	Addr      int
-	Delay     int
-}
-type R struct {
-	D [i2c.BlockMax]byte
-	E error
-}
-
 var ucd9090dAdr uint8
 var ledgpiodAdr uint8
 
 func diagI2c() error {
-	ucd9090dAdr = 0x34
-	ledgpiodAdr = 0x75
-
-        d := eeprom.Device{
-                BusIndex:   0,
-                BusAddress: 0x55,
-        }
-        if err := d.GetInfo(); err != nil {
-		return err
-	}
-	if d.Fields.ChassisType == TOR1 {
-		switch d.Fields.DeviceVersion {
-		case 0xff:
-			ucd9090dAdr = 0x7e
-			ledgpiodAdr = 0x22
-		case 0x00:
-			ucd9090dAdr = 0x7e
-			ledgpiodAdr = 0x22
-		default:
-			ucd9090dAdr = 0x34
-			ledgpiodAdr = 0x75
-		}
+	id := diagIdent()
+	p := platform.Get(id)
+	ucd9090dAdr = uint8(p.Ucd9090d.Addr)
+	ledgpiodAdr = uint8(p.Ledgpiod.Addr)
+	if id.ChassisType == int(TOR1) {
 		diagI2cTor()
-	} else if (d.Fields.ChassisType == CH1) && (d.Fields.BoardType == CH1MC) {
+	} else if (id.ChassisType == int(CH1)) && (id.BoardType == int(CH1MC)) {
 		diagI2cCh1Mc()
-	} else if (d.Fields.ChassisType == CH1) && (d.Fields.BoardType == CH1MC) {
+	} else if (id.ChassisType == int(CH1)) && (id.BoardType == int(CH1MC)) {
                 diagI2cCh1Lc()
 	}
 
@@ -296,9 +255,7 @@ func diagI2cCh1Mc() {
 
 	// avoid conflicts w/ interrupt handlers.
         // i2c STOP
-        sd[0] = 0
-        j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(1), 0}
-        err := DoI2cRpc()
+        err := i2creq.Stop()
         if err != nil {
                 log.Print(err)
         }
@@ -481,7 +438,7 @@ func diagI2cCh1Mc() {
 
         diagI2cWrite1Byte(0x00, 0x71, 0x02)
         time.Sleep(10 * time.Millisecond)
-        result, _ = diagI2cPing(0x00, 0x7e, 0x00, 10)
+        result, _ = diagI2cPing(0x00, ucd9090dAdr, 0x00, 10)
         r = CheckPassB(result, true)
         fmt.Printf("%15s|%25s|%10s|%10t|%10t|%10t|%6s|%35s\n", "i2c", "ping_ucd9090", "-", result,
 		i2cping_response_min, i2cping_response_max, r, "ping device 10x")
@@ -641,9 +598,7 @@ func diagI2cCh1Mc() {
 
 
         //i2c START
-        sd[0] = 0
-        j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(0), 0}
-        err = DoI2cRpc()
+        err = i2creq.Start()
         if err != nil {
                 log.Print(err)
         }
@@ -656,9 +611,7 @@ func diagI2cCh1Lc() {
 func diagSwitchConsole() error {
 
 	//i2c STOP
-	sd[0] = 0
-	j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(1), 0}
-	err := DoI2cRpc()
+	err := i2creq.Stop()
 	if err != nil {
 		return err
 	}
@@ -672,39 +625,10 @@ func diagSwitchConsole() error {
 	time.Sleep(50 * time.Millisecond)
 
 	//i2c START
-	sd[0] = 0
-	j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(0), 0}
-	err = DoI2cRpc()
+	err = i2creq.Start()
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
-
-func clearJ() {
-	x = 0
-	for k := 0; k < MAXOPS; k++ {
-		j[k] = i
-	}
-}
-
-func DoI2cRpc() error {
-	if dialed == 0 {
-		client, err := rpc.DialHTTP("tcp", "127.0.0.1"+":1233")
-		if err != nil {
-			log.Print("dialing:", err)
-			return err
-		}
-		clientA = client
-		dialed = 1
-		time.Sleep(time.Millisecond * time.Duration(50))
-	}
-	err := clientA.Call("I2cReq.ReadWrite", &j, &s)
-	if err != nil {
-		log.Print("i2cReq error:", err)
-		return err
-	}
-	clearJ()
-	return nil
-}
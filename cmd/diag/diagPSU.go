@@ -8,7 +8,8 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/platinasystems/i2c"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/i2creq"
 	"github.com/platinasystems/log"
 )
 
@@ -32,6 +33,11 @@ func diagPSU() error {
 	/* diagTest: PSU[1:0]_PWROK and PSU[1:0]_PWRON_L
 	toggle psu on and validate pwrok behaves appropriately
 	*/
+	// chassisd only switches the PSUs together, so hold it off the
+	// power pins while toggling each PSU directly
+	if err := chassisd.HoldPins("diag"); err != nil {
+		return err
+	}
 	gpioSet("PSU0_PWRON_L", true)
 	time.Sleep(1 * time.Second)
 	pinstate, _ = gpioGet("PSU0_PWROK")
@@ -56,6 +62,9 @@ func diagPSU() error {
 	pinstate, _ = gpioGet("PSU1_PWROK")
 	r = CheckPassB(pinstate, true)
 	fmt.Printf("%15s|%25s|%10s|%10t|%10t|%10t|%6s|%35s\n", "psu", "psu1_pwron_l/pwrok_on", "-", pinstate, active_high_on_min, active_high_on_max, r, "turn psu on, check psu ok is high")
+	if err := chassisd.ReleasePins("diag"); err != nil {
+		return err
+	}
 
 	/* diagTest: PSU[1:0]_INT_L interrupt
 	Check psu interrupt is high
@@ -75,9 +84,7 @@ func diagPSU() error {
 func diagPowerCycle() error {
 
 	//i2c STOP
-	sd[0] = 0
-	j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(1), 0}
-	err := DoI2cRpc()
+	err := i2creq.Stop()
 	if err != nil {
 		return err
 	}
@@ -94,9 +101,7 @@ func diagPowerCycle() error {
 	time.Sleep(100 * time.Millisecond)
 
 	//i2c START
-	sd[0] = 0
-	j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(0), 0}
-	err = DoI2cRpc()
+	err = i2creq.Start()
 	if err != nil {
 		return err
 	}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/platinasystems/eeprom"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
 	"github.com/platinasystems/goes-bmc/cmd/ucd9090d"
+	"github.com/platinasystems/goes-bmc/platform"
+	"github.com/platinasystems/goes/external/redis"
 )
 
 var pm ucd9090d.I2cDev
@@ -17,40 +19,22 @@ var pm ucd9090d.I2cDev
 func diagPower() error {
 
 	const (
-		ucd9090dBus = 4
-		ucd9090dAdr = 0x34
-
 		TOR1  uint8 = 0x00
 		CH1   uint8 = 0x01
 		CH1MC uint8 = 0x04
 		CH1LC uint8 = 0x05
 	)
 
-	pm = ucd9090d.I2cDev{Bus: ucd9090dBus, Addr: ucd9090dAdr}
-
-	d := eeprom.Device{
-		BusIndex:   0,
-		BusAddress: 0x55,
-	}
-	if err := d.GetInfo(); err != nil {
-		return err
-	}
-	if d.Fields.ChassisType == TOR1 {
-		switch d.Fields.DeviceVersion {
-		case 0xff:
-			pm.Addr = 0x7e
-		case 0x00:
-			pm.Addr = 0x7e
-		default:
-			pm.Addr = 0x34
-		}
+	id := diagIdent()
+	p := platform.Get(id)
+	pm = ucd9090d.I2cDev{Bus: p.Ucd9090d.Bus, Addr: int(p.Ucd9090d.Addr)}
+	if id.ChassisType == int(TOR1) {
 		if err := diagPowerTor(); err != nil {
 			return err
 		}
-	} else if (d.Fields.ChassisType == CH1) && (d.Fields.BoardType == CH1MC) {
-		pm.Addr = 0x7e
+	} else if (id.ChassisType == int(CH1)) && (id.BoardType == int(CH1MC)) {
 		diagPowerCh1Mc()
-	} else if (d.Fields.ChassisType == CH1) && (d.Fields.BoardType == CH1MC) {
+	} else if (id.ChassisType == int(CH1)) && (id.BoardType == int(CH1LC)) {
 		diagPowerCh1Lc()
 	}
 
@@ -80,8 +64,10 @@ func diagPowerTor() error {
 	toggle ucd all_power_good output and validate bmc can detect the proper signal states
 	*/
 	//tbd: set high and check ALL_PWR_GOOD is high
-	gpioSet("HOST_CPU_PWRBTN_L", true)
-	time.Sleep(50 * time.Millisecond)
+	// chassisd owns HOST_CPU_PWRBTN_L, power on through it
+	if err := chassisOn(); err != nil {
+		return err
+	}
 	pinstate, _ := gpioGet("ALL_PWR_GOOD")
 	r = CheckPassB(pinstate, true)
 	fmt.Printf("%15s|%25s|%10s|%10t|%10t|%10t|%6s|%35s\n", "power", "all_pwr_good_on", "-", pinstate, active_high_on_min, active_high_on_max, r, "check signal is high")
@@ -162,6 +148,23 @@ func diagPowerTor() error {
 	return nil
 }
 
+// chassisOn requests chassis power on of chassisd and waits for S0.
+func chassisOn() error {
+	_, err := redis.Hset(redis.DefaultHash, chassisd.Field,
+		chassisd.On+" diag")
+	if err != nil {
+		return err
+	}
+	for end := time.Now().Add(30 * time.Second); time.Now().Before(end); {
+		s, err := redis.Hget(redis.DefaultHash, "chassis.power.state")
+		if err == nil && s == chassisd.S0 {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("chassis power on: timeout")
+}
+
 func diagPowerCh1Mc() error {
 	return diagPowerTor()
 }
@@ -171,25 +174,8 @@ func diagPowerCh1Lc() {
 }
 
 func diagLoggedFaults() error {
-	const (
-		ucd9090dBus = 4
-		ucd9090dAdr = 0x34
-	)
-	var pm = ucd9090d.I2cDev{Bus: ucd9090dBus, Addr: ucd9090dAdr}
-
-	d := eeprom.Device{
-		BusIndex:   0,
-		BusAddress: 0x55,
-	}
-	d.GetInfo()
-	switch d.Fields.DeviceVersion {
-	case 0xff:
-		pm.Addr = 0x7e
-	case 0x00:
-		pm.Addr = 0x7e
-	default:
-		pm.Addr = 0x34
-	}
+	p := platform.Get(diagIdent())
+	var pm = ucd9090d.I2cDev{Bus: p.Ucd9090d.Bus, Addr: int(p.Ucd9090d.Addr)}
 	log, err := pm.LoggedFaultDetail()
 	if err == nil {
 		fmt.Printf("%v", log)
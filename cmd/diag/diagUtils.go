@@ -6,7 +6,8 @@ package diag
 
 import (
 	"fmt"
-	"github.com/platinasystems/gpio"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/platform"
 	"github.com/platinasystems/i2c"
 	"github.com/tatsushid/go-fastping"
 	"io/ioutil"
@@ -296,3 +297,13 @@ func diagTestMMC() (bool, error) {
 
 	return true, nil
 }
+
+// diagIdent returns the board identity published by the redisd hook.
+// Boards without a Platina vendor extension are ToRs.
+func diagIdent() platform.Ident {
+	id := platform.IdentFromRedis()
+	if id.ChassisType == platform.Unknown {
+		id.ChassisType = int(TOR1)
+	}
+	return id
+}
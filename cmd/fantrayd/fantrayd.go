@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/platform"
 	"github.com/platinasystems/goes/cmd"
 	"github.com/platinasystems/goes/external/atsock"
 	"github.com/platinasystems/goes/external/log"
@@ -45,13 +47,14 @@ type Command struct {
 }
 
 type Info struct {
-	mutex sync.Mutex
-	rpc   *atsock.RpcServer
-	pub   *publisher.Publisher
-	stop  chan struct{}
-	last  map[string]float64
-	lasts map[string]string
-	lastu map[string]uint16
+	mutex     sync.Mutex
+	rpc       *atsock.RpcServer
+	pub       *publisher.Publisher
+	i2cErrors i2creq.ErrorCount
+	stop      chan struct{}
+	last      map[string]float64
+	lasts     map[string]string
+	lastu     map[string]uint16
 }
 
 type I2cDev struct {
@@ -94,6 +97,7 @@ func (c *Command) Main(...string) error {
 	if c.pub, err = publisher.New(); err != nil {
 		return err
 	}
+	c.i2cErrors.Name = "fantrayd"
 
 	if err = syscall.Sysinfo(&si); err != nil {
 		return err
@@ -132,8 +136,8 @@ func (c *Command) Main(...string) error {
 }
 
 func (c *Command) update() error {
-	stopped := readStopped()
-	if stopped == 1 {
+	c.i2cErrors.Publish(c.pub)
+	if i2creq.Stopped() {
 		return nil
 	}
 	if err := writeRegs(); err != nil {
@@ -163,16 +167,15 @@ var fanTrayLedYellow = []uint8{0x10, 0x01, 0x10, 0x01}
 var fanTrayLedBits = []uint8{0x30, 0x03, 0x30, 0x03}
 var fanTrayDirBits = []uint8{0x80, 0x08, 0x80, 0x08}
 var fanTrayAbsBits = []uint8{0x40, 0x04, 0x40, 0x04}
-var deviceVer int
+var proto bool
 var first int
 
 func (h *I2cDev) FanTrayLedInit() error {
 	r := getRegs()
+	var tr i2creq.Trans
 
-	deviceVer = 0x1
-	s, _ := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-	_, _ = fmt.Sscan(s, &deviceVer)
-	if deviceVer == 0xff || deviceVer == 0x00 {
+	proto = platform.IdentFromRedis().Proto()
+	if proto {
 		fanTrayLedGreen = []uint8{0x10, 0x01, 0x10, 0x01}
 		fanTrayLedYellow = []uint8{0x20, 0x02, 0x20, 0x02}
 	} else {
@@ -180,11 +183,11 @@ func (h *I2cDev) FanTrayLedInit() error {
 		fanTrayLedYellow = []uint8{0x10, 0x01, 0x10, 0x01}
 	}
 
-	r.Output[0].set(h, 0xff&(fanTrayLedOff[2]|fanTrayLedOff[3]))
-	r.Output[1].set(h, 0xff&(fanTrayLedOff[0]|fanTrayLedOff[1]))
-	r.Config[0].set(h, 0xff^fanTrayLeds)
-	r.Config[1].set(h, 0xff^fanTrayLeds)
-	err := DoI2cRpc()
+	r.Output[0].set(&tr, h, 0xff&(fanTrayLedOff[2]|fanTrayLedOff[3]))
+	r.Output[1].set(&tr, h, 0xff&(fanTrayLedOff[0]|fanTrayLedOff[1]))
+	r.Config[0].set(&tr, h, 0xff^fanTrayLeds)
+	r.Config[1].set(&tr, h, 0xff^fanTrayLeds)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
@@ -194,11 +197,10 @@ func (h *I2cDev) FanTrayLedInit() error {
 
 func (h *I2cDev) FanTrayLedReinit() error {
 	r := getRegs()
+	var tr i2creq.Trans
 
-	deviceVer := 0
-	s, _ := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-	_, _ = fmt.Sscan(s, &deviceVer)
-	if deviceVer == 0xff || deviceVer == 0x00 {
+	proto = platform.IdentFromRedis().Proto()
+	if proto {
 		fanTrayLedGreen = []uint8{0x10, 0x01, 0x10, 0x01}
 		fanTrayLedYellow = []uint8{0x20, 0x02, 0x20, 0x02}
 	} else {
@@ -206,9 +208,9 @@ func (h *I2cDev) FanTrayLedReinit() error {
 		fanTrayLedYellow = []uint8{0x10, 0x01, 0x10, 0x01}
 	}
 
-	r.Config[0].set(h, 0xff^fanTrayLeds)
-	r.Config[1].set(h, 0xff^fanTrayLeds)
-	err := DoI2cRpc()
+	r.Config[0].set(&tr, h, 0xff^fanTrayLeds)
+	r.Config[1].set(&tr, h, 0xff^fanTrayLeds)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
@@ -230,7 +232,7 @@ func (h *I2cDev) FanTrayStatus(i uint8) (string, error) {
 		first = 0
 	}
 
-	if deviceVer == 0xff || deviceVer == 0x00 {
+	if proto {
 		fanTrayLedGreen = []uint8{0x10, 0x01, 0x10, 0x01}
 		fanTrayLedYellow = []uint8{0x20, 0x02, 0x20, 0x02}
 	} else {
@@ -239,6 +241,7 @@ func (h *I2cDev) FanTrayStatus(i uint8) (string, error) {
 	}
 
 	r := getRegs()
+	var tr i2creq.Trans
 	n := 0
 	i--
 
@@ -246,21 +249,21 @@ func (h *I2cDev) FanTrayStatus(i uint8) (string, error) {
 		n = 1
 	}
 
-	r.Output[n].get(h)
-	err := DoI2cRpc()
+	r.Output[n].get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "error", err
 	}
-	o := s[0].D[0]
+	o := tr.Byte(0)
 	d := 0xff ^ fanTrayLedBits[i]
 	o &= d
 
-	r.Input[n].get(h)
-	err = DoI2cRpc()
+	r.Input[n].get(&tr, h)
+	err = tr.Do()
 	if err != nil {
 		return "error", err
 	}
-	rInputNGet := s[0].D[0]
+	rInputNGet := tr.Byte(0)
 	if (rInputNGet & fanTrayAbsBits[i]) != 0 {
 		//fan tray is not present, turn LED off
 		w = "not installed"
@@ -306,8 +309,8 @@ func (h *I2cDev) FanTrayStatus(i uint8) (string, error) {
 		}
 	}
 
-	r.Output[n].set(h, o)
-	err = DoI2cRpc()
+	r.Output[n].set(&tr, h, o)
+	err = tr.Do()
 	if err != nil {
 		return "error", err
 	}
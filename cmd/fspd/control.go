@@ -0,0 +1,157 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/pmbus"
+	"github.com/platinasystems/log"
+)
+
+// OPERATION commands, margins act on faults
+const (
+	operationOff        = 0x00
+	operationOn         = 0x80
+	operationMarginLow  = 0x98
+	operationMarginHigh = 0xa8
+)
+
+// onOffConfigOperation has a PSU power up by OPERATION and its CONTROL pin,
+// PWRON_L, rather than CONTROL alone.
+const onOffConfigOperation = 0x18
+
+// fanConfigRpm1 of FAN_CONFIG_1_2 has FAN_COMMAND_1 in RPM rather than duty
+// cycle percent.
+const fanConfigRpm1 = 1 << 6
+
+// Operations are the values of psuN.operation.
+var Operations = []string{"on", "off", "margin_low", "margin_high"}
+
+// FanOverrideRange is that of psuN.fan_override.percent; 0 leaves the fan
+// speed to the PSU.
+var FanOverrideRange = []string{"0", "100"}
+
+// SetOperation turns the PSU on, off, or on with its output margined, by
+// OPERATION. If the PSU NAKs, or its profile lacks OPERATION, on and off are
+// by PWRON_L, after a NAK until the PSU is removed.
+func (h *I2cDev) SetOperation(s string) error {
+	var v uint8
+	switch s {
+	case "on":
+		v = operationOn
+	case "off":
+		v = operationOff
+	case "margin_low":
+		v = operationMarginLow
+	case "margin_high":
+		v = operationMarginHigh
+	default:
+		return fmt.Errorf("invalid operation %q", s)
+	}
+	err := h.setOperation(v)
+	if err == nil {
+		log.Print("notice: psu", h.Slot, " operation ", s)
+		return nil
+	}
+	switch s {
+	case "on":
+		h.SetAdminState("enable")
+	case "off":
+		h.SetAdminState("disable")
+	default:
+		return fmt.Errorf("operation %s: %w", s, err)
+	}
+	log.Print("notice: psu", h.Slot, " operation ", s, " by gpio: ", err)
+	return nil
+}
+
+// setOperation has the PSU respond to OPERATION, then sends it v.
+func (h *I2cDev) setOperation(v uint8) error {
+	if !h.supports(pmbus.Operation) || !h.supports(pmbus.OnOffConfig) {
+		return errUnsupported
+	}
+	r := getRegs()
+	var tr i2creq.Trans
+	r.OnOffConfig.get(&tr, h)
+	if err := tr.Do(); err != nil {
+		return h.nak(pmbus.OnOffConfig, err)
+	}
+	config := tr.Byte(0)
+	tr = i2creq.Trans{}
+	if config&onOffConfigOperation != onOffConfigOperation {
+		r.OnOffConfig.set(&tr, h, config|onOffConfigOperation)
+	}
+	r.Operation.set(&tr, h, v)
+	return h.nak(pmbus.Operation, tr.Do())
+}
+
+// Operation returns the psuN.operation of OPERATION.
+func (h *I2cDev) Operation() (string, error) {
+	if !h.supports(pmbus.Operation) {
+		return "", errUnsupported
+	}
+	r := getRegs()
+	var tr i2creq.Trans
+	r.Operation.get(&tr, h)
+	if err := tr.Do(); err != nil {
+		return "", h.nak(pmbus.Operation, err)
+	}
+	v := tr.Byte(0)
+	switch {
+	case v&operationOn == 0:
+		return "off", nil
+	case v&0x30 == 0x10:
+		return "margin_low", nil
+	case v&0x30 == 0x20:
+		return "margin_high", nil
+	}
+	return "on", nil
+}
+
+// SetFanOverride commands the PSU fan to at least pct percent duty cycle.
+func (h *I2cDev) SetFanOverride(pct int) error {
+	if pct < 0 || pct > 100 {
+		return fmt.Errorf("fan override %d%% out of range", pct)
+	}
+	if !h.supports(pmbus.FanConfig12) || !h.supports(pmbus.FanCommand1) {
+		return errUnsupported
+	}
+	r := getRegs()
+	var tr i2creq.Trans
+	r.FanConfig.get(&tr, h)
+	if err := tr.Do(); err != nil {
+		return h.nak(pmbus.FanConfig12, err)
+	}
+	config := tr.Byte(0)
+	tr = i2creq.Trans{}
+	if config&fanConfigRpm1 != 0 {
+		r.FanConfig.set(&tr, h, config&^fanConfigRpm1)
+	}
+	r.FanCommand1.set(&tr, h, pmbus.ToLinear11(float64(pct)))
+	if err := tr.Do(); err != nil {
+		return h.nak(pmbus.FanCommand1, err)
+	}
+	log.Print("notice: psu", h.Slot, " fan override ", pct, "%")
+	return nil
+}
+
+// FanOverride returns the psuN.fan_override.percent of FAN_COMMAND_1.
+func (h *I2cDev) FanOverride() (string, error) {
+	if !h.supports(pmbus.FanCommand1) {
+		return "", errUnsupported
+	}
+	r := getRegs()
+	var tr i2creq.Trans
+	r.FanCommand1.get(&tr, h)
+	if err := tr.Do(); err != nil {
+		return "", h.nak(pmbus.FanCommand1, err)
+	}
+	pct := math.Round(pmbus.Linear11(tr.Word(0)))
+	return strconv.Itoa(int(pct)), nil
+}
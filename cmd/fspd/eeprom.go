@@ -0,0 +1,61 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"github.com/platinasystems/goes-bmc/fru"
+	"github.com/platinasystems/log"
+)
+
+// fruKeys are those published as psuN.fru.KEY from a FRU EEPROM.
+var fruKeys = []string{
+	"manufacturer",
+	"part_number",
+	"serial",
+	"version",
+	"mfg_date",
+}
+
+// eepromLayout locates the airflow and serial number in the EEPROM of a
+// PSU model that isn't an IPMI FRU EEPROM. Zero offsets are none.
+type eepromLayout struct {
+	// Airflow is the offset of the byte that is 'R' for back to front
+	// airflow.
+	Airflow int
+	// Serial and SerialEnd bound the ASCII serial number.
+	Serial, SerialEnd int
+}
+
+// eepromKeys returns the keys, relative to psuN., and values of the EEPROM
+// b of a PSU with profile p: the fru.* fields of an IPMI FRU EEPROM, and
+// fan_direction and sn. Without a valid FRU EEPROM, or a FRU serial number,
+// the serial number is that of the profile's layout.
+func eepromKeys(psu string, p *Profile, b []byte) map[string]string {
+	m := make(map[string]string)
+	info, err := fru.Parse(b)
+	if err != nil {
+		log.Print("notice: ", psu, " eeprom: ", err)
+	} else {
+		for k, v := range info.Fields() {
+			m["fru."+k] = v
+		}
+	}
+	layout := p.Eeprom
+	if len(p.Airflow) > 0 {
+		m["fan_direction"] = p.Airflow
+	} else if layout.Airflow > 0 && layout.Airflow < len(b) {
+		if b[layout.Airflow] == 'R' {
+			m["fan_direction"] = "back->front"
+		} else {
+			m["fan_direction"] = "front->back"
+		}
+	}
+	if sn, found := m["fru.serial"]; found {
+		m["sn"] = sn
+	} else if layout.SerialEnd > 0 && layout.SerialEnd <= len(b) {
+		m["sn"] = string(b[layout.Serial:layout.SerialEnd])
+	}
+	return m
+}
@@ -0,0 +1,243 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/pmbus"
+	"github.com/platinasystems/log"
+)
+
+const (
+	energyFileName = "energy.json"
+	// energySaveInterval is that of writing the totals to flash.
+	energySaveInterval = 10 * time.Minute
+)
+
+// Dir has the persistent energy totals.
+var Dir = "/perm/var/fspd"
+
+// energyWindows are those of the psuN.p_in_avg_WINDOW.units.W and
+// p_out_avg averages.
+var energyWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+}
+
+// meter totals the energy of READ_EIN or READ_EOUT readings.
+type meter struct {
+	Wh    float64
+	last  pmbus.Energy
+	lastT time.Time
+	valid bool
+	// hist has the totals of the last 15 minutes for the averages
+	hist []energySample
+}
+
+type energySample struct {
+	t  time.Time
+	wh float64
+}
+
+// update adds the energy of the average power since the last reading, e, at
+// t, of the DIRECT coefficients d. It returns false until the average has
+// new samples.
+func (m *meter) update(e pmbus.Energy, t time.Time, d pmbus.Direct) bool {
+	if m.valid {
+		w, ok := e.Power(m.last, d)
+		if !ok {
+			return false
+		}
+		m.Wh += w * t.Sub(m.lastT).Hours()
+	}
+	m.last, m.lastT, m.valid = e, t, true
+	m.hist = append(m.hist, energySample{t, m.Wh})
+	max := energyWindows[len(energyWindows)-1].d
+	for len(m.hist) > 2 && t.Sub(m.hist[1].t) >= max {
+		m.hist = m.hist[1:]
+	}
+	return true
+}
+
+// reset discards the last reading, e.g. of a PSU that lost power and its
+// accumulator.
+func (m *meter) reset() { m.valid = false }
+
+// average returns the average power of the window d before t, or that of
+// the readings since if less than d, false without two readings.
+func (m *meter) average(t time.Time, d time.Duration) (float64, bool) {
+	n := len(m.hist)
+	if n < 2 {
+		return 0, false
+	}
+	i := 0
+	for i < n-2 && t.Sub(m.hist[i+1].t) >= d {
+		i++
+	}
+	h := m.hist[n-1].t.Sub(m.hist[i].t).Hours()
+	if h <= 0 {
+		return 0, false
+	}
+	return (m.hist[n-1].wh - m.hist[i].wh) / h, true
+}
+
+// updateEnergy publishes psuN.energy_in.units.Wh, energy_out and the
+// average powers of each powered PSU, saving the totals every
+// energySaveInterval.
+func (c *Command) updateEnergy() {
+	if c.energy == nil {
+		c.energy = make(map[string]*meter)
+		totals, err := loadEnergy()
+		if err != nil && !os.IsNotExist(err) {
+			log.Print("warning: fspd: ", err)
+		}
+		for k, wh := range totals {
+			c.energy[k] = &meter{Wh: wh}
+		}
+		c.energySaved = time.Now()
+	}
+	now := time.Now()
+	for i := range Vdev {
+		h := &Vdev[i]
+		if h.Slot == 0 {
+			continue
+		}
+		psu := "psu" + strconv.Itoa(h.Slot)
+		for _, x := range []struct {
+			name string
+			cmd  uint8
+			p    string
+		}{
+			{"energy_in", pmbus.ReadEin, "p_in"},
+			{"energy_out", pmbus.ReadEout, "p_out"},
+		} {
+			k := psu + "." + x.name + ".units.Wh"
+			m := c.energy[k]
+			if m == nil {
+				m = new(meter)
+				c.energy[k] = m
+			}
+			p := h.Profile()
+			if !h.present() || !h.powered() || !h.supports(x.cmd) {
+				m.reset()
+				continue
+			}
+			d := pmbus.Dev{Bus: h.Bus, Addr: h.Addr}
+			e, err := d.Energy(x.cmd)
+			if err != nil {
+				h.nak(x.cmd, err)
+				m.reset()
+				continue
+			}
+			if !m.update(e, now, p.Energy) {
+				continue
+			}
+			c.publishf(k, m.Wh)
+			for _, w := range energyWindows {
+				if p, ok := m.average(now, w.d); ok {
+					c.publishf(psu+"."+x.p+"_avg_"+w.name+
+						".units.W", p)
+				}
+			}
+		}
+	}
+	if time.Since(c.energySaved) >= energySaveInterval {
+		c.saveEnergy()
+	}
+}
+
+// saveEnergy writes the totals to flash.
+func (c *Command) saveEnergy() {
+	totals := make(map[string]float64)
+	for k, m := range c.energy {
+		totals[k] = m.Wh
+	}
+	if err := saveEnergy(totals); err != nil {
+		log.Print("warning: fspd: ", err)
+	}
+	c.energySaved = time.Now()
+}
+
+// publishf prints a changed value of k with 3 decimals.
+// removeEnergy deletes the energy and average power keys of a removed PSU
+// and its meters' readings, keeping their totals.
+func (c *Command) removeEnergy(psu string) {
+	for _, x := range []struct{ name, p string }{
+		{"energy_in", "p_in"},
+		{"energy_out", "p_out"},
+	} {
+		k := psu + "." + x.name + ".units.Wh"
+		if m := c.energy[k]; m != nil {
+			m.reset()
+			m.hist = nil
+		}
+		keys := []string{k}
+		for _, w := range energyWindows {
+			keys = append(keys, psu+"."+x.p+"_avg_"+w.name+".units.W")
+		}
+		for _, k := range keys {
+			if _, found := c.lasts[k]; found {
+				c.pub.Print("delete: ", k)
+				delete(c.lasts, k)
+			}
+		}
+	}
+}
+
+func (c *Command) publishf(k string, v float64) {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	if s != c.lasts[k] {
+		c.pub.Print(k, ": ", s)
+		c.lasts[k] = s
+	}
+}
+
+// powered returns whether the PSU's PWROK is high.
+func (h *I2cDev) powered() bool {
+	pin, found := gpio.FindPin(h.GpioPwrok)
+	if !found {
+		return false
+	}
+	t, err := pin.Value()
+	return err == nil && t
+}
+
+func loadEnergy() (map[string]float64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(Dir, energyFileName))
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]float64)
+	return totals, json.Unmarshal(b, &totals)
+}
+
+// saveEnergy replaces the totals file so that a reboot while writing
+// leaves the previous totals.
+func saveEnergy(totals map[string]float64) error {
+	b, err := json.MarshalIndent(totals, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(Dir, 0755); err != nil {
+		return err
+	}
+	fn := filepath.Join(Dir, energyFileName)
+	err = ioutil.WriteFile(fn+".tmp", append(b, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fn+".tmp", fn)
+}
@@ -9,7 +9,6 @@ package fspd
 import (
 	"encoding/hex"
 	"fmt"
-	"math"
 	"net/rpc"
 	"strconv"
 	"strings"
@@ -19,22 +18,32 @@ import (
 
 	"github.com/platinasystems/atsock"
 	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/pmbus"
+	"github.com/platinasystems/goes-bmc/sel"
 	"github.com/platinasystems/goes/cmd"
 	"github.com/platinasystems/goes/external/redis"
 	"github.com/platinasystems/goes/external/redis/publisher"
 	"github.com/platinasystems/goes/external/redis/rpc/args"
 	"github.com/platinasystems/goes/external/redis/rpc/reply"
 	"github.com/platinasystems/goes/lang"
-	"github.com/platinasystems/gpio"
 	"github.com/platinasystems/i2c"
 	"github.com/platinasystems/log"
 )
 
 var (
-	Vdev [2]I2cDev
+	// Vdev are the configured PSUs.
+	Vdev []I2cDev
 
+	// VpageByKey maps each key to the index of its PSU in Vdev.
 	VpageByKey map[string]uint8
 
+	// Thresholds are the alarm limits of the published keys.
+	Thresholds map[string]alarm.Threshold
+
 	WrRegDv  = make(map[string]string)
 	WrRegFn  = make(map[string]string)
 	WrRegVal = make(map[string]string)
@@ -43,10 +52,38 @@ var (
 	command *Command
 )
 
-const (
-	nFanTrays = 4
-	nPSUs     = 2
-)
+// psuKeys are those of each PSU, less the psuN. prefix. All but status and
+// admin.state are deleted on removal.
+var psuKeys = []string{
+	"status",
+	"admin.state",
+	"eeprom",
+	"sn",
+	"fan_direction",
+	"mfg_id",
+	"mfg_model",
+	"profile",
+	"operation",
+	"fan_override.percent",
+	"fan_speed.units.rpm",
+	"i_out.units.A",
+	"v_in.units.V",
+	"v_out.units.V",
+	"p_in.units.W",
+	"p_out.units.W",
+	"temp1.units.C",
+	"temp2.units.C",
+}
+
+// Keys returns the keys of the PSU in slot, which fspd publishes when they
+// are in VpageByKey.
+func Keys(slot int) []string {
+	keys := make([]string, len(psuKeys))
+	for i, k := range psuKeys {
+		keys[i] = "psu" + strconv.Itoa(slot) + "." + k
+	}
+	return keys
+}
 
 type Command struct {
 	Info
@@ -55,12 +92,24 @@ type Command struct {
 }
 
 type Info struct {
-	mutex sync.Mutex
-	rpc   *atsock.RpcServer
-	pub   *publisher.Publisher
-	last  map[string]float64
-	lasts map[string]string
-	lastu map[string]uint16
+	mutex     sync.Mutex
+	rpc       *atsock.RpcServer
+	pub       *publisher.Publisher
+	i2cErrors i2creq.ErrorCount
+	alarms    *alarm.Engine
+	last      map[string]float64
+	lasts     map[string]string
+	lastu     map[string]uint16
+	// energy has the meters of the psuN.energy_in and energy_out keys
+	energy      map[string]*meter
+	energySaved time.Time
+	// watching is whether the PSU interrupts are watched, so that
+	// the status is only polled each statusPollInterval
+	watching     bool
+	statusPolled time.Time
+	// rated has the output ratings of the PSUs by slot
+	rated    map[int]float64
+	overload bool
 }
 
 type I2cDev struct {
@@ -77,6 +126,9 @@ type I2cDev struct {
 	GpioIntL   string
 	Update     [3]bool
 	Delete     bool
+	profile    *Profile
+	// naked are the commands that the PSU NAKed since insertion.
+	naked map[uint8]bool
 }
 
 func (*Command) String() string { return "fspd" }
@@ -109,6 +161,8 @@ func (c *Command) Main(...string) error {
 	if c.pub, err = publisher.New(); err != nil {
 		return err
 	}
+	c.i2cErrors.Name = "fspd"
+	c.alarms = alarm.New("fspd", c.pub, Thresholds)
 
 	if err = syscall.Sysinfo(&si); err != nil {
 		return err
@@ -126,23 +180,31 @@ func (c *Command) Main(...string) error {
 		}
 	}
 
+	events := watch()
+	c.watching = events != nil
+
 	t := time.NewTicker(1 * time.Second)
 	tm := time.NewTicker(5 * time.Second)
 	for {
 		select {
 		case <-goes.Stop:
+			if c.energy != nil {
+				c.saveEnergy()
+			}
 			return nil
 		case <-t.C:
 			c.update()
 		case <-tm.C:
 			c.updateMon()
+		case names := <-events:
+			c.interrupt(names)
 		}
 	}
 }
 
 func (c *Command) update() error {
-	stopped := readStopped()
-	if stopped == 1 {
+	c.i2cErrors.Publish(c.pub)
+	if i2creq.Stopped() {
 		return nil
 	}
 	if err := writeRegs(); err != nil {
@@ -150,18 +212,20 @@ func (c *Command) update() error {
 	}
 
 	for k, i := range VpageByKey {
+		// exact, the other psuN.status_* keys are those of updateMon
+		psu := "psu" + strconv.Itoa(Vdev[i].Slot)
 		pin, found := gpio.FindPin(Vdev[i].GpioPrsntL)
 		t, err := pin.Value()
 		if !found || err != nil || t {
 			//not present
-			if strings.Contains(k, "status") {
+			if k == psu+".status" {
 				v := Vdev[i].PsuStatus()
 				if v != c.lasts[k] {
 					c.pub.Print(k, ": ", v)
 					c.lasts[k] = v
 				}
 			}
-			if strings.Contains(k, "admin.state") {
+			if k == psu+".admin.state" {
 				v := Vdev[i].GetAdminState()
 				if v != c.lasts[k] {
 					c.pub.Print(k, ": ", v)
@@ -169,45 +233,30 @@ func (c *Command) update() error {
 				}
 			}
 			if Vdev[i].Delete {
-				k := "psu" + strconv.Itoa(Vdev[i].Slot) + ".eeprom"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".sn"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".fan_speed.units.rpm"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".i_out.units.A"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".mfg_id"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".mfg_model"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".p_in.units.W"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".p_out.units.W"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".temp1.units.C"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".temp2.units.C"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".fan_direction"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".v_out.units.V"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
-				k = "psu" + strconv.Itoa(Vdev[i].Slot) + ".v_in.units.V"
-				c.pub.Print("delete: ", k)
-				c.lasts[k] = ""
+				// all but status and admin.state
+				for _, k := range Keys(Vdev[i].Slot)[2:] {
+					c.pub.Print("delete: ", k)
+					c.lasts[k] = ""
+				}
+				for _, f := range fruKeys {
+					k := psu + ".fru." + f
+					if _, found := c.lasts[k]; found {
+						c.pub.Print("delete: ", k)
+						delete(c.lasts, k)
+					}
+				}
+				for _, k := range []string{psu + ".faults",
+					psu + ".warnings"} {
+					c.pub.Print("delete: ", k)
+					delete(c.lasts, k)
+				}
+				c.removeEnergy(psu)
+				c.alarms.Clear("psu" + strconv.Itoa(Vdev[i].Slot) + ".")
+				delete(c.rated, Vdev[i].Slot)
+				Vdev[i].removed()
+				sel.Print("fspd", sel.Warning,
+					"psu"+strconv.Itoa(Vdev[i].Slot)+".status",
+					"psu", Vdev[i].Slot, " removed")
 				Vdev[i].Delete = false
 			}
 
@@ -216,14 +265,18 @@ func (c *Command) update() error {
 			}
 		} else {
 			//present
-			if strings.Contains(k, "status") {
+			if k == psu+".status" {
 				v := Vdev[i].PsuStatus()
 				if v != c.lasts[k] {
+					if c.lasts[k] == "not_installed" {
+						sel.Print("fspd", sel.Notice, k,
+							"psu", Vdev[i].Slot, " installed")
+					}
 					c.pub.Print(k, ": ", v)
 					c.lasts[k] = v
 				}
 			}
-			if strings.Contains(k, "admin.state") {
+			if k == psu+".admin.state" {
 				v := Vdev[i].GetAdminState()
 				if v != c.lasts[k] {
 					c.pub.Print(k, ": ", v)
@@ -258,33 +311,28 @@ func (c *Command) update() error {
 			}
 			if Vdev[i].Update[2] {
 				if strings.Contains(k, "eeprom") {
-					v, err := Vdev[i].Eeprom()
+					b, err := Vdev[i].eeprom()
 					if err != nil {
 						return err
 					}
+					v := hex.EncodeToString(b)
 					if v != c.lasts[k] {
 						c.pub.Print(k, ": ", v)
 						c.lasts[k] = v
 					}
 					Vdev[i].Update[2] = false
-					var d string
-					if v[0x38:0x3a] == "52" {
-						d = "back->front"
-					} else {
-						d = "front->back"
-					}
-					k = strings.Replace(k, "eeprom", "fan_direction", 1)
-					if d != c.lasts[k] {
-						c.pub.Print(k, ": ", d)
-						c.lasts[k] = d
+					psu := "psu" + strconv.Itoa(Vdev[i].Slot)
+					p := Vdev[i].identify(b)
+					kk := psu + ".profile"
+					if p.Name != c.lasts[kk] {
+						c.pub.Print(kk, ": ", p.Name)
+						c.lasts[kk] = p.Name
 					}
-					sb, err := hex.DecodeString(v[0x5a:0x76])
-					sn := string(sb)
-					if err == nil {
-						k = strings.Replace(k, "fan_direction", "sn", 1)
-						if sn != c.lasts[k] {
-							c.pub.Print(k, ": ", sn)
-							c.lasts[k] = sn
+					for kk, vv := range eepromKeys(psu, p, b) {
+						kk = psu + "." + kk
+						if vv != c.lasts[kk] {
+							c.pub.Print(kk, ": ", vv)
+							c.lasts[kk] = vv
 						}
 					}
 				}
@@ -295,13 +343,18 @@ func (c *Command) update() error {
 }
 
 func (c *Command) updateMon() error {
-	stopped := readStopped()
-	if stopped == 1 {
+	if i2creq.Stopped() {
 		return nil
 	}
 	if err := writeRegs(); err != nil {
 		return err
 	}
+	if !c.watching || time.Since(c.statusPolled) >= statusPollInterval {
+		c.updateStatus()
+		c.statusPolled = time.Now()
+	}
+	c.updateEnergy()
+	c.updateRedundancy()
 
 	for k, i := range VpageByKey {
 
@@ -309,7 +362,7 @@ func (c *Command) updateMon() error {
 		t, err := pin.Value()
 		if !found || err != nil || t {
 			// PSU not present
-			return nil
+			continue
 		} else {
 			// PSU present
 			if Vdev[i].Id != "" {
@@ -372,6 +425,7 @@ func (c *Command) updateMon() error {
 						c.pub.Print(k, ": ", v)
 						c.lasts[k] = v
 					}
+					c.alarms.UpdateString(k, v)
 				}
 				if strings.Contains(k, "i_in") {
 					v, err := Vdev[i].Iin()
@@ -392,6 +446,7 @@ func (c *Command) updateMon() error {
 						c.pub.Print(k, ": ", v)
 						c.lasts[k] = v
 					}
+					c.alarms.UpdateString(k, v)
 				}
 				if strings.Contains(k, "i_out") {
 					v, err := Vdev[i].Iout()
@@ -463,6 +518,23 @@ func (c *Command) updateMon() error {
 						c.lastu[k] = v
 					}
 				}
+				// PSUs without OPERATION or FAN_COMMAND_1
+				// NAK once, leaving operation unpublished
+				// and fan_override.percent as set
+				if strings.HasSuffix(k, ".operation") {
+					v, err := Vdev[i].Operation()
+					if err == nil && v != c.lasts[k] {
+						c.pub.Print(k, ": ", v)
+						c.lasts[k] = v
+					}
+				}
+				if strings.Contains(k, "fan_override") {
+					v, err := Vdev[i].FanOverride()
+					if err == nil && v != c.lasts[k] {
+						c.pub.Print(k, ": ", v)
+						c.lasts[k] = v
+					}
+				}
 				if strings.Contains(k, "pmbus_rev") {
 					v, err := Vdev[i].PMBusRev()
 					if err != nil {
@@ -492,6 +564,7 @@ func (c *Command) updateMon() error {
 						c.pub.Print(k, ": ", v)
 						c.lasts[k] = v
 					}
+					c.alarms.UpdateString(k, v)
 				}
 				if strings.Contains(k, "temp2") {
 					v, err := Vdev[i].Temp2()
@@ -502,6 +575,7 @@ func (c *Command) updateMon() error {
 						c.pub.Print(k, ": ", v)
 						c.lasts[k] = v
 					}
+					c.alarms.UpdateString(k, v)
 				}
 				if strings.Contains(k, "fan_speed.units.rpm") {
 					v, err := Vdev[i].FanSpeed()
@@ -512,6 +586,7 @@ func (c *Command) updateMon() error {
 						c.pub.Print(k, ": ", v)
 						c.lasts[k] = v
 					}
+					c.alarms.UpdateString(k, v)
 				}
 			}
 
@@ -520,95 +595,23 @@ func (c *Command) updateMon() error {
 	return nil
 }
 
-func (h *I2cDev) convertVoutMode(voutMode uint8, vout uint16) float64 {
-	var nn float64
-	n := voutMode & 0x1f
-	if n > 0xf {
-		n = ((n ^ 0x1f) + 1) & 0x1f
-		nn = float64(n) * (-1)
-	} else {
-		nn = float64(n)
-	}
-	vv := (float64(vout) * (math.Exp2(nn)))
-	vv, _ = strconv.ParseFloat(fmt.Sprintf("%.3f", vv), 64)
-	return vv
-}
-
-func (h *I2cDev) convertLinear(v uint16) (float64, error) {
-	var nn int
-	var y int
-	if (v >> 11) > 0xf {
-		nn = int(((v>>11)^0x1f)+1) * (-1)
-	} else {
-		nn = int(v >> 11)
-	}
-	v = v & 0x7ff
-	if v > 0x3ff {
-		y = int(v^0x7ff+1) * (-1)
-	} else {
-		y = int(v)
-	}
-	vv := float64(y) * (math.Exp2(float64(nn)))
-	vv, _ = strconv.ParseFloat(fmt.Sprintf("%.3f", vv), 64)
-	return vv, nil
-}
-
-func (h *I2cDev) convert(v uint16) (float64, error) {
-	if strings.Contains(h.Id, "Great Wall") {
-		var nn int
-		var y int
-		if (v >> 11) > 0xf {
-			nn = int(((v>>11)^0x1f)+1) * (-1)
-		} else {
-			nn = int(v >> 11)
-		}
-		v = v & 0x7ff
-		if v > 0x3ff {
-			y = int(v^0x7ff+1) * (-1)
-		} else {
-			y = int(v)
-		}
-		vv := float64(y) * (math.Exp2(float64(nn)))
-		vv, _ = strconv.ParseFloat(fmt.Sprintf("%.3f", vv), 64)
-		return vv, nil
-	} else if strings.Contains(h.Id, "FSP") {
-		r := getRegs()
-		var nn float64
-		r.VoutMode.get(h)
-		err := DoI2cRpc()
-		if err != nil {
-			return 0, err
-		}
-		n := (uint16(s[0].D[0])) & 0x1f
-		if n > 0xf {
-			n = ((n ^ 0x1f) + 1) & 0x1f
-			nn = float64(n) * (-1)
-		} else {
-			nn = float64(n)
-		}
-		vv := (float64(v) * (math.Exp2(nn)))
-		vv, _ = strconv.ParseFloat(fmt.Sprintf("%.3f", vv), 64)
-		return vv, nil
-	} else {
-		return 0, nil
-	}
-}
-
 func (h *I2cDev) Page() (uint16, error) {
 	r := getRegs()
-	r.Page.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Page.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) PageWr(i uint16) error {
 	r := getRegs()
-	r.Page.set(h, uint8(i))
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Page.set(&tr, h, uint8(i))
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
@@ -617,268 +620,245 @@ func (h *I2cDev) PageWr(i uint16) error {
 
 func (h *I2cDev) StatusWord() (uint16, error) {
 	r := getRegs()
-	r.StatusWord.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.StatusWord.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
+	t := tr.Word(0)
 	return uint16(t), nil
 }
 
 func (h *I2cDev) StatusVout() (uint16, error) {
 	r := getRegs()
-	r.StatusVout.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.StatusVout.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) StatusIout() (uint16, error) {
 	r := getRegs()
-	r.StatusIout.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.StatusIout.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) StatusInput() (uint16, error) {
 	r := getRegs()
-	r.StatusInput.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.StatusInput.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) StatusTemp() (uint16, error) {
 	r := getRegs()
-	r.StatusTemp.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.StatusTemp.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) StatusFans() (uint16, error) {
 	r := getRegs()
-	r.StatusFans.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.StatusFans.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) Vin() (string, error) {
 	r := getRegs()
-	r.Vin.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Vin.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	v, errs := h.convert(t)
-	if errs != nil {
-		return "", errs
+	v, err := h.value(pmbus.ReadVin, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) Iin() (string, error) {
 	r := getRegs()
-	r.Iin.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Iin.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	v, errs := h.convert(t)
-	if errs != nil {
-		return "", errs
+	v, err := h.value(pmbus.ReadIin, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) Vout() (string, error) {
 	r := getRegs()
-	r.Vout.get(h)
-	r.VoutMode.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Vout.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	vout := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	voutMode := uint8(s[1].D[0])
-	var v float64
-	var errs error
-	if !strings.Contains(h.Model, "CRPS800") {
-		v = h.convertVoutMode(voutMode, vout)
-	} else {
-		v, errs = h.convert(vout)
-		if errs != nil {
-			return "", errs
-		}
+	v, err := h.value(pmbus.ReadVout, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
-
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) Iout() (string, error) {
 	r := getRegs()
-	r.Iout.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Iout.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	var v float64
-	if strings.Contains(h.Id, "Great Wall") {
-		v, err = h.convert(t)
-		if err != nil {
-			return "", err
-		}
-	} else if strings.Contains(h.Id, "FSP") {
-		v, err = h.convertLinear(t)
-		if err != nil {
-			return "", err
-		}
+	v, err := h.value(pmbus.ReadIout, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) Temp1() (string, error) {
 	r := getRegs()
-	r.Temp1.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Temp1.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	var v float64
-	if strings.Contains(h.Id, "Great Wall") {
-		v, err = h.convert(t)
-		if err != nil {
-			return "", err
-		}
-	} else if strings.Contains(h.Id, "FSP") {
-		v = float64(t)
+	v, err := h.value(pmbus.ReadTemp1, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) Temp2() (string, error) {
 	r := getRegs()
-	r.Temp2.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Temp2.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	var v float64
-	if strings.Contains(h.Id, "Great Wall") {
-		v, err = h.convert(t)
-		if err != nil {
-			return "", err
-		}
-	} else if strings.Contains(h.Id, "FSP") {
-		v = float64(t)
+	v, err := h.value(pmbus.ReadTemp2, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) FanSpeed() (string, error) {
 	r := getRegs()
-	r.FanSpeed.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.FanSpeed.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	var v float64
-	if strings.Contains(h.Id, "Great Wall") {
-		v, err = h.convert(t)
-		if err != nil {
-			return "", err
-		}
-	} else if strings.Contains(h.Id, "FSP") {
-		v = float64(t)
+	v, err := h.value(pmbus.ReadFanSpeed1, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 0, 64), nil
 }
 
 func (h *I2cDev) Pout() (string, error) {
 	r := getRegs()
-	r.Pout.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Pout.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	v, errs := h.convert(t)
-	if errs != nil {
-		return "", errs
+	v, err := h.value(pmbus.ReadPout, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) Pin() (string, error) {
 	r := getRegs()
-	r.Pin.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Pin.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
-	v, errs := h.convert(t)
-	if errs != nil {
-		return "", errs
+	v, err := h.value(pmbus.ReadPin, tr.Word(0))
+	if err != nil {
+		return "", err
 	}
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
 
 func (h *I2cDev) PoutRaw() (uint16, error) {
 	r := getRegs()
-	r.Pout.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Pout.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
+	t := tr.Word(0)
 	return t, nil
 }
 
 func (h *I2cDev) PinRaw() (uint16, error) {
 	r := getRegs()
-	r.Pin.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Pin.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
+	t := tr.Word(0)
 	return t, nil
 }
 
 func (h *I2cDev) ModeRaw() (uint16, error) {
 	if h.Id == "Great Wall" {
 		r := getRegs()
-		r.Pin.get(h)
-		err := DoI2cRpc()
+		var tr i2creq.Trans
+		r.Pin.get(&tr, h)
+		err := tr.Do()
 		if err != nil {
 			return 0, err
 		}
-		t := uint16(s[0].D[0]) + (uint16(s[0].D[1]) << 8)
+		t := tr.Word(0)
 		return t, nil
 	} else {
 		return 0, nil
@@ -887,29 +867,31 @@ func (h *I2cDev) ModeRaw() (uint16, error) {
 
 func (h *I2cDev) PMBusRev() (uint16, error) {
 	r := getRegs()
-	r.PMBusRev.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.PMBusRev.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	t := uint16(s[0].D[0])
+	t := uint16(tr.Byte(0))
 	return uint16(t), nil
 }
 
 func (h *I2cDev) MfgIdent() (string, error) {
 	var l byte = 15
 	r := getRegs()
-	r.MfgId.get(h, l)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.MfgId.get(&tr, h, l)
+	err := tr.Do()
 	if err != nil {
 		return "error", err
 	}
-	if s[0].D[1] == 0xff {
+	if tr.D(0)[1] == 0xff {
 		h.Id = "FSP"
 		return "FSP", nil
 	}
-	n := s[0].D[1] + 2
-	t := string(s[0].D[2:n])
+	n := tr.D(0)[1] + 2
+	t := string(tr.D(0)[2:n])
 	if t == "Not Supported" {
 		t = "FSP"
 	}
@@ -921,16 +903,17 @@ func (h *I2cDev) MfgIdent() (string, error) {
 func (h *I2cDev) MfgModel() (string, error) {
 	var l byte = 15
 	r := getRegs()
-	r.MfgMod.get(h, l)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.MfgMod.get(&tr, h, l)
+	err := tr.Do()
 	if err != nil {
 		return "error", err
 	}
-	if s[0].D[1] == 0xff {
+	if tr.D(0)[1] == 0xff {
 		return "FSP", nil
 	}
-	n := s[0].D[1] + 2
-	t := string(s[0].D[2:n])
+	n := tr.D(0)[1] + 2
+	t := string(tr.D(0)[2:n])
 	if t == "Not Supported" {
 		t = "FSP"
 	}
@@ -940,20 +923,27 @@ func (h *I2cDev) MfgModel() (string, error) {
 }
 
 func (h *I2cDev) Eeprom() (string, error) {
-	var v string
+	b, err := h.eeprom()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (h *I2cDev) eeprom() ([]byte, error) {
+	var b []byte
 
 	for n := 0; n < 8; n++ {
 		r := getRegsE()
-		r.block[n].get(h)
-		err := DoI2cRpc()
+		var tr i2creq.Trans
+		r.block[n].get(&tr, h)
+		err := tr.Do()
 		if err != nil {
-			return "", err
-		}
-		for k := 1; k <= i2c.SMBusMax; k++ {
-			v += fmt.Sprintf("%02x", s[0].D[k])
+			return nil, err
 		}
+		b = append(b, tr.D(0)[1:1+i2c.SMBusMax]...)
 	}
-	return v, nil
+	return b, nil
 }
 
 func (h *I2cDev) PsuStatus() string {
@@ -1021,40 +1011,6 @@ func (h *I2cDev) GetAdminState() string {
 	return "enabled"
 }
 
-func powerCycle() error {
-
-	stopI2c()
-	time.Sleep(500 * time.Millisecond)
-
-	log.Print("initiate manual power cycle")
-	pin, found := gpio.FindPin("PSU0_PWRON_L")
-	if found {
-		pin.SetValue(true)
-	}
-	pin, found = gpio.FindPin("PSU1_PWRON_L")
-	if found {
-		pin.SetValue(true)
-	}
-	time.Sleep(1 * time.Second)
-	pin, found = gpio.FindPin("PSU0_PWRON_L")
-	if found {
-		pin.SetValue(false)
-	}
-	pin, found = gpio.FindPin("PSU1_PWRON_L")
-	if found {
-		pin.SetValue(false)
-	}
-	time.Sleep(1 * time.Second)
-	pin, found = gpio.FindPin("ETHX_RST_L")
-	if found {
-		pin.SetValue(false)
-		time.Sleep(50 * time.Millisecond)
-		pin.SetValue(true)
-	}
-	startI2c()
-	return nil
-}
-
 func writeRegs() error {
 	for k, v := range WrRegVal {
 		switch WrRegFn[k] {
@@ -1065,13 +1021,39 @@ func writeRegs() error {
 			}
 		case "powercycle":
 			if v == "true" {
-				powerCycle()
+				_, err := redis.Hset(redis.DefaultHash,
+					chassisd.Field,
+					chassisd.Cycle+" psu.powercycle")
+				if err != nil {
+					log.Print("warning: psu.powercycle: ", err)
+				}
+			}
+		case "clear_faults":
+			if h := bySlot(k); h != nil && v == "true" {
+				if err := h.ClearFaults(); err != nil {
+					log.Print("warning: ", k, ": ", err)
+				} else {
+					log.Print("notice: psu", h.Slot, " faults cleared")
+				}
 			}
 		case "admin.state":
-			if strings.Contains(k, "psu1") {
-				Vdev[1].SetAdminState(v)
-			} else if strings.Contains(k, "psu2") {
-				Vdev[0].SetAdminState(v)
+			if h := bySlot(k); h != nil {
+				h.SetAdminState(v)
+			}
+		case "operation":
+			if h := bySlot(k); h != nil {
+				if err := h.SetOperation(v); err != nil {
+					log.Print("warning: ", k, ": ", err)
+				}
+			}
+		case "fan_override":
+			h := bySlot(k)
+			pct, err := strconv.Atoi(v)
+			if h != nil && err == nil {
+				err = h.SetFanOverride(pct)
+			}
+			if err != nil {
+				log.Print("warning: ", k, ": ", err)
 			}
 		}
 		delete(WrRegVal, k)
@@ -1132,8 +1114,19 @@ func (i *Info) Hset(args args.Hset, reply *reply.Hset) error {
 		WrRegRng[args.Field])
 }
 
+// actions are the WrRegFn of the fields that request an action rather
+// than set a state, so their values aren't published; that of operation is
+// once read back from the PSU.
+var actions = map[string]bool{
+	"powercycle":   true,
+	"clear_faults": true,
+	"operation":    true,
+}
+
 func (i *Info) set(key, value string, isReadyEvent bool) error {
-	i.pub.Print(key, ": ", value)
+	if !actions[WrRegFn[key]] {
+		i.pub.Print(key, ": ", value)
+	}
 	return nil
 }
 
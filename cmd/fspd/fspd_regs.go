@@ -22,7 +22,11 @@ type regs struct {
 	_           [0x1c * 2]byte
 	VoutMode    reg8 // 0x20
 	_           byte
-	_           [0x58 * 2]byte
+	_           [0x19 * 2]byte
+	FanConfig   reg8 // 0x3a
+	_           byte
+	FanCommand1 reg16r // 0x3b
+	_           [0x3d * 2]byte
 	StatusWord  reg16r // 0x79
 	StatusVout  reg8   // 0x7a
 	_           byte
@@ -0,0 +1,571 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2csim"
+	"github.com/platinasystems/goes-bmc/pmbus"
+)
+
+func TestVout(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	sim.PSU[1].SetWord(0x8b, 0x1833) // 12.1V with VOUT_MODE 0x17
+	for i, want := range []string{"12.000", "12.100"} {
+		h := &I2cDev{Bus: 12 + i, Addr: 0x58, AddrProm: 0x50}
+		if id, err := h.MfgIdent(); err != nil || id != "Great Wall" {
+			t.Errorf("psu %d MfgIdent: %q, %v", i, id, err)
+		}
+		if v, err := h.Vout(); err != nil || v != want {
+			t.Errorf("psu %d Vout: %q, %v, want %q", i, v, err, want)
+		}
+		if v, err := h.Vin(); err != nil || v != "230.000" {
+			t.Errorf("psu %d Vin: %q, %v", i, v, err)
+		}
+	}
+
+	sim.Remove(13, 0x58)
+	h := &I2cDev{Bus: 13, Addr: 0x58, AddrProm: 0x50}
+	if _, err := h.Vout(); err == nil {
+		t.Error("Vout of removed PSU succeeded")
+	}
+}
+
+func newFakeGpio() *gpio.Fake {
+	f := gpio.NewFake()
+	for _, psu := range []string{"PSU0", "PSU1"} {
+		f.Add(psu+"_PRSNT_L", "in")
+		f.Add(psu+"_PWROK", "in")
+		f.Add(psu+"_PWRON_L", "low")
+		f.Add(psu+"_INT_L", "in")
+	}
+	f.Add("ETHX_RST_L", "high")
+	return f
+}
+
+func TestPsuHotPlug(t *testing.T) {
+	f := newFakeGpio()
+	gpio.Default = f
+	defer func() { gpio.Default = gpio.Sysfs{} }()
+
+	h := &I2cDev{Slot: 2, GpioPwrok: "PSU0_PWROK",
+		GpioPrsntL: "PSU0_PRSNT_L", GpioPwronL: "PSU0_PWRON_L"}
+	f.Set("PSU0_PRSNT_L", true)
+	if s := h.PsuStatus(); s != "not_installed" || h.Delete {
+		t.Fatalf("empty slot: %q, delete %t", s, h.Delete)
+	}
+
+	f.Set("PSU0_PRSNT_L", false)
+	if s := h.PsuStatus(); s != "powered_off" {
+		t.Errorf("inserted: %q", s)
+	}
+	if h.Update != [3]bool{true, true, true} {
+		t.Errorf("inserted: update %v", h.Update)
+	}
+	f.Set("PSU0_PWROK", true)
+	if s := h.PsuStatus(); s != "powered_on" {
+		t.Errorf("powered: %q", s)
+	}
+
+	f.Set("PSU0_PRSNT_L", true)
+	if s := h.PsuStatus(); s != "not_installed" || !h.Delete {
+		t.Errorf("removed: %q, delete %t", s, h.Delete)
+	}
+
+	h.SetAdminState("disable")
+	if !f.Get("PSU0_PWRON_L") || h.GetAdminState() != "disabled" {
+		t.Error("disable didn't raise PWRON_L")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	h := &I2cDev{Slot: 2, Bus: 12, Addr: 0x58}
+	if s, err := h.Status(); err != nil || s.Faults != nil ||
+		s.Warnings != nil {
+		t.Errorf("no faults: %+v, %v", s, err)
+	}
+
+	// VOUT and INPUT summaries, and VOUT_OV_FAULT repeated in STATUS_WORD
+	sim.PSU[0].SetWord(0x79, 1<<15|1<<13|1<<5)
+	sim.PSU[0].SetByte(0x7a, 0xc0)
+	sim.PSU[0].SetByte(0x7c, 0x02)
+	// not summarized, so not read
+	sim.PSU[0].SetByte(0x81, 0x80)
+	s, err := h.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Faults, []string{"VOUT_OV_FAULT"}) ||
+		!reflect.DeepEqual(s.Warnings,
+			[]string{"IIN_OC_WARNING", "VOUT_OV_WARNING"}) {
+		t.Errorf("%+v", s)
+	}
+
+	sim.PSU[0].SetWord(0x79, 1<<10|1<<2)
+	sim.PSU[0].SetByte(0x7d, 0x40)
+	if s, err = h.Status(); err != nil ||
+		!reflect.DeepEqual(s.Faults, []string{"FAN_1_FAULT"}) ||
+		!reflect.DeepEqual(s.Warnings, []string{"OT_WARNING"}) {
+		t.Errorf("%+v, %v", s, err)
+	}
+
+	if got := newFlags("FAN_1_FAULT", []string{"FAN_1_FAULT",
+		"OT_FAULT"}); !reflect.DeepEqual(got, []string{"OT_FAULT"}) {
+		t.Errorf("newFlags: %q", got)
+	}
+}
+
+func TestClearFaults(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	defer func(v []I2cDev) { Vdev = v }(Vdev)
+	Vdev = []I2cDev{
+		{Slot: 2, Bus: 12, Addr: 0x58},
+		{Slot: 1, Bus: 13, Addr: 0x58},
+	}
+	WrRegFn["psu1.clear_faults"] = "clear_faults"
+	defer delete(WrRegFn, "psu1.clear_faults")
+
+	WrRegVal["psu1.clear_faults"] = "true"
+	if err := writeRegs(); err != nil {
+		t.Fatal(err)
+	}
+	if n := sim.PSU[1].Sent(0x03); n != 1 {
+		t.Errorf("psu1 CLEAR_FAULTS sent %d times", n)
+	}
+	if n := sim.PSU[0].Sent(0x03); n != 0 {
+		t.Errorf("psu2 CLEAR_FAULTS sent %d times", n)
+	}
+}
+
+// newFru returns a FRU EEPROM of a product area with the 8-bit ASCII
+// fields f.
+func newFru(f ...string) []byte {
+	a := []byte{1, 0, 0x19}
+	for _, s := range f {
+		a = append(append(a, 0xc0|byte(len(s))), s...)
+	}
+	a = append(a, 0xc1)
+	for (len(a)+1)%8 != 0 {
+		a = append(a, 0)
+	}
+	a[1] = byte((len(a) + 1) / 8)
+	b := append([]byte{1, 0, 0, 0, 1, 0, 0, 0xfe}, a...)
+	var sum byte
+	for _, c := range a {
+		sum += c
+	}
+	return append(b, -sum)
+}
+
+func TestEeprom(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	// the layout of the PSUs without a FRU EEPROM
+	sim.PSUProm[0].Set(0x1c, 'R')
+	sim.PSUProm[0].Set(0x2d, []byte("GW550A12345678")...)
+	h := &I2cDev{Slot: 2, Bus: 12, Addr: 0x58, AddrProm: 0x50}
+	b, err := h.eeprom()
+	if err != nil || len(b) != 256 {
+		t.Fatalf("eeprom: %d bytes, %v", len(b), err)
+	}
+	want := map[string]string{
+		"fan_direction": "back->front",
+		"sn":            "GW550A12345678",
+	}
+	p := lookupProfile("Great Wall", "CRPS550")
+	if got := eepromKeys("psu2", p, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("legacy: %v", got)
+	}
+
+	b = newFru("FSP Group", "FSP550", "9PA5500201", "A1", "S0123456789",
+		"", "")
+	sim.PSUProm[0].Set(0, b...)
+	if b, err = h.eeprom(); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]string{
+		"fru.manufacturer": "FSP Group",
+		"fru.part_number":  "9PA5500201",
+		"fru.serial":       "S0123456789",
+		"fru.version":      "A1",
+		"fan_direction":    "front->back",
+		"sn":               "S0123456789",
+	}
+	p = lookupProfile("FSP Group", "FSP550")
+	if got := eepromKeys("psu2", p, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("FRU: %v", got)
+	}
+}
+
+func TestMeter(t *testing.T) {
+	m := &meter{Wh: 100}
+	d := pmbus.Direct{M: 1}
+	t0 := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	// 360W, 1000 samples of 360 per reading, each 10s apart
+	e := pmbus.Energy{Accumulator: 1000}
+	for i := 0; i <= 180; i++ {
+		if !m.update(e, t0.Add(time.Duration(i)*10*time.Second), d) {
+			t.Fatalf("update %d", i)
+		}
+		acc := uint32(e.Accumulator) + 360000
+		e.Rollovers += uint8(acc / pmbus.EnergyRollover)
+		e.Accumulator = uint16(acc % pmbus.EnergyRollover)
+		e.Samples += 1000
+	}
+	// 30 minutes of 360W
+	if math.Abs(m.Wh-280) > 1e-6 {
+		t.Errorf("%v Wh", m.Wh)
+	}
+	now := t0.Add(30 * time.Minute)
+	for _, w := range energyWindows {
+		if p, ok := m.average(now, w.d); !ok || math.Abs(p-360) > 1e-6 {
+			t.Errorf("%s average: %v, %t", w.name, p, ok)
+		}
+	}
+	if len(m.hist) > 92 {
+		t.Errorf("%d samples kept", len(m.hist))
+	}
+	if m.update(m.last, now.Add(10*time.Second), d) {
+		t.Error("update without samples")
+	}
+
+	// the accumulator of a PSU that lost power starts over
+	m.reset()
+	if !m.update(pmbus.Energy{}, now.Add(5*time.Minute), d) ||
+		math.Abs(m.Wh-280) > 1e-6 {
+		t.Errorf("after reset: %v Wh", m.Wh)
+	}
+	if p, _ := m.average(now.Add(5*time.Minute), time.Minute); p != 0 {
+		t.Errorf("1m average while off: %v", p)
+	}
+}
+
+func TestEnergyUnsupported(t *testing.T) {
+	sim := i2csim.StartTest(t)
+	sim.PSU[0].Unsupport(0x86)
+	sim.PSU[0].Unsupport(0x87)
+	f := newFakeGpio()
+	f.Set("PSU0_PWROK", true)
+	gpio.Default = f
+	defer func() { gpio.Default = gpio.Sysfs{} }()
+	defer func(dir string) { Dir = dir }(Dir)
+	Dir = t.TempDir()
+	defer func(v []I2cDev) { Vdev = v }(Vdev)
+	Vdev = []I2cDev{{Slot: 2, Id: "Great Wall", Bus: 12, Addr: 0x58,
+		GpioPrsntL: "PSU0_PRSNT_L", GpioPwrok: "PSU0_PWROK"}}
+
+	c := new(Command)
+	for i := 0; i < 3; i++ {
+		c.updateEnergy()
+	}
+	for _, cmd := range []uint8{0x86, 0x87} {
+		if n := sim.PSU[0].Naks(cmd); n != 1 {
+			t.Errorf("%#x NAKed %d times", cmd, n)
+		}
+	}
+}
+
+func TestEnergyFile(t *testing.T) {
+	defer func(dir string) { Dir = dir }(Dir)
+	Dir = filepath.Join(t.TempDir(), "fspd")
+	if _, err := loadEnergy(); !os.IsNotExist(err) {
+		t.Errorf("load without file: %v", err)
+	}
+	want := map[string]float64{
+		"psu1.energy_in.units.Wh":  1234.5,
+		"psu1.energy_out.units.Wh": 1111,
+	}
+	if err := saveEnergy(want); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := loadEnergy(); err != nil || !reflect.DeepEqual(got,
+		want) {
+		t.Errorf("%v, %v", got, err)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	f := newFakeGpio()
+	f.Add("PSU2_PWRON_L", "low")
+	gpio.Default = f
+	defer func() { gpio.Default = gpio.Sysfs{} }()
+
+	defer func(v []I2cDev) { Vdev = v }(Vdev)
+	Vdev = []I2cDev{
+		{Slot: 2, GpioPwronL: "PSU0_PWRON_L"},
+		{Slot: 1, GpioPwronL: "PSU1_PWRON_L"},
+		{Slot: 3, GpioPwronL: "PSU2_PWRON_L"},
+	}
+	keys := Keys(3)
+	if len(keys) != len(psuKeys) || keys[0] != "psu3.status" ||
+		keys[len(keys)-1] != "psu3.temp2.units.C" {
+		t.Errorf("Keys: %q", keys)
+	}
+
+	WrRegFn["psu3.admin.state"] = "admin.state"
+	defer delete(WrRegFn, "psu3.admin.state")
+	WrRegVal["psu3.admin.state"] = "disable"
+	if err := writeRegs(); err != nil {
+		t.Fatal(err)
+	}
+	if !f.Get("PSU2_PWRON_L") || f.Get("PSU0_PWRON_L") ||
+		f.Get("PSU1_PWRON_L") {
+		t.Error("psu3 disable")
+	}
+	if h := bySlot("psu1.admin.state"); h != &Vdev[1] {
+		t.Errorf("psu1 is %+v", h)
+	}
+	if h := bySlot("psu13.admin.state"); h != nil {
+		t.Errorf("psu13 is %+v", h)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f := newFakeGpio()
+	gpio.Default = f
+	defer func() { gpio.Default = gpio.Sysfs{} }()
+
+	defer func(v []I2cDev) { Vdev = v }(Vdev)
+	Vdev = []I2cDev{
+		{Slot: 2, GpioPrsntL: "PSU0_PRSNT_L", GpioIntL: "PSU0_INT_L"},
+		{Slot: 1, GpioPrsntL: "PSU1_PRSNT_L", GpioIntL: "PSU1_INT_L"},
+		{Slot: 3, GpioPrsntL: "PSU2_PRSNT_L"},
+	}
+	names := watchPins()
+	if !reflect.DeepEqual(names, []string{"PSU0_PRSNT_L", "PSU0_INT_L",
+		"PSU1_PRSNT_L", "PSU1_INT_L"}) {
+		t.Fatalf("watchPins: %q", names)
+	}
+	w, err := gpio.Watch(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got, err := w.Wait(10 * time.Millisecond); err != nil || got != nil {
+		t.Errorf("no edge: %q, %v", got, err)
+	}
+	f.Set("PSU1_INT_L", true)
+	f.Set("PSU0_PWROK", true)
+	f.Set("PSU0_PRSNT_L", true)
+	got, err := w.Wait(time.Second)
+	if err != nil || !reflect.DeepEqual(got,
+		[]string{"PSU1_INT_L", "PSU0_PRSNT_L"}) {
+		t.Errorf("edges: %q, %v", got, err)
+	}
+	if _, err := gpio.Watch("PSU2_PRSNT_L"); err == nil {
+		t.Error("watched a missing pin")
+	}
+}
+
+func TestRedundancy(t *testing.T) {
+	for _, x := range []struct {
+		n       int
+		ratings []float64
+		load    float64
+		want    string
+	}{
+		{2, []float64{550, 550}, 300, Redundant},
+		{2, []float64{550, 550}, 600, NonRedundant},
+		{2, []float64{0, 550}, 100, NonRedundant},
+		{2, []float64{0, 0}, 0, NonRedundant},
+		{2, []float64{550}, 300, Degraded},
+		{1, []float64{550}, 300, NonRedundant},
+		{2, nil, 0, Lost},
+	} {
+		if got := redundancy(x.n, x.ratings, x.load); got != x.want {
+			t.Errorf("%d %v %v: %s, want %s", x.n, x.ratings, x.load,
+				got, x.want)
+		}
+	}
+
+	sim := i2csim.StartTest(t)
+
+	c := &Command{}
+	c.lasts = make(map[string]string)
+	h := &I2cDev{Slot: 2, Id: "Great Wall", Model: "CRPS550", Bus: 12,
+		Addr: 0x58}
+	if pin, pout, err := h.power(); err != nil || pin != 330 ||
+		pout != 300 {
+		t.Errorf("power: %v, %v, %v", pin, pout, err)
+	}
+	if w := c.rating(h); w != 550 {
+		t.Errorf("model rating: %v", w)
+	}
+	// reinserted with MFR_POUT_MAX
+	delete(c.rated, 2)
+	h.removed()
+	sim.PSU[0].SetWord(0xa7, i2csim.Linear11(1600))
+	if w := c.rating(h); w != 1600 {
+		t.Errorf("MFR_POUT_MAX rating: %v", w)
+	}
+	h = &I2cDev{Slot: 1, Bus: 13, Addr: 0x58, Id: "Unknown"}
+	if w := c.rating(h); w != 0 {
+		t.Errorf("unknown rating: %v", w)
+	}
+
+	// a model without a rating that NAKs MFR_POUT_MAX is asked once
+	sim.PSU[1].Unsupport(0xa7)
+	h = &I2cDev{Slot: 1, Bus: 13, Addr: 0x58, Id: "Great Wall"}
+	delete(c.rated, 1)
+	for i := 0; i < 3; i++ {
+		if w := c.rating(h); w != 0 {
+			t.Errorf("NAKed rating: %v", w)
+		}
+	}
+	if n := sim.PSU[1].Naks(0xa7); n != 1 {
+		t.Errorf("MFR_POUT_MAX NAKed %d times", n)
+	}
+}
+
+func TestOperation(t *testing.T) {
+	sim := i2csim.StartTest(t)
+	f := newFakeGpio()
+	gpio.Default = f
+	defer func() { gpio.Default = gpio.Sysfs{} }()
+
+	h := &I2cDev{Slot: 2, Id: "Great Wall", Bus: 12, Addr: 0x58,
+		GpioPwronL: "PSU0_PWRON_L"}
+	if err := h.SetOperation("margin_low"); err != nil {
+		t.Fatal(err)
+	}
+	if v := sim.PSU[0].Byte(0x02); v != 0x1f {
+		t.Errorf("ON_OFF_CONFIG %#x", v)
+	}
+	if s, err := h.Operation(); err != nil || s != "margin_low" {
+		t.Errorf("Operation: %q, %v", s, err)
+	}
+	if err := h.SetOperation("off"); err != nil || f.Get("PSU0_PWRON_L") {
+		t.Errorf("off: %v, PWRON_L %t", err, f.Get("PSU0_PWRON_L"))
+	}
+	if s, err := h.Operation(); err != nil || s != "off" {
+		t.Errorf("Operation: %q, %v", s, err)
+	}
+	if err := h.SetOperation("standby"); err == nil {
+		t.Error("invalid operation")
+	}
+
+	// NAKs fall back to PWRON_L
+	sim.PSU[0].Unsupport(0x01)
+	if err := h.SetOperation("off"); err != nil || !f.Get("PSU0_PWRON_L") {
+		t.Errorf("gpio off: %v", err)
+	}
+	if err := h.SetOperation("margin_high"); err == nil {
+		t.Error("margin without OPERATION")
+	}
+
+	sim.PSU[0].SetByte(0x3a, 0xd0)
+	if err := h.SetFanOverride(60); err != nil {
+		t.Fatal(err)
+	}
+	if v := sim.PSU[0].Byte(0x3a); v != 0x90 {
+		t.Errorf("FAN_CONFIG_1_2 %#x", v)
+	}
+	if s, err := h.FanOverride(); err != nil || s != "60" {
+		t.Errorf("FanOverride: %q, %v", s, err)
+	}
+	if err := h.SetFanOverride(101); err == nil {
+		t.Error("fan override 101%")
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	sim := i2csim.StartTest(t)
+	for _, cmd := range []uint8{0x01, 0x3a, 0x3b} {
+		sim.PSU[0].Unsupport(cmd)
+	}
+
+	h := &I2cDev{Slot: 2, Id: "Great Wall", Bus: 12, Addr: 0x58}
+	for i := 0; i < 3; i++ {
+		if _, err := h.Operation(); err == nil {
+			t.Error("OPERATION of a PSU without it")
+		}
+		if _, err := h.FanOverride(); err == nil {
+			t.Error("FAN_COMMAND_1 of a PSU without it")
+		}
+		h.SetFanOverride(50)
+	}
+	for _, cmd := range []uint8{0x01, 0x3b} {
+		if n := sim.PSU[0].Naks(cmd); n != 1 {
+			t.Errorf("%#x NAKed %d times", cmd, n)
+		}
+	}
+	if n := sim.PSU[0].Naks(0x3a); n != 0 {
+		t.Errorf("FAN_CONFIG_1_2 read without FAN_COMMAND_1, %d NAKs", n)
+	}
+
+	// a PSU inserted in its place may support them
+	h.removed()
+	sim.PSU[0].SetByte(0x01, 0x80)
+	if s, err := h.Operation(); err != nil || s != "on" {
+		t.Errorf("Operation after reinsertion: %q, %v", s, err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	for _, x := range []struct {
+		id, model, want string
+	}{
+		{"Great Wall", "CRPS550", "Great Wall CRPS550"},
+		{"Great Wall", "CRPS1300", "Great Wall"},
+		{"Acme", "CRPS800", "CRPS800"},
+		{"FSP Group", "FSP550", "FSP"},
+	} {
+		if p := lookupProfile(x.id, x.model); p == nil || p.Name != x.want {
+			t.Errorf("%s %s: %+v", x.id, x.model, p)
+		}
+	}
+	if p := lookupProfile("Acme", "PS1000"); p != nil {
+		t.Errorf("Acme PS1000: %+v", p)
+	}
+
+	sim := i2csim.StartTest(t)
+
+	// identified by the FRU EEPROM if MFR_ID and MFR_MODEL don't match
+	sim.PSU[0].SetBlock(0x99, []byte("Acme"))
+	sim.PSU[0].SetBlock(0x9a, []byte("PS1000"))
+	h := &I2cDev{Slot: 2, Bus: 12, Addr: 0x58}
+	b := newFru("FSP Group", "FSP550", "9PA5500201", "A1", "S0123456789",
+		"", "")
+	if p := h.identify(b); p.Name != "FSP" || h.Profile() != p {
+		t.Errorf("FRU: %+v", p)
+	}
+	sim.PSU[0].SetWord(0x8d, 41)
+	if v, err := h.Temp1(); err != nil || v != "41.000" {
+		t.Errorf("FSP Temp1: %q, %v", v, err)
+	}
+
+	p := h.identify(make([]byte, 256))
+	if p.Name != "unknown" || p.supports(pmbus.Operation) ||
+		!p.supports(pmbus.ReadPout) {
+		t.Errorf("unknown: %+v", p)
+	}
+	if v, err := h.Pout(); err != nil || v != "300.000" {
+		t.Errorf("unknown Pout: %q, %v", v, err)
+	}
+	if err := h.SetFanOverride(50); err != errUnsupported {
+		t.Errorf("unknown SetFanOverride: %v", err)
+	}
+	if m := eepromKeys("psu2", p, make([]byte, 256)); len(m) != 0 {
+		t.Errorf("unknown eeprom: %v", m)
+	}
+
+	d := &Profile{Format: FormatDirect,
+		Coefficients: pmbus.Direct{M: 2, R: 1}}
+	h.profile = d
+	if v, err := h.value(pmbus.ReadPin, 8000); err != nil || v != 400 {
+		t.Errorf("direct: %v, %v", v, err)
+	}
+}
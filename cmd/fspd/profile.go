@@ -0,0 +1,248 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/fru"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/pmbus"
+	"github.com/platinasystems/log"
+)
+
+// Format is that of the words of the READ_* commands.
+type Format int
+
+const (
+	FormatLinear11 Format = iota
+	// FormatVoutMode is LINEAR16, or DIRECT, as given by VOUT_MODE.
+	FormatVoutMode
+	FormatDirect
+	// FormatInteger is an unscaled integer.
+	FormatInteger
+)
+
+var errUnsupported = errors.New("unsupported by the PSU profile")
+
+// Profile describes the PMBus and EEPROM of a PSU model.
+type Profile struct {
+	// Name is published as psuN.profile.
+	Name string
+	// Id is contained in MFR_ID, or the FRU manufacturer, and Model is
+	// MFR_MODEL, or the FRU part number; "" matches any.
+	Id, Model string
+	// Format is that of the READ_* commands other than those of
+	// Formats. READ_VOUT is FormatVoutMode unless in Formats.
+	Format  Format
+	Formats map[uint8]Format
+	// Coefficients are those of FormatDirect.
+	Coefficients pmbus.Direct
+	// Energy has the DIRECT coefficients of READ_EIN and READ_EOUT, of
+	// watts.
+	Energy pmbus.Direct
+	Eeprom eepromLayout
+	// Airflow is the fan_direction of a model with one airflow,
+	// otherwise that is from the Eeprom layout.
+	Airflow string
+	// RatedPower is the output rating in watts, if there's no
+	// MFR_POUT_MAX.
+	RatedPower float64
+	// Commands are those supported of a model that doesn't support
+	// all of the standard commands used by fspd.
+	Commands []uint8
+}
+
+// legacyLayout is that of the EEPROM of the PSUs first supported.
+var legacyLayout = eepromLayout{Airflow: 0x1c, Serial: 0x2d, SerialEnd: 0x3b}
+
+// profiles are matched in order.
+var profiles = []Profile{
+	{
+		Name:       "CRPS800",
+		Model:      "CRPS800",
+		Format:     FormatLinear11,
+		Formats:    map[uint8]Format{pmbus.ReadVout: FormatLinear11},
+		Energy:     pmbus.Direct{M: 1},
+		Eeprom:     legacyLayout,
+		RatedPower: 800,
+	},
+	{
+		Name:       "Great Wall CRPS550",
+		Id:         "Great Wall",
+		Model:      "CRPS550",
+		Format:     FormatLinear11,
+		Energy:     pmbus.Direct{M: 1},
+		Eeprom:     legacyLayout,
+		RatedPower: 550,
+	},
+	{
+		Name:   "Great Wall",
+		Id:     "Great Wall",
+		Format: FormatLinear11,
+		Energy: pmbus.Direct{M: 1},
+		Eeprom: legacyLayout,
+	},
+	{
+		Name:   "FSP",
+		Id:     "FSP",
+		Format: FormatVoutMode,
+		Formats: map[uint8]Format{
+			pmbus.ReadIout:      FormatLinear11,
+			pmbus.ReadTemp1:     FormatInteger,
+			pmbus.ReadTemp2:     FormatInteger,
+			pmbus.ReadFanSpeed1: FormatInteger,
+		},
+		Energy: pmbus.Direct{M: 1},
+		Eeprom: legacyLayout,
+	},
+}
+
+// unknownProfile is that of other models: standard LINEAR11 readings,
+// status and CLEAR_FAULTS, but no energy, control or EEPROM layout.
+var unknownProfile = Profile{
+	Name:   "unknown",
+	Format: FormatLinear11,
+	Commands: []uint8{
+		pmbus.ClearFaults,
+		pmbus.VoutMode,
+		pmbus.StatusWord,
+		pmbus.StatusVout,
+		pmbus.StatusIout,
+		pmbus.StatusInput,
+		pmbus.StatusTemp,
+		pmbus.StatusFans12,
+		pmbus.ReadVin,
+		pmbus.ReadIin,
+		pmbus.ReadVout,
+		pmbus.ReadIout,
+		pmbus.ReadTemp1,
+		pmbus.ReadTemp2,
+		pmbus.ReadFanSpeed1,
+		pmbus.ReadPout,
+		pmbus.ReadPin,
+		pmbus.MfrId,
+		pmbus.MfrModel,
+	},
+}
+
+// lookupProfile returns the first profile of id and model, or nil.
+func lookupProfile(id, model string) *Profile {
+	for i := range profiles {
+		p := &profiles[i]
+		if strings.Contains(id, p.Id) &&
+			(p.Model == "" || p.Model == model) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Profile returns that identified on insertion or, before then, that of
+// the last MFR_ID and MFR_MODEL read.
+func (h *I2cDev) Profile() *Profile {
+	if h.profile != nil {
+		return h.profile
+	}
+	if p := lookupProfile(h.Id, h.Model); p != nil {
+		return p
+	}
+	return &unknownProfile
+}
+
+// identify sets the profile of h from MFR_ID and MFR_MODEL or, lacking a
+// match, the FRU manufacturer and part number of the EEPROM b.
+func (h *I2cDev) identify(b []byte) *Profile {
+	id, _ := h.MfgIdent()
+	model, _ := h.MfgModel()
+	p := lookupProfile(id, model)
+	if p == nil {
+		if info, err := fru.Parse(b); err == nil {
+			f := info.Fields()
+			p = lookupProfile(f["manufacturer"], f["part_number"])
+		}
+	}
+	if p == nil {
+		log.Print("notice: psu", h.Slot, " unknown model ", id, " ",
+			model)
+		p = &unknownProfile
+	}
+	h.profile = p
+	return p
+}
+
+func (p *Profile) format(cmd uint8) Format {
+	if f, found := p.Formats[cmd]; found {
+		return f
+	}
+	if cmd == pmbus.ReadVout {
+		return FormatVoutMode
+	}
+	return p.Format
+}
+
+// supports returns whether the model supports the command cmd.
+func (p *Profile) supports(cmd uint8) bool {
+	if p.Commands == nil {
+		return true
+	}
+	for _, c := range p.Commands {
+		if c == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// supports returns whether the profile of h has the command cmd and the PSU
+// hasn't NAKed it since insertion.
+func (h *I2cDev) supports(cmd uint8) bool {
+	return h.Profile().supports(cmd) && !h.naked[cmd]
+}
+
+// nak returns err, first recording cmd as unsupported until removal if err
+// is the PSU's rather than that of the connection to i2cd.
+func (h *I2cDev) nak(cmd uint8, err error) error {
+	var e *i2creq.OpError
+	if !errors.As(err, &e) || h.naked[cmd] {
+		return err
+	}
+	if h.naked == nil {
+		h.naked = make(map[uint8]bool)
+	}
+	h.naked[cmd] = true
+	log.Print("notice: psu", h.Slot, " unsupported command ",
+		fmt.Sprintf("%#02x", cmd))
+	return err
+}
+
+// removed forgets the profile and NAKed commands of a removed PSU.
+func (h *I2cDev) removed() {
+	h.profile = nil
+	h.naked = nil
+}
+
+// value returns the value of the word v of the READ_* command cmd in the
+// format of the PSU's profile.
+func (h *I2cDev) value(cmd uint8, v uint16) (float64, error) {
+	p := h.Profile()
+	switch p.format(cmd) {
+	case FormatVoutMode:
+		r := getRegs()
+		var tr i2creq.Trans
+		r.VoutMode.get(&tr, h)
+		if err := tr.Do(); err != nil {
+			return 0, err
+		}
+		return pmbus.Vout(v, tr.Byte(0), p.Coefficients)
+	case FormatDirect:
+		return p.Coefficients.Value(v), nil
+	case FormatInteger:
+		return float64(v), nil
+	}
+	return pmbus.Linear11(v), nil
+}
@@ -0,0 +1,151 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"strconv"
+
+	"github.com/platinasystems/goes-bmc/pmbus"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/log"
+)
+
+// Redundancy states of power.redundancy
+const (
+	Redundant    = "redundant"
+	NonRedundant = "non-redundant"
+	Degraded     = "degraded"
+	Lost         = "lost"
+)
+
+// redundancy returns the power.redundancy state of n configured PSUs of
+// which those with the ratings are supplying the load. A rating of 0 is
+// unknown, so that redundancy can't be claimed.
+func redundancy(n int, ratings []float64, load float64) string {
+	switch {
+	case len(ratings) == 0:
+		return Lost
+	case len(ratings) < n:
+		return Degraded
+	case len(ratings) < 2:
+		return NonRedundant
+	}
+	var sum, max float64
+	for _, w := range ratings {
+		if w == 0 {
+			return NonRedundant
+		}
+		sum += w
+		if w > max {
+			max = w
+		}
+	}
+	if load > sum-max {
+		return NonRedundant
+	}
+	return Redundant
+}
+
+// updateRedundancy publishes power.redundancy and the total power.p_in,
+// p_out, capacity and headroom of the PSUs that are powered without faults,
+// warning of a load exceeding a single PSU.
+func (c *Command) updateRedundancy() {
+	var n int
+	var ratings []float64
+	var pin, pout, capacity, single float64
+	for i := range Vdev {
+		h := &Vdev[i]
+		if h.Slot == 0 {
+			continue
+		}
+		n++
+		psu := "psu" + strconv.Itoa(h.Slot)
+		if !h.present() || !h.powered() || c.lasts[psu+".faults"] != "" {
+			continue
+		}
+		in, out, err := h.power()
+		if err != nil {
+			continue
+		}
+		w := c.rating(h)
+		ratings = append(ratings, w)
+		pin += in
+		pout += out
+		capacity += w
+		if w > single {
+			single = w
+		}
+	}
+	state := redundancy(n, ratings, pout)
+	k := "power.redundancy"
+	if last, found := c.lasts[k]; state != last {
+		if found {
+			level := sel.Warning
+			if state == Redundant {
+				level = sel.Notice
+			}
+			sel.Print("fspd", level, k, "power ", state)
+		}
+		c.pub.Print(k, ": ", state)
+		c.lasts[k] = state
+	}
+	c.publishf("power.p_in.units.W", pin)
+	c.publishf("power.p_out.units.W", pout)
+	c.publishf("power.capacity.units.W", capacity)
+	c.publishf("power.headroom.units.W", capacity-pout)
+
+	overload := single > 0 && pout > single
+	if overload && !c.overload {
+		log.Print("warning: fspd: load ", int(pout),
+			"W exceeds a single PSU's ", int(single), "W")
+	}
+	c.overload = overload
+}
+
+// rating returns the output rating of h from MFR_POUT_MAX or, lacking that,
+// its profile, 0 if unknown. Ratings, known or not, are kept until removal
+// unless MFR_POUT_MAX failed for other than a NAK.
+func (c *Command) rating(h *I2cDev) float64 {
+	if w, found := c.rated[h.Slot]; found {
+		return w
+	}
+	w := h.Profile().RatedPower
+	keep := true
+	if h.supports(pmbus.MfrPoutMax) {
+		d := pmbus.Dev{Bus: h.Bus, Addr: h.Addr}
+		pmax, err := d.Linear11(pmbus.MfrPoutMax)
+		switch {
+		case err != nil:
+			h.nak(pmbus.MfrPoutMax, err)
+			keep = !h.supports(pmbus.MfrPoutMax)
+		case pmax > 0:
+			w = pmax
+		}
+	}
+	if keep {
+		if c.rated == nil {
+			c.rated = make(map[int]float64)
+		}
+		c.rated[h.Slot] = w
+	}
+	return w
+}
+
+// power returns READ_PIN and READ_POUT.
+func (h *I2cDev) power() (pin, pout float64, err error) {
+	in, err := h.PinRaw()
+	if err != nil {
+		return
+	}
+	out, err := h.PoutRaw()
+	if err != nil {
+		return
+	}
+	if pin, err = h.value(pmbus.ReadPin, in); err != nil {
+		return
+	}
+	pout, err = h.value(pmbus.ReadPout, out)
+	return
+}
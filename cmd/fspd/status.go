@@ -0,0 +1,241 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/log"
+)
+
+// flag names a PMBus status bit, either a fault or a warning.
+type flag struct {
+	name  string
+	fault bool
+}
+
+// Status register bits, indexed by bit number, per PMBus part II section
+// 17. The STATUS_WORD bits that summarize another status register aren't
+// flags; they select which of those registers are read.
+var (
+	statusWordFlags = [16]flag{
+		7:  {"BUSY", true},
+		5:  {"VOUT_OV_FAULT", true},
+		4:  {"IOUT_OC_FAULT", true},
+		3:  {"VIN_UV_FAULT", true},
+		1:  {"CML_FAULT", true},
+		12: {"MFR_SPECIFIC_WARNING", false},
+		11: {"POWER_GOOD_NEGATED", false},
+		9:  {"OTHER_WARNING", false},
+		8:  {"UNKNOWN_FAULT", true},
+	}
+	statusVoutFlags = [8]flag{
+		{"VOUT_TRACKING_ERROR", true},
+		{"TOFF_MAX_WARNING", false},
+		{"TON_MAX_FAULT", true},
+		{"VOUT_MAX_MIN_WARNING", false},
+		{"VOUT_UV_FAULT", true},
+		{"VOUT_UV_WARNING", false},
+		{"VOUT_OV_WARNING", false},
+		{"VOUT_OV_FAULT", true},
+	}
+	statusIoutFlags = [8]flag{
+		{"POUT_OP_WARNING", false},
+		{"POUT_OP_FAULT", true},
+		{"POWER_LIMITING", false},
+		{"CURRENT_SHARE_FAULT", true},
+		{"IOUT_UC_FAULT", true},
+		{"IOUT_OC_WARNING", false},
+		{"IOUT_OC_LV_FAULT", true},
+		{"IOUT_OC_FAULT", true},
+	}
+	statusInputFlags = [8]flag{
+		{"PIN_OP_WARNING", false},
+		{"IIN_OC_WARNING", false},
+		{"IIN_OC_FAULT", true},
+		{"UNIT_OFF_LOW_VIN", true},
+		{"VIN_UV_FAULT", true},
+		{"VIN_UV_WARNING", false},
+		{"VIN_OV_WARNING", false},
+		{"VIN_OV_FAULT", true},
+	}
+	statusTempFlags = [8]flag{
+		4: {"UT_FAULT", true},
+		5: {"UT_WARNING", false},
+		6: {"OT_WARNING", false},
+		7: {"OT_FAULT", true},
+	}
+	statusFansFlags = [8]flag{
+		{"AIRFLOW_WARNING", false},
+		{"AIRFLOW_FAULT", true},
+		{"FAN_2_SPEED_OVERRIDDEN", false},
+		{"FAN_1_SPEED_OVERRIDDEN", false},
+		{"FAN_2_WARNING", false},
+		{"FAN_1_WARNING", false},
+		{"FAN_2_FAULT", true},
+		{"FAN_1_FAULT", true},
+	}
+)
+
+// STATUS_WORD bits summarizing the other status registers.
+const (
+	statusWordTemp  = 1 << 2
+	statusWordFans  = 1 << 10
+	statusWordInput = 1 << 13
+	statusWordIout  = 1 << 14
+	statusWordVout  = 1 << 15
+)
+
+// Status is the set of PMBus status flags of a PSU.
+type Status struct {
+	Faults   []string
+	Warnings []string
+}
+
+func (s *Status) add(flags []flag, v uint16) {
+	for bit, f := range flags {
+		if len(f.name) == 0 || v&(1<<uint(bit)) == 0 {
+			continue
+		}
+		if f.fault {
+			s.Faults = appendFlag(s.Faults, f.name)
+		} else {
+			s.Warnings = appendFlag(s.Warnings, f.name)
+		}
+	}
+}
+
+func appendFlag(l []string, name string) []string {
+	for _, s := range l {
+		if s == name {
+			return l
+		}
+	}
+	return append(l, name)
+}
+
+// Status reads STATUS_WORD and those of the other status registers that it
+// summarizes, and returns their flags in sorted order.
+func (h *I2cDev) Status() (Status, error) {
+	var s Status
+	w, err := h.StatusWord()
+	if err != nil {
+		return s, err
+	}
+	s.add(statusWordFlags[:], w)
+	for _, x := range []struct {
+		bit   uint16
+		read  func() (uint16, error)
+		flags []flag
+	}{
+		{statusWordVout, h.StatusVout, statusVoutFlags[:]},
+		{statusWordIout, h.StatusIout, statusIoutFlags[:]},
+		{statusWordInput, h.StatusInput, statusInputFlags[:]},
+		{statusWordTemp, h.StatusTemp, statusTempFlags[:]},
+		{statusWordFans, h.StatusFans, statusFansFlags[:]},
+	} {
+		if w&x.bit == 0 {
+			continue
+		}
+		v, err := x.read()
+		if err != nil {
+			return s, err
+		}
+		s.add(x.flags, v)
+	}
+	sort.Strings(s.Faults)
+	sort.Strings(s.Warnings)
+	return s, nil
+}
+
+// ClearFaults sends CLEAR_FAULTS, which clears the status registers until
+// the conditions recur.
+func (h *I2cDev) ClearFaults() error {
+	r := getRegs()
+	var tr i2creq.Trans
+	r.ClearFaults.send(&tr, h)
+	return tr.Do()
+}
+
+// updateStatus publishes the psuN.faults and psuN.warnings of each present
+// PSU, space separated, and logs the new flags.
+func (c *Command) updateStatus() {
+	for i := range Vdev {
+		c.updateStatusOf(&Vdev[i])
+	}
+}
+
+// updateStatusOf publishes the faults and warnings of the PSU h.
+func (c *Command) updateStatusOf(h *I2cDev) {
+	if h.Slot == 0 || !h.present() {
+		return
+	}
+	s, err := h.Status()
+	if err != nil {
+		return
+	}
+	psu := "psu" + strconv.Itoa(h.Slot)
+	faults := strings.Join(s.Faults, " ")
+	warnings := strings.Join(s.Warnings, " ")
+	k := psu + ".faults"
+	if _, found := c.lasts[k]; !found || faults != c.lasts[k] {
+		for _, f := range newFlags(c.lasts[k], s.Faults) {
+			sel.Print("fspd", sel.Critical, k, psu, " ", f)
+		}
+		c.pub.Print(k, ": ", faults)
+		c.lasts[k] = faults
+	}
+	k = psu + ".warnings"
+	if _, found := c.lasts[k]; !found || warnings != c.lasts[k] {
+		for _, w := range newFlags(c.lasts[k], s.Warnings) {
+			log.Print("warning: ", psu, " ", w)
+		}
+		c.pub.Print(k, ": ", warnings)
+		c.lasts[k] = warnings
+	}
+}
+
+// newFlags returns those of flags that weren't in the space separated last.
+func newFlags(last string, flags []string) []string {
+	var l []string
+	for _, f := range flags {
+		found := false
+		for _, s := range strings.Fields(last) {
+			if s == f {
+				found = true
+				break
+			}
+		}
+		if !found {
+			l = append(l, f)
+		}
+	}
+	return l
+}
+
+// present returns whether the PSU's PRSNT_L is low.
+func (h *I2cDev) present() bool {
+	pin, found := gpio.FindPin(h.GpioPrsntL)
+	if !found {
+		return false
+	}
+	t, err := pin.Value()
+	return err == nil && !t
+}
+
+// bySlot returns the Vdev of the psuN key prefix of k, or nil.
+func bySlot(k string) *I2cDev {
+	for i := range Vdev {
+		if strings.HasPrefix(k, "psu"+strconv.Itoa(Vdev[i].Slot)+".") {
+			return &Vdev[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fspd
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/log"
+)
+
+// statusPollInterval is that of reading the PMBus status of each PSU while
+// the interrupts are watched, in case of a missed edge.
+const statusPollInterval = time.Minute
+
+// watchPins returns the PRSNT_L and INT_L pins of the configured PSUs that
+// are in the device tree.
+func watchPins() []string {
+	var names []string
+	for _, h := range Vdev {
+		if h.Slot == 0 {
+			continue
+		}
+		for _, name := range []string{h.GpioPrsntL, h.GpioIntL} {
+			if _, found := gpio.FindPin(name); found {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// watch returns a channel of the PSU pins with an edge, until goes.Stop, or
+// nil if they can't be watched.
+func watch() <-chan []string {
+	names := watchPins()
+	if len(names) == 0 {
+		return nil
+	}
+	w, err := gpio.Watch(names...)
+	if err != nil {
+		log.Print("notice: fspd: polling, can't watch gpio: ", err)
+		return nil
+	}
+	c := make(chan []string)
+	go func() {
+		defer w.Close()
+		for {
+			names, err := w.Wait(time.Second)
+			if err != nil {
+				log.Print("warning: fspd: gpio watch: ", err)
+				return
+			}
+			if len(names) == 0 {
+				select {
+				case <-goes.Stop:
+					return
+				default:
+				}
+				continue
+			}
+			select {
+			case c <- names:
+			case <-goes.Stop:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// interrupt updates the presence of the PSUs on a PRSNT_L edge and
+// publishes the status of a PSU on an INT_L edge.
+func (c *Command) interrupt(names []string) {
+	presence := false
+	for _, name := range names {
+		for i := range Vdev {
+			h := &Vdev[i]
+			if h.Slot == 0 {
+				continue
+			}
+			switch name {
+			case h.GpioPrsntL:
+				presence = true
+			case h.GpioIntL:
+				c.updateStatusOf(h)
+				c.updateStatusWord(h)
+			}
+		}
+	}
+	if presence {
+		c.update()
+	}
+}
+
+// updateStatusWord publishes the psuN.status_word of h if it's a key.
+func (c *Command) updateStatusWord(h *I2cDev) {
+	k := "psu" + strconv.Itoa(h.Slot) + ".status_word"
+	if _, found := VpageByKey[k]; !found || !h.present() {
+		return
+	}
+	v, err := h.StatusWord()
+	if err != nil {
+		return
+	}
+	if v != c.lastu[k] {
+		c.pub.Print(k, ": ", v)
+		c.lastu[k] = v
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package i2cd serves the i2c transactions of the i2creq package, one at a
+// time under its bus mutex.
+//
+// It's that of goes, with the same bus recovery after a failed operation,
+// but for passing on all of the data of a block write rather than only its
+// first 4 bytes, e.g. for the 8 byte UCD9090 RUN_TIME_CLOCK.
+package i2cd
+
+import (
+	"fmt"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+	"github.com/platinasystems/i2c"
+	"github.com/platinasystems/ioport"
+	"github.com/platinasystems/log"
+)
+
+type Command struct{}
+
+func (Command) String() string { return "i2cd" }
+
+func (Command) Usage() string { return "i2cd" }
+
+func (Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "i2c server daemon",
+	}
+}
+
+func (Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c Command) Main(...string) error {
+	rpc.Register(&I2cReq{})
+	rpc.HandleHTTP()
+	srv := http.Server{
+		Addr: ":1233",
+	}
+	goes.WG.Add(1)
+	go func() {
+		defer goes.WG.Done()
+		srv.ListenAndServe()
+	}()
+	<-goes.Stop
+	return srv.Close()
+}
+
+type I2cReq struct{}
+
+var (
+	mutex   sync.Mutex
+	stopped byte
+)
+
+// ReadWrite executes the operations of g in order, stopping at the first
+// error, which names the failed op with i2creq.OpFailed. The pseudo busses of i2creq are only honored in the first op.
+func (*I2cReq) ReadWrite(g *[i2creq.MAXOPS]i2creq.I,
+	f *[i2creq.MAXOPS]i2creq.R) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if g[0].Bus == i2creq.StartStopBus {
+		stopped = byte(g[0].Addr)
+		return nil
+	}
+	if g[0].Bus == i2creq.StatusBus {
+		f[0].D[0] = stopped
+		return nil
+	}
+	for x := range g {
+		if !g[x].InUse {
+			continue
+		}
+		data, err := do(&g[x])
+		if err != nil {
+			for y := 0; y < x; y++ {
+				log.Printf("I2C R/W before Error: %s", op(&g[y]))
+			}
+			log.Printf("Error doing I2C R/W: %s: %v", op(&g[x]), err)
+			recoverBus()
+			return i2creq.OpFailed(x, err)
+		}
+		f[x].D[0] = data[0]
+		f[x].D[1] = data[1]
+		if g[x].BusSize == i2c.I2CBlockData {
+			copy(f[x].D[2:], data[2:])
+		}
+		if g[x].Delay > 0 {
+			time.Sleep(time.Duration(g[x].Delay) * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// do executes the operation o, passing on all of its data.
+var do = func(o *i2creq.I) (*i2c.SMBusData, error) {
+	var bus i2c.Bus
+	var data i2c.SMBusData
+	if err := bus.Open(o.Bus); err != nil {
+		return nil, err
+	}
+	defer bus.Close()
+	if err := bus.ForceSlaveAddress(o.Addr); err != nil {
+		return nil, err
+	}
+	copy(data[:], o.Data[:])
+	return &data, bus.Do(o.RW, o.RegOffset, o.BusSize, &data)
+}
+
+func op(o *i2creq.I) string {
+	return fmt.Sprintf("bus 0x%x addr 0x%x offset 0x%x data 0x%x RW %d "+
+		"BusSize %d delay %d", o.Bus, o.Addr, o.RegOffset, o.Data[0],
+		o.RW, o.BusSize, o.Delay)
+}
+
+// machine returns the machine published by the redisd hook.
+var machine = func() string {
+	m, _ := redis.Hget(redis.DefaultHash, "machine")
+	return m
+}
+
+// inb and outb are those of the ioports of the platina-mk1 host.
+var (
+	inb  = ioport.Inb
+	outb = ioport.Outb
+)
+
+// recoverBus resets the i2c muxes, or on the platina-mk1 host those of
+// ioport 0x603, to recover a bus that a device is holding.
+func recoverBus() {
+	switch machine() {
+	case "platina-mk1":
+		if d, err := inb(0x603); err == nil {
+			outb(0x603, d&0xb0)
+			time.Sleep(10 * time.Microsecond)
+			outb(0x603, d|0x40)
+		}
+	case "platina-mk1-bmc":
+		for _, name := range []string{
+			"FRU_I2C_MUX_RST_L",
+			"MAIN_I2C_MUX_RST_L",
+		} {
+			if pin, found := gpio.FindPin(name); found {
+				pin.SetValue(false)
+				time.Sleep(10 * time.Microsecond)
+				pin.SetValue(true)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package i2cd
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/i2c"
+)
+
+// fake replaces the i2c busses, NAKing the operations on bus nak.
+type fake struct {
+	ops []i2creq.I
+	nak int
+}
+
+func (f *fake) do(o *i2creq.I) (*i2c.SMBusData, error) {
+	f.ops = append(f.ops, *o)
+	if o.Bus == f.nak {
+		return nil, errors.New("no acknowledge")
+	}
+	var data i2c.SMBusData
+	copy(data[:], o.Data[:])
+	if o.RW == i2c.Read {
+		for k := range data {
+			data[k] = byte(o.RegOffset) + byte(k)
+		}
+	}
+	return &data, nil
+}
+
+func start(t *testing.T, machineName string) *fake {
+	f := &fake{nak: -1}
+	do0, machine0 := do, machine
+	do = f.do
+	machine = func() string { return machineName }
+	t.Cleanup(func() { do, machine = do0, machine0 })
+	return f
+}
+
+func TestBlock(t *testing.T) {
+	f := start(t, "")
+	var g [i2creq.MAXOPS]i2creq.I
+	var r [i2creq.MAXOPS]i2creq.R
+	clock := []byte{8, 1, 2, 3, 4, 5, 6, 7, 8}
+	g[0] = i2creq.I{InUse: true, RW: i2c.Write, RegOffset: 0xd7,
+		BusSize: i2c.I2CBlockData, Bus: 4, Addr: 0x34}
+	copy(g[0].Data[:], clock)
+	g[1] = i2creq.I{InUse: true, RW: i2c.Read, RegOffset: 0x10,
+		BusSize: i2c.I2CBlockData, Bus: 4, Addr: 0x34}
+	if err := new(I2cReq).ReadWrite(&g, &r); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.ops) != 2 || !reflect.DeepEqual(f.ops[0].Data[:len(clock)],
+		clock) {
+		t.Fatalf("ops %v", f.ops)
+	}
+	if r[1].D[0] != 0x10 || r[1].D[20] != 0x10+20 {
+		t.Errorf("block read %v", r[1].D[:24])
+	}
+}
+
+func TestStop(t *testing.T) {
+	start(t, "")
+	defer func() { stopped = 0 }()
+	var g [i2creq.MAXOPS]i2creq.I
+	var r [i2creq.MAXOPS]i2creq.R
+	g[0] = i2creq.I{InUse: true, Bus: i2creq.StartStopBus, Addr: 1}
+	if err := new(I2cReq).ReadWrite(&g, &r); err != nil {
+		t.Fatal(err)
+	}
+	g[0] = i2creq.I{InUse: true, Bus: i2creq.StatusBus}
+	if err := new(I2cReq).ReadWrite(&g, &r); err != nil || r[0].D[0] != 1 {
+		t.Errorf("status %d, %v", r[0].D[0], err)
+	}
+}
+
+func TestRecoverBus(t *testing.T) {
+	gf := gpio.NewFake()
+	gf.Add("FRU_I2C_MUX_RST_L", "high")
+	gf.Add("MAIN_I2C_MUX_RST_L", "high")
+	gpio.Default = gf
+	defer func() { gpio.Default = gpio.Sysfs{} }()
+
+	var g [i2creq.MAXOPS]i2creq.I
+	var r [i2creq.MAXOPS]i2creq.R
+	for n := range g[:3] {
+		g[n] = i2creq.I{InUse: true, RW: i2c.Read, BusSize: i2c.ByteData,
+			Bus: 11 + n, Addr: 0x2f}
+	}
+
+	f := start(t, "platina-mk1-bmc")
+	f.nak = 12
+	err := new(I2cReq).ReadWrite(&g, &r)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	if s := err.Error(); !strings.HasPrefix(s, "i2cd op 1: ") {
+		t.Errorf("error %q doesn't name op 1", s)
+	}
+	if len(f.ops) != 2 {
+		t.Errorf("ops after the failure: %v", f.ops)
+	}
+	ts := gf.Transitions()
+	if len(ts) != 4 || ts[0].Value || !ts[1].Value {
+		t.Errorf("mux resets %v", ts)
+	}
+
+	// the platina-mk1 host pulses bit 6 of ioport 0x603 low
+	var outs []byte
+	inb0, outb0 := inb, outb
+	defer func() { inb, outb = inb0, outb0 }()
+	inb = func(uint16) (byte, error) { return 0xf0, nil }
+	outb = func(addr uint16, b byte) error {
+		if addr == 0x603 {
+			outs = append(outs, b)
+		}
+		return nil
+	}
+	f = start(t, "platina-mk1")
+	f.nak = 11
+	gf.ClearTransitions()
+	if err := new(I2cReq).ReadWrite(&g, &r); err == nil {
+		t.Fatal("no error")
+	}
+	if !reflect.DeepEqual(outs, []byte{0xb0, 0xf0}) {
+		t.Errorf("ioport 0x603 %#x", outs)
+	}
+	if ts := gf.Transitions(); len(ts) != 0 {
+		t.Errorf("mux resets on the host %v", ts)
+	}
+}
@@ -0,0 +1,520 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/platform"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/log"
+)
+
+// Network functions.
+const (
+	netFnChassis = 0x00
+	netFnSensor  = 0x04
+	netFnApp     = 0x06
+	netFnStorage = 0x0a
+)
+
+// Completion codes, IPMI v2.0 table 5-2.
+const (
+	ccOk                = 0x00
+	ccInvalidCommand    = 0xc1
+	ccReservation       = 0xc5
+	ccDataLength        = 0xc7
+	ccNotPresent        = 0xcb
+	ccInvalidField      = 0xcc
+	ccPrivilege         = 0xd4
+	ccNotInPresentState = 0xd5
+	ccUnspecified       = 0xff
+
+	ccInvalidSessionID = 0x87
+	ccPrivilegeLimit   = 0x81
+)
+
+const channel = 0x01
+
+func cmdKey(netFn, cmd byte) uint16 { return uint16(netFn)<<8 | uint16(cmd) }
+
+type handler struct {
+	priv byte
+	fn   func(s *Server, sess *session, data []byte) (byte, []byte)
+}
+
+var handlers map[uint16]handler
+
+func init() {
+	handlers = map[uint16]handler{
+		cmdKey(netFnApp, 0x01):     {privUser, (*Server).getDeviceID},
+		cmdKey(netFnApp, 0x37):     {privUser, (*Server).getSystemGUID},
+		cmdKey(netFnApp, 0x38):     {privCallback, (*Server).getChannelAuthCaps},
+		cmdKey(netFnApp, 0x3b):     {privCallback, (*Server).setSessionPriv},
+		cmdKey(netFnApp, 0x3c):     {privCallback, (*Server).closeSession},
+		cmdKey(netFnApp, 0x54):     {privCallback, (*Server).getChannelCipherSuites},
+		cmdKey(netFnChassis, 0x01): {privUser, (*Server).chassisStatus},
+		cmdKey(netFnChassis, 0x02): {privOperator, (*Server).chassisControl},
+		cmdKey(netFnSensor, 0x27):  {privUser, (*Server).getSensorThresholds},
+		cmdKey(netFnSensor, 0x2d):  {privUser, (*Server).getSensorReading},
+		cmdKey(netFnStorage, 0x20): {privUser, (*Server).getSDRRepositoryInfo},
+		cmdKey(netFnStorage, 0x22): {privUser, (*Server).reserveSDRRepository},
+		cmdKey(netFnStorage, 0x23): {privUser, (*Server).getSDR},
+		cmdKey(netFnStorage, 0x40): {privUser, (*Server).getSELInfo},
+		cmdKey(netFnStorage, 0x42): {privUser, (*Server).reserveSEL},
+		cmdKey(netFnStorage, 0x43): {privUser, (*Server).getSELEntry},
+		cmdKey(netFnStorage, 0x47): {privOperator, (*Server).clearSEL},
+		cmdKey(netFnStorage, 0x48): {privUser, (*Server).getSELTime},
+	}
+}
+
+// sessionlessCmds may be sent outside of a session.
+var sessionlessCmds = map[uint16]bool{
+	cmdKey(netFnApp, 0x37): true,
+	cmdKey(netFnApp, 0x38): true,
+	cmdKey(netFnApp, 0x54): true,
+}
+
+func (s *Server) sessionless(m *message) []byte {
+	k := cmdKey(m.netFn, m.cmd)
+	if !sessionlessCmds[k] {
+		return m.response(ccPrivilege, nil)
+	}
+	cc, data := handlers[k].fn(s, nil, m.data)
+	return m.response(cc, data)
+}
+
+func (s *Server) command(sess *session, m *message) []byte {
+	h, found := handlers[cmdKey(m.netFn, m.cmd)]
+	if !found {
+		return m.response(ccInvalidCommand, nil)
+	}
+	if sess.priv < h.priv {
+		return m.response(ccPrivilege, nil)
+	}
+	cc, data := h.fn(s, sess, m.data)
+	return m.response(cc, data)
+}
+
+var versionRe = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// getDeviceID, IPMI v2.0 section 20.1.
+func (s *Server) getDeviceID(sess *session, data []byte) (byte, []byte) {
+	var major, minor int
+	if m := versionRe.FindStringSubmatch(s.Version); m != nil {
+		major, _ = strconv.Atoi(m[1])
+		minor, _ = strconv.Atoi(m[2])
+	}
+	if major > 0x7f {
+		major = 0x7f
+	}
+	minor %= 100
+	pen := uint32(platform.PEN)
+	return ccOk, []byte{
+		0x20,                         // device ID
+		0x01,                         // device revision
+		byte(major),                  // device available
+		byte(minor/10<<4 | minor%10), // BCD
+		0x02,                         // IPMI v2.0
+		0x87,                         // chassis, SEL, SDR repository and sensor device
+		byte(pen), byte(pen >> 8), byte(pen >> 16),
+		0x00, 0x00, // product ID
+	}
+}
+
+func (s *Server) getSystemGUID(sess *session, data []byte) (byte, []byte) {
+	return ccOk, s.GUID[:]
+}
+
+// getChannelAuthCaps, IPMI v2.0 section 22.13, offers RMCP+ only.
+func (s *Server) getChannelAuthCaps(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 2 {
+		return ccDataLength, nil
+	}
+	if ch := data[0] & 0x0f; ch != channel && ch != 0x0e {
+		return ccInvalidField, nil
+	}
+	var v2, login byte
+	if data[0]&0x80 != 0 {
+		v2 = 0x80
+	}
+	for name := range s.Users {
+		if len(name) > 0 {
+			login |= 0x04 // non-null user names
+		} else {
+			login |= 0x02 // null user names
+		}
+	}
+	return ccOk, []byte{channel, v2, login, 0x02, 0, 0, 0, 0}
+}
+
+// getChannelCipherSuites, IPMI v2.0 section 22.15, lists the records of
+// the cipher suites 16 bytes at a time.
+func (s *Server) getChannelCipherSuites(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 3 {
+		return ccDataLength, nil
+	}
+	resp := []byte{channel}
+	if data[1] != payloadIPMI {
+		return ccOk, resp
+	}
+	var records []byte
+	for _, cs := range cipherSuites {
+		records = append(records, 0xc0, cs.id, cs.auth, 0x40|cs.integ,
+			0x80|cs.conf)
+	}
+	i := int(data[2]&0x3f) * 16
+	if i < len(records) {
+		records = records[i:]
+		if len(records) > 16 {
+			records = records[:16]
+		}
+		resp = append(resp, records...)
+	}
+	return ccOk, resp
+}
+
+// setSessionPriv, IPMI v2.0 section 22.18.
+func (s *Server) setSessionPriv(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 1 {
+		return ccDataLength, nil
+	}
+	switch priv := data[0] & 0x0f; {
+	case priv == 0:
+	case priv < privUser || priv > privAdmin:
+		return ccInvalidField, nil
+	case priv > sess.maxPriv:
+		return ccPrivilegeLimit, nil
+	default:
+		sess.priv = priv
+	}
+	return ccOk, []byte{sess.priv}
+}
+
+// closeSession, IPMI v2.0 section 22.19, closes the session or, for an
+// administrator, another one.
+func (s *Server) closeSession(sess *session, data []byte) (byte, []byte) {
+	if len(data) < 4 {
+		return ccDataLength, nil
+	}
+	other, found := s.sessions[le.Uint32(data)]
+	switch {
+	case !found:
+		return ccInvalidSessionID, nil
+	case other == sess:
+		s.closing = sess
+	case sess.priv < privAdmin:
+		return ccPrivilege, nil
+	default:
+		delete(s.sessions, other.id)
+	}
+	return ccOk, nil
+}
+
+// chassisStatus, IPMI v2.0 section 28.2. Power is on while a PSU is.
+func (s *Server) chassisStatus(sess *session, data []byte) (byte, []byte) {
+	keys, err := s.Store.Hgetall()
+	if err != nil {
+		return ccUnspecified, nil
+	}
+	state := byte(0x60) // power restore policy unknown
+	if store.PoweredOn(keys) {
+		state |= 0x01
+	}
+	return ccOk, []byte{state, 0x00, 0x00}
+}
+
+// chassisControl, IPMI v2.0 section 28.3, through chassisd.
+func (s *Server) chassisControl(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 1 {
+		return ccDataLength, nil
+	}
+	var action string
+	switch data[0] & 0x0f {
+	case 0x00: // power down
+		action = chassisd.Off
+	case 0x01: // power up
+		action = chassisd.On
+	case 0x02: // power cycle
+		action = chassisd.Cycle
+	case 0x03: // hard reset
+		action = chassisd.Reset
+	case 0x05: // soft shutdown
+		action = chassisd.Soft
+	default:
+		return ccInvalidField, nil
+	}
+	if err := s.Store.Hset(chassisd.Field, action+" ipmid"); err != nil {
+		log.Print("ipmid: chassis control: ", action, ": ", err)
+		return ccUnspecified, nil
+	}
+	log.Print("notice: ipmid: ", string(sess.name), " chassis control ",
+		action)
+	return ccOk, nil
+}
+
+func (s *Server) sensor(n byte) *Sensor {
+	if n == 0 || int(n) > len(s.SDR) {
+		return nil
+	}
+	return s.SDR[n-1]
+}
+
+// getSensorReading, IPMI v2.0 section 35.14.
+func (s *Server) getSensorReading(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 1 {
+		return ccDataLength, nil
+	}
+	sensor := s.sensor(data[0])
+	if sensor == nil {
+		return ccNotPresent, nil
+	}
+	keys, err := s.Store.Hgetall()
+	if err != nil {
+		return ccUnspecified, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(keys[sensor.Key]), 64)
+	if err != nil {
+		return ccOk, []byte{0, 0x60, 0x00, 0x80} // reading unavailable
+	}
+	return ccOk, []byte{sensor.Raw(v), 0x40, sensor.Status(v), 0x80}
+}
+
+// getSensorThresholds, IPMI v2.0 section 35.9.
+func (s *Server) getSensorThresholds(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 1 {
+		return ccDataLength, nil
+	}
+	sensor := s.sensor(data[0])
+	if sensor == nil {
+		return ccNotPresent, nil
+	}
+	mask, raw := sensor.Thresholds()
+	return ccOk, append([]byte{mask}, raw[:]...)
+}
+
+func (s *Server) getSDRRepositoryInfo(sess *session, data []byte) (byte, []byte) {
+	n := len(s.SDR)
+	return ccOk, []byte{sdrVersion, byte(n), byte(n >> 8),
+		0, 0, // free space
+		0, 0, 0, 0, // most recent addition
+		0, 0, 0, 0, // most recent erase
+		0x02, // reserve supported
+	}
+}
+
+func (s *Server) reserveSDRRepository(sess *session, data []byte) (byte, []byte) {
+	s.sdrReservation++
+	if s.sdrReservation == 0 {
+		s.sdrReservation++
+	}
+	return ccOk, []byte{byte(s.sdrReservation), byte(s.sdrReservation >> 8)}
+}
+
+// getSDR, IPMI v2.0 section 33.12. Record IDs are sensor numbers.
+func (s *Server) getSDR(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 6 {
+		return ccDataLength, nil
+	}
+	rsv, id := le.Uint16(data), le.Uint16(data[2:])
+	offset, n := int(data[4]), int(data[5])
+	if offset != 0 && rsv != s.sdrReservation {
+		return ccReservation, nil
+	}
+	if id == 0 {
+		id = 1
+	} else if id == sdrLastRecord {
+		id = uint16(len(s.SDR))
+	}
+	if id == 0 || int(id) > len(s.SDR) {
+		return ccNotPresent, nil
+	}
+	next := id + 1
+	if int(id) == len(s.SDR) {
+		next = sdrLastRecord
+	}
+	record := s.SDR[id-1].Record(id)
+	if offset > len(record) {
+		return ccInvalidField, nil
+	}
+	record = record[offset:]
+	if n < len(record) {
+		record = record[:n]
+	}
+	return ccOk, append([]byte{byte(next), byte(next >> 8)}, record...)
+}
+
+func (s *Server) entries() ([]sel.Entry, error) {
+	if s.SEL == nil {
+		return nil, nil
+	}
+	return s.SEL.Entries()
+}
+
+func timestamp(t time.Time) []byte {
+	if t.IsZero() {
+		return []byte{0xff, 0xff, 0xff, 0xff}
+	}
+	return le32(uint32(t.Unix()))
+}
+
+// getSELInfo, IPMI v2.0 section 31.2.
+func (s *Server) getSELInfo(sess *session, data []byte) (byte, []byte) {
+	entries, err := s.entries()
+	if err != nil {
+		return ccUnspecified, nil
+	}
+	resp := []byte{sdrVersion, byte(len(entries)), byte(len(entries) >> 8),
+		0xff, 0xff} // free space
+	var last time.Time
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].Time
+	}
+	resp = append(resp, timestamp(last)...)
+	resp = append(resp, timestamp(time.Time{})...)
+	return ccOk, append(resp, 0x02) // reserve supported
+}
+
+func (s *Server) reserveSEL(sess *session, data []byte) (byte, []byte) {
+	s.selReservation++
+	if s.selReservation == 0 {
+		s.selReservation++
+	}
+	return ccOk, []byte{byte(s.selReservation), byte(s.selReservation >> 8)}
+}
+
+// getSELEntry, IPMI v2.0 section 31.5. Record IDs are the low 16 bits of
+// the sel ids.
+func (s *Server) getSELEntry(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 6 {
+		return ccDataLength, nil
+	}
+	rsv, id := le.Uint16(data), le.Uint16(data[2:])
+	offset, n := int(data[4]), int(data[5])
+	if offset != 0 && rsv != s.selReservation {
+		return ccReservation, nil
+	}
+	entries, err := s.entries()
+	if err != nil {
+		return ccUnspecified, nil
+	}
+	i := -1
+	switch id {
+	case 0x0000:
+		if len(entries) > 0 {
+			i = 0
+		}
+	case 0xffff:
+		i = len(entries) - 1
+	default:
+		for j := range entries {
+			if uint16(entries[j].Id) == id {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 {
+		return ccNotPresent, nil
+	}
+	next := uint16(0xffff)
+	if i+1 < len(entries) {
+		next = uint16(entries[i+1].Id)
+	}
+	record := s.selRecord(&entries[i])
+	if offset > len(record) {
+		return ccInvalidField, nil
+	}
+	record = record[offset:]
+	if n < len(record) {
+		record = record[:n]
+	}
+	return ccOk, append([]byte{byte(next), byte(next >> 8)}, record...)
+}
+
+// clearSEL, IPMI v2.0 section 31.9.
+func (s *Server) clearSEL(sess *session, data []byte) (byte, []byte) {
+	if len(data) != 6 {
+		return ccDataLength, nil
+	}
+	if le.Uint16(data) != s.selReservation ||
+		string(data[2:5]) != "CLR" {
+		return ccReservation, nil
+	}
+	if data[5] == 0xaa && s.SEL != nil {
+		if err := s.SEL.Clear(); err != nil {
+			return ccUnspecified, nil
+		}
+		log.Print("notice: ipmid: ", string(sess.name), " cleared the SEL")
+	}
+	return ccOk, []byte{0x01} // erasure completed
+}
+
+func (s *Server) getSELTime(sess *session, data []byte) (byte, []byte) {
+	return ccOk, timestamp(time.Now())
+}
+
+// selEvent is the sensor and event of the sel entries of a key.
+type selEvent struct {
+	sensorType, eventType, offset byte
+	// asserted reports whether an entry of the given severity is an
+	// assertion of the event.
+	asserted func(sel.Severity) bool
+}
+
+func raised(sev sel.Severity) bool {
+	return sev == sel.Warning || sev == sel.Critical
+}
+
+func always(sel.Severity) bool { return true }
+
+// selEvents are by key, the key prefix of the daemon's events.
+var selEvents = []struct {
+	prefix string
+	selEvent
+}{
+	// presence detected; removals deassert it
+	{"psu", selEvent{0x08, 0x6f, 0x00, func(sev sel.Severity) bool {
+		return !raised(sev)
+	}}},
+	// thermal event, upper non-critical going high
+	{"fan_tray.duty", selEvent{0x01, 0x01, 0x07, always}},
+	// digital discrete state asserted
+	{"fan_tray.", selEvent{0x04, 0x03, 0x01, raised}},
+	// power unit failure detected
+	{"vmon.poweroff.events", selEvent{0x09, 0x6f, 0x06, always}},
+	// watchdog hard reset
+	{"watchdog.", selEvent{0x23, 0x6f, 0x01, always}},
+}
+
+// selRecord returns the system event record, IPMI v2.0 section 32.1, of e.
+func (s *Server) selRecord(e *sel.Entry) []byte {
+	ev := selEvent{0xc0, 0x6f, 0x00, raised} // OEM
+	for _, x := range selEvents {
+		if strings.HasPrefix(e.Key, x.prefix) {
+			ev = x.selEvent
+			break
+		}
+	}
+	number := byte(0xff)
+	for _, sensor := range s.SDR {
+		if sensor.Key == e.Key {
+			number = sensor.Number
+		}
+	}
+	dir := ev.eventType
+	if !ev.asserted(e.Severity) {
+		dir |= 0x80
+	}
+	b := []byte{byte(e.Id), byte(e.Id >> 8), 0x02}
+	b = append(b, timestamp(e.Time)...)
+	return append(b, bmcAddr, 0x00, 0x04, ev.sensorType, number, dir,
+		ev.offset, 0xff, 0xff)
+}
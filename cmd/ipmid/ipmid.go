@@ -0,0 +1,153 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package ipmid provides an IPMI v2.0 RMCP+ responder of the chassis,
+// sensor and event log of the BMC.
+package ipmid
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+	"github.com/platinasystems/log"
+)
+
+const (
+	DefaultAddr   = ":623"
+	DefaultDir    = "/perm/var/ipmid"
+	UsersFileName = "users"
+	GUIDFileName  = "guid"
+)
+
+var (
+	// Keys are the published redis keys, those with a sensor unit
+	// make the SDR repository.
+	Keys []string
+	// Thresholds are the alarm limits of the sensors.
+	Thresholds map[string]alarm.Threshold
+	// Version is the firmware revision of Get Device ID.
+	Version string
+)
+
+type Command struct {
+	Init func()
+	init sync.Once
+	// Addr is the UDP address to listen on, DefaultAddr if empty.
+	Addr string
+	// Dir has the users and GUID files, DefaultDir if empty.
+	Dir string
+}
+
+func (*Command) String() string { return "ipmid" }
+
+func (*Command) Usage() string { return "ipmid" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "IPMI over LAN responder of chassis, sensors and SEL",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The ipmid daemon answers IPMI v2.0 RMCP+ requests on UDP port 623,
+	e.g. "ipmitool -I lanplus -C 17". It offers cipher suites 17 and 3,
+	with HMAC integrity and AES-CBC-128 confidentiality.
+
+	Chassis Control powers down, powers up, power cycles, hard resets
+	and soft shuts down the chassis through chassisd. Sensors are the psuN.*, vmon.*, fan_tray.* and hwmon.*
+	keys with a unit, numbered in key order, and the SEL is that of the
+	"sel" command.
+
+	Consoles authenticate with the users of /perm/var/ipmid/users, lines
+	of "NAME:PASSWORD[:PRIVILEGE]" where PRIVILEGE is user, operator or
+	administrator, the default. Without that file, no session may open.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	if err := redis.IsReady(); err != nil {
+		return err
+	}
+	addr, dir := c.Addr, c.Dir
+	if len(addr) == 0 {
+		addr = DefaultAddr
+	}
+	if len(dir) == 0 {
+		dir = DefaultDir
+	}
+	s := &Server{
+		Store:   store.Redis{},
+		SDR:     NewSDR(Keys, Thresholds),
+		SEL:     sel.Default,
+		Version: Version,
+	}
+	users, err := LoadUsers(filepath.Join(dir, UsersFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		log.Print("notice: ipmid: no users, sessionless requests only")
+	}
+	s.Users = users
+	guid, err := loadGUID(filepath.Join(dir, GUIDFileName))
+	if err != nil {
+		return err
+	}
+	copy(s.GUID[:], guid)
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(conn)
+	}()
+	select {
+	case <-goes.Stop:
+		return conn.Close()
+	case err := <-done:
+		conn.Close()
+		return err
+	}
+}
+
+// loadGUID returns the system GUID of fn, creating a random one if the file
+// doesn't exist.
+func loadGUID(fn string) ([]byte, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err == nil && len(b) == 16 {
+		return b, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	b = make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return nil, err
+	}
+	return b, ioutil.WriteFile(fn, b, 0600)
+}
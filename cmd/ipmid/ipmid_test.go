@@ -0,0 +1,384 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/goes-bmc/store"
+)
+
+func limit(v float64) *float64 { return &v }
+
+var guid = [16]byte{0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17,
+	0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f}
+
+func newServer(t *testing.T) (net.Conn, *store.Map, *sel.Log, func()) {
+	keys := store.NewMap(map[string]string{
+		"psu1.status":          "powered_on",
+		"psu1.admin.state":     "enable",
+		"psu2.status":          "powered_on",
+		"psu2.admin.state":     "enable",
+		"psu2.v_in.units.V":    "230.2",
+		"vmon.5v.sb.units.V":   "5.01",
+		"hwmon.target.units.C": "50",
+	})
+	users, err := ParseUsers([]byte(
+		"admin:secret\noper:opsecret:operator\nguest:guest:user\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &sel.Log{File: filepath.Join(t.TempDir(), "sel.json")}
+	s := &Server{
+		Store: keys,
+		Users: users,
+		SDR: NewSDR([]string{"psu2.v_in.units.V", "vmon.5v.sb.units.V",
+			"hwmon.target.units.C", "psu2.status"},
+			map[string]alarm.Threshold{
+				"vmon.5v.sb.units.V": {
+					LowWarning:  limit(4.75),
+					HighWarning: limit(5.25),
+				},
+			}),
+		SEL:     log,
+		GUID:    guid,
+		Version: "v1.2.0",
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(pc)
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, keys, log, func() {
+		conn.Close()
+		pc.Close()
+	}
+}
+
+func exchange(t *testing.T, conn net.Conn, pkt []byte) []byte {
+	if _, err := conn.Write(pkt); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1024)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b[:n]
+}
+
+// request is an IPMI request message of the console.
+func request(netFn, cmd byte, data ...byte) []byte {
+	b := []byte{bmcAddr, netFn << 2}
+	b = append(b, checksum(b))
+	b = append(b, consoleAddr, 0x04, cmd)
+	b = append(b, data...)
+	return append(b, checksum(b[3:]))
+}
+
+// reply returns the completion code and data of a response message.
+func reply(t *testing.T, b []byte) (byte, []byte) {
+	if len(b) < 8 || checksum(b[:2]) != b[2] ||
+		checksum(b[3:len(b)-1]) != b[len(b)-1] {
+		t.Fatalf("bad response % x", b)
+	}
+	return b[6], b[7 : len(b)-1]
+}
+
+// console is the remote console side of a session.
+type console struct {
+	t    *testing.T
+	conn net.Conn
+	sess *session
+	seq  uint32
+}
+
+// open returns the console of a new session, or the RAKP status that
+// failed it.
+func open(t *testing.T, conn net.Conn, suite byte, name, password string,
+	role byte) (*console, byte) {
+	var cs cipherSuite
+	for _, x := range cipherSuites {
+		if x.id == suite {
+			cs = x
+		}
+	}
+	req := []byte{1, 0, 0, 0, 0xa0, 0xa1, 0xa2, 0xa3,
+		0x00, 0, 0, 8, cs.auth, 0, 0, 0,
+		0x01, 0, 0, 8, cs.integ, 0, 0, 0,
+		0x02, 0, 0, 8, cs.conf, 0, 0, 0,
+	}
+	h := v2(t, exchange(t, conn, v2Packet(payloadOpenSession, 0, 0, req,
+		nil)), payloadOpenSessionR, nil)
+	if h.payload[1] != rakpOk {
+		return nil, h.payload[1]
+	}
+	sess := &session{
+		suite:     cs,
+		consoleID: le.Uint32(h.payload[4:]),
+		id:        le.Uint32(h.payload[8:]),
+		user:      &User{Name: name, Password: password},
+		role:      role,
+		name:      []byte(name),
+		maxPriv:   role & 0x0f,
+	}
+	copy(sess.rm[:], "console random #")
+	req = []byte{2, 0, 0, 0}
+	req = append(req, le32(sess.id)...)
+	req = append(req, sess.rm[:]...)
+	req = append(req, role, 0, 0, byte(len(name)))
+	req = append(req, name...)
+	h = v2(t, exchange(t, conn, v2Packet(payloadRAKP1, 0, 0, req, nil)),
+		payloadRAKP2, nil)
+	if h.payload[1] != rakpOk {
+		return nil, h.payload[1]
+	}
+	copy(sess.rc[:], h.payload[8:24])
+	if !bytes.Equal(h.payload[24:40], guid[:]) {
+		t.Errorf("RAKP 2 GUID % x", h.payload[24:40])
+	}
+	// with a wrong password, the auth code won't match but RAKP 3 shows
+	// that the BMC refuses the session too
+	auth := h.payload[40:]
+	matched := bytes.Equal(auth, sess.rakp2Auth(guid[:]))
+	req = []byte{3, rakpOk, 0, 0}
+	req = append(req, le32(sess.id)...)
+	req = append(req, sess.rakp3Auth()...)
+	h = v2(t, exchange(t, conn, v2Packet(payloadRAKP3, 0, 0, req, nil)),
+		payloadRAKP4, nil)
+	if h.payload[1] != rakpOk {
+		return nil, h.payload[1]
+	}
+	if !matched {
+		t.Fatalf("RAKP 2 auth code % x", auth)
+	}
+	if icv := sess.activate(nil, guid[:]); !bytes.Equal(h.payload[8:],
+		icv) {
+		t.Fatalf("RAKP 4 integrity check value % x", h.payload[8:])
+	}
+	return &console{t: t, conn: conn, sess: sess}, rakpOk
+}
+
+// v2 parses a reply packet of the payload type.
+func v2(t *testing.T, b []byte, payloadType byte, sess *session) *v2Header {
+	if len(b) < 4 || !bytes.Equal(b[:4], rmcpHeader) {
+		t.Fatalf("bad RMCP header % x", b)
+	}
+	h, err := parseV2(b[4:], func(uint32) int {
+		if sess == nil {
+			return 0
+		}
+		return sess.suite.authLen()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.payloadType&payloadTypeMask != payloadType {
+		t.Fatalf("payload type %#x, want %#x", h.payloadType,
+			payloadType)
+	}
+	return h
+}
+
+func (c *console) do(netFn, cmd byte, data ...byte) (byte, []byte) {
+	payload, err := c.sess.encrypt(request(netFn, cmd, data...))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.seq++
+	pkt := v2Packet(payloadIPMI|payloadEncrypted, c.sess.id, c.seq,
+		payload, c.sess.sign)
+	h := v2(c.t, exchange(c.t, c.conn, pkt), payloadIPMI, c.sess)
+	if h.sid != c.sess.consoleID || !c.sess.verify(h) {
+		c.t.Fatalf("bad reply session % x", h.signed)
+	}
+	msg, err := c.sess.decrypt(h.payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply(c.t, msg)
+}
+
+func TestSessionless(t *testing.T) {
+	conn, _, _, done := newServer(t)
+	defer done()
+	ping := []byte{rmcpVersion, 0, rmcpNoAck, classASF,
+		0x00, 0x00, 0x11, 0xbe, asfPing, 7, 0, 0}
+	if b := exchange(t, conn, ping); len(b) < 28 || b[8] != asfPong ||
+		b[9] != 7 {
+		t.Errorf("pong % x", b)
+	}
+	pkt := v15Packet(request(netFnApp, 0x38, 0x8e, privAdmin))
+	b := exchange(t, conn, pkt)
+	if len(b) < 14 {
+		t.Fatalf("short v1.5 reply % x", b)
+	}
+	cc, data := reply(t, b[14:])
+	if cc != ccOk || !bytes.Equal(data, []byte{channel, 0x80, 0x04, 0x02,
+		0, 0, 0, 0}) {
+		t.Errorf("channel auth caps %#x % x", cc, data)
+	}
+	b = exchange(t, conn, v15Packet(request(netFnChassis, 0x02, 0x02)))
+	if cc, _ := reply(t, b[14:]); cc != ccPrivilege {
+		t.Errorf("sessionless chassis control %#x", cc)
+	}
+}
+
+func TestSession(t *testing.T) {
+	conn, _, _, done := newServer(t)
+	defer done()
+	for _, suite := range []byte{17, 3} {
+		c, status := open(t, conn, suite, "oper", "opsecret", privOperator)
+		if status != rakpOk {
+			t.Fatalf("suite %d: RAKP status %#x", suite, status)
+		}
+		cc, data := c.do(netFnApp, 0x01)
+		if cc != ccOk || len(data) != 11 || data[2] != 1 ||
+			data[3] != 0x02 || data[4] != 0x02 {
+			t.Errorf("suite %d: device ID %#x % x", suite, cc, data)
+		}
+		if cc, data = c.do(netFnChassis, 0x01); cc != ccOk ||
+			data[0]&0x01 == 0 {
+			t.Errorf("suite %d: chassis status %#x % x", suite, cc,
+				data)
+		}
+		if cc, _ = c.do(netFnApp, 0x3b, privAdmin); cc != ccPrivilegeLimit {
+			t.Errorf("suite %d: admin privilege %#x", suite, cc)
+		}
+		if cc, data = c.do(netFnApp, 0x3b, privOperator); cc != ccOk ||
+			data[0] != privOperator {
+			t.Errorf("suite %d: operator privilege %#x % x", suite,
+				cc, data)
+		}
+		if cc, _ = c.do(netFnApp, 0x3c, le32(c.sess.id)...); cc != ccOk {
+			t.Errorf("suite %d: close session %#x", suite, cc)
+		}
+	}
+	for _, x := range []struct {
+		name, password string
+		role, status   byte
+	}{
+		{"admin", "wrong", privAdmin, rakpInvalidIntegrity},
+		{"nobody", "secret", privAdmin, rakpUnauthorizedName},
+		{"guest", "guest", privOperator, rakpUnauthorizedRole},
+	} {
+		if _, status := open(t, conn, 17, x.name, x.password,
+			x.role); status != x.status {
+			t.Errorf("%s: RAKP status %#x, want %#x", x.name, status,
+				x.status)
+		}
+	}
+}
+
+func TestChassisControl(t *testing.T) {
+	conn, keys, _, done := newServer(t)
+	defer done()
+	guest, _ := open(t, conn, 17, "guest", "guest", privUser)
+	if cc, _ := guest.do(netFnChassis, 0x02, 0x02); cc != ccPrivilege {
+		t.Errorf("user chassis control %#x", cc)
+	}
+	c, _ := open(t, conn, 17, "admin", "secret", privAdmin)
+	if cc, _ := c.do(netFnApp, 0x3b, privAdmin); cc != ccOk {
+		t.Fatalf("admin privilege %#x", cc)
+	}
+	for _, x := range []struct {
+		control byte
+		value   string
+	}{
+		{0x00, "off ipmid"},
+		{0x01, "on ipmid"},
+		{0x02, "cycle ipmid"},
+		{0x03, "reset ipmid"},
+		{0x05, "soft ipmid"},
+	} {
+		keys.Hdel(chassisd.Field)
+		if cc, _ := c.do(netFnChassis, 0x02, x.control); cc != ccOk {
+			t.Errorf("control %#x: %#x", x.control, cc)
+		}
+		if v := keys.Hget(chassisd.Field); v != x.value {
+			t.Errorf("control %#x: %q, want %q", x.control, v, x.value)
+		}
+	}
+	if cc, _ := c.do(netFnChassis, 0x02, 0x04); cc != ccInvalidField {
+		t.Errorf("diagnostic interrupt %#x", cc)
+	}
+}
+
+func TestSensors(t *testing.T) {
+	conn, keys, log, done := newServer(t)
+	defer done()
+	c, _ := open(t, conn, 3, "admin", "secret", privAdmin)
+	// sensors in key order: psu2.v_in, vmon.5v.sb
+	cc, data := c.do(netFnStorage, 0x23, 0, 0, 0, 0, 0, 0xff)
+	if cc != ccOk || le.Uint16(data) != 2 || data[2+3] != sdrFullSensor ||
+		data[2+7] != 1 || !bytes.HasSuffix(data, []byte("psu2.v_in")) {
+		t.Errorf("SDR 1 %#x % x", cc, data)
+	}
+	cc, data = c.do(netFnStorage, 0x23, 0, 0, 2, 0, 0, 0xff)
+	if cc != ccOk || le.Uint16(data) != sdrLastRecord {
+		t.Errorf("SDR 2 %#x % x", cc, data)
+	}
+	sdr := NewSDR([]string{"psu2.v_in.units.V", "vmon.5v.sb.units.V"}, nil)
+	for _, x := range []struct {
+		value  string
+		sensor byte
+		raw    byte
+		status byte
+	}{
+		{"230.2", 1, sdr[0].Raw(230.2), 0},
+		{"5.01", 2, sdr[1].Raw(5.01), 0},
+		{"5.5", 2, sdr[1].Raw(5.5), 0x08},
+		{"4.5", 2, sdr[1].Raw(4.5), 0x01},
+	} {
+		keys.Hset(sdr[x.sensor-1].Key, x.value)
+		cc, data := c.do(netFnSensor, 0x2d, x.sensor)
+		if cc != ccOk || data[0] != x.raw || data[2] != x.status {
+			t.Errorf("sensor %d %s: %#x % x", x.sensor, x.value, cc,
+				data)
+		}
+	}
+	if cc, _ := c.do(netFnSensor, 0x2d, 3); cc != ccNotPresent {
+		t.Errorf("sensor 3 %#x", cc)
+	}
+	if cc, data = c.do(netFnSensor, 0x27, 2); cc != ccOk ||
+		data[0] != 0x09 || data[1] != sdr[1].Raw(4.75) ||
+		data[4] != sdr[1].Raw(5.25) {
+		t.Errorf("thresholds %#x % x", cc, data)
+	}
+
+	stamp := time.Unix(1600000000, 0)
+	log.Add(sel.Entry{Time: stamp, Source: "fspd",
+		Severity: sel.Warning, Key: "psu1.status",
+		Message: "psu1 removed"})
+	log.Add(sel.Entry{Time: stamp, Source: "ucd9090d",
+		Severity: sel.Critical, Key: "watchdog.expired",
+		Message: "watchdog expired"})
+	cc, data = c.do(netFnStorage, 0x43, 0, 0, 0, 0, 0, 0xff)
+	want := []byte{2, 0, 1, 0, 0x02, 0x00, 0x10, 0x5e, 0x5f, bmcAddr, 0,
+		0x04, 0x08, 0xff, 0xef, 0x00, 0xff, 0xff}
+	if cc != ccOk || !bytes.Equal(data, want) {
+		t.Errorf("SEL entry 1 %#x % x", cc, data)
+	}
+	cc, data = c.do(netFnStorage, 0x43, 0, 0, 2, 0, 0, 0xff)
+	if cc != ccOk || le.Uint16(data) != 0xffff || data[2+10] != 0x23 {
+		t.Errorf("SEL entry 2 %#x % x", cc, data)
+	}
+	if cc, _ = c.do(netFnStorage, 0x43, 0, 0, 3, 0, 0, 0xff); cc !=
+		ccNotPresent {
+		t.Errorf("SEL entry 3 %#x", cc)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// RMCP, ASF and IPMI LAN session framing, IPMI v2.0 sections 13.1-13.8.
+const (
+	rmcpVersion = 0x06
+	rmcpNoAck   = 0xff
+	classASF    = 0x06
+	classIPMI   = 0x07
+
+	asfIANA = 0x000011be
+	asfPing = 0x80
+	asfPong = 0x40
+
+	authNone     = 0x00
+	authRMCPPlus = 0x06
+
+	payloadIPMI         = 0x00
+	payloadOEM          = 0x02
+	payloadOpenSession  = 0x10
+	payloadOpenSessionR = 0x11
+	payloadRAKP1        = 0x12
+	payloadRAKP2        = 0x13
+	payloadRAKP3        = 0x14
+	payloadRAKP4        = 0x15
+	payloadEncrypted    = 0x80
+	payloadAuthed       = 0x40
+	payloadTypeMask     = 0x3f
+
+	nextHeader = 0x07
+
+	bmcAddr     = 0x20
+	consoleAddr = 0x81
+)
+
+var errShort = errors.New("short packet")
+
+var le = binary.LittleEndian
+
+// rmcpHeader is the header of the IPMI messages the BMC sends.
+var rmcpHeader = []byte{rmcpVersion, 0, rmcpNoAck, classIPMI}
+
+// pong returns the ASF presence pong of a ping, nil if asf isn't one.
+func pong(asf []byte) []byte {
+	if len(asf) < 8 || binary.BigEndian.Uint32(asf) != asfIANA ||
+		asf[4] != asfPing {
+		return nil
+	}
+	b := []byte{rmcpVersion, 0, rmcpNoAck, classASF,
+		0x00, 0x00, 0x11, 0xbe, // IANA
+		asfPong, asf[5], 0, 16,
+		0x00, 0x00, 0x11, 0xbe, // IANA
+		0, 0, 0, 0, // OEM
+		0x81, // IPMI and ASF 1.0 supported
+		0x00,
+		0, 0, 0, 0, 0, 0,
+	}
+	return b
+}
+
+func checksum(b []byte) byte {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return -sum
+}
+
+// message is an IPMI LAN request.
+type message struct {
+	netFn, cmd byte
+	rqAddr     byte
+	rqSeqLUN   byte // rqSeq << 2 | rqLUN
+	rsLUN      byte
+	data       []byte
+}
+
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < 7 {
+		return nil, errShort
+	}
+	if checksum(b[:2]) != b[2] || checksum(b[3:len(b)-1]) != b[len(b)-1] {
+		return nil, errors.New("bad checksum")
+	}
+	return &message{
+		netFn:    b[1] >> 2,
+		rsLUN:    b[1] & 3,
+		rqAddr:   b[3],
+		rqSeqLUN: b[4],
+		cmd:      b[5],
+		data:     b[6 : len(b)-1],
+	}, nil
+}
+
+// response returns the response to m with completion code cc.
+func (m *message) response(cc byte, data []byte) []byte {
+	b := make([]byte, 0, 8+len(data))
+	b = append(b, m.rqAddr, (m.netFn|1)<<2|m.rqSeqLUN&3)
+	b = append(b, checksum(b))
+	b = append(b, bmcAddr, m.rqSeqLUN&^3|m.rsLUN, m.cmd, cc)
+	b = append(b, data...)
+	return append(b, checksum(b[3:]))
+}
+
+// v15Packet frames an IPMI v1.5 sessionless message.
+func v15Packet(msg []byte) []byte {
+	b := append([]byte{}, rmcpHeader...)
+	b = append(b, authNone, 0, 0, 0, 0, 0, 0, 0, 0, byte(len(msg)))
+	return append(b, msg...)
+}
+
+// v2Header is the IPMI v2.0 session header.
+type v2Header struct {
+	payloadType byte
+	sid, seq    uint32
+	payload     []byte
+	// signed is the part of the packet covered by the integrity check,
+	// authCode its value.
+	signed, authCode []byte
+}
+
+// parseV2 parses an IPMI v2.0 packet, after its RMCP header. authLen is
+// the length of the AuthCode of authenticated packets.
+func parseV2(b []byte, authLen func(sid uint32) int) (*v2Header, error) {
+	if len(b) < 12 {
+		return nil, errShort
+	}
+	h := &v2Header{
+		payloadType: b[1],
+		sid:         le.Uint32(b[2:]),
+		seq:         le.Uint32(b[6:]),
+	}
+	if h.payloadType&payloadTypeMask == payloadOEM {
+		return nil, errors.New("OEM payload")
+	}
+	n := int(le.Uint16(b[10:]))
+	if 12+n > len(b) {
+		return nil, errShort
+	}
+	h.payload = b[12 : 12+n]
+	if h.payloadType&payloadAuthed == 0 {
+		return h, nil
+	}
+	l := authLen(h.sid)
+	if l == 0 || len(b) < 12+n+2+l {
+		return nil, errShort
+	}
+	trailer := b[12+n : len(b)-l]
+	pad := len(trailer) - 2
+	if int(trailer[pad]) != pad || trailer[pad+1] != nextHeader {
+		return nil, errors.New("bad session trailer")
+	}
+	h.signed = b[:len(b)-l]
+	h.authCode = b[len(b)-l:]
+	return h, nil
+}
+
+// v2Packet frames a payload in an IPMI v2.0 packet; if sign isn't nil, it
+// is authenticated with the AuthCode returned by sign.
+func v2Packet(payloadType byte, sid, seq uint32, payload []byte,
+	sign func([]byte) []byte) []byte {
+	b := append([]byte{}, rmcpHeader...)
+	if sign != nil {
+		payloadType |= payloadAuthed
+	}
+	b = append(b, authRMCPPlus, payloadType, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	le.PutUint32(b[6:], sid)
+	le.PutUint32(b[10:], seq)
+	le.PutUint16(b[14:], uint16(len(payload)))
+	b = append(b, payload...)
+	if sign == nil {
+		return b
+	}
+	// pad from the AuthType through the next header to 4 bytes
+	pad := (4 - (len(b)-len(rmcpHeader)+2)%4) % 4
+	for i := 0; i < pad; i++ {
+		b = append(b, 0xff)
+	}
+	b = append(b, byte(pad), nextHeader)
+	return append(b, sign(b[len(rmcpHeader):])...)
+}
@@ -0,0 +1,238 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/alarm"
+)
+
+const (
+	sdrVersion    = 0x51
+	sdrFullSensor = 0x01
+	sdrLastRecord = 0xffff
+)
+
+// Sensor types, IPMI v2.0 table 42-3.
+const (
+	sensorTemperature = 0x01
+	sensorVoltage     = 0x02
+	sensorCurrent     = 0x03
+	sensorFan         = 0x04
+	sensorOtherUnits  = 0x0b
+)
+
+// Entity IDs, IPMI v2.0 table 43-13.
+const (
+	entitySystemBoard = 0x07
+	entityPowerSupply = 0x0a
+	entityFan         = 0x1d
+)
+
+// sensorUnit is the type and unit of the sensors of a ".units.X" key and
+// the default full scale of their readings.
+type sensorUnit struct {
+	sensorType, unit byte
+	max              float64
+}
+
+var sensorUnits = map[string]sensorUnit{
+	"C":   {sensorTemperature, 1, 127.5},
+	"V":   {sensorVoltage, 4, 15},
+	"A":   {sensorCurrent, 5, 100},
+	"W":   {sensorOtherUnits, 6, 1000},
+	"rpm": {sensorFan, 18, 25500},
+}
+
+// Sensor is a full sensor record of a published key. Readings are
+// M * raw * 10^RExp, with the raw reading of 0 to 255.
+type Sensor struct {
+	Number    byte
+	Key       string
+	Name      string
+	Type      byte
+	Unit      byte
+	Entity    byte
+	Instance  byte
+	M         int
+	RExp      int
+	Threshold *alarm.Threshold
+}
+
+// NewSDR returns the sensors of the keys with a sensor unit, e.g.
+// "psu1.v_in.units.V", numbered in key order from 1.
+func NewSDR(keys []string, thresholds map[string]alarm.Threshold) []*Sensor {
+	keys = append([]string{}, keys...)
+	sort.Strings(keys)
+	var sdr []*Sensor
+	for _, k := range keys {
+		i := strings.Index(k, ".units.")
+		if i < 0 || strings.Contains(k, ".target.") || len(sdr) == 254 {
+			continue
+		}
+		u, found := sensorUnits[k[i+len(".units."):]]
+		if !found {
+			continue
+		}
+		s := &Sensor{
+			Number:   byte(len(sdr) + 1),
+			Key:      k,
+			Name:     sensorName(k[:i]),
+			Type:     u.sensorType,
+			Unit:     u.unit,
+			Entity:   entitySystemBoard,
+			Instance: 1,
+		}
+		max := u.max
+		if strings.Contains(k, ".v_in.") {
+			max = 300
+		}
+		if t, found := thresholds[k]; found {
+			s.Threshold = &t
+			for _, limit := range []*float64{t.HighCritical,
+				t.HighWarning} {
+				if limit != nil && *limit*1.25 > max {
+					max = *limit * 1.25
+				}
+			}
+		}
+		s.setScale(max)
+		switch f := strings.Split(k, "."); {
+		case strings.HasPrefix(k, "psu"):
+			s.Entity = entityPowerSupply
+			s.Instance = instance(strings.TrimPrefix(f[0], "psu"))
+		case strings.HasPrefix(k, "fan_tray."):
+			s.Entity = entityFan
+			s.Instance = instance(f[1])
+		}
+		sdr = append(sdr, s)
+	}
+	return sdr
+}
+
+// sensorName shortens a key to the 16 characters of an ID string.
+func sensorName(k string) string {
+	k = strings.Replace(k, "fan_tray.", "fan", 1)
+	k = strings.Replace(k, ".speed", "", 1)
+	k = strings.Replace(k, ".temp", "", 1)
+	if len(k) > 16 {
+		k = k[:16]
+	}
+	return k
+}
+
+func instance(s string) byte {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 0x7f {
+		return 1
+	}
+	return byte(n)
+}
+
+// setScale sets M and RExp for the finest resolution of readings up to max.
+func (s *Sensor) setScale(max float64) {
+	step := max / 255
+	s.RExp = int(math.Ceil(math.Log10(step))) - 2
+	s.M = int(math.Ceil(step / math.Pow10(s.RExp)))
+}
+
+// Raw returns the raw reading of v.
+func (s *Sensor) Raw(v float64) byte {
+	raw := math.Round(v / (float64(s.M) * math.Pow10(s.RExp)))
+	return byte(math.Max(0, math.Min(255, raw)))
+}
+
+// Thresholds returns the readable threshold mask and the raw lower
+// non-critical, lower critical, lower non-recoverable, upper non-critical,
+// upper critical and upper non-recoverable thresholds.
+func (s *Sensor) Thresholds() (mask byte, raw [6]byte) {
+	if s.Threshold == nil {
+		return
+	}
+	for i, limit := range []*float64{
+		s.Threshold.LowWarning,
+		s.Threshold.LowCritical,
+		nil,
+		s.Threshold.HighWarning,
+		s.Threshold.HighCritical,
+		nil,
+	} {
+		if limit != nil {
+			mask |= 1 << uint(i)
+			raw[i] = s.Raw(*limit)
+		}
+	}
+	return
+}
+
+// Status returns the threshold comparison status of v, the bits of the
+// thresholds of Thresholds that v is at or beyond.
+func (s *Sensor) Status(v float64) byte {
+	var status byte
+	if s.Threshold == nil {
+		return 0
+	}
+	for i, x := range []struct {
+		limit *float64
+		high  bool
+	}{
+		{s.Threshold.LowWarning, false},
+		{s.Threshold.LowCritical, false},
+		{nil, false},
+		{s.Threshold.HighWarning, true},
+		{s.Threshold.HighCritical, true},
+	} {
+		switch {
+		case x.limit == nil:
+		case x.high && v >= *x.limit, !x.high && v <= *x.limit:
+			status |= 1 << uint(i)
+		}
+	}
+	return status
+}
+
+// Record returns the full sensor record, IPMI v2.0 section 43.1, of id.
+func (s *Sensor) Record(id uint16) []byte {
+	mask, thresholds := s.Thresholds()
+	caps := byte(0x40) // auto re-arm
+	if mask != 0 {
+		caps |= 0x04 // thresholds readable per mask
+	}
+	m := uint16(s.M) & 0x3ff
+	b := []byte{
+		byte(id), byte(id >> 8), sdrVersion, sdrFullSensor, 0,
+		bmcAddr, 0, s.Number,
+		s.Entity, s.Instance,
+		0x03, // events and scanning enabled
+		caps,
+		s.Type,
+		0x01,       // threshold event/reading type
+		0x00, 0x00, // assertion event mask
+		0x00, 0x00, // deassertion event mask
+		mask, 0x00, // readable and settable thresholds
+		0x00, // unsigned
+		s.Unit,
+		0x00,                           // modifier unit
+		0x00,                           // linear
+		byte(m), byte(m>>8) << 6, 0, 0, // M, B
+		0,
+		byte(s.RExp&0xf) << 4, // B exponent is 0
+		0x00,                  // no nominal or normal readings
+		0, 0, 0,
+		0xff, 0x00, // max and min reading
+		thresholds[5], thresholds[4], thresholds[3],
+		thresholds[2], thresholds[1], thresholds[0],
+		0, 0, // hysteresis
+		0, 0, 0, // reserved and OEM
+		0xc0 | byte(len(s.Name)), // 8-bit ASCII
+	}
+	b = append(b, s.Name...)
+	b[4] = byte(len(b) - 5)
+	return b
+}
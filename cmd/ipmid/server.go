@@ -0,0 +1,313 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/log"
+)
+
+// Server is an IPMI v2.0 LAN responder of the BMC.
+type Server struct {
+	Store   store.Store
+	Users   map[string]*User
+	SDR     []*Sensor
+	SEL     *sel.Log
+	GUID    [16]byte
+	Version string
+
+	mutex          sync.Mutex
+	sessions       map[uint32]*session
+	sdrReservation uint16
+	selReservation uint16
+	// closing is the session to delete once its reply to Close Session
+	// is sent.
+	closing *session
+}
+
+// Serve responds to the requests of conn until it's closed.
+func (s *Server) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if reply := s.Handle(buf[:n]); reply != nil {
+			if _, err = conn.WriteTo(reply, addr); err != nil {
+				log.Print("ipmid: ", addr, ": ", err)
+			}
+		}
+	}
+}
+
+// Handle returns the reply to an RMCP packet, nil if none.
+func (s *Server) Handle(pkt []byte) []byte {
+	if len(pkt) < 5 || pkt[0] != rmcpVersion {
+		return nil
+	}
+	switch pkt[3] {
+	case classASF:
+		return pong(pkt[4:])
+	case classIPMI:
+	default:
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+	b := pkt[4:]
+	switch b[0] {
+	case authNone:
+		return s.handleV15(b)
+	case authRMCPPlus:
+		return s.handleV2(b)
+	}
+	return nil
+}
+
+func (s *Server) expire() {
+	now := time.Now()
+	for id, sess := range s.sessions {
+		if now.Sub(sess.last) > sessionTimeout {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// handleV15 answers the sessionless requests of IPMI v1.5 packets, those
+// that a console sends before it opens an RMCP+ session.
+func (s *Server) handleV15(b []byte) []byte {
+	if len(b) < 10 || le.Uint32(b[5:]) != 0 {
+		return nil
+	}
+	n := int(b[9])
+	if 10+n > len(b) {
+		return nil
+	}
+	m, err := parseMessage(b[10 : 10+n])
+	if err != nil {
+		return nil
+	}
+	return v15Packet(s.sessionless(m))
+}
+
+func (s *Server) handleV2(b []byte) []byte {
+	h, err := parseV2(b, func(sid uint32) int {
+		if sess, found := s.sessions[sid]; found && sess.active {
+			return sess.suite.authLen()
+		}
+		return 0
+	})
+	if err != nil {
+		return nil
+	}
+	if h.sid == 0 {
+		if h.payloadType&(payloadEncrypted|payloadAuthed) != 0 {
+			return nil
+		}
+		switch h.payloadType {
+		case payloadIPMI:
+			m, err := parseMessage(h.payload)
+			if err != nil {
+				return nil
+			}
+			return v2Packet(payloadIPMI, 0, 0, s.sessionless(m), nil)
+		case payloadOpenSession:
+			return s.openSession(h.payload)
+		case payloadRAKP1:
+			return s.rakp1(h.payload)
+		case payloadRAKP3:
+			return s.rakp3(h.payload)
+		}
+		return nil
+	}
+	sess, found := s.sessions[h.sid]
+	if !found || !sess.active ||
+		h.payloadType != payloadIPMI|payloadAuthed|payloadEncrypted ||
+		!sess.verify(h) || !sess.checkSeq(h.seq) {
+		return nil
+	}
+	payload, err := sess.decrypt(h.payload)
+	if err != nil {
+		return nil
+	}
+	m, err := parseMessage(payload)
+	if err != nil {
+		return nil
+	}
+	sess.last = time.Now()
+	reply, err := sess.packet(s.command(sess, m))
+	if err != nil {
+		log.Print("ipmid: ", err)
+		return nil
+	}
+	if s.closing != nil {
+		delete(s.sessions, s.closing.id)
+		s.closing = nil
+	}
+	return reply
+}
+
+// openSession answers an RMCP+ Open Session Request, IPMI v2.0 section
+// 13.17.
+func (s *Server) openSession(req []byte) []byte {
+	if len(req) < 8 {
+		return nil
+	}
+	tag, priv := req[0], req[1]&0x0f
+	consoleID := le.Uint32(req[4:])
+	fail := func(status byte) []byte {
+		resp := []byte{tag, status, 0, 0, 0, 0, 0, 0}
+		le.PutUint32(resp[4:], consoleID)
+		return v2Packet(payloadOpenSessionR, 0, 0, resp, nil)
+	}
+	if len(req) < 32 {
+		return fail(rakpIllegalParameter)
+	}
+	if priv == 0 {
+		priv = privAdmin
+	} else if priv > privAdmin {
+		return fail(rakpInvalidRole)
+	}
+	// each algorithm payload has its type, length 0 to leave the
+	// choice to the BMC, or length 8 and the algorithm
+	algs := [3]int{}
+	for i := range algs {
+		p := req[8+8*i:]
+		if p[0] != byte(i) {
+			return fail(rakpIllegalParameter)
+		}
+		algs[i] = -1
+		if p[3] != 0 {
+			algs[i] = int(p[4] & 0x3f)
+		}
+	}
+	var suite *cipherSuite
+	for i := range cipherSuites {
+		cs := &cipherSuites[i]
+		if (algs[0] < 0 || algs[0] == int(cs.auth)) &&
+			(algs[1] < 0 || algs[1] == int(cs.integ)) &&
+			(algs[2] < 0 || algs[2] == int(cs.conf)) {
+			suite = cs
+			break
+		}
+	}
+	if suite == nil {
+		return fail(rakpNoCipherSuite)
+	}
+	if len(s.sessions) >= maxSessions {
+		return fail(rakpNoResources)
+	}
+	id, err := randomID()
+	for err == nil && s.sessions[id] != nil {
+		id, err = randomID()
+	}
+	if err != nil {
+		return fail(rakpNoResources)
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[uint32]*session)
+	}
+	s.sessions[id] = &session{
+		suite:     *suite,
+		id:        id,
+		consoleID: consoleID,
+		maxPriv:   priv,
+		last:      time.Now(),
+	}
+	resp := []byte{tag, rakpOk, priv, 0,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+		0x00, 0, 0, 8, suite.auth, 0, 0, 0,
+		0x01, 0, 0, 8, suite.integ, 0, 0, 0,
+		0x02, 0, 0, 8, suite.conf, 0, 0, 0,
+	}
+	le.PutUint32(resp[4:], consoleID)
+	le.PutUint32(resp[8:], id)
+	return v2Packet(payloadOpenSessionR, 0, 0, resp, nil)
+}
+
+// rakp1 answers RAKP Message 1 with Message 2, IPMI v2.0 section 13.20.
+func (s *Server) rakp1(req []byte) []byte {
+	if len(req) < 28 || len(req) < 28+int(req[27]) || req[27] > 16 {
+		return nil
+	}
+	tag := req[0]
+	sess, found := s.sessions[le.Uint32(req[4:])]
+	fail := func(status byte, consoleID uint32) []byte {
+		resp := []byte{tag, status, 0, 0, 0, 0, 0, 0}
+		le.PutUint32(resp[4:], consoleID)
+		return v2Packet(payloadRAKP2, 0, 0, resp, nil)
+	}
+	if !found || sess.active {
+		return fail(rakpInvalidSessionID, 0)
+	}
+	copy(sess.rm[:], req[8:24])
+	sess.role = req[24]
+	sess.name = append([]byte{}, req[28:28+int(req[27])]...)
+	priv := sess.role & 0x0f
+	user, found := s.Users[string(sess.name)]
+	switch {
+	case !found:
+		delete(s.sessions, sess.id)
+		return fail(rakpUnauthorizedName, sess.consoleID)
+	case priv == 0 || priv > privAdmin:
+		delete(s.sessions, sess.id)
+		return fail(rakpInvalidRole, sess.consoleID)
+	case priv > user.Priv || priv > sess.maxPriv:
+		delete(s.sessions, sess.id)
+		return fail(rakpUnauthorizedRole, sess.consoleID)
+	}
+	sess.user = user
+	sess.maxPriv = priv
+	if _, err := rand.Read(sess.rc[:]); err != nil {
+		return fail(rakpNoResources, sess.consoleID)
+	}
+	sess.last = time.Now()
+	resp := []byte{tag, rakpOk, 0, 0, 0, 0, 0, 0}
+	le.PutUint32(resp[4:], sess.consoleID)
+	resp = append(resp, sess.rc[:]...)
+	resp = append(resp, s.GUID[:]...)
+	resp = append(resp, sess.rakp2Auth(s.GUID[:])...)
+	return v2Packet(payloadRAKP2, 0, 0, resp, nil)
+}
+
+// rakp3 answers RAKP Message 3 with Message 4, IPMI v2.0 section 13.22,
+// and activates the session.
+func (s *Server) rakp3(req []byte) []byte {
+	if len(req) < 8 {
+		return nil
+	}
+	tag := req[0]
+	sess, found := s.sessions[le.Uint32(req[4:])]
+	if !found || sess.active || sess.user == nil {
+		resp := []byte{tag, rakpInvalidSessionID, 0, 0, 0, 0, 0, 0}
+		return v2Packet(payloadRAKP4, 0, 0, resp, nil)
+	}
+	resp := []byte{tag, rakpOk, 0, 0, 0, 0, 0, 0}
+	le.PutUint32(resp[4:], sess.consoleID)
+	if req[1] != rakpOk {
+		delete(s.sessions, sess.id)
+		return nil
+	}
+	if !hmac.Equal(req[8:], sess.rakp3Auth()) {
+		delete(s.sessions, sess.id)
+		log.Print("warning: ipmid: ", string(sess.name),
+			": authentication failed")
+		resp[1] = rakpInvalidIntegrity
+		return v2Packet(payloadRAKP4, 0, 0, resp, nil)
+	}
+	sess.last = time.Now()
+	resp = append(resp, sess.activate(nil, s.GUID[:])...)
+	return v2Packet(payloadRAKP4, 0, 0, resp, nil)
+}
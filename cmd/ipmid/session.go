@@ -0,0 +1,244 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"time"
+)
+
+// Privilege levels.
+const (
+	privCallback = 1
+	privUser     = 2
+	privOperator = 3
+	privAdmin    = 4
+)
+
+// RMCP+ algorithms, IPMI v2.0 section 13.28.
+const (
+	authHMACSHA1   = 0x01
+	authHMACSHA256 = 0x03
+
+	integHMACSHA196    = 0x01
+	integHMACSHA256128 = 0x04
+
+	confAESCBC128 = 0x01
+)
+
+// RMCP+ and RAKP status codes, IPMI v2.0 table 13-15.
+const (
+	rakpOk               = 0x00
+	rakpNoResources      = 0x01
+	rakpInvalidSessionID = 0x02
+	rakpInvalidRole      = 0x05
+	rakpUnauthorizedRole = 0x09
+	rakpUnauthorizedName = 0x0d
+	rakpInvalidIntegrity = 0x0f
+	rakpNoCipherSuite    = 0x11
+	rakpIllegalParameter = 0x12
+)
+
+const (
+	maxSessions      = 16
+	sessionTimeout   = 60 * time.Second
+	sessionSeqWindow = 16
+)
+
+// cipherSuite is a standard cipher suite, IPMI v2.0 table 22-20. Only those
+// with integrity and confidentiality are offered.
+type cipherSuite struct {
+	id, auth, integ, conf byte
+}
+
+var cipherSuites = []cipherSuite{
+	{17, authHMACSHA256, integHMACSHA256128, confAESCBC128},
+	{3, authHMACSHA1, integHMACSHA196, confAESCBC128},
+}
+
+func (cs *cipherSuite) hash() func() hash.Hash {
+	if cs.auth == authHMACSHA256 {
+		return sha256.New
+	}
+	return sha1.New
+}
+
+// authLen is the length of the integrity AuthCode and of the RAKP 4
+// integrity check value.
+func (cs *cipherSuite) authLen() int {
+	if cs.integ == integHMACSHA256128 {
+		return 16
+	}
+	return 12
+}
+
+type session struct {
+	suite cipherSuite
+	// id is the managed system session ID, consoleID that of the
+	// remote console.
+	id, consoleID uint32
+	maxPriv, priv byte
+	active        bool
+	last          time.Time
+
+	// RAKP state
+	user   *User
+	rm, rc [16]byte
+	role   byte
+	name   []byte
+
+	k1, k2 []byte
+	outSeq uint32
+	inSeq  uint32
+	seen   uint32 // bit i is inSeq-i
+}
+
+func (s *session) hmac(key []byte, data ...[]byte) []byte {
+	h := hmac.New(s.suite.hash(), key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	le.PutUint32(b, v)
+	return b
+}
+
+// rakp2Auth is the key exchange auth code of RAKP message 2.
+func (s *session) rakp2Auth(guid []byte) []byte {
+	return s.hmac(s.user.key(), le32(s.consoleID), le32(s.id), s.rm[:],
+		s.rc[:], guid, []byte{s.role, byte(len(s.name))}, s.name)
+}
+
+// rakp3Auth is the key exchange auth code of RAKP message 3.
+func (s *session) rakp3Auth() []byte {
+	return s.hmac(s.user.key(), s.rc[:], le32(s.consoleID),
+		[]byte{s.role, byte(len(s.name))}, s.name)
+}
+
+// activate derives the session keys and returns the integrity check
+// value of RAKP message 4.
+func (s *session) activate(kg, guid []byte) []byte {
+	if kg == nil {
+		kg = s.user.key()
+	}
+	sik := s.hmac(kg, s.rm[:], s.rc[:], []byte{s.role, byte(len(s.name))},
+		s.name)
+	s.k1 = s.hmac(sik, bytes.Repeat([]byte{1}, 20))
+	s.k2 = s.hmac(sik, bytes.Repeat([]byte{2}, 20))
+	s.active = true
+	s.priv = privUser
+	if s.maxPriv < s.priv {
+		s.priv = s.maxPriv
+	}
+	return s.hmac(sik, s.rm[:], le32(s.id), guid)[:s.suite.authLen()]
+}
+
+func (s *session) sign(b []byte) []byte {
+	h := hmac.New(s.suite.hash(), s.k1)
+	h.Write(b)
+	return h.Sum(nil)[:s.suite.authLen()]
+}
+
+func (s *session) verify(h *v2Header) bool {
+	return hmac.Equal(s.sign(h.signed), h.authCode)
+}
+
+// checkSeq accepts each sequence number of the window about the highest
+// yet received only once.
+func (s *session) checkSeq(seq uint32) bool {
+	if seq == 0 {
+		return false
+	}
+	switch d := int64(seq) - int64(s.inSeq); {
+	case d > 0 && d <= sessionSeqWindow:
+		s.seen = s.seen<<uint(d) | 1
+		s.inSeq = seq
+	case d <= 0 && d > -sessionSeqWindow:
+		bit := uint32(1) << uint(-d)
+		if s.seen&bit != 0 {
+			return false
+		}
+		s.seen |= bit
+	case s.inSeq == 0:
+		s.inSeq, s.seen = seq, 1
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *session) encrypt(payload []byte) ([]byte, error) {
+	block, err := aes.NewCipher(s.k2[:16])
+	if err != nil {
+		return nil, err
+	}
+	n := (aes.BlockSize - (len(payload)+1)%aes.BlockSize) % aes.BlockSize
+	b := make([]byte, aes.BlockSize, aes.BlockSize+len(payload)+n+1)
+	if _, err = rand.Read(b); err != nil {
+		return nil, err
+	}
+	b = append(b, payload...)
+	for i := 1; i <= n; i++ {
+		b = append(b, byte(i))
+	}
+	b = append(b, byte(n))
+	cipher.NewCBCEncrypter(block, b[:aes.BlockSize]).
+		CryptBlocks(b[aes.BlockSize:], b[aes.BlockSize:])
+	return b, nil
+}
+
+func (s *session) decrypt(payload []byte) ([]byte, error) {
+	if len(payload) < 2*aes.BlockSize || len(payload)%aes.BlockSize != 0 {
+		return nil, errors.New("bad encrypted payload length")
+	}
+	block, err := aes.NewCipher(s.k2[:16])
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(payload)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, payload[:aes.BlockSize]).
+		CryptBlocks(b, payload[aes.BlockSize:])
+	n := int(b[len(b)-1])
+	if n >= aes.BlockSize {
+		return nil, errors.New("bad confidentiality pad")
+	}
+	return b[:len(b)-1-n], nil
+}
+
+// packet frames an IPMI message of the active session.
+func (s *session) packet(msg []byte) ([]byte, error) {
+	payload, err := s.encrypt(msg)
+	if err != nil {
+		return nil, err
+	}
+	s.outSeq++
+	return v2Packet(payloadIPMI|payloadEncrypted, s.consoleID, s.outSeq,
+		payload, s.sign), nil
+}
+
+// randomID returns a random, non-zero session ID.
+func randomID() (uint32, error) {
+	var b [4]byte
+	for {
+		if _, err := rand.Read(b[:]); err != nil {
+			return 0, err
+		}
+		if id := le.Uint32(b[:]); id != 0 {
+			return id, nil
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ipmid
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// maxPassword is the length of an IPMI v2.0 password.
+const maxPassword = 20
+
+// User is an account of the users file. RAKP authenticates with the
+// password itself, so the file keeps it in clear and must be private.
+type User struct {
+	Name     string
+	Password string
+	Priv     byte
+}
+
+func (u *User) key() []byte { return []byte(u.Password) }
+
+var privByName = map[string]byte{
+	"callback":      privCallback,
+	"user":          privUser,
+	"operator":      privOperator,
+	"administrator": privAdmin,
+	"admin":         privAdmin,
+}
+
+// LoadUsers reads a file of "NAME:PASSWORD[:PRIVILEGE]" lines, where
+// PRIVILEGE is user, operator or administrator, the default. Neither the
+// name nor the password may have a colon.
+func LoadUsers(fn string) (map[string]*User, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	users, err := ParseUsers(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return users, nil
+}
+
+func ParseUsers(b []byte) (map[string]*User, error) {
+	users := make(map[string]*User)
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimRight(scan.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 ||
+			strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.SplitN(line, ":", 3)
+		if len(f) < 2 {
+			return nil, fmt.Errorf("line %d: missing password", n)
+		}
+		u := &User{Name: f[0], Password: f[1], Priv: privAdmin}
+		if len(u.Name) > 16 {
+			return nil, fmt.Errorf("line %d: name too long", n)
+		}
+		if len(u.Password) > maxPassword {
+			return nil, fmt.Errorf("line %d: password too long", n)
+		}
+		if len(f) == 3 {
+			priv, found := privByName[strings.ToLower(f[2])]
+			if !found {
+				return nil, fmt.Errorf("line %d: %q: unknown privilege",
+					n, f[2])
+			}
+			u.Priv = priv
+		}
+		users[u.Name] = u
+	}
+	return users, scan.Err()
+}
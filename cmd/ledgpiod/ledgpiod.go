@@ -16,13 +16,17 @@ import (
 
 	"github.com/platinasystems/atsock"
 	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/platform"
+	"github.com/platinasystems/goes-bmc/sel"
 	"github.com/platinasystems/goes/cmd"
 	"github.com/platinasystems/goes/external/redis"
 	"github.com/platinasystems/goes/external/redis/publisher"
 	"github.com/platinasystems/goes/external/redis/rpc/args"
 	"github.com/platinasystems/goes/external/redis/rpc/reply"
 	"github.com/platinasystems/goes/lang"
-	"github.com/platinasystems/gpio"
 	"github.com/platinasystems/log"
 )
 
@@ -34,6 +38,7 @@ const (
 var (
 	lastFanStatus [maxFanTrays]string
 	lastPsuStatus [maxPsu]string
+	lastAlarm     alarm.Level
 
 	psuLed       = []uint8{0x8, 0x10}
 	psuLedYellow = []uint8{0x8, 0x10}
@@ -49,7 +54,7 @@ var (
 	fanLedYellow byte = 0x6
 	fanLedOff    byte = 0x0
 
-	deviceVer          byte
+	proto              bool
 	forceFanSpeed      bool
 	systemFanDirection string
 
@@ -72,12 +77,13 @@ type Command struct {
 }
 
 type Info struct {
-	mutex sync.Mutex
-	rpc   *atsock.RpcServer
-	pub   *publisher.Publisher
-	last  map[string]float64
-	lasts map[string]string
-	lastu map[string]uint16
+	mutex     sync.Mutex
+	rpc       *atsock.RpcServer
+	pub       *publisher.Publisher
+	i2cErrors i2creq.ErrorCount
+	last      map[string]float64
+	lasts     map[string]string
+	lastu     map[string]uint16
 }
 
 type I2cDev struct {
@@ -118,6 +124,7 @@ func (c *Command) Main(...string) error {
 	if c.pub, err = publisher.New(); err != nil {
 		return err
 	}
+	c.i2cErrors.Name = "ledgpiod"
 
 	if err = syscall.Sysinfo(&si); err != nil {
 		return err
@@ -150,8 +157,8 @@ func (c *Command) Main(...string) error {
 }
 
 func (c *Command) update() error {
-	stopped := readStopped()
-	if stopped == 1 {
+	c.i2cErrors.Publish(c.pub)
+	if i2creq.Stopped() {
 		return nil
 	}
 	if err := writeRegs(); err != nil {
@@ -191,41 +198,41 @@ func (h *I2cDev) LedFpInit() error {
 		pin.SetValue(true)
 	}
 
-	ss, _ := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-	_, _ = fmt.Sscan(ss, &deviceVer)
+	proto = platform.IdentFromRedis().Proto()
 
 	forceFanSpeed = false
 
 	r := getRegs()
-	r.Output[0].get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.Output[0].get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
-	o := s[0].D[0]
+	o := tr.Byte(0)
 
 	//on bmc boot up set front panel SYS led to green, FAN led to yellow, let PSU drive PSU LEDs
 	d = 0xff ^ (sysLed | fanLed)
 	o &= d
 	o |= sysLedGreen | fanLedYellow
 
-	r.Output[0].set(h, o)
-	err = DoI2cRpc()
+	r.Output[0].set(&tr, h, o)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
 
-	r.Config[0].get(h)
-	err = DoI2cRpc()
+	r.Config[0].get(&tr, h)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
-	o = s[0].D[0]
+	o = tr.Byte(0)
 	o |= psuLed[0] | psuLed[1]
 	o &= (sysLed | fanLed) ^ 0xff
 
-	r.Config[0].set(h, o)
-	err = DoI2cRpc()
+	r.Config[0].set(&tr, h, o)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
@@ -234,21 +241,21 @@ func (h *I2cDev) LedFpInit() error {
 
 func (h *I2cDev) LedFpReinit() error {
 
-	ss, _ := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-	_, _ = fmt.Sscan(ss, &deviceVer)
+	proto = platform.IdentFromRedis().Proto()
 	r := getRegs()
+	var tr i2creq.Trans
 
-	r.Config[0].get(h)
-	err := DoI2cRpc()
+	r.Config[0].get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
-	o := s[0].D[0]
+	o := tr.Byte(0)
 	o |= psuLed[0] | psuLed[1]
 	o &= (sysLed | fanLed) ^ 0xff
 
-	r.Config[0].set(h, o)
-	err = DoI2cRpc()
+	r.Config[0].set(&tr, h, o)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
@@ -257,10 +264,11 @@ func (h *I2cDev) LedFpReinit() error {
 
 func (h *I2cDev) LedStatus() error {
 	r := getRegs()
+	var tr i2creq.Trans
 	var o, c uint8
 	var d byte
 
-	if deviceVer == 0xff || deviceVer == 0x00 {
+	if proto {
 		psuLed = []uint8{0x0c, 0x03}
 		psuLedYellow = []uint8{0x00, 0x00}
 		psuLedOff = []uint8{0x04, 0x01}
@@ -277,7 +285,8 @@ func (h *I2cDev) LedStatus() error {
 	allFanGood := true
 	fanStatChange := false
 	for j := 0; j < maxFanTrays; j++ {
-		p, _ := redis.Hget(redis.DefaultHash, "fan_tray."+strconv.Itoa(int(j+1))+".status")
+		k := "fan_tray." + strconv.Itoa(j+1) + ".status"
+		p, _ := redis.Hget(redis.DefaultHash, k)
 		if !strings.Contains(p, "ok") {
 			allFanGood = false
 		}
@@ -285,47 +294,50 @@ func (h *I2cDev) LedStatus() error {
 			fanStatChange = true
 			//if any fan tray is failed or not installed, set front panel FAN led to yellow
 			if strings.Contains(p, "warning") && !strings.Contains(lastFanStatus[j], "not installed") {
-				r.Output[0].get(h)
-				err := DoI2cRpc()
+				r.Output[0].get(&tr, h)
+				err := tr.Do()
 				if err != nil {
 					return err
 				}
-				o = s[0].D[0]
+				o = tr.Byte(0)
 				d = 0xff ^ fanLed
 				o &= d
 				o |= fanLedYellow
-				r.Output[0].set(h, o)
-				err = DoI2cRpc()
+				r.Output[0].set(&tr, h, o)
+				err = tr.Do()
 				if err != nil {
 					return err
 				}
-				log.Print("warning: fan tray ", j+1, " failure")
+				sel.Print("ledgpiod", sel.Warning, k,
+					"fan tray ", j+1, " failure")
 				if !forceFanSpeed {
 					redis.Hset(redis.DefaultHash, "fan_tray.speed", "max")
 					forceFanSpeed = true
 				}
 			} else if strings.Contains(p, "not installed") {
-				r.Output[0].get(h)
-				err := DoI2cRpc()
+				r.Output[0].get(&tr, h)
+				err := tr.Do()
 				if err != nil {
 					return err
 				}
-				o = s[0].D[0]
+				o = tr.Byte(0)
 				d = 0xff ^ fanLed
 				o &= d
 				o |= fanLedYellow
-				r.Output[0].set(h, o)
-				err = DoI2cRpc()
+				r.Output[0].set(&tr, h, o)
+				err = tr.Do()
 				if err != nil {
 					return err
 				}
-				log.Print("warning: fan tray ", j+1, " not installed")
+				sel.Print("ledgpiod", sel.Warning, k,
+					"fan tray ", j+1, " not installed")
 				if !forceFanSpeed {
 					redis.Hset(redis.DefaultHash, "fan_tray.speed", "max")
 					forceFanSpeed = true
 				}
 			} else if strings.Contains(lastFanStatus[j], "not installed") && (strings.Contains(p, "warning") || strings.Contains(p, "ok")) {
-				log.Print("notice: fan tray ", j+1, " installed")
+				sel.Print("ledgpiod", sel.Notice, k,
+					"fan tray ", j+1, " installed")
 			}
 		}
 		lastFanStatus[j] = p
@@ -342,17 +354,17 @@ func (h *I2cDev) LedStatus() error {
 				}
 			}
 			if allStat {
-				r.Output[0].get(h)
-				err := DoI2cRpc()
+				r.Output[0].get(&tr, h)
+				err := tr.Do()
 				if err != nil {
 					return err
 				}
-				o = s[0].D[0]
+				o = tr.Byte(0)
 				d = 0xff ^ fanLed
 				o &= d
 				o |= fanLedGreen
-				r.Output[0].set(h, o)
-				err = DoI2cRpc()
+				r.Output[0].set(&tr, h, o)
+				err = tr.Do()
 				if err != nil {
 					return err
 				}
@@ -367,14 +379,14 @@ func (h *I2cDev) LedStatus() error {
 	for j := 0; j < maxPsu; j++ {
 		p, _ := redis.Hget(redis.DefaultHash, "psu"+strconv.Itoa(j+1)+".status")
 		if lastPsuStatus[j] != p {
-			r.Output[0].get(h)
-			r.Config[0].get(h)
-			err := DoI2cRpc()
+			r.Output[0].get(&tr, h)
+			r.Config[0].get(&tr, h)
+			err := tr.Do()
 			if err != nil {
 				return err
 			}
-			o = s[0].D[0]
-			c = s[1].D[0]
+			o = tr.Byte(0)
+			c = tr.Byte(1)
 			//if PSU is not installed or installed and powered on, set front panel PSU led to off or green (PSU drives)
 			if strings.Contains(p, "not_installed") || strings.Contains(p, "powered_on") {
 				c |= psuLed[j]
@@ -385,9 +397,9 @@ func (h *I2cDev) LedStatus() error {
 				o |= psuLedYellow[j]
 				c &= (psuLed[j]) ^ 0xff
 			}
-			r.Output[0].set(h, o)
-			r.Config[0].set(h, c)
-			err = DoI2cRpc()
+			r.Output[0].set(&tr, h, o)
+			r.Config[0].set(&tr, h, c)
+			err = tr.Do()
 			if err != nil {
 				return err
 			}
@@ -398,9 +410,53 @@ func (h *I2cDev) LedStatus() error {
 			}
 		}
 	}
+
+	//if any sensor alarm is raised, set front panel SYS led to yellow
+	if l := SystemAlarm(); l != lastAlarm {
+		r.Output[0].get(&tr, h)
+		err := tr.Do()
+		if err != nil {
+			return err
+		}
+		o = tr.Byte(0)
+		d = 0xff ^ sysLed
+		o &= d
+		if l == alarm.Ok {
+			o |= sysLedGreen
+			log.Print("notice: system alarm ", l)
+		} else {
+			o |= sysLedYellow
+			log.Print("warning: system alarm ", l)
+		}
+		r.Output[0].set(&tr, h, o)
+		err = tr.Do()
+		if err != nil {
+			return err
+		}
+		lastAlarm = l
+	}
 	return nil
 }
 
+// AlarmKeys are the alarm.<daemon> keys of the daemons with an alarm
+// Engine.
+var AlarmKeys = []string{"alarm.fspd", "alarm.ucd9090d", "alarm.w83795d"}
+
+// SystemAlarm returns the most severe of the AlarmKeys levels.
+func SystemAlarm() alarm.Level {
+	worst := alarm.Ok
+	for _, k := range AlarmKeys {
+		s, err := redis.Hget(redis.DefaultHash, k)
+		if err != nil {
+			continue
+		}
+		if l, err := alarm.ParseLevel(s); err == nil && l > worst {
+			worst = l
+		}
+	}
+	return worst
+}
+
 func (h *I2cDev) CheckSystemFans() string {
 
 	mismatch := false
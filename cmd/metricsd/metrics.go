@@ -0,0 +1,320 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package metricsd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const namespace = "bmc"
+
+// unitNames are the Prometheus base units of the ".units.X" suffixes.
+var unitNames = map[string]string{
+	"C":   "celsius",
+	"V":   "volts",
+	"A":   "amperes",
+	"W":   "watts",
+	"rpm": "rpm",
+}
+
+// enum is a status key published as one sample per state, 1 for the
+// current one and 0 for the others.
+type enum struct {
+	re     *regexp.Regexp
+	name   string
+	labels []string
+	help   string
+	states []string
+	// state returns the state of a value, "" if it's none of them.
+	state func(v string) string
+}
+
+func exact(v string) string { return v }
+
+// fanTrayState classifies the fantrayd status, e.g. "ok.front->back" or
+// "warning low rpm detected".
+func fanTrayState(v string) string {
+	switch {
+	case strings.HasPrefix(v, "ok"):
+		return "ok"
+	case strings.HasPrefix(v, "warning"):
+		return "warning"
+	case v == "not installed":
+		return "not_installed"
+	}
+	return ""
+}
+
+var alarmStates = []string{"ok", "warning", "critical"}
+
+var enums = []enum{
+	{
+		re:     regexp.MustCompile(`^psu(\d+)\.status$`),
+		name:   "psu_status",
+		labels: []string{"slot"},
+		help:   "Power supply status.",
+		states: []string{"not_installed", "powered_off", "powered_on"},
+		state:  exact,
+	},
+	{
+		re:     regexp.MustCompile(`^fan_tray\.(\d+)\.status$`),
+		name:   "fan_tray_status",
+		labels: []string{"tray"},
+		help:   "Fan tray status.",
+		states: []string{"not_installed", "ok", "warning"},
+		state:  fanTrayState,
+	},
+	{
+		re:     regexp.MustCompile(`^alarm\.(\w+)$`),
+		name:   "alarm",
+		labels: []string{"daemon"},
+		help:   "Worst sensor alarm of each daemon.",
+		states: alarmStates,
+		state:  exact,
+	},
+	{
+		re:     regexp.MustCompile(`^(.+)\.alarm$`),
+		name:   "sensor_alarm",
+		labels: []string{"sensor"},
+		help:   "Sensor alarm level.",
+		states: alarmStates,
+		state:  exact,
+	},
+}
+
+// gauges are the sensor keys with labels, by the names of their
+// submatches; other ".units." keys are gauges named after the whole key.
+var gauges = []struct {
+	re     *regexp.Regexp
+	name   string
+	labels []string
+}{
+	{regexp.MustCompile(`^psu(\d+)\.(.+)$`), "psu_", []string{"slot"}},
+	{regexp.MustCompile(`^fan_tray\.(\d+)\.(\d+)\.(speed)$`), "fan_",
+		[]string{"tray", "rotor"}},
+	{regexp.MustCompile(`^vmon\.(.+)$`), "vmon_", []string{"rail"}},
+}
+
+var (
+	i2cErrorsRe = regexp.MustCompile(`^i2c\.errors\.(\w+)$`)
+	invalidRe   = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+type family struct {
+	typ, help string
+	samples   map[string]string
+}
+
+type families map[string]*family
+
+func (fs families) add(name, typ, help, labels, value string) {
+	f, found := fs[name]
+	if !found {
+		f = &family{typ: typ, help: help, samples: make(map[string]string)}
+		fs[name] = f
+	}
+	f.samples[labels] = value
+}
+
+// Exporter serves the metrics of the published keys.
+type Exporter struct {
+	// Daemons are those whose liveness is reported.
+	Daemons []string
+	// Running returns the command lines of the running daemons.
+	Running func() (string, error)
+
+	mutex sync.Mutex
+	keys  map[string]string
+}
+
+// Set records a published key.
+func (e *Exporter) Set(k, v string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.keys == nil {
+		e.keys = make(map[string]string)
+	}
+	e.keys[k] = v
+}
+
+// Apply records a message of the publisher stream, "KEY: VALUE" or
+// "delete: KEY".
+func (e *Exporter) Apply(msg string) {
+	i := strings.Index(msg, ": ")
+	if i < 0 {
+		return
+	}
+	k, v := msg[:i], msg[i+2:]
+	if k == "delete" {
+		e.mutex.Lock()
+		delete(e.keys, v)
+		e.mutex.Unlock()
+		return
+	}
+	e.Set(k, v)
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (e *Exporter) WriteTo(w io.Writer) (int64, error) {
+	fs := make(families)
+	e.mutex.Lock()
+	for k, v := range e.keys {
+		fs.key(k, v)
+	}
+	e.mutex.Unlock()
+	if len(e.Daemons) > 0 && e.Running != nil {
+		e.up(fs)
+	}
+
+	names := make([]string, 0, len(fs))
+	for name := range fs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, name := range names {
+		f := fs[name]
+		i, _ := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name,
+			f.help, name, f.typ)
+		n += int64(i)
+		labels := make([]string, 0, len(f.samples))
+		for l := range f.samples {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+		for _, l := range labels {
+			i, _ = fmt.Fprintf(bw, "%s%s %s\n", name, l, f.samples[l])
+			n += int64(i)
+		}
+	}
+	return n, bw.Flush()
+}
+
+// key adds the samples of a published key.
+func (fs families) key(k, v string) {
+	if m := i2cErrorsRe.FindStringSubmatch(k); m != nil {
+		if _, err := strconv.ParseUint(v, 10, 64); err == nil {
+			fs.add(namespace+"_i2c_errors_total", "counter",
+				"Failed i2c transactions of each daemon.",
+				labels([]string{"daemon"}, m[1:]), v)
+		}
+		return
+	}
+	if i := strings.Index(k, ".units."); i > 0 {
+		fs.gauge(k[:i], k[i+len(".units."):], v)
+		return
+	}
+	for _, x := range enums {
+		m := x.re.FindStringSubmatch(k)
+		if m == nil {
+			continue
+		}
+		state := x.state(v)
+		for _, s := range x.states {
+			value := "0"
+			if s == state {
+				value = "1"
+			}
+			fs.add(namespace+"_"+x.name, "gauge", x.help,
+				labels(append(x.labels, "state"),
+					append(m[1:], s)), value)
+		}
+		return
+	}
+}
+
+// gauge adds the sample of a sensor, e.g. "psu1.p_in" in "W", as
+// bmc_psu_p_in_watts{slot="1"}.
+func (fs families) gauge(sensor, unit, v string) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return
+	}
+	u, found := unitNames[unit]
+	if !found {
+		u = strings.ToLower(unit)
+	}
+	name, l := strings.Replace(sensor, ".", "_", -1), ""
+	for _, x := range gauges {
+		m := x.re.FindStringSubmatch(sensor)
+		if m == nil {
+			continue
+		}
+		n := len(x.labels)
+		name = x.name + strings.Replace(strings.Join(m[n+1:], "_"), ".",
+			"_", -1)
+		l = labels(x.labels, m[1:n+1])
+		break
+	}
+	name = strings.TrimSuffix(name, "_")
+	name = namespace + "_" + invalidRe.ReplaceAllString(name, "_") + "_" +
+		invalidRe.ReplaceAllString(u, "_")
+	fs.add(name, "gauge", fmt.Sprint("Sensor reading in ", u, "."), l,
+		strconv.FormatFloat(f, 'g', -1, 64))
+}
+
+// up adds whether each of the Daemons is running.
+func (e *Exporter) up(fs families) {
+	running := make(map[string]bool)
+	s, err := e.Running()
+	if err != nil {
+		return
+	}
+	// lines of "PID: [PROG ... DAEMON ARGS...]"
+	for _, line := range strings.Split(s, "\n") {
+		i, j := strings.Index(line, "["), strings.LastIndex(line, "]")
+		if i < 0 || j < i {
+			continue
+		}
+		for _, f := range strings.Fields(line[i+1 : j]) {
+			running[f] = true
+		}
+	}
+	for _, d := range e.Daemons {
+		v := "0"
+		if running[d] {
+			v = "1"
+		}
+		fs.add(namespace+"_daemon_up", "gauge",
+			"Whether the daemon is running.",
+			labels([]string{"daemon"}, []string{d}), v)
+	}
+}
+
+func labels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(values[i]))
+	}
+	b.WriteString("}")
+	return b.String()
+}
@@ -0,0 +1,148 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package metricsd provides a Prometheus exporter of the keys published to
+// redis by the other daemons.
+package metricsd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/atsock"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+)
+
+const DefaultAddr = ":9100"
+
+// Daemons are those whose liveness is reported.
+var Daemons []string
+
+type Command struct {
+	Init func()
+	init sync.Once
+	// Addr is the address to listen on, DefaultAddr if empty.
+	Addr string
+}
+
+func (*Command) String() string { return "metricsd" }
+
+func (*Command) Usage() string { return "metricsd" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "prometheus exporter of the published sensors",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The metricsd daemon follows the redis publisher stream and serves
+	its keys in the Prometheus text format at http://BMC:9100/metrics.
+
+	Keys with a ".units.X" suffix are gauges in the base unit, labeled
+	by PSU slot, fan tray and rotor, or voltage rail, e.g.
+
+		bmc_psu_p_in_watts{slot="1"} 212.5
+		bmc_fan_speed_rpm{rotor="1",tray="1"} 8000
+		bmc_vmon_volts{rail="3v3.sys"} 3.31
+
+	The psuN.status, fan_tray.N.status and alarm keys are gauges of
+	each state, 1 for the current one, e.g.
+
+		bmc_psu_status{slot="2",state="powered_on"} 1
+
+	bmc_daemon_up is whether each daemon is running and
+	bmc_i2c_errors_total counts the failed i2c transactions of each
+	polling daemon.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	if err := redis.IsReady(); err != nil {
+		return err
+	}
+	addr := c.Addr
+	if len(addr) == 0 {
+		addr = DefaultAddr
+	}
+	e := &Exporter{
+		Daemons: Daemons,
+		Running: running,
+	}
+
+	// subscribe before reading the hash so that no update is lost
+	psc, err := redis.Subscribe(redis.DefaultHash)
+	if err != nil {
+		return err
+	}
+	defer psc.Close()
+	keys, err := store.Redis{}.Hgetall()
+	if err != nil {
+		return err
+	}
+	for k, v := range keys {
+		e.Set(k, v)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	done := make(chan error, 2)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	go func() {
+		for {
+			switch t := psc.Receive().(type) {
+			case redigo.Message:
+				if t.Channel == redis.DefaultHash {
+					e.Apply(string(t.Data))
+				}
+			case error:
+				done <- t
+				return
+			}
+		}
+	}()
+	select {
+	case <-goes.Stop:
+		return srv.Close()
+	case err := <-done:
+		srv.Close()
+		return err
+	}
+}
+
+// running returns the "daemons status" of goes-daemons.
+func running() (string, error) {
+	cl, err := atsock.NewRpcClient(filepath.Base(os.Args[0]) + "-daemons")
+	if err != nil {
+		return "", err
+	}
+	defer cl.Close()
+	var s string
+	err = cl.Call("Daemons.List", struct{}{}, &s)
+	return s, err
+}
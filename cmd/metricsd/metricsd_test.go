@@ -0,0 +1,82 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package metricsd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	e := &Exporter{
+		Daemons: []string{"fspd", "w83795d"},
+		Running: func() (string, error) {
+			return "101: [/usr/bin/goes redisd]\n" +
+				"102: [/usr/bin/goes fspd]\n", nil
+		},
+	}
+	for _, msg := range []string{
+		"psu1.p_in.units.W: 212.5",
+		"psu1.temp1.units.C: 41.25",
+		"psu1.status: powered_on",
+		"psu2.status: not_installed",
+		"psu2.v_in.units.V: 230",
+		"psu2.v_in.units.V: n/a",
+		"fan_tray.1.2.speed.units.rpm: 8000",
+		"fan_tray.3.status: warning low rpm detected",
+		"vmon.3v3.sys.units.V: 3.31",
+		"hwmon.front.temp.units.C: 30",
+		"vmon.3v3.sys.alarm: ok",
+		"alarm.fspd: warning",
+		"i2c.errors.fspd: 3",
+		"machine: platina-mk1-bmc",
+		"psu9.status: powered_on",
+		"delete: psu9.status",
+	} {
+		e.Apply(msg)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := e.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE bmc_psu_p_in_watts gauge\n",
+		`bmc_psu_p_in_watts{slot="1"} 212.5` + "\n",
+		`bmc_psu_temp1_celsius{slot="1"} 41.25` + "\n",
+		`bmc_psu_status{slot="1",state="powered_on"} 1` + "\n",
+		`bmc_psu_status{slot="1",state="powered_off"} 0` + "\n",
+		`bmc_psu_status{slot="2",state="not_installed"} 1` + "\n",
+		`bmc_fan_speed_rpm{tray="1",rotor="2"} 8000` + "\n",
+		`bmc_fan_tray_status{tray="3",state="warning"} 1` + "\n",
+		`bmc_fan_tray_status{tray="3",state="ok"} 0` + "\n",
+		`bmc_vmon_volts{rail="3v3.sys"} 3.31` + "\n",
+		"bmc_hwmon_front_temp_celsius 30\n",
+		`bmc_sensor_alarm{sensor="vmon.3v3.sys",state="ok"} 1` + "\n",
+		`bmc_alarm{daemon="fspd",state="warning"} 1` + "\n",
+		"# TYPE bmc_i2c_errors_total counter\n",
+		`bmc_i2c_errors_total{daemon="fspd"} 3` + "\n",
+		`bmc_daemon_up{daemon="fspd"} 1` + "\n",
+		`bmc_daemon_up{daemon="w83795d"} 0` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+	for _, unwanted := range []string{
+		"psu_v_in",
+		"machine",
+		`slot="9"`,
+		`daemon="redisd"`,
+	} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("unexpected %q", unwanted)
+		}
+	}
+	if t.Failed() {
+		t.Log(out)
+	}
+}
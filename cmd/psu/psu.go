@@ -0,0 +1,52 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package psu
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+)
+
+type Command struct{}
+
+func (Command) String() string { return "psu" }
+
+func (Command) Usage() string { return "psu clear-faults N" }
+
+func (Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "power supply unit actions",
+	}
+}
+
+func (Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The psu command requests an action of fspd for the PSU in slot N.
+
+	clear-faults	send the PMBus CLEAR_FAULTS command; the flags of
+			psuN.faults and psuN.warnings return if their
+			conditions persist`,
+	}
+}
+
+func (Command) Main(args ...string) error {
+	if len(args) != 2 || args[0] != "clear-faults" {
+		return fmt.Errorf("usage: %s", Command{}.Usage())
+	}
+	if _, err := strconv.ParseUint(args[1], 10, 8); err != nil {
+		return fmt.Errorf("%s: invalid slot", args[1])
+	}
+	if err := redis.IsReady(); err != nil {
+		return err
+	}
+	_, err := redis.Hset(redis.DefaultHash, "psu"+args[1]+".clear_faults",
+		"true")
+	return err
+}
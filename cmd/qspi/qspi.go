@@ -17,9 +17,9 @@ import (
 	"time"
 
 	"github.com/platinasystems/flags"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/i2creq"
 	"github.com/platinasystems/goes/lang"
-	"github.com/platinasystems/gpio"
-	"github.com/platinasystems/i2c"
 	"github.com/platinasystems/mtd"
 	"github.com/platinasystems/ubi"
 )
@@ -59,13 +59,11 @@ DESCRIPTION
 	}
 }
 
-func selectQSPI(pin *gpio.Pin, q bool) error {
+func selectQSPI(pin gpio.Pin, q bool) error {
 	//i2c STOP
-	sd[0] = 0
-	j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(1), 0}
-	err := DoI2cRpc()
+	err := i2creq.Stop()
 	if err != nil {
-		return fmt.Errorf("Error in DoI2cRpc 99-1: %s", err)
+		return fmt.Errorf("Error stopping i2c: %s", err)
 	}
 
 	pin, found := gpio.FindPin("QSPI_MUX_SEL")
@@ -77,11 +75,9 @@ func selectQSPI(pin *gpio.Pin, q bool) error {
 	time.Sleep(200 * time.Millisecond)
 
 	//i2c START
-	sd[0] = 0
-	j[0] = I{true, i2c.Write, 0, 0, sd, int(0x99), int(0), 0}
-	err = DoI2cRpc()
+	err = i2creq.Start()
 	if err != nil {
-		return fmt.Errorf("Error in DoI2cRpc 99-0: %s", err)
+		return fmt.Errorf("Error starting i2c: %s", err)
 	}
 	return nil
 }
@@ -0,0 +1,142 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package redfishd provides a Redfish service of the chassis, power,
+// thermal and firmware resources published to redis by the other daemons.
+package redfishd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/upgrade"
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+	"github.com/platinasystems/log"
+)
+
+const (
+	DefaultAddr   = ":443"
+	DefaultDir    = "/perm/var/redfishd"
+	CertFileName  = "cert.pem"
+	KeyFileName   = "key.pem"
+	UsersFileName = "passwd"
+)
+
+// Thresholds are the alarm limits reported with the sensor readings.
+var Thresholds map[string]alarm.Threshold
+
+type Command struct {
+	Init func()
+	init sync.Once
+	// Addr is the address to listen on, DefaultAddr if empty.
+	Addr string
+	// Dir has the certificate, key and users files, DefaultDir if empty.
+	Dir string
+}
+
+func (*Command) String() string { return "redfishd" }
+
+func (*Command) Usage() string { return "redfishd" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "redfish service of chassis, power, thermal and firmware",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The redfishd daemon serves the Redfish API over HTTPS, mapping the
+	psuN.*, vmon.*, fan_tray.* and hwmon.* redis keys to the Power and
+	Thermal resources of Chassis/1 and the images of "upgrade -r" to
+	UpdateService/FirmwareInventory.
+
+	Systems/1 supports the ComputerSystem.Reset action with ResetType
+	On, ForceOff, GracefulShutdown, ForceRestart, a host reset, or
+	PowerCycle, a PSU power cycle, each requested of chassisd.
+
+	The service uses cert.pem and key.pem of /perm/var/redfishd,
+	creating a self-signed certificate if they don't exist. Clients
+	authenticate with the basic authentication of the users in
+	/perm/var/redfishd/passwd, made with "htpasswd -nB USER"; without
+	that file only the service root is available.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	if err := redis.IsReady(); err != nil {
+		return err
+	}
+	addr, dir := c.Addr, c.Dir
+	if len(addr) == 0 {
+		addr = DefaultAddr
+	}
+	if len(dir) == 0 {
+		dir = DefaultDir
+	}
+	certFile := filepath.Join(dir, CertFileName)
+	keyFile := filepath.Join(dir, KeyFileName)
+	if err := selfSign(certFile, keyFile); err != nil {
+		return err
+	}
+	s := &Server{
+		Store:      store.Redis{},
+		Thresholds: Thresholds,
+		Firmware:   firmware,
+	}
+	users, err := LoadUsers(filepath.Join(dir, UsersFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		log.Print("notice: redfishd: no users, ",
+			"serving the service root only")
+		users = ParseUsers(nil)
+	}
+	s.Users = users
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      s,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServeTLS(certFile, keyFile)
+	}()
+	select {
+	case <-goes.Stop:
+		return srv.Close()
+	case err := <-done:
+		return err
+	}
+}
+
+func firmware() (string, []upgrade.IMGINFO, error) {
+	version, err := upgrade.GetVerArchive()
+	if err != nil {
+		return "", nil, err
+	}
+	images, err := upgrade.GetImgInfo()
+	if err != nil {
+		log.Print("redfishd: ", err)
+	}
+	return version, images, nil
+}
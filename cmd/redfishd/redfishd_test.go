@@ -0,0 +1,190 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package redfishd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/upgrade"
+	"github.com/platinasystems/goes-bmc/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func limit(v float64) *float64 { return &v }
+
+func newServer(t *testing.T) (*httptest.Server, *store.Map) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"),
+		bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := store.NewMap(map[string]string{
+		"platform.name":                "mk1-tor1",
+		"psu1.status":                  "not_installed",
+		"psu2.status":                  "powered_on",
+		"psu2.mfg_model":               "DPS-550AB-39 A",
+		"psu2.p_in.units.W":            "212.5",
+		"psu2.temp1.units.C":           "72",
+		"psu2.temp1.alarm":             "warning",
+		"vmon.5v.sb.units.V":           "5.01",
+		"vmon.5v.sb.alarm":             "ok",
+		"fan_tray.1.status":            "ok.front->back",
+		"fan_tray.1.1.speed.units.rpm": "8000",
+		"fan_tray.2.status":            "not installed",
+		"fan_tray.2.1.speed.units.rpm": "0",
+		"hwmon.target.units.C":         "50",
+	})
+	s := &Server{
+		Store: keys,
+		Users: ParseUsers([]byte("admin:" + string(hash) + "\n")),
+		Thresholds: map[string]alarm.Threshold{
+			"vmon.5v.sb.units.V": {
+				LowWarning:  limit(4.75),
+				HighWarning: limit(5.25),
+			},
+		},
+		Firmware: func() (string, []upgrade.IMGINFO, error) {
+			return "v1.2.0", []upgrade.IMGINFO{
+				{Name: "ubo", Tag: "v1.2.0"},
+				{Name: "kernel", Tag: "v1.2.0-1"},
+			}, nil
+		},
+	}
+	return httptest.NewServer(s), keys
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string,
+	auth bool) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, srv.URL+path,
+		strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth {
+		req.SetBasicAuth("admin", "secret")
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var v map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&v)
+	return resp.StatusCode, v
+}
+
+// get returns the value at the path of JSON keys and array indexes.
+func get(v interface{}, path ...interface{}) interface{} {
+	for _, p := range path {
+		switch x := p.(type) {
+		case string:
+			m, _ := v.(map[string]interface{})
+			v = m[x]
+		case int:
+			a, _ := v.([]interface{})
+			if x >= len(a) {
+				return nil
+			}
+			v = a[x]
+		}
+	}
+	return v
+}
+
+func TestResources(t *testing.T) {
+	srv, _ := newServer(t)
+	defer srv.Close()
+	if code, _ := do(t, srv, "GET", root+"/Chassis/1", "", false); code !=
+		http.StatusUnauthorized {
+		t.Error("unauthenticated chassis:", code)
+	}
+	if code, _ := do(t, srv, "GET", root+"/", "", false); code !=
+		http.StatusOK {
+		t.Error("unauthenticated service root:", code)
+	}
+	_, power := do(t, srv, "GET", chassis+"/Power", "", true)
+	_, thermal := do(t, srv, "GET", chassis+"/Thermal", "", true)
+	_, fw := do(t, srv, "GET", inventory+"/kernel", "", true)
+	_, sys := do(t, srv, "GET", system, "", true)
+	for _, x := range []struct {
+		v    map[string]interface{}
+		path []interface{}
+		want interface{}
+	}{
+		{power, []interface{}{"PowerSupplies", 0, "Status", "State"},
+			"Absent"},
+		{power, []interface{}{"PowerSupplies", 1, "Model"},
+			"DPS-550AB-39 A"},
+		{power, []interface{}{"PowerSupplies", 1, "PowerInputWatts"},
+			212.5},
+		{power, []interface{}{"PowerSupplies", 1, "Status", "Health"},
+			"Warning"},
+		{power, []interface{}{"Voltages", 0, "Name"}, "vmon.5v.sb"},
+		{power, []interface{}{"Voltages", 0, "ReadingVolts"}, 5.01},
+		{power, []interface{}{"Voltages", 0,
+			"UpperThresholdNonCritical"}, 5.25},
+		{thermal, []interface{}{"Fans", 0, "Reading"}, 8000.0},
+		{thermal, []interface{}{"Fans", 0, "Status", "State"},
+			"Enabled"},
+		{thermal, []interface{}{"Fans", 1, "Status", "State"},
+			"Absent"},
+		{thermal, []interface{}{"Temperatures", 0, "Name"},
+			"psu2.temp1"},
+		{thermal, []interface{}{"Temperatures", 1}, nil},
+		{fw, []interface{}{"Version"}, "v1.2.0-1"},
+		{sys, []interface{}{"PowerState"}, "On"},
+		{sys, []interface{}{"Actions", "#ComputerSystem.Reset",
+			"ResetType@Redfish.AllowableValues"},
+			[]interface{}{"ForceOff", "ForceRestart",
+				"GracefulShutdown", "On", "PowerCycle"}},
+	} {
+		if got := get(x.v, x.path...); !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%v: got %v, want %v", x.path, got, x.want)
+		}
+	}
+	if code, _ := do(t, srv, "GET", inventory+"/dtb", "", true); code !=
+		http.StatusNotFound {
+		t.Error("missing image:", code)
+	}
+}
+
+func TestReset(t *testing.T) {
+	srv, keys := newServer(t)
+	defer srv.Close()
+	for _, x := range []struct {
+		method, body string
+		code         int
+		request      string
+	}{
+		{"POST", `{"ResetType": "ForceRestart"}`, http.StatusNoContent,
+			"reset redfishd"},
+		{"POST", `{"ResetType": "PowerCycle"}`, http.StatusNoContent,
+			"cycle redfishd"},
+		{"POST", `{"ResetType": "ForceOff"}`, http.StatusNoContent,
+			"off redfishd"},
+		{"POST", `{"ResetType": "GracefulShutdown"}`,
+			http.StatusNoContent, "soft redfishd"},
+		{"POST", `{"ResetType": "Nmi"}`, http.StatusBadRequest, ""},
+		{"POST", `{`, http.StatusBadRequest, ""},
+		{"GET", "", http.StatusMethodNotAllowed, ""},
+	} {
+		keys.Hdel("chassis.power")
+		code, _ := do(t, srv, x.method, resetAction, x.body, true)
+		if code != x.code {
+			t.Errorf("%s %s: got %d, want %d", x.method, x.body,
+				code, x.code)
+		}
+		if v := keys.Hget("chassis.power"); v != x.request {
+			t.Errorf("%s %s: chassis.power %q, want %q", x.method,
+				x.body, v, x.request)
+		}
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package redfishd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/cmd/upgrade"
+	"github.com/platinasystems/goes-bmc/store"
+)
+
+const (
+	root        = "/redfish/v1"
+	system      = root + "/Systems/1"
+	chassis     = root + "/Chassis/1"
+	manager     = root + "/Managers/bmc"
+	inventory   = root + "/UpdateService/FirmwareInventory"
+	resetAction = system + "/Actions/ComputerSystem.Reset"
+)
+
+// ResetTypes are the supported ComputerSystem.Reset actions and the
+// chassisd action of each.
+var ResetTypes = map[string]string{
+	"On":               chassisd.On,
+	"ForceOff":         chassisd.Off,
+	"GracefulShutdown": chassisd.Soft,
+	"ForceRestart":     chassisd.Reset,
+	"PowerCycle":       chassisd.Cycle,
+}
+
+// Server maps the redis keys of the daemons to Redfish resources.
+type Server struct {
+	Store store.Store
+	// Users, if not nil, authenticates requests other than those of the
+	// service root.
+	Users *Users
+	// Thresholds of the sensor readings, by redis key.
+	Thresholds map[string]alarm.Threshold
+	// Firmware returns the installed version and images.
+	Firmware func() (string, []upgrade.IMGINFO, error)
+}
+
+type object map[string]interface{}
+
+func link(path string) object { return object{"@odata.id": path} }
+
+func collection(path, typ, name string, members ...string) object {
+	links := make([]object, 0, len(members))
+	for _, m := range members {
+		links = append(links, link(m))
+	}
+	return object{
+		"@odata.id":           path,
+		"@odata.type":         "#" + typ + "." + typ,
+		"Name":                name,
+		"Members@odata.count": len(links),
+		"Members":             links,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if path != "/redfish" && path != root && s.Users != nil &&
+		!s.Users.Authenticate(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="redfish"`)
+		fail(w, http.StatusUnauthorized, "Base.1.0.InsufficientPrivilege",
+			"authentication required")
+		return
+	}
+	if path == resetAction {
+		if r.Method != http.MethodPost {
+			notAllowed(w, http.MethodPost)
+			return
+		}
+		s.reset(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		notAllowed(w, http.MethodGet)
+		return
+	}
+	v, err := s.get(path)
+	if err != nil {
+		fail(w, http.StatusInternalServerError,
+			"Base.1.0.InternalError", err.Error())
+		return
+	}
+	if v == nil {
+		fail(w, http.StatusNotFound, "Base.1.0.ResourceMissingAtURI",
+			r.URL.Path+": not found")
+		return
+	}
+	reply(w, http.StatusOK, v)
+}
+
+func (s *Server) get(path string) (object, error) {
+	switch path {
+	case "/redfish":
+		return object{"v1": root + "/"}, nil
+	case root:
+		return object{
+			"@odata.id":      root,
+			"@odata.type":    "#ServiceRoot.v1_5_0.ServiceRoot",
+			"Id":             "RootService",
+			"Name":           "Root Service",
+			"RedfishVersion": "1.6.0",
+			"Systems":        link(root + "/Systems"),
+			"Chassis":        link(root + "/Chassis"),
+			"Managers":       link(root + "/Managers"),
+			"UpdateService":  link(root + "/UpdateService"),
+		}, nil
+	case root + "/Systems":
+		return collection(path, "ComputerSystemCollection",
+			"Computer System Collection", system), nil
+	case root + "/Chassis":
+		return collection(path, "ChassisCollection",
+			"Chassis Collection", chassis), nil
+	case root + "/Managers":
+		return collection(path, "ManagerCollection",
+			"Manager Collection", manager), nil
+	case system:
+		return s.system()
+	case chassis:
+		return s.chassis()
+	case chassis + "/Power":
+		return s.power()
+	case chassis + "/Thermal":
+		return s.thermal()
+	case manager:
+		return s.manager()
+	case root + "/UpdateService":
+		return object{
+			"@odata.id":         path,
+			"@odata.type":       "#UpdateService.v1_2_0.UpdateService",
+			"Id":                "UpdateService",
+			"Name":              "Update Service",
+			"FirmwareInventory": link(inventory),
+		}, nil
+	case inventory:
+		_, images, err := s.Firmware()
+		if err != nil {
+			return nil, err
+		}
+		var members []string
+		for _, im := range images {
+			members = append(members, inventory+"/"+im.Name)
+		}
+		return collection(path, "SoftwareInventoryCollection",
+			"Firmware Inventory Collection", members...), nil
+	}
+	if strings.HasPrefix(path, inventory+"/") {
+		return s.image(strings.TrimPrefix(path, inventory+"/"))
+	}
+	return nil, nil
+}
+
+func (s *Server) system() (object, error) {
+	keys, err := s.Store.Hgetall()
+	if err != nil {
+		return nil, err
+	}
+	var allowed []string
+	for t := range ResetTypes {
+		allowed = append(allowed, t)
+	}
+	sort.Strings(allowed)
+	return object{
+		"@odata.id":   system,
+		"@odata.type": "#ComputerSystem.v1_5_0.ComputerSystem",
+		"Id":          "1",
+		"Name":        "Host",
+		"SystemType":  "Physical",
+		"PowerState":  powerState(keys),
+		"Status":      status("Enabled", worst(keys, "")),
+		"Links": object{
+			"Chassis":   []object{link(chassis)},
+			"ManagedBy": []object{link(manager)},
+		},
+		"Actions": object{
+			"#ComputerSystem.Reset": object{
+				"target":                            resetAction,
+				"ResetType@Redfish.AllowableValues": allowed,
+			},
+		},
+	}, nil
+}
+
+func (s *Server) chassis() (object, error) {
+	keys, err := s.Store.Hgetall()
+	if err != nil {
+		return nil, err
+	}
+	return object{
+		"@odata.id":   chassis,
+		"@odata.type": "#Chassis.v1_7_0.Chassis",
+		"Id":          "1",
+		"Name":        "Chassis",
+		"ChassisType": "RackMount",
+		"Model":       keys["platform.name"],
+		"PartNumber":  keys["platform.ppn"],
+		"PowerState":  powerState(keys),
+		"Status":      status("Enabled", worst(keys, "")),
+		"Power":       link(chassis + "/Power"),
+		"Thermal":     link(chassis + "/Thermal"),
+		"Links": object{
+			"ComputerSystems": []object{link(system)},
+			"ManagedBy":       []object{link(manager)},
+		},
+	}, nil
+}
+
+func (s *Server) power() (object, error) {
+	keys, err := s.Store.Hgetall()
+	if err != nil {
+		return nil, err
+	}
+	path := chassis + "/Power"
+	psus := []object{}
+	for _, slot := range store.Indexes(keys, "psu", ".status") {
+		n := strconv.Itoa(slot)
+		p := "psu" + n + "."
+		state := "Enabled"
+		switch keys[p+"status"] {
+		case "not_installed":
+			state = "Absent"
+		case "powered_off":
+			state = "StandbyOffline"
+		}
+		psu := object{
+			"@odata.id": fmt.Sprint(path, "#/PowerSupplies/",
+				len(psus)),
+			"MemberId":     n,
+			"Name":         "PSU " + n,
+			"Manufacturer": keys[p+"mfg_id"],
+			"Model":        keys[p+"mfg_model"],
+			"SerialNumber": keys[p+"sn"],
+			"Status":       status(state, worst(keys, p)),
+		}
+		for k, name := range map[string]string{
+			"p_in.units.W":  "PowerInputWatts",
+			"p_out.units.W": "PowerOutputWatts",
+			"v_in.units.V":  "LineInputVoltage",
+		} {
+			if v, ok := reading(keys, p+k); ok {
+				psu[name] = v
+			}
+		}
+		psus = append(psus, psu)
+	}
+	voltages := []object{}
+	for _, k := range sortedKeys(keys) {
+		if !strings.HasPrefix(k, "vmon.") ||
+			!strings.HasSuffix(k, ".units.V") {
+			continue
+		}
+		v := s.sensor(keys, k, "ReadingVolts")
+		v["@odata.id"] = fmt.Sprint(path, "#/Voltages/", len(voltages))
+		v["MemberId"] = fmt.Sprint(len(voltages))
+		voltages = append(voltages, v)
+	}
+	return object{
+		"@odata.id":     path,
+		"@odata.type":   "#Power.v1_5_0.Power",
+		"Id":            "Power",
+		"Name":          "Power",
+		"PowerSupplies": psus,
+		"Voltages":      voltages,
+	}, nil
+}
+
+func (s *Server) thermal() (object, error) {
+	keys, err := s.Store.Hgetall()
+	if err != nil {
+		return nil, err
+	}
+	path := chassis + "/Thermal"
+	fans := []object{}
+	temps := []object{}
+	for _, k := range sortedKeys(keys) {
+		switch {
+		case strings.HasSuffix(k, ".units.rpm"):
+			f := s.sensor(keys, k, "Reading")
+			f["ReadingUnits"] = "RPM"
+			f["@odata.id"] = fmt.Sprint(path, "#/Fans/", len(fans))
+			f["MemberId"] = fmt.Sprint(len(fans))
+			// fan_tray.N.M.speed.units.rpm is absent with its tray
+			if i := strings.Index(k, ".speed."); i > 0 &&
+				strings.HasPrefix(k, "fan_tray.") {
+				tray := k[:strings.LastIndex(k[:i], ".")]
+				if strings.Contains(keys[tray+".status"],
+					"not installed") {
+					f["Status"].(object)["State"] = "Absent"
+				}
+			}
+			fans = append(fans, f)
+		case strings.HasSuffix(k, ".units.C") &&
+			!strings.Contains(k, ".target."):
+			t := s.sensor(keys, k, "ReadingCelsius")
+			t["@odata.id"] = fmt.Sprint(path, "#/Temperatures/",
+				len(temps))
+			t["MemberId"] = fmt.Sprint(len(temps))
+			temps = append(temps, t)
+		}
+	}
+	return object{
+		"@odata.id":    path,
+		"@odata.type":  "#Thermal.v1_4_0.Thermal",
+		"Id":           "Thermal",
+		"Name":         "Thermal",
+		"Fans":         fans,
+		"Temperatures": temps,
+	}, nil
+}
+
+func (s *Server) manager() (object, error) {
+	version, _, err := s.Firmware()
+	if err != nil {
+		return nil, err
+	}
+	return object{
+		"@odata.id":       manager,
+		"@odata.type":     "#Manager.v1_5_0.Manager",
+		"Id":              "bmc",
+		"Name":            "BMC",
+		"ManagerType":     "BMC",
+		"FirmwareVersion": version,
+		"Status":          status("Enabled", alarm.Ok),
+		"Links": object{
+			"ManagerForServers": []object{link(system)},
+			"ManagerForChassis": []object{link(chassis)},
+		},
+	}, nil
+}
+
+func (s *Server) image(name string) (object, error) {
+	_, images, err := s.Firmware()
+	if err != nil {
+		return nil, err
+	}
+	for _, im := range images {
+		if im.Name != name {
+			continue
+		}
+		return object{
+			"@odata.id":   inventory + "/" + im.Name,
+			"@odata.type": "#SoftwareInventory.v1_2_0.SoftwareInventory",
+			"Id":          im.Name,
+			"Name":        im.Name,
+			"Version":     im.Tag,
+			"Updateable":  true,
+			"Status":      status("Enabled", alarm.Ok),
+			"Oem": object{
+				"Platina": object{
+					"Build":  im.Build,
+					"User":   im.User,
+					"Size":   im.Size,
+					"Commit": im.Commit,
+					"Chksum": im.Chksum,
+				},
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResetType string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fail(w, http.StatusBadRequest, "Base.1.0.MalformedJSON",
+			err.Error())
+		return
+	}
+	action, found := ResetTypes[req.ResetType]
+	if !found {
+		fail(w, http.StatusBadRequest,
+			"Base.1.0.ActionParameterValueNotInList",
+			fmt.Sprintf("ResetType %q: not supported", req.ResetType))
+		return
+	}
+	err := s.Store.Hset(chassisd.Field, action+" redfishd")
+	if err != nil {
+		fail(w, http.StatusInternalServerError,
+			"Base.1.0.InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sensor returns the reading of k with its thresholds and health.
+func (s *Server) sensor(keys map[string]string, k, name string) object {
+	o := object{
+		"Name":   strings.Split(k, ".units.")[0],
+		"Status": status("Enabled", level(keys, alarm.Key(k))),
+	}
+	if v, ok := reading(keys, k); ok {
+		o[name] = v
+	}
+	if t, found := s.Thresholds[k]; found {
+		for name, limit := range map[string]*float64{
+			"LowerThresholdCritical":    t.LowCritical,
+			"LowerThresholdNonCritical": t.LowWarning,
+			"UpperThresholdNonCritical": t.HighWarning,
+			"UpperThresholdCritical":    t.HighCritical,
+		} {
+			if limit != nil {
+				o[name] = *limit
+			}
+		}
+	}
+	return o
+}
+
+func powerState(keys map[string]string) string {
+	if store.PoweredOn(keys) {
+		return "On"
+	}
+	return "Off"
+}
+
+func status(state string, l alarm.Level) object {
+	health := "OK"
+	switch l {
+	case alarm.Warning:
+		health = "Warning"
+	case alarm.Critical:
+		health = "Critical"
+	}
+	return object{"State": state, "Health": health}
+}
+
+func level(keys map[string]string, k string) alarm.Level {
+	l, _ := alarm.ParseLevel(keys[k])
+	return l
+}
+
+// worst returns the most severe of the alarms of the keys with prefix.
+func worst(keys map[string]string, prefix string) alarm.Level {
+	w := alarm.Ok
+	for k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if !strings.HasSuffix(k, ".alarm") &&
+			!strings.HasPrefix(k, "alarm.") {
+			continue
+		}
+		if l := level(keys, k); l > w {
+			w = l
+		}
+	}
+	return w
+}
+
+func reading(keys map[string]string, k string) (float64, bool) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(keys[k]), 64)
+	return v, err == nil
+}
+
+func sortedKeys(keys map[string]string) []string {
+	s := make([]string, 0, len(keys))
+	for k := range keys {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func reply(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("OData-Version", "4.0")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func fail(w http.ResponseWriter, code int, id, msg string) {
+	reply(w, code, object{
+		"error": object{
+			"code":    id,
+			"message": msg,
+		},
+	})
+}
+
+func notAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	fail(w, http.StatusMethodNotAllowed, "Base.1.0.GeneralError",
+		"method not allowed")
+}
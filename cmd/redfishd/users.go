@@ -0,0 +1,57 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package redfishd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Users are the accounts of the service, read from a file of
+// "USER:BCRYPT-HASH" lines as made by "htpasswd -nB USER".
+type Users struct {
+	hash map[string][]byte
+}
+
+func LoadUsers(fn string) (*Users, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return ParseUsers(b), nil
+}
+
+func ParseUsers(b []byte) *Users {
+	u := &Users{hash: make(map[string][]byte)}
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			u.hash[line[:i]] = []byte(line[i+1:])
+		}
+	}
+	return u
+}
+
+// Authenticate checks the basic authentication of r.
+func (u *Users) Authenticate(r *http.Request) bool {
+	user, passwd, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, found := u.hash[user]
+	if !found {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(passwd)) == nil
+}
@@ -0,0 +1,109 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package sel
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/platinasystems/flags"
+	"github.com/platinasystems/goes-bmc/sel"
+	"github.com/platinasystems/goes/lang"
+)
+
+type Command struct{}
+
+func (Command) String() string { return "sel" }
+
+func (Command) Usage() string {
+	return "sel list [-json] | clear | export [FILE]"
+}
+
+func (Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "display or clear the system event log",
+	}
+}
+
+func (Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The sel command manages the system event log, the PSU, fan,
+	thermal and power events that the daemons record in
+	/perm/var/sel/sel.json. The log keeps the newest 1024 events.
+
+	list	display the events, oldest first; with -json, as a JSON
+		array
+	clear	remove all events
+	export	write the events as a JSON array to FILE or, by default,
+		to standard output`,
+	}
+}
+
+func (Command) Main(args ...string) error {
+	flag, args := flags.New(args, "-json")
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list":
+		if len(args) > 1 {
+			return fmt.Errorf("%v: unexpected", args[1:])
+		}
+		entries, err := sel.Default.Entries()
+		if err != nil {
+			return err
+		}
+		if flag.ByName["-json"] {
+			return export(os.Stdout, entries)
+		}
+		for i := range entries {
+			fmt.Println(entries[i].String())
+		}
+	case "clear":
+		if len(args) > 1 {
+			return fmt.Errorf("%v: unexpected", args[1:])
+		}
+		return sel.Default.Clear()
+	case "export":
+		if len(args) > 2 {
+			return fmt.Errorf("%v: unexpected", args[2:])
+		}
+		entries, err := sel.Default.Entries()
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			return export(os.Stdout, entries)
+		}
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		err = export(f, entries)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	default:
+		return fmt.Errorf("%s: unknown", args[0])
+	}
+	return nil
+}
+
+func export(w io.Writer, entries []sel.Entry) error {
+	if entries == nil {
+		entries = []sel.Entry{}
+	}
+	b, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
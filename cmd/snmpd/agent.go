@@ -0,0 +1,371 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/log"
+)
+
+// maxMessageSize is the largest message sent or received, that of an
+// unfragmented UDP datagram on ethernet.
+const maxMessageSize = 1472
+
+// Error status of a response PDU.
+const (
+	errTooBig      = 1
+	errGenErr      = 5
+	errNotWritable = 17
+)
+
+// Agent answers SNMPv2c and SNMPv3 requests of the MIB of the published
+// keys and sends their notifications.
+type Agent struct {
+	Store store.Store
+	// Keys are the published redis keys, those with a sensor unit make
+	// the entPhySensorTable.
+	Keys     []string
+	Config   *Config
+	EngineID []byte
+	Boots    int32
+	// Descr and Name are the sysDescr and sysName.
+	Descr, Name string
+
+	once  sync.Once
+	start time.Time
+	keys  map[string]*keys
+	salt  uint64
+	reqID int32
+
+	mutex sync.Mutex
+	stats map[string]uint32
+	last  map[string]string
+}
+
+func (a *Agent) init() {
+	a.start = time.Now()
+	var b [8]byte
+	rand.Read(b[:])
+	a.salt = binary.BigEndian.Uint64(b[:])
+	a.reqID = int32(binary.BigEndian.Uint32(b[4:]) & 0x7fffffff)
+	if a.Config == nil {
+		a.Config = &Config{}
+	}
+	a.keys = make(map[string]*keys)
+	for name, u := range a.Config.Users {
+		a.keys[name] = newKeys(u, a.EngineID)
+	}
+	a.stats = make(map[string]uint32)
+	a.last = make(map[string]string)
+}
+
+func (a *Agent) upTime() TimeTicks {
+	a.once.Do(a.init)
+	return TimeTicks(time.Since(a.start) / (10 * time.Millisecond))
+}
+
+func (a *Agent) engineTime() int32 {
+	a.once.Do(a.init)
+	return int32(time.Since(a.start) / time.Second)
+}
+
+func (a *Agent) nextSalt() uint64 { return atomic.AddUint64(&a.salt, 1) }
+
+func (a *Agent) nextID() int32 {
+	return atomic.AddInt32(&a.reqID, 1) & 0x7fffffff
+}
+
+// count increments and returns a usmStats counter.
+func (a *Agent) count(o OID) uint32 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.stats[o.String()]++
+	return a.stats[o.String()]
+}
+
+func (a *Agent) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 2*maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if reply := a.Handle(buf[:n]); reply != nil {
+			if _, err = conn.WriteTo(reply, addr); err != nil {
+				log.Print("snmpd: ", addr, ": ", err)
+			}
+		}
+	}
+}
+
+// Handle returns the reply to a message, nil if none.
+func (a *Agent) Handle(pkt []byte) []byte {
+	a.once.Do(a.init)
+	t, _, err := next(pkt)
+	if err != nil || t.tag != tagSequence {
+		return nil
+	}
+	v, _, err := next(t.body)
+	if err != nil {
+		return nil
+	}
+	switch version, _ := v.int(); version {
+	case 1:
+		return a.handleV2c(t.body)
+	case 3:
+		return a.handleV3(pkt)
+	}
+	return nil
+}
+
+func (a *Agent) handleV2c(b []byte) []byte {
+	l, err := elements(b, tagInteger, tagOctetString, 0)
+	if err != nil {
+		return nil
+	}
+	community := l[1].body
+	ok := false
+	for _, s := range a.Config.Communities {
+		if hmac.Equal([]byte(s), community) {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	p, err := parsePDU(enc(l[2].tag, l[2].body))
+	if err != nil {
+		return nil
+	}
+	r := a.respond(p, maxMessageSize-16-len(community))
+	if r == nil {
+		return nil
+	}
+	return enc(tagSequence, encInt(tagInteger, 1),
+		enc(tagOctetString, community), r.encode())
+}
+
+func (a *Agent) handleV3(pkt []byte) []byte {
+	b := append([]byte{}, pkt...)
+	m, err := parseV3(b)
+	if err != nil {
+		return nil
+	}
+	var reqID int32
+	if m.flags&flagPriv == 0 {
+		if _, _, p, err := parseScoped(m.scoped); err == nil {
+			reqID = p.RequestID
+		}
+	}
+	h := &v3Header{
+		id:       m.id,
+		engineID: a.EngineID,
+		boots:    a.Boots,
+		time:     a.engineTime(),
+		user:     m.user,
+	}
+	report := func(o OID, flags byte) []byte {
+		n := a.count(o)
+		if m.flags&flagReportable == 0 {
+			return nil
+		}
+		h.flags, h.salt = flags, a.nextSalt()
+		out, err := encodeV3(h, a.EngineID, &PDU{
+			Type:      pduReport,
+			RequestID: reqID,
+			Vars:      []Var{{o, Counter32(n)}},
+		})
+		if err != nil {
+			return nil
+		}
+		return out
+	}
+	if !bytes.Equal(m.engineID, a.EngineID) {
+		return report(usmStatsUnknownEngineIDs, 0)
+	}
+	k := a.keys[string(m.user)]
+	if k == nil {
+		return report(usmStatsUnknownUserNames, 0)
+	}
+	level := m.flags & (flagAuth | flagPriv)
+	if level != k.user.level() {
+		return report(usmStatsUnsupportedSecLevels, 0)
+	}
+	h.keys = k
+	scoped := m.scoped
+	if level&flagAuth != 0 {
+		if len(m.authParams) != authParamsLen {
+			return report(usmStatsWrongDigests, 0)
+		}
+		got := append([]byte{}, m.authParams...)
+		for i := range m.authParams {
+			m.authParams[i] = 0
+		}
+		if !hmac.Equal(got, k.digest(b)) {
+			return report(usmStatsWrongDigests, 0)
+		}
+		dt := m.time - h.time
+		if m.boots != a.Boots || dt > timeWindow || dt < -timeWindow {
+			return report(usmStatsNotInTimeWindows, flagAuth)
+		}
+	}
+	if level&flagPriv != 0 {
+		scoped, err = k.decrypt(m.scoped, m.boots, m.time, m.privParams)
+		if err != nil {
+			return report(usmStatsDecryptionErrors, flagAuth)
+		}
+	}
+	_, _, p, err := parseScoped(scoped)
+	if err != nil {
+		if level&flagPriv != 0 {
+			return report(usmStatsDecryptionErrors, flagAuth)
+		}
+		return nil
+	}
+	max := int(m.maxSize)
+	if max > maxMessageSize || max < 484 {
+		max = maxMessageSize
+	}
+	r := a.respond(p, max-160-len(m.user))
+	if r == nil {
+		return nil
+	}
+	h.flags, h.salt = level, a.nextSalt()
+	out, err := encodeV3(h, a.EngineID, r)
+	if err != nil {
+		log.Print("snmpd: ", err)
+		return nil
+	}
+	return out
+}
+
+// respond returns the response of a request PDU of at most max bytes, nil
+// if p isn't a request.
+func (a *Agent) respond(p *PDU, max int) *PDU {
+	r := &PDU{Type: pduResponse, RequestID: p.RequestID}
+	switch p.Type {
+	case pduGet, pduGetNext, pduGetBulk:
+	case pduSet:
+		r.ErrorStatus, r.ErrorIndex, r.Vars = errNotWritable, 1, p.Vars
+		return r
+	default:
+		return nil
+	}
+	values, err := a.Store.Hgetall()
+	if err != nil {
+		log.Print("snmpd: ", err)
+		r.ErrorStatus, r.ErrorIndex, r.Vars = errGenErr, 1, p.Vars
+		return r
+	}
+	view := a.view(values)
+	size := len(r.encode())
+	add := func(v Var) bool {
+		size += len(enc(tagSequence, v.OID.encode(),
+			encodeValue(v.Value)))
+		if size+4 > max {
+			return false
+		}
+		r.Vars = append(r.Vars, v)
+		return true
+	}
+	tooBig := func() *PDU {
+		r.ErrorStatus, r.ErrorIndex, r.Vars = errTooBig, 0, nil
+		return r
+	}
+	switch p.Type {
+	case pduGet:
+		for _, v := range p.Vars {
+			if !add(get(view, v.OID)) {
+				return tooBig()
+			}
+		}
+	case pduGetNext:
+		for _, v := range p.Vars {
+			if !add(getNext(view, v.OID)) {
+				return tooBig()
+			}
+		}
+	case pduGetBulk:
+		nonRepeaters, maxRepetitions := p.ErrorStatus, p.ErrorIndex
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if nonRepeaters > len(p.Vars) {
+			nonRepeaters = len(p.Vars)
+		}
+		for _, v := range p.Vars[:nonRepeaters] {
+			if !add(getNext(view, v.OID)) {
+				return tooBig()
+			}
+		}
+		var oids []OID
+		for _, v := range p.Vars[nonRepeaters:] {
+			oids = append(oids, v.OID)
+		}
+		for n := 0; n < maxRepetitions && len(oids) > 0; n++ {
+			end := true
+			for i, o := range oids {
+				v := getNext(view, o)
+				if !add(v) {
+					if len(r.Vars) == 0 {
+						return tooBig()
+					}
+					return r
+				}
+				oids[i] = v.OID
+				if _, ok := v.Value.(exception); !ok {
+					end = false
+				}
+			}
+			if end {
+				break
+			}
+		}
+	}
+	return r
+}
+
+func get(view []Var, o OID) Var {
+	i := sort.Search(len(view), func(i int) bool {
+		return view[i].OID.Compare(o) >= 0
+	})
+	if i < len(view) && view[i].OID.Compare(o) == 0 {
+		return view[i]
+	}
+	if len(o) > 1 {
+		parent := o[:len(o)-1]
+		for _, j := range []int{i - 1, i} {
+			if j >= 0 && j < len(view) &&
+				hasPrefix(view[j].OID, parent) {
+				return Var{o, exception(tagNoSuchInstance)}
+			}
+		}
+	}
+	return Var{o, exception(tagNoSuchObject)}
+}
+
+func getNext(view []Var, o OID) Var {
+	i := sort.Search(len(view), func(i int) bool {
+		return view[i].OID.Compare(o) > 0
+	})
+	if i < len(view) {
+		return view[i]
+	}
+	return Var{o, exception(tagEndOfMibView)}
+}
+
+func hasPrefix(o, prefix OID) bool {
+	return len(o) > len(prefix) && o[:len(prefix)].Compare(prefix) == 0
+}
@@ -0,0 +1,394 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags of SNMP, RFC 3416.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagIPAddress   = 0x40
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+	tagCounter64   = 0x46
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+	pduInform   = 0xa6
+	pduTrap     = 0xa7
+	pduReport   = 0xa8
+)
+
+var errBER = errors.New("malformed BER")
+
+// tlv is a decoded BER element; body aliases the decoded buffer.
+type tlv struct {
+	tag  byte
+	body []byte
+}
+
+// next decodes the first element of b and returns the rest.
+func next(b []byte) (tlv, []byte, error) {
+	if len(b) < 2 {
+		return tlv{}, nil, errBER
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		l := n & 0x7f
+		if l == 0 || l > 3 || len(b) < l {
+			return tlv{}, nil, errBER
+		}
+		n = 0
+		for _, c := range b[:l] {
+			n = n<<8 | int(c)
+		}
+		b = b[l:]
+	}
+	if n > len(b) {
+		return tlv{}, nil, errBER
+	}
+	return tlv{tag, b[:n]}, b[n:], nil
+}
+
+// elements decodes the elements of a constructed body, which must have
+// the given tags.
+func elements(b []byte, tags ...byte) ([]tlv, error) {
+	var l []tlv
+	for len(b) > 0 {
+		var t tlv
+		var err error
+		if t, b, err = next(b); err != nil {
+			return nil, err
+		}
+		l = append(l, t)
+	}
+	if len(l) != len(tags) {
+		return nil, errBER
+	}
+	for i, tag := range tags {
+		if tag != 0 && l[i].tag != tag {
+			return nil, fmt.Errorf("tag %#x, want %#x", l[i].tag, tag)
+		}
+	}
+	return l, nil
+}
+
+func (t tlv) int() (int64, error) {
+	if t.tag != tagInteger || len(t.body) == 0 || len(t.body) > 8 {
+		return 0, errBER
+	}
+	v := int64(int8(t.body[0]))
+	for _, c := range t.body[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+func (t tlv) octets() ([]byte, error) {
+	if t.tag != tagOctetString {
+		return nil, errBER
+	}
+	return t.body, nil
+}
+
+func (t tlv) oid() (OID, error) {
+	if t.tag != tagOID || len(t.body) == 0 {
+		return nil, errBER
+	}
+	o := OID{uint32(t.body[0] / 40), uint32(t.body[0] % 40)}
+	var v uint32
+	for i, c := range t.body[1:] {
+		if v > 0x1ffffff {
+			return nil, errBER
+		}
+		v = v<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			o = append(o, v)
+			v = 0
+		} else if i == len(t.body)-2 {
+			return nil, errBER
+		}
+	}
+	return o, nil
+}
+
+// enc encodes an element of the concatenated parts.
+func enc(tag byte, parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := make([]byte, 0, n+6)
+	b = append(b, tag)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func encInt(tag byte, v int64) []byte {
+	n := 1
+	for n < 8 && (v>>(8*uint(n)-1) != 0 && v>>(8*uint(n)-1) != -1) {
+		n++
+	}
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(v >> (8 * uint(n-1-i)))
+	}
+	return enc(tag, b)
+}
+
+// encUint encodes the unsigned application types.
+func encUint(tag byte, v uint64) []byte {
+	var b []byte
+	for ; v != 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if len(b) == 0 || b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return enc(tag, b)
+}
+
+// OID is an object identifier.
+type OID []uint32
+
+// ParseOID returns the OID of a dotted string, e.g. "1.3.6.1.2.1".
+func ParseOID(s string) (OID, error) {
+	var o OID
+	for _, f := range strings.Split(strings.TrimPrefix(s, "."), ".") {
+		v, err := strconv.ParseUint(f, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q: invalid OID", s)
+		}
+		o = append(o, uint32(v))
+	}
+	return o, nil
+}
+
+func mustOID(s string) OID {
+	o, err := ParseOID(s)
+	if err != nil {
+		panic(err)
+	}
+	return o
+}
+
+func (o OID) String() string {
+	s := make([]string, len(o))
+	for i, v := range o {
+		s[i] = strconv.FormatUint(uint64(v), 10)
+	}
+	return strings.Join(s, ".")
+}
+
+// Append returns a new OID of o and the sub-identifiers.
+func (o OID) Append(ids ...uint32) OID {
+	return append(append(OID{}, o...), ids...)
+}
+
+// Compare returns -1, 0 or 1 as o is before, the same or after p.
+func (o OID) Compare(p OID) int {
+	for i := 0; i < len(o) && i < len(p); i++ {
+		switch {
+		case o[i] < p[i]:
+			return -1
+		case o[i] > p[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(p):
+		return -1
+	case len(o) > len(p):
+		return 1
+	}
+	return 0
+}
+
+func (o OID) encode() []byte {
+	if len(o) < 2 {
+		return enc(tagOID, []byte{0})
+	}
+	b := []byte{byte(o[0]*40 + o[1])}
+	for _, v := range o[2:] {
+		var tmp [5]byte
+		i := len(tmp) - 1
+		tmp[i] = byte(v & 0x7f)
+		for v >>= 7; v != 0; v >>= 7 {
+			i--
+			tmp[i] = byte(v&0x7f) | 0x80
+		}
+		b = append(b, tmp[i:]...)
+	}
+	return enc(tagOID, b)
+}
+
+// Values of the application types; an int is an INTEGER, a string or
+// []byte an OCTET STRING and nil a NULL.
+type (
+	Counter32 uint32
+	Gauge32   uint32
+	TimeTicks uint32
+	Counter64 uint64
+	// exception is noSuchObject, noSuchInstance or endOfMibView.
+	exception byte
+)
+
+// Var is a variable binding.
+type Var struct {
+	OID   OID
+	Value interface{}
+}
+
+func encodeValue(v interface{}) []byte {
+	switch x := v.(type) {
+	case int:
+		return encInt(tagInteger, int64(x))
+	case string:
+		return enc(tagOctetString, []byte(x))
+	case []byte:
+		return enc(tagOctetString, x)
+	case OID:
+		return x.encode()
+	case Counter32:
+		return encUint(tagCounter32, uint64(x))
+	case Gauge32:
+		return encUint(tagGauge32, uint64(x))
+	case TimeTicks:
+		return encUint(tagTimeTicks, uint64(x))
+	case Counter64:
+		return encUint(tagCounter64, uint64(x))
+	case exception:
+		return []byte{byte(x), 0}
+	}
+	return []byte{tagNull, 0}
+}
+
+func decodeValue(t tlv) (interface{}, error) {
+	switch t.tag {
+	case tagInteger:
+		v, err := t.int()
+		return int(v), err
+	case tagOctetString:
+		return t.body, nil
+	case tagOID:
+		return t.oid()
+	case tagNull:
+		return nil, nil
+	case tagCounter32, tagGauge32, tagTimeTicks, tagCounter64:
+		var v uint64
+		for _, c := range t.body {
+			v = v<<8 | uint64(c)
+		}
+		switch t.tag {
+		case tagCounter32:
+			return Counter32(v), nil
+		case tagGauge32:
+			return Gauge32(v), nil
+		case tagTimeTicks:
+			return TimeTicks(v), nil
+		}
+		return Counter64(v), nil
+	case tagNoSuchObject, tagNoSuchInstance, tagEndOfMibView:
+		return exception(t.tag), nil
+	}
+	return t.body, nil
+}
+
+// PDU is a request, response or notification.
+type PDU struct {
+	Type      byte
+	RequestID int32
+	// ErrorStatus and ErrorIndex are the non-repeaters and
+	// max-repetitions of a GetBulk request.
+	ErrorStatus int
+	ErrorIndex  int
+	Vars        []Var
+}
+
+func parsePDU(b []byte) (*PDU, error) {
+	t, rest, err := next(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errBER
+	}
+	l, err := elements(t.body, tagInteger, tagInteger, tagInteger,
+		tagSequence)
+	if err != nil {
+		return nil, err
+	}
+	p := &PDU{Type: t.tag}
+	var v [3]int64
+	for i := range v {
+		if v[i], err = l[i].int(); err != nil {
+			return nil, err
+		}
+	}
+	p.RequestID = int32(v[0])
+	p.ErrorStatus, p.ErrorIndex = int(v[1]), int(v[2])
+	for b := l[3].body; len(b) > 0; {
+		var vb tlv
+		if vb, b, err = next(b); err != nil {
+			return nil, err
+		}
+		f, err := elements(vb.body, tagOID, 0)
+		if err != nil {
+			return nil, err
+		}
+		o, err := f[0].oid()
+		if err != nil {
+			return nil, err
+		}
+		val, err := decodeValue(f[1])
+		if err != nil {
+			return nil, err
+		}
+		p.Vars = append(p.Vars, Var{o, val})
+	}
+	return p, nil
+}
+
+func (p *PDU) encode() []byte {
+	var vbs [][]byte
+	for _, v := range p.Vars {
+		vbs = append(vbs, enc(tagSequence, v.OID.encode(),
+			encodeValue(v.Value)))
+	}
+	return enc(p.Type,
+		encInt(tagInteger, int64(p.RequestID)),
+		encInt(tagInteger, int64(p.ErrorStatus)),
+		encInt(tagInteger, int64(p.ErrorIndex)),
+		enc(tagSequence, vbs...))
+}
@@ -0,0 +1,144 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// minPassword is the shortest USM password, as net-snmp requires.
+const minPassword = 8
+
+// Config is the snmpd.conf of communities, users and trap targets.
+type Config struct {
+	// Communities are the read-only SNMPv2c communities.
+	Communities []string
+	Users       map[string]*User
+	Targets     []*Target
+}
+
+// Target is a trap receiver.
+type Target struct {
+	// Addr is HOST:PORT.
+	Addr string
+	// Community is that of a v2c trap and User that of a v3 one.
+	Community string
+	User      *User
+}
+
+func LoadConfig(fn string) (*Config, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	c, err := ParseConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return c, nil
+}
+
+// ParseConfig returns the Config of lines of:
+//
+//	community NAME
+//	user NAME [md5|sha AUTHPASS [des|aes PRIVPASS]]
+//	trap HOST[:PORT] v2c COMMUNITY
+//	trap HOST[:PORT] v3 USER
+//
+// A trap user must precede the trap line.
+func ParseConfig(b []byte) (*Config, error) {
+	c := &Config{Users: make(map[string]*User)}
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		var err error
+		switch f[0] {
+		case "community":
+			if len(f) != 2 {
+				err = fmt.Errorf("usage: community NAME")
+				break
+			}
+			c.Communities = append(c.Communities, f[1])
+		case "user":
+			var u *User
+			if u, err = parseUser(f[1:]); err == nil {
+				c.Users[u.Name] = u
+			}
+		case "trap":
+			var t *Target
+			if t, err = c.parseTarget(f[1:]); err == nil {
+				c.Targets = append(c.Targets, t)
+			}
+		default:
+			err = fmt.Errorf("%q: unknown directive", f[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	return c, scan.Err()
+}
+
+func parseUser(f []string) (*User, error) {
+	if len(f) != 1 && len(f) != 3 && len(f) != 5 {
+		return nil, fmt.Errorf("usage: user NAME " +
+			"[md5|sha AUTHPASS [des|aes PRIVPASS]]")
+	}
+	u := &User{Name: f[0]}
+	if len(u.Name) > 32 {
+		return nil, fmt.Errorf("name too long")
+	}
+	if len(f) > 1 {
+		u.Auth, u.AuthPassword = strings.ToLower(f[1]), f[2]
+		if u.Auth != AuthMD5 && u.Auth != AuthSHA {
+			return nil, fmt.Errorf("%q: unknown authentication", f[1])
+		}
+		if len(u.AuthPassword) < minPassword {
+			return nil, fmt.Errorf("password too short")
+		}
+	}
+	if len(f) > 3 {
+		u.Priv, u.PrivPassword = strings.ToLower(f[3]), f[4]
+		if u.Priv != PrivDES && u.Priv != PrivAES {
+			return nil, fmt.Errorf("%q: unknown privacy", f[3])
+		}
+		if len(u.PrivPassword) < minPassword {
+			return nil, fmt.Errorf("password too short")
+		}
+	}
+	return u, nil
+}
+
+func (c *Config) parseTarget(f []string) (*Target, error) {
+	if len(f) != 3 {
+		return nil, fmt.Errorf("usage: trap HOST[:PORT] v2c|v3 " +
+			"COMMUNITY|USER")
+	}
+	t := &Target{Addr: f[0]}
+	if _, _, err := net.SplitHostPort(t.Addr); err != nil {
+		t.Addr = net.JoinHostPort(t.Addr, "162")
+	}
+	switch f[1] {
+	case "v2c":
+		t.Community = f[2]
+	case "v3":
+		t.User = c.Users[f[2]]
+		if t.User == nil {
+			return nil, fmt.Errorf("%q: unknown user", f[2])
+		}
+	default:
+		return nil, fmt.Errorf("%q: unsupported version", f[1])
+	}
+	return t, nil
+}
@@ -0,0 +1,276 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/platform"
+)
+
+var (
+	enterprise = OID{1, 3, 6, 1, 4, 1, uint32(platform.PEN)}
+
+	sysDescr    = mustOID("1.3.6.1.2.1.1.1.0")
+	sysObjectID = mustOID("1.3.6.1.2.1.1.2.0")
+	sysUpTime   = mustOID("1.3.6.1.2.1.1.3.0")
+	sysName     = mustOID("1.3.6.1.2.1.1.5.0")
+
+	snmpEngineID      = mustOID("1.3.6.1.6.3.10.2.1.1.0")
+	snmpEngineBoots   = mustOID("1.3.6.1.6.3.10.2.1.2.0")
+	snmpEngineTime    = mustOID("1.3.6.1.6.3.10.2.1.3.0")
+	snmpEngineMaxSize = mustOID("1.3.6.1.6.3.10.2.1.4.0")
+
+	// entPhysicalEntry of ENTITY-MIB, RFC 6933
+	entPhysicalEntry = mustOID("1.3.6.1.2.1.47.1.1.1.1")
+	// entPhySensorEntry of ENTITY-SENSOR-MIB, RFC 3433
+	entPhySensorEntry = mustOID("1.3.6.1.2.1.99.1.1.1")
+)
+
+// entPhysicalEntry columns.
+const (
+	entPhysicalDescr        = 2
+	entPhysicalVendorType   = 3
+	entPhysicalContainedIn  = 4
+	entPhysicalClass        = 5
+	entPhysicalParentRelPos = 6
+	entPhysicalName         = 7
+	entPhysicalModelName    = 13
+	entPhysicalIsFRU        = 16
+)
+
+// entPhySensorEntry columns.
+const (
+	entPhySensorType         = 1
+	entPhySensorScale        = 2
+	entPhySensorPrecision    = 3
+	entPhySensorValue        = 4
+	entPhySensorOperStatus   = 5
+	entPhySensorUnitsDisplay = 6
+)
+
+// PhysicalClass values.
+const (
+	classChassis     = 3
+	classPowerSupply = 6
+	classFan         = 7
+	classSensor      = 8
+)
+
+// Entity indexes are those of the chassis, psuN at psuIndex+N, fan_tray.N
+// at fanTrayIndex+N and the sensors from sensorIndex+1 in key order, so
+// that they don't change with the installed PSUs and fan trays.
+const (
+	chassisIndex  = 1
+	psuIndex      = 100
+	fanTrayIndex  = 200
+	sensorIndex   = 1000
+	scaleUnits    = 9
+	sensorOk      = 1
+	sensorUnavail = 2
+	truthTrue     = 1
+	truthFalse    = 2
+)
+
+// sensorType is the EntitySensorDataType, precision and display of the
+// sensors of a ".units.X" key.
+type sensorType struct {
+	dataType, precision int
+	display             string
+}
+
+var sensorTypes = map[string]sensorType{
+	"V":   {4, 3, "volts"}, // voltsDC
+	"A":   {5, 3, "amperes"},
+	"W":   {6, 1, "watts"},
+	"C":   {8, 1, "celsius"},
+	"rpm": {10, 0, "rpm"},
+}
+
+const voltsAC = 3
+
+type entity struct {
+	index       int
+	descr, name string
+	class       int
+	containedIn int
+	relPos      int
+	fru         bool
+	// key is the published key of a sensor.
+	key string
+	sensorType
+}
+
+// entities returns the chassis, PSU, fan tray and sensor entities of the
+// published keys.
+func entities(keys []string) []*entity {
+	keys = append([]string{}, keys...)
+	sort.Strings(keys)
+	psus, trays := make(map[int]bool), make(map[int]bool)
+	var sensors []*entity
+	for _, k := range keys {
+		if n, ok := slot(k, "psu", "."); ok {
+			psus[n] = true
+		}
+		if n, ok := slot(k, "fan_tray.", "."); ok {
+			trays[n] = true
+		}
+		i := strings.Index(k, ".units.")
+		if i < 0 || strings.Contains(k, "target.") {
+			continue
+		}
+		t, found := sensorTypes[k[i+len(".units."):]]
+		if !found {
+			continue
+		}
+		if t.dataType == 4 && strings.Contains(k, ".v_in.") {
+			t.dataType = voltsAC
+		}
+		e := &entity{
+			index:       sensorIndex + len(sensors) + 1,
+			descr:       k,
+			name:        k[:i],
+			class:       classSensor,
+			containedIn: chassisIndex,
+			key:         k,
+			sensorType:  t,
+		}
+		if n, ok := slot(k, "psu", "."); ok {
+			e.containedIn = psuIndex + n
+		} else if n, ok := slot(k, "fan_tray.", "."); ok {
+			e.containedIn = fanTrayIndex + n
+		}
+		sensors = append(sensors, e)
+	}
+	l := []*entity{{
+		index: chassisIndex,
+		descr: "chassis",
+		name:  "chassis",
+		class: classChassis,
+	}}
+	for _, n := range sortedKeys(psus) {
+		l = append(l, &entity{
+			index:       psuIndex + n,
+			descr:       fmt.Sprint("power supply ", n),
+			name:        fmt.Sprint("psu", n),
+			class:       classPowerSupply,
+			containedIn: chassisIndex,
+			relPos:      n,
+			fru:         true,
+		})
+	}
+	for _, n := range sortedKeys(trays) {
+		l = append(l, &entity{
+			index:       fanTrayIndex + n,
+			descr:       fmt.Sprint("fan tray ", n),
+			name:        fmt.Sprint("fan_tray.", n),
+			class:       classFan,
+			containedIn: chassisIndex,
+			relPos:      n,
+			fru:         true,
+		})
+	}
+	pos := make(map[int]int)
+	for _, e := range sensors {
+		pos[e.containedIn]++
+		e.relPos = pos[e.containedIn]
+	}
+	return append(l, sensors...)
+}
+
+// slot returns the number of a key with the prefix, e.g. 2 of psu2.status.
+func slot(k, prefix, sep string) (int, bool) {
+	if !strings.HasPrefix(k, prefix) {
+		return 0, false
+	}
+	s := strings.TrimPrefix(k, prefix)
+	i := strings.Index(s, sep)
+	if i <= 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[:i])
+	return n, err == nil && n > 0
+}
+
+func sortedKeys(m map[int]bool) []int {
+	var l []int
+	for n := range m {
+		l = append(l, n)
+	}
+	sort.Ints(l)
+	return l
+}
+
+// sensorValue returns the entPhySensorValue and entPhySensorOperStatus of
+// a reading.
+func (e *entity) sensorValue(s string) (int, int) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, sensorUnavail
+	}
+	v = math.Round(v * math.Pow10(e.precision))
+	return int(math.Max(-1e9, math.Min(1e9, v))), sensorOk
+}
+
+// view returns the variables of the MIB in OID order.
+func (a *Agent) view(values map[string]string) []Var {
+	vars := []Var{
+		{sysDescr, a.Descr},
+		{sysObjectID, enterprise},
+		{sysUpTime, a.upTime()},
+		{sysName, a.Name},
+		{snmpEngineID, a.EngineID},
+		{snmpEngineBoots, int(a.Boots)},
+		{snmpEngineTime, int(a.engineTime())},
+		{snmpEngineMaxSize, maxMessageSize},
+	}
+	col := func(entry OID, column, index int, v interface{}) {
+		vars = append(vars, Var{entry.Append(uint32(column),
+			uint32(index)), v})
+	}
+	for _, e := range entities(a.Keys) {
+		col(entPhysicalEntry, entPhysicalDescr, e.index, e.descr)
+		col(entPhysicalEntry, entPhysicalVendorType, e.index, OID{0, 0})
+		col(entPhysicalEntry, entPhysicalContainedIn, e.index,
+			e.containedIn)
+		col(entPhysicalEntry, entPhysicalClass, e.index, e.class)
+		relPos := e.relPos
+		if e.containedIn == 0 {
+			relPos = -1
+		}
+		col(entPhysicalEntry, entPhysicalParentRelPos, e.index, relPos)
+		col(entPhysicalEntry, entPhysicalName, e.index, e.name)
+		model := ""
+		if e.class == classPowerSupply {
+			model = values[e.name+".mfg_model"]
+		}
+		col(entPhysicalEntry, entPhysicalModelName, e.index, model)
+		fru := truthFalse
+		if e.fru {
+			fru = truthTrue
+		}
+		col(entPhysicalEntry, entPhysicalIsFRU, e.index, fru)
+		if e.class != classSensor {
+			continue
+		}
+		v, status := e.sensorValue(values[e.key])
+		col(entPhySensorEntry, entPhySensorType, e.index, e.dataType)
+		col(entPhySensorEntry, entPhySensorScale, e.index, scaleUnits)
+		col(entPhySensorEntry, entPhySensorPrecision, e.index,
+			e.precision)
+		col(entPhySensorEntry, entPhySensorValue, e.index, v)
+		col(entPhySensorEntry, entPhySensorOperStatus, e.index, status)
+		col(entPhySensorEntry, entPhySensorUnitsDisplay, e.index,
+			e.display)
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		return vars[i].OID.Compare(vars[j].OID) < 0
+	})
+	return vars
+}
@@ -0,0 +1,211 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package snmpd provides an SNMPv2c and SNMPv3 agent of the ENTITY-MIB and
+// ENTITY-SENSOR-MIB rows of the published sensors.
+package snmpd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/platform"
+	"github.com/platinasystems/goes-bmc/store"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/lang"
+	"github.com/platinasystems/log"
+)
+
+const (
+	DefaultAddr    = ":161"
+	DefaultDir     = "/perm/var/snmpd"
+	ConfigFileName = "snmpd.conf"
+	EngineFileName = "engine"
+)
+
+var (
+	// Keys are the published redis keys, those with a sensor unit
+	// make the entPhySensorTable.
+	Keys []string
+	// Version is that of the sysDescr.
+	Version string
+)
+
+type Command struct {
+	Init func()
+	init sync.Once
+	// Addr is the UDP address to listen on, DefaultAddr if empty.
+	Addr string
+	// Dir has the config and engine files, DefaultDir if empty.
+	Dir string
+}
+
+func (*Command) String() string { return "snmpd" }
+
+func (*Command) Usage() string { return "snmpd" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "SNMP agent of the entity sensors",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The snmpd daemon answers SNMPv2c and SNMPv3 get, get-next and
+	get-bulk requests on UDP port 161 with the system group and the
+	entPhysicalTable and entPhySensorTable rows of the chassis, PSUs,
+	fan trays and each vmon rail, temperature, fan tachometer and PSU
+	reading, e.g.
+
+		snmpwalk -v2c -c public BMC ENTITY-SENSOR-MIB::entPhySensorValue
+
+	It sends an SNMPv2-Trap to each target on psuN.status transitions,
+	fan trays entering "warning low rpm detected" and ucd9090d power
+	events.
+
+	The communities, users and trap targets are lines of
+	/perm/var/snmpd/snmpd.conf:
+
+		community NAME
+		user NAME [md5|sha AUTHPASS [des|aes PRIVPASS]]
+		trap HOST[:PORT] v2c COMMUNITY
+		trap HOST[:PORT] v3 USER
+
+	Without that file, every request is dropped.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	if err := redis.IsReady(); err != nil {
+		return err
+	}
+	addr, dir := c.Addr, c.Dir
+	if len(addr) == 0 {
+		addr = DefaultAddr
+	}
+	if len(dir) == 0 {
+		dir = DefaultDir
+	}
+	config, err := LoadConfig(filepath.Join(dir, ConfigFileName))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		log.Print("notice: snmpd: no ", ConfigFileName)
+	}
+	engineID, boots, err := loadEngine(filepath.Join(dir, EngineFileName))
+	if err != nil {
+		return err
+	}
+	name, _ := os.Hostname()
+	a := &Agent{
+		Store:    store.Redis{},
+		Keys:     Keys,
+		Config:   config,
+		EngineID: engineID,
+		Boots:    boots,
+		Descr:    strings.TrimSpace("Platina Systems BMC " + Version),
+		Name:     name,
+	}
+
+	// subscribe before reading the hash so that no transition is lost
+	psc, err := redis.Subscribe(redis.DefaultHash)
+	if err != nil {
+		return err
+	}
+	defer psc.Close()
+	keys, err := a.Store.Hgetall()
+	if err != nil {
+		return err
+	}
+	for k, v := range keys {
+		a.Set(k, v)
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	a.Notify(coldStart)
+	done := make(chan error, 2)
+	go func() {
+		done <- a.Serve(conn)
+	}()
+	go func() {
+		for {
+			switch t := psc.Receive().(type) {
+			case redigo.Message:
+				if t.Channel == redis.DefaultHash {
+					a.Apply(string(t.Data))
+				}
+			case error:
+				done <- t
+				return
+			}
+		}
+	}()
+	select {
+	case <-goes.Stop:
+		return conn.Close()
+	case err := <-done:
+		conn.Close()
+		return err
+	}
+}
+
+// loadEngine returns the engine ID of fn, creating a random one of the
+// enterprise if the file doesn't exist, and the incremented boots that it
+// saves.
+func loadEngine(fn string) ([]byte, int32, error) {
+	var engineID []byte
+	var boots int32
+	b, err := ioutil.ReadFile(fn)
+	if err == nil {
+		var s string
+		if _, err = fmt.Sscan(string(b), &s, &boots); err == nil {
+			engineID, err = hex.DecodeString(s)
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %v", fn, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	if len(engineID) == 0 {
+		// RFC 3411 format 5, octets of the administrator
+		pen := uint32(platform.PEN) | 0x80000000
+		engineID = append(be32(pen), 5)
+		random := make([]byte, 8)
+		if _, err = rand.Read(random); err != nil {
+			return nil, 0, err
+		}
+		engineID = append(engineID, random...)
+	}
+	if boots++; boots == 0x7fffffff || boots < 1 {
+		boots = 1
+	}
+	if err = os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return nil, 0, err
+	}
+	s := fmt.Sprintf("%x %d\n", engineID, boots)
+	return engineID, boots, ioutil.WriteFile(fn, []byte(s), 0600)
+}
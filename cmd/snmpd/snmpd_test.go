@@ -0,0 +1,489 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/store"
+)
+
+var engineID = []byte{0x80, 0x00, 0xbc, 0x65, 5, 1, 2, 3, 4, 5, 6, 7, 8}
+
+var testKeys = []string{
+	"psu1.status",
+	"psu1.p_in.units.W",
+	"psu1.v_in.units.V",
+	"fan_tray.1.1.speed.units.rpm",
+	"fan_tray.1.status",
+	"vmon.3v3.sys.units.V",
+	"hwmon.front.temp.units.C",
+}
+
+const testConfig = `
+# test config
+community public
+user admin sha authpassword aes privpassword
+user oper md5 authpassword des privpassword
+user monitor sha authpassword
+`
+
+func newAgent(t *testing.T, config string) (*Agent, net.Conn, func()) {
+	c, err := ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Agent{
+		Store: store.NewMap(map[string]string{
+			"psu1.status":                  "powered_on",
+			"psu1.mfg_model":               "DPS-800AB",
+			"psu1.p_in.units.W":            "212.56",
+			"psu1.v_in.units.V":            "230.2",
+			"fan_tray.1.1.speed.units.rpm": "8000",
+			"fan_tray.1.status":            "ok.front->back",
+			"vmon.3v3.sys.units.V":         "n/a",
+			"hwmon.front.temp.units.C":     "50",
+		}),
+		Keys:     testKeys,
+		Config:   c,
+		EngineID: engineID,
+		Boots:    3,
+		Descr:    "Platina Systems BMC v1.2.0",
+		Name:     "bmc",
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go a.Serve(pc)
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, conn, func() {
+		conn.Close()
+		pc.Close()
+	}
+}
+
+// exchange returns the reply to a message, nil if none.
+func exchange(t *testing.T, conn net.Conn, msg []byte) []byte {
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+func v2c(t *testing.T, conn net.Conn, community string, p *PDU) *PDU {
+	reply := exchange(t, conn, enc(tagSequence, encInt(tagInteger, 1),
+		enc(tagOctetString, []byte(community)), p.encode()))
+	if reply == nil {
+		return nil
+	}
+	m, _, err := next(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := elements(m.body, tagInteger, tagOctetString, pduResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := parsePDU(enc(l[2].tag, l[2].body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RequestID != p.RequestID {
+		t.Fatalf("request ID %d, want %d", r.RequestID, p.RequestID)
+	}
+	return r
+}
+
+func vars(oids ...string) []Var {
+	var l []Var
+	for _, s := range oids {
+		l = append(l, Var{mustOID(s), nil})
+	}
+	return l
+}
+
+func checkVars(t *testing.T, got []Var, want ...Var) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%d vars %v, want %d", len(got), got, len(want))
+	}
+	for i := range want {
+		if got[i].OID.Compare(want[i].OID) != 0 {
+			t.Errorf("var %d: %v, want %v", i, got[i].OID, want[i].OID)
+		}
+		g, w := got[i].Value, want[i].Value
+		if s, ok := w.(string); ok {
+			w = []byte(s)
+		}
+		if b, ok := w.([]byte); ok {
+			if gb, _ := g.([]byte); !bytes.Equal(gb, b) {
+				t.Errorf("%v: %q, want %q", got[i].OID, g, b)
+			}
+		} else if o, ok := w.(OID); ok {
+			if og, _ := g.(OID); og.Compare(o) != 0 {
+				t.Errorf("%v: %v, want %v", got[i].OID, g, o)
+			}
+		} else if g != w {
+			t.Errorf("%v: %v, want %v", got[i].OID, g, w)
+		}
+	}
+}
+
+func TestLocalKey(t *testing.T) {
+	// RFC 3414 A.3
+	id := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	for _, x := range []struct {
+		k    []byte
+		want string
+	}{
+		{localKey(md5.New, "maplesyrup", id),
+			"526f5eed9fcce26f8964c2930787d82b"},
+		{localKey(sha1.New, "maplesyrup", id),
+			"6695febc9288e36282235fc7151f128497b38f3f"},
+	} {
+		if got := hex.EncodeToString(x.k); got != x.want {
+			t.Errorf("%s, want %s", got, x.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	for _, s := range []string{
+		"community",
+		"user admin sha short",
+		"user admin sha authpassword 3des privpassword",
+		"trap 10.0.0.1 v3 nobody",
+		"trap 10.0.0.1 v1 public",
+		"view all",
+	} {
+		if _, err := ParseConfig([]byte(s)); err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+	c, err := ParseConfig([]byte(testConfig +
+		"trap 10.0.0.1 v2c public\ntrap [::1]:1162 v3 admin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Targets) != 2 || c.Targets[0].Addr != "10.0.0.1:162" ||
+		c.Targets[1].User != c.Users["admin"] {
+		t.Errorf("targets %+v", c.Targets)
+	}
+}
+
+func TestV2c(t *testing.T) {
+	_, conn, done := newAgent(t, testConfig)
+	defer done()
+
+	r := v2c(t, conn, "public", &PDU{
+		Type:      pduGet,
+		RequestID: 1,
+		Vars: vars("1.3.6.1.2.1.1.1.0", "1.3.6.1.2.1.1.2.0",
+			"1.3.6.1.2.1.1.1", "1.3.6.1.2.1.2.1.0"),
+	})
+	checkVars(t, r.Vars,
+		Var{sysDescr, "Platina Systems BMC v1.2.0"},
+		Var{sysObjectID, enterprise},
+		Var{mustOID("1.3.6.1.2.1.1.1"), exception(tagNoSuchInstance)},
+		Var{mustOID("1.3.6.1.2.1.2.1.0"), exception(tagNoSuchObject)})
+
+	// the sensors are numbered in key order from 1001
+	r = v2c(t, conn, "public", &PDU{
+		Type:      pduGetBulk,
+		RequestID: 2,
+		// non-repeaters
+		ErrorStatus: 1,
+		// max-repetitions
+		ErrorIndex: 6,
+		Vars:       vars("1.3.6.1.2.1.1.4", "1.3.6.1.2.1.99.1.1.1.4"),
+	})
+	value := entPhySensorEntry.Append(entPhySensorValue)
+	status := entPhySensorEntry.Append(entPhySensorOperStatus)
+	checkVars(t, r.Vars,
+		Var{sysName, "bmc"},
+		Var{value.Append(1001), 8000},
+		Var{value.Append(1002), 500},
+		Var{value.Append(1003), 2126},
+		Var{value.Append(1004), 230200},
+		Var{value.Append(1005), 0},
+		Var{status.Append(1001), sensorOk})
+
+	entry := entPhysicalEntry
+	r = v2c(t, conn, "public", &PDU{
+		Type:      pduGetNext,
+		RequestID: 3,
+		Vars: []Var{
+			{entry.Append(entPhysicalName, 200), nil},
+			{entry.Append(entPhysicalContainedIn, 1003), nil},
+			{entry.Append(entPhysicalModelName, 100), nil},
+			{status.Append(1005), nil},
+			{mustOID("1.3.6.1.6.3.10.2.1.1.0"), nil},
+			{mustOID("1.3.7"), nil},
+		},
+	})
+	checkVars(t, r.Vars,
+		Var{entry.Append(entPhysicalName, 201), "fan_tray.1"},
+		Var{entry.Append(entPhysicalContainedIn, 1004), 101},
+		Var{entry.Append(entPhysicalModelName, 101), "DPS-800AB"},
+		Var{entPhySensorEntry.Append(entPhySensorUnitsDisplay, 1001),
+			"rpm"},
+		Var{snmpEngineBoots, 3},
+		Var{mustOID("1.3.7"), exception(tagEndOfMibView)})
+
+	r = v2c(t, conn, "public", &PDU{
+		Type:      pduGet,
+		RequestID: 4,
+		Vars: []Var{
+			{status.Append(1005), nil},
+			{entPhySensorEntry.Append(entPhySensorType, 1004), nil},
+		},
+	})
+	checkVars(t, r.Vars,
+		Var{status.Append(1005), sensorUnavail},
+		Var{entPhySensorEntry.Append(entPhySensorType, 1004), voltsAC})
+
+	r = v2c(t, conn, "public", &PDU{
+		Type:      pduSet,
+		RequestID: 5,
+		Vars:      []Var{{sysName, "x"}},
+	})
+	if r.ErrorStatus != errNotWritable || r.ErrorIndex != 1 {
+		t.Errorf("set: error %d index %d", r.ErrorStatus, r.ErrorIndex)
+	}
+
+	if r = v2c(t, conn, "private", &PDU{
+		Type:      pduGet,
+		RequestID: 6,
+		Vars:      vars("1.3.6.1.2.1.1.1.0"),
+	}); r != nil {
+		t.Error("reply to an unknown community")
+	}
+}
+
+// v3 returns the scoped PDU of the reply to a v3 request of u, nil if none.
+func v3(t *testing.T, conn net.Conn, h *v3Header, p *PDU) (*PDU, byte) {
+	msg, err := encodeV3(h, h.engineID, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := exchange(t, conn, msg)
+	if reply == nil {
+		return nil, 0
+	}
+	m, err := parseV3(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.id != h.id {
+		t.Fatalf("message ID %d, want %d", m.id, h.id)
+	}
+	if m.flags&flagAuth != 0 {
+		got := append([]byte{}, m.authParams...)
+		for i := range m.authParams {
+			m.authParams[i] = 0
+		}
+		if !bytes.Equal(got, h.keys.digest(reply)) {
+			t.Fatal("wrong reply digest")
+		}
+	}
+	scoped := m.scoped
+	if m.flags&flagPriv != 0 {
+		scoped, err = h.keys.decrypt(m.scoped, m.boots, m.time,
+			m.privParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, r, err := parseScoped(scoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the next request is in the agent's time
+	h.engineID, h.boots, h.time = m.engineID, m.boots, m.time
+	return r, m.flags
+}
+
+func TestV3(t *testing.T) {
+	a, conn, done := newAgent(t, testConfig)
+	defer done()
+
+	// discovery
+	h := &v3Header{id: 1, flags: flagReportable}
+	r, _ := v3(t, conn, h, &PDU{Type: pduGet, RequestID: 1})
+	if r == nil || r.Type != pduReport {
+		t.Fatal("no discovery report")
+	}
+	checkVars(t, r.Vars, Var{usmStatsUnknownEngineIDs, Counter32(1)})
+	if !bytes.Equal(h.engineID, engineID) || h.boots != 3 {
+		t.Fatalf("engine %x boots %d", h.engineID, h.boots)
+	}
+
+	for _, x := range []struct {
+		user  string
+		flags byte
+	}{
+		{"admin", flagAuth | flagPriv},
+		{"oper", flagAuth | flagPriv},
+		{"monitor", flagAuth},
+	} {
+		u := a.Config.Users[x.user]
+		h := &v3Header{
+			id:       2,
+			flags:    x.flags | flagReportable,
+			engineID: engineID,
+			user:     []byte(u.Name),
+			keys:     newKeys(u, engineID),
+			salt:     7,
+		}
+		// time synchronization
+		r, flags := v3(t, conn, h, &PDU{Type: pduGet, RequestID: 2})
+		if r == nil || r.Type != pduReport || flags != flagAuth {
+			t.Fatalf("%s: no authenticated time report", x.user)
+		}
+		checkVars(t, r.Vars, Var{usmStatsNotInTimeWindows,
+			Counter32(r.Vars[0].Value.(Counter32))})
+
+		h.id = 3
+		r, flags = v3(t, conn, h, &PDU{
+			Type:      pduGet,
+			RequestID: 3,
+			Vars:      vars("1.3.6.1.2.1.1.5.0"),
+		})
+		if r == nil || r.Type != pduResponse || flags != x.flags {
+			t.Fatalf("%s: no response", x.user)
+		}
+		checkVars(t, r.Vars, Var{sysName, "bmc"})
+	}
+
+	u := *a.Config.Users["admin"]
+	u.AuthPassword = "wrongpassword"
+	h = &v3Header{
+		id:       4,
+		flags:    flagAuth | flagPriv | flagReportable,
+		engineID: engineID,
+		boots:    3,
+		user:     []byte(u.Name),
+		keys:     newKeys(&u, engineID),
+	}
+	r, _ = v3(t, conn, h, &PDU{Type: pduGet, RequestID: 4})
+	if r == nil || r.Type != pduReport ||
+		r.Vars[0].OID.Compare(usmStatsWrongDigests) != 0 {
+		t.Error("no wrong digest report")
+	}
+
+	h = &v3Header{
+		id:       5,
+		flags:    flagReportable,
+		engineID: engineID,
+		user:     []byte("admin"),
+	}
+	r, _ = v3(t, conn, h, &PDU{Type: pduGet, RequestID: 5})
+	if r == nil || r.Type != pduReport ||
+		r.Vars[0].OID.Compare(usmStatsUnsupportedSecLevels) != 0 {
+		t.Error("no unsupported security level report")
+	}
+
+	h.user = []byte("nobody")
+	r, _ = v3(t, conn, h, &PDU{Type: pduGet, RequestID: 6})
+	if r == nil || r.Type != pduReport ||
+		r.Vars[0].OID.Compare(usmStatsUnknownUserNames) != 0 {
+		t.Error("no unknown user report")
+	}
+}
+
+func TestTraps(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	a, _, done := newAgent(t, testConfig+
+		"trap "+pc.LocalAddr().String()+" v2c public\n")
+	defer done()
+	receive := func() *PDU {
+		pc.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		buf := make([]byte, 4096)
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return nil
+		}
+		m, _, err := next(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err := elements(m.body, tagInteger, tagOctetString, pduTrap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := parsePDU(enc(l[2].tag, l[2].body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	a.Set("psu1.status", "powered_on")
+	a.Set("fan_tray.1.status", "ok.front->back")
+	a.Set("vmon.poweroff.events", "")
+	for _, x := range []struct {
+		msg  string
+		want []Var
+	}{
+		{"psu1.status: powered_off", []Var{
+			{snmpTrapOID, psuStatusChange},
+			{entPhysicalEntry.Append(entPhysicalName, 101), "psu1"},
+			{bmcStatus, "powered_off"},
+			{bmcPreviousStatus, "powered_on"},
+		}},
+		{"fan_tray.1.status: warning low rpm detected", []Var{
+			{snmpTrapOID, fanTrayWarning},
+			{entPhysicalEntry.Append(entPhysicalName, 201),
+				"fan_tray.1"},
+			{bmcStatus, "warning low rpm detected"},
+		}},
+		{"vmon.poweroff.events: 2020-09-13 12:26:40", []Var{
+			{snmpTrapOID, powerEvent},
+			{bmcStatus, "2020-09-13 12:26:40"},
+		}},
+	} {
+		a.Apply(x.msg)
+		p := receive()
+		if p == nil {
+			t.Fatalf("%s: no trap", x.msg)
+		}
+		if _, ok := p.Vars[0].Value.(TimeTicks); !ok {
+			t.Errorf("%s: %v isn't sysUpTime", x.msg, p.Vars[0])
+		}
+		checkVars(t, p.Vars[1:], x.want...)
+	}
+
+	for _, msg := range []string{
+		"psu1.status: powered_off",
+		"psu2.status: powered_on",
+		"fan_tray.1.status: warning low rpm detected",
+		"psu1.p_in.units.W: 0",
+	} {
+		a.Apply(msg)
+		if p := receive(); p != nil {
+			t.Errorf("%s: unexpected trap %v", msg, p.Vars)
+		}
+	}
+}
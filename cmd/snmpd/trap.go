@@ -0,0 +1,143 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/platinasystems/log"
+)
+
+var (
+	snmpTrapOID = mustOID("1.3.6.1.6.3.1.1.4.1.0")
+	coldStart   = mustOID("1.3.6.1.6.3.1.1.5.1")
+
+	// The notifications and their objects are those of the enterprise
+	// bmc(1) subtree.
+	psuStatusChange   = enterprise.Append(1, 0, 1)
+	fanTrayWarning    = enterprise.Append(1, 0, 2)
+	powerEvent        = enterprise.Append(1, 0, 3)
+	bmcStatus         = enterprise.Append(1, 1, 1, 0)
+	bmcPreviousStatus = enterprise.Append(1, 1, 2, 0)
+)
+
+// psuStates are those of a psuN.status transition trap.
+var psuStates = map[string]bool{
+	"not_installed": true,
+	"powered_off":   true,
+	"powered_on":    true,
+}
+
+// Set records a published key without notification.
+func (a *Agent) Set(k, v string) {
+	a.once.Do(a.init)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.last[k] = v
+}
+
+// Apply follows a message of the publisher stream, "KEY: VALUE" or
+// "delete: KEY", notifying the targets of PSU status transitions, fan
+// trays entering warning and ucd9090d power events.
+func (a *Agent) Apply(msg string) {
+	a.once.Do(a.init)
+	if strings.HasPrefix(msg, "delete: ") {
+		a.mutex.Lock()
+		delete(a.last, strings.TrimPrefix(msg, "delete: "))
+		a.mutex.Unlock()
+		return
+	}
+	i := strings.Index(msg, ": ")
+	if i < 0 {
+		return
+	}
+	k, v := msg[:i], msg[i+2:]
+	a.mutex.Lock()
+	old, found := a.last[k]
+	a.last[k] = v
+	a.mutex.Unlock()
+	if !found || old == v {
+		return
+	}
+	if n, ok := slot(k, "psu", "."); ok &&
+		k == fmt.Sprint("psu", n, ".status") && psuStates[v] {
+		a.Notify(psuStatusChange, a.entityName(psuIndex+n),
+			Var{bmcStatus, v}, Var{bmcPreviousStatus, old})
+	} else if n, ok := slot(k, "fan_tray.", "."); ok &&
+		k == fmt.Sprint("fan_tray.", n, ".status") &&
+		strings.HasPrefix(v, "warning") &&
+		!strings.HasPrefix(old, "warning") {
+		a.Notify(fanTrayWarning, a.entityName(fanTrayIndex+n),
+			Var{bmcStatus, v})
+	} else if k == "vmon.poweroff.events" {
+		a.Notify(powerEvent, Var{bmcStatus, v})
+	}
+}
+
+func (a *Agent) entityName(index int) Var {
+	name := "chassis"
+	switch {
+	case index > fanTrayIndex:
+		name = fmt.Sprint("fan_tray.", index-fanTrayIndex)
+	case index > psuIndex:
+		name = fmt.Sprint("psu", index-psuIndex)
+	}
+	return Var{entPhysicalEntry.Append(entPhysicalName, uint32(index)),
+		name}
+}
+
+// Notify sends an SNMPv2-Trap of the notification and objects to each
+// target of the config.
+func (a *Agent) Notify(trap OID, vars ...Var) {
+	a.once.Do(a.init)
+	p := &PDU{
+		Type:      pduTrap,
+		RequestID: a.nextID(),
+		Vars: append([]Var{
+			{sysUpTime, a.upTime()},
+			{snmpTrapOID, trap},
+		}, vars...),
+	}
+	for _, t := range a.Config.Targets {
+		var msg []byte
+		if t.User == nil {
+			msg = enc(tagSequence, encInt(tagInteger, 1),
+				enc(tagOctetString, []byte(t.Community)),
+				p.encode())
+		} else {
+			var err error
+			msg, err = encodeV3(&v3Header{
+				id:       int64(a.nextID()),
+				flags:    t.User.level(),
+				engineID: a.EngineID,
+				boots:    a.Boots,
+				time:     a.engineTime(),
+				user:     []byte(t.User.Name),
+				keys:     a.keys[t.User.Name],
+				salt:     a.nextSalt(),
+			}, a.EngineID, p)
+			if err != nil {
+				log.Print("snmpd: ", t.Addr, ": ", err)
+				continue
+			}
+		}
+		if err := send(t.Addr, msg); err != nil {
+			log.Print("snmpd: ", t.Addr, ": ", err)
+		}
+	}
+}
+
+func send(addr string, msg []byte) error {
+	conn, err := net.DialTimeout("udp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(msg)
+	return err
+}
@@ -0,0 +1,347 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package snmpd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"errors"
+	"hash"
+)
+
+// User-based security model, RFC 3414, with the AES privacy of RFC 3826.
+const (
+	securityModelUSM = 3
+
+	flagAuth       = 0x01
+	flagPriv       = 0x02
+	flagReportable = 0x04
+
+	authParamsLen = 12
+
+	// timeWindow is the seconds that a message's engine time may differ
+	// from the agent's.
+	timeWindow = 150
+)
+
+// Authentication and privacy protocols.
+const (
+	AuthNone = ""
+	AuthMD5  = "md5"
+	AuthSHA  = "sha"
+	PrivNone = ""
+	PrivDES  = "des"
+	PrivAES  = "aes"
+)
+
+// usmStats are the report OIDs of the usmStats counters.
+var (
+	usmStatsUnsupportedSecLevels = mustOID("1.3.6.1.6.3.15.1.1.1.0")
+	usmStatsNotInTimeWindows     = mustOID("1.3.6.1.6.3.15.1.1.2.0")
+	usmStatsUnknownUserNames     = mustOID("1.3.6.1.6.3.15.1.1.3.0")
+	usmStatsUnknownEngineIDs     = mustOID("1.3.6.1.6.3.15.1.1.4.0")
+	usmStatsWrongDigests         = mustOID("1.3.6.1.6.3.15.1.1.5.0")
+	usmStatsDecryptionErrors     = mustOID("1.3.6.1.6.3.15.1.1.6.0")
+)
+
+var errDecrypt = errors.New("decryption error")
+
+// User is an SNMPv3 user of the config file.
+type User struct {
+	Name         string
+	Auth         string
+	AuthPassword string
+	Priv         string
+	PrivPassword string
+}
+
+// level returns the message flags of the user's security level.
+func (u *User) level() byte {
+	var flags byte
+	if u.Auth != AuthNone {
+		flags |= flagAuth
+		if u.Priv != PrivNone {
+			flags |= flagPriv
+		}
+	}
+	return flags
+}
+
+func (u *User) hash() func() hash.Hash {
+	if u.Auth == AuthMD5 {
+		return md5.New
+	}
+	return sha1.New
+}
+
+// localKey returns the key of password localized to engineID, RFC 3414
+// section A.2.
+func localKey(h func() hash.Hash, password string, engineID []byte) []byte {
+	ku := h()
+	if len(password) > 0 {
+		buf := make([]byte, 64)
+		for i := 0; i < 1048576; i += len(buf) {
+			for j := range buf {
+				buf[j] = password[(i+j)%len(password)]
+			}
+			ku.Write(buf)
+		}
+	}
+	k := ku.Sum(nil)
+	kul := h()
+	kul.Write(k)
+	kul.Write(engineID)
+	kul.Write(k)
+	return kul.Sum(nil)
+}
+
+// keys are the localized keys of a user.
+type keys struct {
+	user       *User
+	auth, priv []byte
+}
+
+func newKeys(u *User, engineID []byte) *keys {
+	k := &keys{user: u}
+	if u.Auth != AuthNone {
+		k.auth = localKey(u.hash(), u.AuthPassword, engineID)
+		if u.Priv != PrivNone {
+			k.priv = localKey(u.hash(), u.PrivPassword, engineID)
+		}
+	}
+	return k
+}
+
+// digest returns the HMAC-96 of a whole message.
+func (k *keys) digest(msg []byte) []byte {
+	h := hmac.New(k.user.hash(), k.auth)
+	h.Write(msg)
+	return h.Sum(nil)[:authParamsLen]
+}
+
+// encrypt returns the encrypted scoped PDU and the privacy parameters; the
+// salt must be unique for the key.
+func (k *keys) encrypt(b []byte, boots, time int32, salt uint64) ([]byte,
+	[]byte, error) {
+	params := make([]byte, 8)
+	for i := range params {
+		params[i] = byte(salt >> (56 - 8*uint(i)))
+	}
+	if k.user.Priv == PrivDES {
+		// the salt of DES is the boots and a local integer
+		copy(params, be32(uint32(boots)))
+		block, err := des.NewCipher(k.priv[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = k.priv[8+i] ^ params[i]
+		}
+		out := append([]byte{}, b...)
+		for len(out)%des.BlockSize != 0 {
+			out = append(out, 0)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, out)
+		return out, params, nil
+	}
+	block, err := aes.NewCipher(k.priv[:16])
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([]byte, len(b))
+	cipher.NewCFBEncrypter(block, aesIV(boots, time, params)).
+		XORKeyStream(out, b)
+	return out, params, nil
+}
+
+func (k *keys) decrypt(b []byte, boots, time int32, params []byte) ([]byte,
+	error) {
+	if len(params) != 8 {
+		return nil, errDecrypt
+	}
+	if k.user.Priv == PrivDES {
+		if len(b) == 0 || len(b)%des.BlockSize != 0 {
+			return nil, errDecrypt
+		}
+		block, err := des.NewCipher(k.priv[:8])
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, 8)
+		for i := range iv {
+			iv[i] = k.priv[8+i] ^ params[i]
+		}
+		out := make([]byte, len(b))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, b)
+		return out, nil
+	}
+	block, err := aes.NewCipher(k.priv[:16])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(b))
+	cipher.NewCFBDecrypter(block, aesIV(boots, time, params)).
+		XORKeyStream(out, b)
+	return out, nil
+}
+
+func aesIV(boots, time int32, salt []byte) []byte {
+	iv := append(be32(uint32(boots)), be32(uint32(time))...)
+	return append(iv, salt...)
+}
+
+func be32(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// v3Message is a decoded SNMPv3 message.
+type v3Message struct {
+	id, maxSize int64
+	flags       byte
+	engineID    []byte
+	boots, time int32
+	user        []byte
+	// authParams aliases the message buffer so that it may be zeroed to
+	// check the digest.
+	authParams []byte
+	privParams []byte
+	// scoped is the scoped PDU, or the encrypted one if flagPriv.
+	scoped []byte
+}
+
+func parseV3(b []byte) (*v3Message, error) {
+	t, _, err := next(b)
+	if err != nil || t.tag != tagSequence {
+		return nil, errBER
+	}
+	l, err := elements(t.body, tagInteger, tagSequence, tagOctetString, 0)
+	if err != nil {
+		return nil, err
+	}
+	g, err := elements(l[1].body, tagInteger, tagInteger, tagOctetString,
+		tagInteger)
+	if err != nil {
+		return nil, err
+	}
+	m := &v3Message{}
+	if m.id, err = g[0].int(); err != nil {
+		return nil, err
+	}
+	if m.maxSize, err = g[1].int(); err != nil {
+		return nil, err
+	}
+	if len(g[2].body) != 1 {
+		return nil, errBER
+	}
+	m.flags = g[2].body[0]
+	if model, err := g[3].int(); err != nil || model != securityModelUSM {
+		return nil, errors.New("unsupported security model")
+	}
+	sp, _, err := next(l[2].body)
+	if err != nil || sp.tag != tagSequence {
+		return nil, errBER
+	}
+	s, err := elements(sp.body, tagOctetString, tagInteger, tagInteger,
+		tagOctetString, tagOctetString, tagOctetString)
+	if err != nil {
+		return nil, err
+	}
+	m.engineID = s[0].body
+	boots, err := s[1].int()
+	if err != nil {
+		return nil, err
+	}
+	time, err := s[2].int()
+	if err != nil {
+		return nil, err
+	}
+	m.boots, m.time = int32(boots), int32(time)
+	m.user, m.authParams, m.privParams = s[3].body, s[4].body, s[5].body
+	switch {
+	case m.flags&flagPriv != 0 && l[3].tag == tagOctetString:
+		m.scoped = l[3].body
+	case m.flags&flagPriv == 0 && l[3].tag == tagSequence:
+		m.scoped = enc(tagSequence, l[3].body)
+	default:
+		return nil, errBER
+	}
+	return m, nil
+}
+
+// parseScoped returns the context engine ID, context name and PDU of a
+// scoped PDU.
+func parseScoped(b []byte) ([]byte, []byte, *PDU, error) {
+	t, _, err := next(b)
+	if err != nil || t.tag != tagSequence {
+		return nil, nil, nil, errBER
+	}
+	l, err := elements(t.body, tagOctetString, tagOctetString, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p, err := parsePDU(enc(l[2].tag, l[2].body))
+	return l[0].body, l[1].body, p, err
+}
+
+// v3Header is the security of an outgoing SNMPv3 message.
+type v3Header struct {
+	id          int64
+	flags       byte
+	engineID    []byte
+	boots, time int32
+	user        []byte
+	keys        *keys
+	salt        uint64
+}
+
+// encodeV3 returns the message of a scoped PDU, encrypted and signed as
+// the flags of h require.
+func encodeV3(h *v3Header, contextEngineID []byte, p *PDU) ([]byte, error) {
+	scoped := enc(tagSequence, enc(tagOctetString, contextEngineID),
+		enc(tagOctetString), p.encode())
+	data := scoped
+	var privParams []byte
+	if h.flags&flagPriv != 0 {
+		out, params, err := h.keys.encrypt(scoped, h.boots, h.time,
+			h.salt)
+		if err != nil {
+			return nil, err
+		}
+		data, privParams = enc(tagOctetString, out), params
+	}
+	authParams := []byte{}
+	if h.flags&flagAuth != 0 {
+		authParams = make([]byte, authParamsLen)
+	}
+	privTLV := enc(tagOctetString, privParams)
+	sp := enc(tagSequence,
+		enc(tagOctetString, h.engineID),
+		encInt(tagInteger, int64(h.boots)),
+		encInt(tagInteger, int64(h.time)),
+		enc(tagOctetString, h.user),
+		enc(tagOctetString, authParams),
+		privTLV)
+	head := append(encInt(tagInteger, 3),
+		enc(tagSequence,
+			encInt(tagInteger, h.id),
+			encInt(tagInteger, maxMessageSize),
+			enc(tagOctetString, []byte{h.flags}),
+			encInt(tagInteger, securityModelUSM))...)
+	spTLV := enc(tagOctetString, sp)
+	msg := enc(tagSequence, head, spTLV, data)
+	if h.flags&flagAuth != 0 {
+		// the auth params are the last but one element of sp, which
+		// follows the message header and head
+		hdr := len(msg) - len(head) - len(spTLV) - len(data)
+		at := hdr + len(head) + len(spTLV) - len(privTLV) - authParamsLen
+		copy(msg[at:], h.keys.digest(msg))
+	}
+	return msg, nil
+}
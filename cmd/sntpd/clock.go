@@ -0,0 +1,191 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package sntpd
+
+import (
+	"fmt"
+	"math"
+	"syscall"
+	"time"
+
+	"github.com/platinasystems/log"
+)
+
+const (
+	// StepThreshold is the offset beyond which the clock is stepped
+	// rather than slewed.
+	StepThreshold = 128 * time.Millisecond
+	// filterLen is the number of samples of the clock filter.
+	filterLen = 8
+	// adjOffsetSingleshot is the adjtimex mode of adjtime(3).
+	adjOffsetSingleshot = 0x8001
+)
+
+// Clock is that corrected by Sync.
+type Clock interface {
+	Step(offset time.Duration) error
+	Slew(offset time.Duration) error
+}
+
+// SystemClock is the kernel's clock.
+type SystemClock struct{}
+
+func (SystemClock) Step(offset time.Duration) error {
+	tv := syscall.NsecToTimeval(time.Now().Add(offset).UnixNano())
+	return syscall.Settimeofday(&tv)
+}
+
+// Slew has the kernel gradually correct the clock by offset, of less than
+// a second, replacing any correction in progress.
+func (SystemClock) Slew(offset time.Duration) error {
+	us := offset.Microseconds()
+	if us < 0 {
+		us = -us
+	}
+	// Timex.Offset has the arch dependent type of Timeval.Usec
+	tv := syscall.NsecToTimeval(us * 1000)
+	tx := syscall.Timex{Modes: adjOffsetSingleshot, Offset: tv.Usec}
+	if offset < 0 {
+		tx.Offset = -tx.Offset
+	}
+	_, err := syscall.Adjtimex(&tx)
+	return err
+}
+
+// Printer publishes "KEY: VALUE" lines, e.g. a *publisher.Publisher.
+type Printer interface {
+	Print(a ...interface{}) (int, error)
+}
+
+// Sync disciplines a Clock with the samples of SNTP servers.
+type Sync struct {
+	Clock Clock
+	Pub   Printer
+	// Timeout is that of each query.
+	Timeout time.Duration
+	// MinPoll and MaxPoll bound the interval between polls, which
+	// doubles while the clock is slewed and is MinPoll otherwise.
+	MinPoll, MaxPoll time.Duration
+	// Stale is how long after its last sample the clock is no longer
+	// synchronized.
+	Stale time.Duration
+
+	filter       []Sample
+	poll         time.Duration
+	synchronized bool
+	last         time.Time
+	lasts        map[string]string
+}
+
+// Poll queries the first of servers that replies, corrects the clock with
+// the best sample of the filter, and returns the interval until the next
+// poll.
+func (s *Sync) Poll(servers []string) time.Duration {
+	if s.lasts == nil {
+		s.lasts = make(map[string]string)
+		s.poll = s.MinPoll
+		s.publish(SynchronizedKey, "false")
+	}
+	sample, err := s.query(servers)
+	if err != nil {
+		if len(servers) > 0 {
+			log.Print("warning: sntpd: ", err)
+		}
+		if s.synchronized && time.Since(s.last) > s.Stale {
+			log.Print("warning: sntpd: no samples since ",
+				s.last.Format(time.RFC3339))
+			s.synchronized = false
+			s.publish(SynchronizedKey, "false")
+		}
+		s.poll = s.MinPoll
+		return s.poll
+	}
+	if len(s.filter) > 0 && s.filter[0].Server != sample.Server {
+		s.filter = s.filter[:0]
+	}
+	s.filter = append(s.filter, sample)
+	if len(s.filter) > filterLen {
+		s.filter = s.filter[1:]
+	}
+	best := s.filter[0]
+	for _, f := range s.filter[1:] {
+		if f.Delay < best.Delay {
+			best = f
+		}
+	}
+	var sum float64
+	for _, f := range s.filter {
+		d := float64(f.Offset - best.Offset)
+		sum += d * d
+	}
+	jitter := time.Duration(0)
+	if len(s.filter) > 1 {
+		jitter = time.Duration(math.Sqrt(sum / float64(len(s.filter)-1)))
+	}
+
+	offset := best.Offset
+	if offset >= StepThreshold || offset <= -StepThreshold {
+		err = s.Clock.Step(offset)
+		if err == nil {
+			log.Print("notice: sntpd: stepped clock ", offset,
+				" from ", best.Server)
+		}
+		// the samples were of the old clock
+		s.filter = s.filter[:0]
+		s.poll = s.MinPoll
+	} else {
+		err = s.Clock.Slew(offset)
+		// the samples were of the clock before the slew, which
+		// is applied only once
+		for i := range s.filter {
+			s.filter[i].Offset -= offset
+		}
+		if s.poll *= 2; s.poll > s.MaxPoll {
+			s.poll = s.MaxPoll
+		}
+	}
+	if err != nil {
+		log.Print("warning: sntpd: ", err)
+		s.poll = s.MinPoll
+		return s.poll
+	}
+	s.last = time.Now()
+	s.publish("time.server", best.Server)
+	s.publish("time.stratum", fmt.Sprint(best.Stratum))
+	s.publish("time.offset.units.ms", ms(offset))
+	s.publish("time.delay.units.ms", ms(best.Delay))
+	s.publish("time.jitter.units.ms", ms(jitter))
+	if !s.synchronized {
+		log.Print("notice: sntpd: synchronized to ", best.Server)
+		s.synchronized = true
+		s.publish(SynchronizedKey, "true")
+	}
+	return s.poll
+}
+
+func (s *Sync) query(servers []string) (Sample, error) {
+	err := fmt.Errorf("no servers")
+	for _, server := range servers {
+		sample, qerr := Query(server, s.Timeout)
+		if qerr == nil {
+			return sample, nil
+		}
+		err = fmt.Errorf("%s: %v", server, qerr)
+	}
+	return Sample{}, err
+}
+
+// publish prints a changed value of k.
+func (s *Sync) publish(k, v string) {
+	if s.lasts[k] == v {
+		return
+	}
+	s.lasts[k] = v
+	s.Pub.Print(k, ": ", v)
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,127 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package sntpd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ntpPort   = "123"
+	packetLen = 48
+	// ntpEpoch is the Unix time of the NTP epoch, 1900-01-01.
+	ntpEpoch = -2208988800
+)
+
+// Sample is the measurement of a server's clock by one SNTP query.
+type Sample struct {
+	Server string
+	// Offset is that of the server's clock from ours.
+	Offset time.Duration
+	// Delay is the round trip delay less the server's processing.
+	Delay   time.Duration
+	Stratum uint8
+}
+
+// Query sends an SNTP v4 request to server, HOST[:PORT], and returns the
+// sample of its reply.
+func Query(server string, timeout time.Duration) (Sample, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, ntpPort)
+	}
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return Sample{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var req [packetLen]byte
+	req[0] = 4<<3 | 3 // version 4, client mode
+	t1 := time.Now()
+	xmt := toNtp(t1)
+	binary.BigEndian.PutUint64(req[40:], xmt)
+	if _, err = conn.Write(req[:]); err != nil {
+		return Sample{}, err
+	}
+	b := make([]byte, 512)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return Sample{}, err
+		}
+		t4 := time.Now()
+		s, err := parse(b[:n], xmt, t1, t4)
+		if err == errBogus {
+			// a late reply to an earlier request
+			continue
+		}
+		s.Server = server
+		return s, err
+	}
+}
+
+var errBogus = fmt.Errorf("bogus reply")
+
+// parse returns the sample of reply to the request transmitted at xmt,
+// the NTP time of t1, and received at t4.
+func parse(reply []byte, xmt uint64, t1, t4 time.Time) (Sample, error) {
+	if len(reply) < packetLen {
+		return Sample{}, fmt.Errorf("short reply")
+	}
+	li, vn, mode := reply[0]>>6, reply[0]>>3&7, reply[0]&7
+	if mode != 4 || vn < 3 || vn > 4 {
+		return Sample{}, fmt.Errorf("version %d mode %d reply", vn,
+			mode)
+	}
+	if binary.BigEndian.Uint64(reply[24:]) != xmt {
+		return Sample{}, errBogus
+	}
+	stratum := reply[1]
+	if stratum == 0 {
+		return Sample{}, fmt.Errorf("kiss of death %q", reply[12:16])
+	}
+	if li == 3 || stratum > 15 {
+		return Sample{}, fmt.Errorf("unsynchronized server")
+	}
+	rec := binary.BigEndian.Uint64(reply[32:])
+	tx := binary.BigEndian.Uint64(reply[40:])
+	if rec == 0 || tx == 0 {
+		return Sample{}, fmt.Errorf("no server time")
+	}
+	t2, t3 := fromNtp(rec), fromNtp(tx)
+	s := Sample{
+		Offset:  (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		Delay:   t4.Sub(t1) - t3.Sub(t2),
+		Stratum: stratum,
+	}
+	if s.Delay < 0 {
+		s.Delay = 0
+	}
+	return s, nil
+}
+
+// toNtp returns the 32.32 fixed point NTP time of t.
+func toNtp(t time.Time) uint64 {
+	sec := uint64(t.Unix() - ntpEpoch)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return sec<<32 | frac
+}
+
+// fromNtp returns the time of the 32.32 fixed point NTP time v, in 1968 to
+// 2104 as of RFC 4330 section 3: seconds without the high bit set are of
+// the era after 2036.
+func fromNtp(v uint64) time.Time {
+	sec := int64(v >> 32)
+	if sec&0x80000000 == 0 {
+		sec += 1 << 32
+	}
+	nsec := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(sec+ntpEpoch, nsec)
+}
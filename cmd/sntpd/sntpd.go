@@ -0,0 +1,149 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package sntpd provides an SNTP client daemon that sets the system clock
+// and publishes whether it's synchronized.
+package sntpd
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/cmd/dhcpcd"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/external/redis/publisher"
+	"github.com/platinasystems/goes/lang"
+	"github.com/platinasystems/log"
+)
+
+const (
+	DefaultDir      = "/perm/var/sntpd"
+	ServersFileName = "servers"
+
+	// SynchronizedKey is "true" once the clock is set by an SNTP server.
+	SynchronizedKey = "time.synchronized"
+)
+
+type Command struct {
+	Init func()
+	init sync.Once
+	// Dir has the servers file, DefaultDir if empty.
+	Dir string
+}
+
+func (*Command) String() string { return "sntpd" }
+
+func (*Command) Usage() string { return "sntpd" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "synchronize the clock with SNTP servers",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The sntpd daemon sets the system clock with the first server to
+	reply of /perm/var/sntpd/servers, lines of HOST[:PORT], or without
+	that file, of the NTP servers offered by DHCP through dhcpcd.
+
+	It steps the clock by offsets of 128ms or more and slews it by
+	smaller ones, polling every 64 seconds until the clock is slewed,
+	then up to every 1024 seconds.
+
+	It publishes time.synchronized, true once the clock is set and
+	false if there hasn't been a reply for an hour, and the
+	time.server, time.stratum, time.offset.units.ms,
+	time.delay.units.ms and time.jitter.units.ms of the last sample.
+	Daemons that need the wall clock time wait for time.synchronized.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	err := redis.IsReady()
+	if err != nil {
+		return err
+	}
+	dir := c.Dir
+	if len(dir) == 0 {
+		dir = DefaultDir
+	}
+	pub, err := publisher.New()
+	if err != nil {
+		return err
+	}
+	defer pub.Close()
+	s := &Sync{
+		Clock:   SystemClock{},
+		Pub:     pub,
+		Timeout: 5 * time.Second,
+		MinPoll: 64 * time.Second,
+		MaxPoll: 1024 * time.Second,
+		Stale:   time.Hour,
+	}
+	fn := filepath.Join(dir, ServersFileName)
+	for {
+		servers, err := LoadServers(fn)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Print("warning: sntpd: ", err)
+			}
+			v, _ := redis.Hget(redis.DefaultHash, dhcpcd.NtpServersKey)
+			servers = strings.Fields(v)
+		}
+		t := time.NewTimer(s.Poll(servers))
+		select {
+		case <-goes.Stop:
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// LoadServers returns the HOST[:PORT] lines of fn, less blanks and #
+// comments.
+func LoadServers(fn string) ([]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var servers []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			servers = append(servers, line)
+		}
+	}
+	return servers, scan.Err()
+}
+
+// WaitSynchronized waits up to timeout for sntpd to set the clock.
+func WaitSynchronized(timeout time.Duration) error {
+	return redis.Hwait(redis.DefaultHash, SynchronizedKey, "true", timeout)
+}
+
+// Synchronized returns whether sntpd has set the clock.
+func Synchronized() bool {
+	s, err := redis.Hget(redis.DefaultHash, SynchronizedKey)
+	return err == nil && s == "true"
+}
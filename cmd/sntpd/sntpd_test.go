@@ -0,0 +1,285 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package sntpd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server is a local NTP stand-in whose clock is offset from ours.
+type server struct {
+	conn net.PacketConn
+
+	mutex   sync.Mutex
+	offset  time.Duration
+	stratum byte
+	// bogus first sends a reply to some other request
+	bogus bool
+	// delay is that of the replies
+	delay time.Duration
+}
+
+func newServer(t *testing.T, offset time.Duration) *server {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{conn: conn, offset: offset, stratum: 2}
+	go s.serve()
+	return s
+}
+
+func (s *server) String() string { return s.conn.LocalAddr().String() }
+
+func (s *server) set(offset time.Duration, stratum byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.offset, s.stratum = offset, stratum
+}
+
+func (s *server) serve() {
+	b := make([]byte, 512)
+	for {
+		n, addr, err := s.conn.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		if n < packetLen {
+			continue
+		}
+		s.mutex.Lock()
+		rec := toNtp(time.Now().Add(s.offset))
+		var r [packetLen]byte
+		r[0] = 4<<3 | 4
+		r[1] = s.stratum
+		if s.stratum == 0 {
+			copy(r[12:], "RATE")
+		}
+		copy(r[24:32], b[40:48])
+		binary.BigEndian.PutUint64(r[32:], rec)
+		if s.bogus {
+			r[24] ^= 0xff
+			s.conn.WriteTo(r[:], addr)
+			r[24] ^= 0xff
+		}
+		time.Sleep(time.Millisecond)
+		binary.BigEndian.PutUint64(r[40:], toNtp(time.Now().Add(s.offset)))
+		delay := s.delay
+		s.mutex.Unlock()
+		time.Sleep(delay)
+		s.conn.WriteTo(r[:], addr)
+	}
+}
+
+// clock records the corrections.
+type clock struct {
+	steps, slews []time.Duration
+}
+
+func (c *clock) Step(d time.Duration) error {
+	c.steps = append(c.steps, d)
+	return nil
+}
+
+func (c *clock) Slew(d time.Duration) error {
+	c.slews = append(c.slews, d)
+	return nil
+}
+
+// following is a clock whose corrections are those of the server's offset.
+type following struct {
+	clock
+	s *server
+}
+
+func (c *following) Step(d time.Duration) error {
+	c.s.mutex.Lock()
+	c.s.offset -= d
+	c.s.mutex.Unlock()
+	return c.clock.Step(d)
+}
+
+func (c *following) Slew(d time.Duration) error {
+	c.s.mutex.Lock()
+	c.s.offset -= d
+	c.s.mutex.Unlock()
+	return c.clock.Slew(d)
+}
+
+type pub map[string]string
+
+func (p pub) Print(a ...interface{}) (int, error) {
+	s := fmt.Sprint(a...)
+	kv := strings.SplitN(s, ": ", 2)
+	p[kv[0]] = kv[1]
+	return len(s), nil
+}
+
+func near(d, want time.Duration) bool {
+	return d > want-20*time.Millisecond && d < want+20*time.Millisecond
+}
+
+func TestNtpTime(t *testing.T) {
+	for _, want := range []time.Time{
+		time.Date(2020, 9, 13, 12, 26, 40, 500e6, time.UTC),
+		time.Date(2040, 2, 29, 0, 0, 0, 0, time.UTC),
+	} {
+		got := fromNtp(toNtp(want))
+		if d := got.Sub(want); d < -time.Nanosecond || d > time.Nanosecond {
+			t.Errorf("%v: %v", want, got)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	s := newServer(t, 5*time.Second)
+	defer s.conn.Close()
+	s.mutex.Lock()
+	s.bogus = true
+	s.mutex.Unlock()
+	sample, err := Query(s.String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !near(sample.Offset, 5*time.Second) || sample.Stratum != 2 ||
+		sample.Server != s.String() {
+		t.Errorf("%+v", sample)
+	}
+	if sample.Delay < 0 || sample.Delay > 20*time.Millisecond {
+		t.Errorf("delay %v", sample.Delay)
+	}
+
+	s.set(0, 0)
+	if _, err = Query(s.String(), time.Second); err == nil ||
+		!strings.Contains(err.Error(), "RATE") {
+		t.Errorf("kiss of death: %v", err)
+	}
+	s.set(0, 16)
+	if _, err = Query(s.String(), time.Second); err == nil {
+		t.Error("unsynchronized server")
+	}
+}
+
+func TestSync(t *testing.T) {
+	s := newServer(t, -3*time.Second)
+	defer s.conn.Close()
+	down, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Close()
+	servers := []string{down.LocalAddr().String(), s.String()}
+
+	c := new(clock)
+	p := make(pub)
+	sync := &Sync{
+		Clock:   c,
+		Pub:     p,
+		Timeout: 100 * time.Millisecond,
+		MinPoll: 64 * time.Second,
+		MaxPoll: 256 * time.Second,
+		Stale:   time.Hour,
+	}
+
+	// the first server to reply steps the clock
+	if d := sync.Poll(servers); d != 64*time.Second {
+		t.Errorf("poll %v after step", d)
+	}
+	if len(c.steps) != 1 || !near(c.steps[0], -3*time.Second) {
+		t.Fatalf("steps %v", c.steps)
+	}
+	if p[SynchronizedKey] != "true" || p["time.server"] != s.String() ||
+		p["time.stratum"] != "2" {
+		t.Errorf("published %v", p)
+	}
+
+	// then it's slewed, polling less often
+	s.set(10*time.Millisecond, 2)
+	for _, want := range []time.Duration{128, 256, 256} {
+		if d := sync.Poll(servers); d != want*time.Second {
+			t.Errorf("poll %v, want %v", d, want*time.Second)
+		}
+	}
+	if len(c.slews) != 3 || !near(c.slews[2], 10*time.Millisecond) {
+		t.Errorf("slews %v", c.slews)
+	}
+	if len(c.steps) != 1 {
+		t.Errorf("steps %v", c.steps)
+	}
+	if _, found := p["time.jitter.units.ms"]; !found {
+		t.Error("no jitter")
+	}
+
+	// and is no longer synchronized without replies
+	sync.Stale = 0
+	if d := sync.Poll(servers[:1]); d != 64*time.Second {
+		t.Errorf("poll %v without replies", d)
+	}
+	if p[SynchronizedKey] != "false" {
+		t.Errorf("synchronized %q without replies", p[SynchronizedKey])
+	}
+}
+
+func TestSyncSlewOnce(t *testing.T) {
+	s := newServer(t, 100*time.Millisecond)
+	defer s.conn.Close()
+	c := &following{s: s}
+	sync := &Sync{
+		Clock:   c,
+		Pub:     make(pub),
+		Timeout: time.Second,
+		MinPoll: 64 * time.Second,
+		MaxPoll: 256 * time.Second,
+		Stale:   time.Hour,
+	}
+	sync.Poll([]string{s.String()})
+	if len(c.slews) != 1 || !near(c.slews[0], 100*time.Millisecond) {
+		t.Fatalf("slews %v", c.slews)
+	}
+
+	// the slewed sample stays the best of the filter, but its offset
+	// isn't applied again
+	s.mutex.Lock()
+	s.delay = 30 * time.Millisecond
+	s.mutex.Unlock()
+	for i := 0; i < 4; i++ {
+		sync.Poll([]string{s.String()})
+	}
+	if len(c.steps) != 0 {
+		t.Errorf("steps %v", c.steps)
+	}
+	for _, d := range c.slews[1:] {
+		if !near(d, 0) {
+			t.Errorf("slews %v", c.slews)
+			break
+		}
+	}
+}
+
+func TestLoadServers(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), ServersFileName)
+	err := ioutil.WriteFile(fn, []byte(`# pool
+0.pool.ntp.org
+  10.0.0.1:1123  # local
+
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := LoadServers(fn)
+	want := []string{"0.pool.ntp.org", "10.0.0.1:1123"}
+	if err != nil || !reflect.DeepEqual(servers, want) {
+		t.Errorf("%q, %v", servers, err)
+	}
+}
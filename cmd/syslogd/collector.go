@@ -0,0 +1,266 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package syslogd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/platinasystems/log"
+)
+
+const (
+	// DefaultMaxSpool is the most bytes spooled to each collector's files.
+	DefaultMaxSpool = 4 << 20
+	dialTimeout     = 5 * time.Second
+	retryInterval   = 10 * time.Second
+)
+
+var defaultPorts = map[string]string{
+	"udp": "514",
+	"tcp": "601",
+	"tls": "6514",
+}
+
+var errRetry = errors.New("collector down")
+
+// Collector forwards records to a syslog server over UDP, TCP or TLS,
+// spooling them to a file while the server is unreachable.
+type Collector struct {
+	// Network is udp, tcp or tls.
+	Network string
+	Addr    string
+	TLS     *tls.Config
+	// Spool is the file of unsent records, which rotates to Spool.1
+	// past MaxSpool/2 bytes.
+	Spool    string
+	MaxSpool int64
+
+	conn  net.Conn
+	retry time.Time
+	down  bool
+}
+
+// ParseCollector returns the Collector of a URL, udp://HOST[:PORT],
+// tcp://HOST[:PORT] or tls://HOST[:PORT][?ca=FILE], that spools to dir.
+func ParseCollector(s, dir string) (*Collector, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	port, found := defaultPorts[u.Scheme]
+	if !found {
+		return nil, fmt.Errorf("%s: unsupported transport", s)
+	}
+	if len(u.Hostname()) == 0 {
+		return nil, fmt.Errorf("%s: missing host", s)
+	}
+	if len(u.Port()) > 0 {
+		port = u.Port()
+	}
+	c := &Collector{
+		Network:  u.Scheme,
+		Addr:     net.JoinHostPort(u.Hostname(), port),
+		MaxSpool: DefaultMaxSpool,
+	}
+	c.Spool = filepath.Join(dir, u.Scheme+"-"+
+		strings.NewReplacer(":", "_", "[", "", "]", "").Replace(c.Addr))
+	if c.Network == "tls" {
+		c.TLS = &tls.Config{ServerName: u.Hostname()}
+		if ca := u.Query().Get("ca"); len(ca) > 0 {
+			pem, err := ioutil.ReadFile(ca)
+			if err != nil {
+				return nil, err
+			}
+			c.TLS.RootCAs = x509.NewCertPool()
+			if !c.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates", ca)
+			}
+		}
+	}
+	return c, nil
+}
+
+// LoadCollectors returns the collectors of the URL lines of fn.
+func LoadCollectors(fn, dir string) ([]*Collector, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var l []*Collector
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c, err := ParseCollector(line, dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %v", fn, n, err)
+		}
+		l = append(l, c)
+	}
+	return l, scan.Err()
+}
+
+func (c *Collector) String() string { return c.Network + "://" + c.Addr }
+
+// Forward sends a message, after any spooled ones, or spools it if the
+// collector is down.
+func (c *Collector) Forward(msg []byte) {
+	if c.spooled() {
+		c.spool(msg)
+		if !time.Now().Before(c.retry) {
+			c.Flush()
+		}
+		return
+	}
+	if err := c.send(msg); err != nil {
+		c.spool(msg)
+	}
+}
+
+// Flush replays the spooled messages, leaving those that couldn't be sent.
+func (c *Collector) Flush() error {
+	for _, fn := range []string{c.Spool + ".1", c.Spool} {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for len(b) > 0 {
+			msg, rest, err := frame(b)
+			if err != nil {
+				log.Print("warning: syslogd: ", fn, ": ", err)
+				break
+			}
+			if err = c.send(msg); err != nil {
+				return ioutil.WriteFile(fn, b, 0600)
+			}
+			b = rest
+		}
+		os.Remove(fn)
+	}
+	return nil
+}
+
+func (c *Collector) spooled() bool {
+	for _, fn := range []string{c.Spool, c.Spool + ".1"} {
+		if _, err := os.Stat(fn); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collector) spool(msg []byte) {
+	if fi, err := os.Stat(c.Spool); err == nil && fi.Size() > c.MaxSpool/2 {
+		// drop the oldest half
+		if err = os.Rename(c.Spool, c.Spool+".1"); err != nil {
+			log.Print("warning: syslogd: ", err)
+		}
+	}
+	f, err := os.OpenFile(c.Spool, os.O_CREATE|os.O_APPEND|os.O_WRONLY,
+		0600)
+	if err != nil {
+		log.Print("warning: syslogd: ", err)
+		return
+	}
+	defer f.Close()
+	f.Write(octetCounted(msg))
+}
+
+func (c *Collector) send(msg []byte) error {
+	if c.conn == nil {
+		if time.Now().Before(c.retry) {
+			return errRetry
+		}
+		if err := c.dial(); err != nil {
+			c.fail(err)
+			return err
+		}
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	var err error
+	if c.Network == "udp" {
+		_, err = c.conn.Write(msg)
+	} else {
+		_, err = c.conn.Write(octetCounted(msg))
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.fail(err)
+		return err
+	}
+	if c.down {
+		c.down = false
+		log.Print("notice: syslogd: ", c, " up")
+	}
+	return nil
+}
+
+func (c *Collector) dial() error {
+	d := &net.Dialer{Timeout: dialTimeout}
+	var err error
+	switch c.Network {
+	case "tls":
+		c.conn, err = tls.DialWithDialer(d, "tcp", c.Addr, c.TLS)
+	default:
+		c.conn, err = d.Dial(c.Network, c.Addr)
+	}
+	return err
+}
+
+func (c *Collector) fail(err error) {
+	c.retry = time.Now().Add(retryInterval)
+	if !c.down {
+		c.down = true
+		log.Print("warning: syslogd: ", c, ": ", err)
+	}
+}
+
+// Close closes the connection to the collector.
+func (c *Collector) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// octetCounted returns the RFC 6587 frame of a message, "LEN MSG".
+func octetCounted(msg []byte) []byte {
+	return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+}
+
+// frame returns the first message of octet counted frames and the rest.
+func frame(b []byte) ([]byte, []byte, error) {
+	i := bytes.IndexByte(b, ' ')
+	if i < 1 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	n, err := strconv.Atoi(string(b[:i]))
+	if err != nil || n < 0 || i+1+n > len(b) {
+		return nil, nil, fmt.Errorf("corrupt spool")
+	}
+	return b[i+1 : i+1+n], b[i+1+n:], nil
+}
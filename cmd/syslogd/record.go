@@ -0,0 +1,147 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package syslogd
+
+import (
+	"fmt"
+	"log/syslog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/platform"
+	"github.com/platinasystems/log"
+)
+
+// sdID is the enterprise structured data element of each record.
+var sdID = fmt.Sprint("kmsg@", platform.PEN)
+
+// Record is a /dev/kmsg record.
+type Record struct {
+	Pri  syslog.Priority
+	Seq  uint64
+	Time time.Time
+	// App and PID are those of the "NAME[PID]: " prefix of a message,
+	// "kernel" and empty for kernel records.
+	App, PID string
+	Msg      string
+}
+
+var reID = regexp.MustCompile(`^([^\s\[\]:]+)\[([0-9]+)\]: (.*)$`)
+
+// severities are those of the "notice: " style prefixes of the daemons'
+// messages, which log.Print writes with the debug priority.
+var severities = map[string]syslog.Priority{
+	"emerg":    syslog.LOG_EMERG,
+	"alert":    syslog.LOG_ALERT,
+	"crit":     syslog.LOG_CRIT,
+	"critical": syslog.LOG_CRIT,
+	"err":      syslog.LOG_ERR,
+	"error":    syslog.LOG_ERR,
+	"warn":     syslog.LOG_WARNING,
+	"warning":  syslog.LOG_WARNING,
+	"note":     syslog.LOG_NOTICE,
+	"notice":   syslog.LOG_NOTICE,
+	"info":     syslog.LOG_INFO,
+}
+
+// NewRecord returns the Record of a parsed kmsg stamped from the boot time.
+func NewRecord(k *log.Kmsg, boot time.Time) *Record {
+	r := &Record{
+		Pri:  k.Pri,
+		Seq:  uint64(k.Seq),
+		Time: boot.Add(time.Duration(k.Stamp) * time.Microsecond),
+		App:  "kernel",
+		Msg:  k.Msg,
+	}
+	if k.IsKern() {
+		return r
+	}
+	if m := reID.FindStringSubmatch(k.Msg); m != nil {
+		// "goes.fspd" is fspd of the goes executable
+		r.App = m[1][strings.LastIndex(m[1], ".")+1:]
+		r.PID, r.Msg = m[2], m[3]
+	} else {
+		r.App = ""
+	}
+	if r.Pri&log.PriorityMask == syslog.LOG_DEBUG {
+		if i := strings.Index(r.Msg, ": "); i > 0 {
+			if pri, found := severities[r.Msg[:i]]; found {
+				r.Pri = r.Pri&log.FacilityMask | pri
+			}
+		}
+	}
+	return r
+}
+
+// Format returns the RFC 5424 message of the record with the structured
+// data of its daemon, severity and sequence number, e.g.
+//
+//	<13>1 2020-09-13T12:26:40.000000Z bmc fspd 234 - [kmsg@48229
+//	daemon="fspd" severity="note" seq="1041"] psu1 powered on
+func (r *Record) Format(hostname string) []byte {
+	severity := log.LogPriorityByValue[r.Pri&log.PriorityMask]
+	sd := fmt.Sprintf(`[%s daemon="%s" severity="%s" seq="%d"]`, sdID,
+		sdEscape(r.App), severity, r.Seq)
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %s - %s %s", r.Pri,
+		r.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		header(hostname, 255), header(r.App, 48), header(r.PID, 128),
+		sd, r.Msg))
+}
+
+// header returns a header field of printable ASCII, "-" if empty.
+func header(s string, max int) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// bootClock is the wall clock time of the kmsg stamp 0, which follows the
+// steps of the wall clock, e.g. by sntpd, since /proc/uptime was read.
+type bootClock struct {
+	// boot is by the wall clock of read, which has Go's monotonic
+	// clock reading too.
+	boot, read time.Time
+}
+
+// newBootClock returns the bootClock of the seconds since boot of
+// /proc/uptime.
+func newBootClock(uptime string) (*bootClock, error) {
+	f := strings.Fields(uptime)
+	if len(f) == 0 {
+		return nil, fmt.Errorf("%q: invalid uptime", uptime)
+	}
+	sec, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &bootClock{
+		boot: now.Round(0).Add(-time.Duration(sec * float64(time.Second))),
+		read: now,
+	}, nil
+}
+
+// Boot returns the boot time by the wall clock of now, the difference of
+// the wall and monotonic clocks since read being the steps in between.
+func (c *bootClock) Boot() time.Time {
+	now := time.Now()
+	step := now.Round(0).Sub(c.read.Round(0)) - now.Sub(c.read)
+	return c.boot.Add(step)
+}
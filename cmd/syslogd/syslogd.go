@@ -0,0 +1,232 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package syslogd provides a daemon that forwards the /dev/kmsg records to
+// remote syslog collectors.
+package syslogd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes/cmd"
+	"github.com/platinasystems/goes/lang"
+	"github.com/platinasystems/log"
+)
+
+const (
+	DefaultDir         = "/perm/var/syslogd"
+	CollectorsFileName = "collectors"
+	PositionFileName   = "position"
+	SpoolDirName       = "spool"
+	bootIDFileName     = "/proc/sys/kernel/random/boot_id"
+)
+
+type Command struct {
+	Init func()
+	init sync.Once
+	// Dir has the collectors, position and spool files, DefaultDir if
+	// empty.
+	Dir string
+}
+
+func (*Command) String() string { return "syslogd" }
+
+func (*Command) Usage() string { return "syslogd" }
+
+func (*Command) Apropos() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: "forward kernel log records to syslog collectors",
+	}
+}
+
+func (*Command) Man() lang.Alt {
+	return lang.Alt{
+		lang.EnUS: `
+DESCRIPTION
+	The syslogd daemon tails /dev/kmsg and forwards each record as an
+	RFC 5424 message to the collectors of /perm/var/syslogd/collectors,
+	lines of:
+
+		udp://HOST[:PORT]
+		tcp://HOST[:PORT]
+		tls://HOST[:PORT][?ca=FILE]
+
+	The default ports are 514, 601 and 6514. TCP and TLS messages are
+	octet counted, and TLS collectors are verified with the system or
+	given certificate authorities.
+
+	Each message has the daemon name, e.g. fspd, as its APP-NAME and a
+	structured data element of its daemon, severity and kmsg sequence
+	number, e.g.
+
+		[kmsg@48229 daemon="fspd" severity="warn" seq="1041"]
+
+	While a collector is unreachable, its messages are spooled to
+	/perm/var/syslogd/spool and replayed, in order, once it is back.
+	The spool of each collector is limited to 4MiB, dropping the oldest
+	half when full.`,
+	}
+}
+
+func (*Command) Kind() cmd.Kind { return cmd.Daemon }
+
+func (c *Command) Main(...string) error {
+	if c.Init != nil {
+		c.init.Do(c.Init)
+	}
+	dir := c.Dir
+	if len(dir) == 0 {
+		dir = DefaultDir
+	}
+	spool := filepath.Join(dir, SpoolDirName)
+	if err := os.MkdirAll(spool, 0700); err != nil {
+		return err
+	}
+	collectors, err := LoadCollectors(filepath.Join(dir,
+		CollectorsFileName), spool)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		log.Print("notice: syslogd: no ", CollectorsFileName)
+	}
+	hostname, _ := os.Hostname()
+	pos := &position{file: filepath.Join(dir, PositionFileName)}
+	if err = pos.load(); err != nil {
+		log.Print("warning: syslogd: ", err)
+	}
+
+	records := make(chan *Record, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- readKmsg(records)
+	}()
+	t := time.NewTicker(retryInterval)
+	defer t.Stop()
+	defer func() {
+		pos.save()
+		for _, c := range collectors {
+			c.Close()
+		}
+	}()
+	for {
+		select {
+		case <-goes.Stop:
+			return nil
+		case err := <-done:
+			return err
+		case r := <-records:
+			if !pos.after(r.Seq) {
+				continue
+			}
+			msg := r.Format(hostname)
+			for _, c := range collectors {
+				c.Forward(msg)
+			}
+			pos.seq = r.Seq
+		case <-t.C:
+			for _, c := range collectors {
+				if c.spooled() {
+					c.Flush()
+				}
+			}
+			pos.save()
+		}
+	}
+}
+
+// readKmsg sends the records of /dev/kmsg, from the oldest in its ring.
+func readKmsg(records chan<- *Record) error {
+	f, err := os.Open(log.DevKmsg)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	uptime, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return err
+	}
+	clock, err := newBootClock(string(uptime))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8192)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if pe, ok := err.(*os.PathError); ok &&
+				pe.Err == syscall.EPIPE {
+				// the ring overwrote unread records
+				continue
+			}
+			return err
+		}
+		var k log.Kmsg
+		k.Parse(buf[:n])
+		records <- NewRecord(&k, clock.Boot())
+	}
+}
+
+// position is the sequence number of the last forwarded record of this
+// boot, so that a restarted daemon doesn't forward the ring's records again.
+type position struct {
+	file   string
+	bootID string
+	seq    uint64
+	valid  bool
+	saved  uint64
+}
+
+func (p *position) load() error {
+	b, err := ioutil.ReadFile(bootIDFileName)
+	if err != nil {
+		return err
+	}
+	p.bootID = strings.TrimSpace(string(b))
+	b, err = ioutil.ReadFile(p.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var id string
+	var seq uint64
+	if _, err = fmt.Sscan(string(b), &id, &seq); err != nil {
+		return fmt.Errorf("%s: %v", p.file, err)
+	}
+	if id == p.bootID {
+		p.seq, p.saved, p.valid = seq, seq, true
+	}
+	return nil
+}
+
+// after returns whether seq wasn't forwarded before.
+func (p *position) after(seq uint64) bool {
+	if !p.valid {
+		p.valid = true
+		return true
+	}
+	return seq > p.seq
+}
+
+func (p *position) save() {
+	if len(p.bootID) == 0 || p.seq == p.saved {
+		return
+	}
+	s := fmt.Sprintln(p.bootID, p.seq)
+	if err := ioutil.WriteFile(p.file, []byte(s), 0600); err != nil {
+		log.Print("warning: syslogd: ", err)
+		return
+	}
+	p.saved = p.seq
+}
@@ -0,0 +1,205 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package syslogd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/platinasystems/log"
+)
+
+func TestRecord(t *testing.T) {
+	boot := time.Date(2020, 9, 13, 12, 0, 0, 0, time.UTC)
+	for _, x := range []struct {
+		kmsg, want string
+	}{
+		{
+			"15,1041,1600000000,-;goes.fspd[234]: notice: psu1 powered on",
+			`<13>1 2020-09-13T12:26:40.000000Z bmc fspd 234 - ` +
+				`[kmsg@48229 daemon="fspd" severity="note" ` +
+				`seq="1041"] notice: psu1 powered on`,
+		},
+		{
+			"15,7,1500,-;ipmid[9]: session opened",
+			`<15>1 2020-09-13T12:00:00.001500Z bmc ipmid 9 - ` +
+				`[kmsg@48229 daemon="ipmid" severity="debug" ` +
+				`seq="7"] session opened`,
+		},
+		{
+			"4,2,0,-;usb 1-1: new device",
+			`<4>1 2020-09-13T12:00:00.000000Z bmc kernel - - ` +
+				`[kmsg@48229 daemon="kernel" severity="warn" ` +
+				`seq="2"] usb 1-1: new device`,
+		},
+		{
+			"14,3,0,-;no \"prefix\"",
+			`<14>1 2020-09-13T12:00:00.000000Z bmc - - - ` +
+				`[kmsg@48229 daemon="" severity="info" ` +
+				`seq="3"] no "prefix"`,
+		},
+	} {
+		var k log.Kmsg
+		k.Parse([]byte(x.kmsg))
+		got := string(NewRecord(&k, boot).Format("bmc"))
+		if got != x.want {
+			t.Errorf("%s\n%s\nwant\n%s", x.kmsg, got, x.want)
+		}
+	}
+}
+
+func TestBootClock(t *testing.T) {
+	if _, err := newBootClock(""); err == nil {
+		t.Error("empty uptime")
+	}
+	c, err := newBootClock("3600.50 7000.00\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Now().Add(-3600500 * time.Millisecond)
+	if d := c.Boot().Sub(want); d < -time.Second || d > time.Second {
+		t.Errorf("boot %v, want %v", c.Boot(), want)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	dir := t.TempDir()
+	for _, s := range []string{
+		"http://host",
+		"udp://",
+		"tls://host?ca=" + filepath.Join(dir, "missing.pem"),
+	} {
+		if _, err := ParseCollector(s, dir); err == nil {
+			t.Errorf("%s: no error", s)
+		}
+	}
+	c, err := ParseCollector("tls://[::1]", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Addr != "[::1]:6514" || c.TLS.ServerName != "::1" {
+		t.Errorf("%s: %+v", c, c)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	c, err = ParseCollector("tcp://"+addr, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	received := make(chan string, 8)
+	serve := func(ln net.Listener) {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			n := 0
+			if _, err := fmt.Fscan(r, &n); err != nil {
+				return
+			}
+			r.ReadByte()
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return
+			}
+			received <- string(b)
+		}
+	}
+	go serve(ln)
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-received:
+				if got != w {
+					t.Errorf("received %q, want %q", got, w)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%q not received", w)
+			}
+		}
+	}
+	c.Forward([]byte("one"))
+	expect("one")
+
+	// an outage spools the messages
+	ln.Close()
+	c.Close()
+	c.Forward([]byte("two"))
+	c.Forward([]byte("three"))
+	if !c.spooled() {
+		t.Fatal("nothing spooled")
+	}
+
+	// and they're replayed in order once it's back
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(ln)
+	c.retry = time.Time{}
+	c.Forward([]byte("four"))
+	expect("two", "three", "four")
+	if c.spooled() {
+		t.Error("spool remains")
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	u, err := ParseCollector("udp://"+pc.LocalAddr().String(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	u.Forward([]byte("<13>1 - - - - - - five"))
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 512)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); !strings.HasSuffix(got, " five") {
+		t.Errorf("udp %q", got)
+	}
+}
+
+func TestSpoolLimit(t *testing.T) {
+	c, err := ParseCollector("tcp://127.0.0.1:1", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxSpool = 64
+	c.retry = time.Now().Add(time.Hour)
+	for i := 0; i < 20; i++ {
+		c.Forward([]byte("0123456789"))
+	}
+	var n int64
+	for _, fn := range []string{c.Spool, c.Spool + ".1"} {
+		if fi, err := os.Stat(fn); err == nil {
+			n += fi.Size()
+		}
+	}
+	if n == 0 || n > c.MaxSpool+13 {
+		t.Errorf("spooled %d bytes, limit %d", n, c.MaxSpool)
+	}
+}
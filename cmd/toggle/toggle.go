@@ -7,8 +7,8 @@ package toggle
 import (
 	"time"
 
+	"github.com/platinasystems/goes-bmc/gpio"
 	"github.com/platinasystems/goes/lang"
-	"github.com/platinasystems/gpio"
 	"github.com/platinasystems/i2c"
 )
 
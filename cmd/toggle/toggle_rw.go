@@ -1,73 +0,0 @@
-// Copyright © 2015-2016 Platina Systems, Inc. All rights reserved.
-// Use of this source code is governed by the GPL-2 license described in the
-// LICENSE file.
-
-// Package ucd9090 provides access to the UCD9090 Power Sequencer/Monitor chip
-package toggle
-
-import (
-	"net/rpc"
-	"unsafe"
-
-	"github.com/platinasystems/i2c"
-	"github.com/platinasystems/log"
-)
-
-const MAXOPS = 30
-
-type I struct {
-	InUse     bool
-	RW        i2c.RW
-	RegOffset uint8
-	BusSize   i2c.SMBusSize
-	Data      [i2c.BlockMax]byte
-	Bus       int
-	Addr      int
-	Delay     int
-}
-type R struct {
-	D [i2c.BlockMax]byte
-	E error
-}
-
-type I2cReq int
-
-var b = [i2c.BlockMax]byte{0}
-var i = I{false, i2c.RW(0), 0, 0, b, 0, 0, 0}
-var j [MAXOPS]I
-var r = R{b, nil}
-var s [MAXOPS]R
-var x int
-
-var dummy byte
-var regsPointer = unsafe.Pointer(&dummy)
-var regsAddr = uintptr(unsafe.Pointer(&dummy))
-
-var clientA *rpc.Client
-var dialed int = 0
-
-func clearJ() {
-	x = 0
-	for k := 0; k < MAXOPS; k++ {
-		j[k] = i
-	}
-}
-
-func DoI2cRpc() error {
-	if dialed == 0 {
-		client, err := rpc.DialHTTP("tcp", "127.0.0.1"+":1233")
-		if err != nil {
-			log.Print("dialing:", err)
-			return err
-		}
-		clientA = client
-		dialed = 1
-	}
-	err := clientA.Call("I2cReq.ReadWrite", &j, &s)
-	if err != nil {
-		log.Print("i2cReq error:", err)
-		return err
-	}
-	clearJ()
-	return nil
-}
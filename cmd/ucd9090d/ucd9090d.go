@@ -7,7 +7,7 @@ package ucd9090d
 
 import (
 	"fmt"
-	"io/ioutil"
+	"math"
 	"net/rpc"
 	"strconv"
 	"strings"
@@ -17,24 +17,37 @@ import (
 
 	"github.com/platinasystems/atsock"
 	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
 	"github.com/platinasystems/goes-bmc/cmd/fantrayd"
 	"github.com/platinasystems/goes-bmc/cmd/ledgpiod"
+	"github.com/platinasystems/goes-bmc/cmd/sntpd"
 	"github.com/platinasystems/goes-bmc/cmd/w83795d"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/platform"
+	"github.com/platinasystems/goes-bmc/pmbus"
+	"github.com/platinasystems/goes-bmc/sel"
 	"github.com/platinasystems/goes/cmd"
 	"github.com/platinasystems/goes/external/redis"
 	"github.com/platinasystems/goes/external/redis/publisher"
 	"github.com/platinasystems/goes/external/redis/rpc/args"
 	"github.com/platinasystems/goes/external/redis/rpc/reply"
 	"github.com/platinasystems/goes/lang"
-	"github.com/platinasystems/gpio"
+	"github.com/platinasystems/i2c"
 	"github.com/platinasystems/log"
 )
 
+// clockSyncInterval is that of setting the run time clock, which drifts.
+const clockSyncInterval = time.Hour
+
 var (
 	Vdev I2cDev
 
 	VpageByKey map[string]uint8
 
+	// Thresholds are the alarm limits of the published keys.
+	Thresholds map[string]alarm.Threshold
+
 	WrRegDv  = make(map[string]string)
 	WrRegFn  = make(map[string]string)
 	WrRegVal = make(map[string]string)
@@ -43,9 +56,15 @@ var (
 	loggedFaultCount      uint8
 	lastLoggedFaultDetail [12]byte
 
-	first    int
 	firstLog int
 
+	// clockSynced is when the run time clock was last set.
+	clockSynced time.Time
+
+	// MinValidTime is the earliest wall clock time; fault log times
+	// before it are since the sequencer powered up.
+	MinValidTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	watchdogEn       bool
 	watchdogTimeout  uint
 	watchdogSequence string
@@ -60,12 +79,14 @@ type Command struct {
 }
 
 type Info struct {
-	mutex sync.Mutex
-	rpc   *atsock.RpcServer
-	pub   *publisher.Publisher
-	last  map[string]float64
-	lasts map[string]string
-	lastu map[string]uint16
+	mutex     sync.Mutex
+	rpc       *atsock.RpcServer
+	pub       *publisher.Publisher
+	i2cErrors i2creq.ErrorCount
+	alarms    *alarm.Engine
+	last      map[string]float64
+	lasts     map[string]string
+	lastu     map[string]uint16
 }
 
 type I2cDev struct {
@@ -97,8 +118,8 @@ func (c *Command) Main(...string) error {
 		return err
 	}
 
-	first = 1
 	firstLog = 1
+	clockSynced = time.Time{}
 	watchdogEn = false
 	watchdogTimer = 0
 	watchdogTimeout = 30
@@ -112,6 +133,8 @@ func (c *Command) Main(...string) error {
 	if c.pub, err = publisher.New(); err != nil {
 		return err
 	}
+	c.i2cErrors.Name = "ucd9090d"
+	c.alarms = alarm.New("ucd9090d", c.pub, Thresholds)
 
 	if err = syscall.Sysinfo(&si); err != nil {
 		return err
@@ -150,15 +173,12 @@ func (c *Command) Main(...string) error {
 }
 
 func (c *Command) update() error {
-	stopped := readStopped()
-	if stopped == 1 {
+	c.i2cErrors.Publish(c.pub)
+	if i2creq.Stopped() {
 		return nil
 	}
 
-	if first == 1 {
-		Vdev.ucdInit()
-		first = 0
-	}
+	Vdev.syncRunTimeClock()
 
 	for k, i := range VpageByKey {
 		if strings.Contains(k, "units.V") {
@@ -170,12 +190,24 @@ func (c *Command) update() error {
 				c.pub.Print(k, ": ", v)
 				c.last[k] = v
 			}
+			c.alarms.Update(k, v)
 		}
 		if strings.Contains(k, "poweroff.events") {
 			v, err := Vdev.PowerCycles()
 			if err != nil {
 				return err
 			}
+			if _, found := c.lasts[chassisd.ACLossKey]; !found {
+				// the first read has every event of the log
+				ac, err := chassisd.ACLoss(v)
+				if err != nil {
+					log.Print("warning: ", chassisd.ACLossKey,
+						": ", err)
+				}
+				s := strconv.FormatBool(ac)
+				c.pub.Print(chassisd.ACLossKey, ": ", s)
+				c.lasts[chassisd.ACLossKey] = s
+			}
 			if (v != "") && (v != c.lasts[k]) {
 				c.pub.Print(k, ": ", v)
 				c.lasts[k] = v
@@ -231,13 +263,14 @@ func (c *Command) updateW() error {
 			watchdogTimer++
 		}
 		if watchdogTimer >= watchdogTimeout {
-			log.Print("warning: host watchdog timer expired; reset host; disable watchdog")
+			sel.Print("ucd9090d", sel.Critical, "watchdog.expired",
+				"host watchdog timer expired; reset host; disable watchdog")
 			watchdogExpired = true
-			pin, found := gpio.FindPin("BMC_TO_HOST_RST_L")
-			if found {
-				pin.SetValue(false)
-				time.Sleep(100 * time.Millisecond)
-				pin.SetValue(true)
+			_, err := redis.Hset(redis.DefaultHash, chassisd.Field,
+				chassisd.Reset+" watchdog")
+			if err != nil {
+				log.Print("warning: watchdog reset: ", err)
+			} else {
 				watchdogEn = false
 				watchdogTimer = 0
 			}
@@ -247,53 +280,100 @@ func (c *Command) updateW() error {
 	return nil
 }
 
-func (h *I2cDev) ucdInit() error {
-	//FIXME configure UCD run time clock, pending ntp
-	return nil
+// syncRunTimeClock sets the run time clock from a valid system time every
+// clockSyncInterval, so that the fault log has wall clock times.
+func (h *I2cDev) syncRunTimeClock() {
+	if time.Since(clockSynced) < clockSyncInterval || !timeValid() {
+		return
+	}
+	if err := h.SetRunTimeClock(time.Now()); err != nil {
+		log.Print("warning: ucd9090d: run time clock: ", err)
+		return
+	}
+	if clockSynced.IsZero() {
+		log.Print("notice: ucd9090d: run time clock set")
+	}
+	clockSynced = time.Now()
 }
 
-func (h *I2cDev) Vout(i uint8) (float64, error) {
-	if i > 10 {
-		panic("Voltage rail subscript out of range\n")
+// timeValid returns whether sntpd has set the system time.
+func timeValid() bool {
+	return sntpd.Synchronized() && time.Now().After(MinValidTime)
+}
+
+// SetRunTimeClock sets RUN_TIME_CLOCK to t with an 8 byte block write.
+func (h *I2cDev) SetRunTimeClock(t time.Time) error {
+	b := runTimeClock(t)
+	var tr i2creq.Trans
+	tr.Write(h.Bus, h.Addr, getRegs().RunTimeClock.offset(), i2c.BlockData,
+		append([]byte{byte(len(b))}, b[:]...)...)
+	return tr.Do()
+}
+
+// runTimeClock returns the RUN_TIME_CLOCK block of t, the milliseconds of
+// the day then the days since the Unix epoch.
+func runTimeClock(t time.Time) [8]byte {
+	t = t.UTC()
+	days := uint32(t.Unix() / 86400)
+	milli := uint32(t.Sub(time.Unix(int64(days)*86400, 0)) /
+		time.Millisecond)
+	return [8]byte{
+		byte(milli >> 24), byte(milli >> 16), byte(milli >> 8),
+		byte(milli),
+		byte(days >> 24), byte(days >> 16), byte(days >> 8), byte(days),
 	}
+}
 
-	file, err := ioutil.ReadFile("/sys/class/hwmon/hwmon1/in" +
-		strconv.Itoa(int(i)) + "_input")
-	if err != nil {
-		return 0, fmt.Errorf("Error reading hwmon1: %w", err)
+// faultTime returns the time of the LOGGED_FAULT_DETAIL block d. Records
+// from before the run time clock was set have the time since the sequencer
+// powered up, flagged as relative with a "+" prefix, e.g. "+1h0m5s".
+func faultTime(d []byte) string {
+	milli := uint32(d[2])<<24 | uint32(d[3])<<16 | uint32(d[4])<<8 |
+		uint32(d[5])
+	days := uint32(d[7]&0x7f)<<16 | uint32(d[8])<<8 | uint32(d[9])
+	t := time.Duration(days)*24*time.Hour +
+		time.Duration(milli/1000)*time.Second
+	if t < time.Duration(MinValidTime.Unix())*time.Second {
+		return "+" + t.String()
 	}
-	n, err := strconv.Atoi(strings.TrimSuffix(string(file), "\n"))
+	return time.Unix(int64(t/time.Second), 0).UTC().Format(time.RFC3339)
+}
+
+// Vout returns the voltage of rail i, 1 through 10, that of PAGE i-1 as the
+// hwmon in<i>_input read before.
+func (h *I2cDev) Vout(i uint8) (float64, error) {
+	if i < 1 || i > 10 {
+		return 0, fmt.Errorf("rail %d: out of range", i)
+	}
+	v, err := pmbus.Dev{Bus: h.Bus, Addr: h.Addr}.PageVout(i - 1)
 	if err != nil {
-		return 0, fmt.Errorf("Error converting %s to integer: %w", file,
-			err)
+		return 0, fmt.Errorf("rail %d: %w", i, err)
 	}
-
-	return float64(n) / 1000, nil
+	return math.Round(v*1000) / 1000, nil
 }
 
 func (h *I2cDev) PowerCycles() (string, error) {
 	r := getRegs()
-	r.LoggedFaultIndex.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.LoggedFaultIndex.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
 
-	d := s[0].D[1]
+	d := tr.D(0)[1]
 
-	var milli uint32
-	var seconds uint32
 	var faultType uint8
 	var pwrCycles string
 
 	for i := 0; i < int(d); i++ {
-		r.LoggedFaultIndex.set(h, uint16(i)<<8)
-		err := DoI2cRpc()
+		r.LoggedFaultIndex.set(&tr, h, uint16(i)<<8)
+		err := tr.Do()
 		if err != nil {
 			return "", err
 		}
-		r.LoggedFaultDetail.get(h, 11)
-		err = DoI2cRpc()
+		r.LoggedFaultDetail.get(&tr, h, 11)
+		err = tr.Do()
 		if err != nil {
 			return "", err
 		}
@@ -302,12 +382,12 @@ func (h *I2cDev) PowerCycles() (string, error) {
 			new := false
 			if loggedFaultCount != d {
 				loggedFaultCount = d
-				copy(lastLoggedFaultDetail[:], s[0].D[0:12])
+				copy(lastLoggedFaultDetail[:], tr.D(0)[0:12])
 				new = true
 			} else {
 				for j := 0; j < 12; j++ {
-					if s[0].D[j] != lastLoggedFaultDetail[j] {
-						copy(lastLoggedFaultDetail[:], s[0].D[0:12])
+					if tr.D(0)[j] != lastLoggedFaultDetail[j] {
+						copy(lastLoggedFaultDetail[:], tr.D(0)[0:12])
 						new = true
 						break
 					}
@@ -317,40 +397,33 @@ func (h *I2cDev) PowerCycles() (string, error) {
 				return "", nil
 			}
 			if firstLog == 0 {
-				log.Printf("warning: power event detected")
+				sel.Print("ucd9090d", sel.Critical, "vmon.poweroff.events",
+					"power event detected")
 				time.Sleep(5 * time.Second)
 
+				p := platform.Current()
 				log.Print("notice: re-init fan controller")
-				w83795d.Vdev.Bus = 11
-				w83795d.Vdev.Addr = 0x2f
+				w83795d.Vdev.Bus = p.W83795d.Bus
+				w83795d.Vdev.Addr = int(p.W83795d.Addr)
 				w83795d.Vdev.FanInit()
 
 				log.Print("notice: re-init fan trays")
-				fantrayd.Vdev.Bus = 1
-				fantrayd.Vdev.Addr = 0x20
-				fantrayd.Vdev.MuxBus = 1
-				fantrayd.Vdev.MuxAddr = 0x72
-				fantrayd.Vdev.MuxValue = 0x04
+				fantrayd.Vdev.Bus = p.Fantrayd.Reinit.Bus
+				fantrayd.Vdev.Addr = int(p.Fantrayd.Reinit.Addr)
+				fantrayd.Vdev.MuxBus = p.Fantrayd.Reinit.Mux.Bus
+				fantrayd.Vdev.MuxAddr = int(p.Fantrayd.Reinit.Mux.Addr)
+				fantrayd.Vdev.MuxValue = int(p.Fantrayd.Reinit.Mux.Value)
 				fantrayd.Vdev.FanTrayLedReinit()
 
 				log.Print("notice: re-init front panel LEDs")
-				ver := 0
-				s, _ := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-				_, _ = fmt.Sscan(s, &ver)
-				if ver == 0 || ver == 0xff {
-					ledgpiod.Vdev.Addr = 0x22
-				} else {
-					ledgpiod.Vdev.Addr = 0x75
-				}
-				ledgpiod.Vdev.Bus = 5
+				ledgpiod.Vdev.Bus = p.Ledgpiod.Bus
+				ledgpiod.Vdev.Addr = int(p.Ledgpiod.Addr)
 				ledgpiod.Vdev.LedFpReinit()
 			}
 		}
-		milli = uint32(s[0].D[5]) + uint32(s[0].D[4])<<8 + uint32(s[0].D[3])<<16 + uint32(s[0].D[2])<<24
-		seconds = milli / 1000
-		timestamp := time.Unix(int64(seconds), 0).Format(time.RFC3339)
+		timestamp := faultTime(tr.D(0))
 
-		faultType = (s[0].D[6] >> 3) & 0xF
+		faultType = (tr.D(0)[6] >> 3) & 0xF
 
 		if !strings.Contains(pwrCycles, timestamp) && (faultType == 0 || faultType == 1) {
 			pwrCycles += timestamp + "."
@@ -363,17 +436,16 @@ func (h *I2cDev) PowerCycles() (string, error) {
 
 func (h *I2cDev) LoggedFaultDetail() (string, error) {
 	r := getRegs()
-	r.LoggedFaultIndex.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.LoggedFaultIndex.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
 
-	d := s[0].D[1]
+	d := tr.D(0)[1]
 
-	var milli uint32
 	var page uint8
-	var seconds uint32
 	var faultType uint8
 	var paged uint8
 	var rail string
@@ -381,13 +453,13 @@ func (h *I2cDev) LoggedFaultDetail() (string, error) {
 	var log string
 
 	for i := 0; i < int(d); i++ {
-		r.LoggedFaultIndex.set(h, uint16(i)<<8)
-		err := DoI2cRpc()
+		r.LoggedFaultIndex.set(&tr, h, uint16(i)<<8)
+		err := tr.Do()
 		if err != nil {
 			return "", err
 		}
-		r.LoggedFaultDetail.get(h, 11)
-		err = DoI2cRpc()
+		r.LoggedFaultDetail.get(&tr, h, 11)
+		err = tr.Do()
 		if err != nil {
 			return "", err
 		}
@@ -396,12 +468,12 @@ func (h *I2cDev) LoggedFaultDetail() (string, error) {
 			new := false
 			if loggedFaultCount != d {
 				loggedFaultCount = d
-				copy(lastLoggedFaultDetail[:], s[0].D[0:12])
+				copy(lastLoggedFaultDetail[:], tr.D(0)[0:12])
 				new = true
 			} else {
 				for j := 0; j < 12; j++ {
-					if s[0].D[j] != lastLoggedFaultDetail[j] {
-						copy(lastLoggedFaultDetail[:], s[0].D[0:12])
+					if tr.D(0)[j] != lastLoggedFaultDetail[j] {
+						copy(lastLoggedFaultDetail[:], tr.D(0)[0:12])
 						new = true
 						break
 					}
@@ -411,13 +483,11 @@ func (h *I2cDev) LoggedFaultDetail() (string, error) {
 				return "", nil
 			}
 		}
-		milli = uint32(s[0].D[5]) + uint32(s[0].D[4])<<8 + uint32(s[0].D[3])<<16 + uint32(s[0].D[2])<<24
-		seconds = milli / 1000
-		timestamp := time.Unix(int64(seconds), 0).Format(time.RFC3339)
+		timestamp := faultTime(tr.D(0))
 
-		faultType = (s[0].D[6] >> 3) & 0xF
-		paged = s[0].D[6] & 0x80 >> 7
-		page = ((s[0].D[7] & 0x80) >> 7) + ((s[0].D[6] & 0x7) << 1)
+		faultType = (tr.D(0)[6] >> 3) & 0xF
+		paged = tr.D(0)[6] & 0x80 >> 7
+		page = ((tr.D(0)[7] & 0x80) >> 7) + ((tr.D(0)[6] & 0x7) << 1)
 
 		if paged == 1 {
 			switch page {
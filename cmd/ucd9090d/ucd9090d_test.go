@@ -0,0 +1,91 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package ucd9090d
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/i2csim"
+)
+
+func TestPowerCycles(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	// As after Main; the first fault log read doesn't re-init the fans.
+	firstLog = 1
+	loggedFaultCount = 0
+	h := &I2cDev{Bus: 4, Addr: 0x34}
+
+	// The run time clock wasn't set, so these are times since power on.
+	sim.UCD9090.LogFault(i2csim.Fault{Milli: 5000})
+	sim.UCD9090.LogFault(i2csim.Fault{Milli: 3605000, Type: 1})
+	sim.UCD9090.LogFault(i2csim.Fault{Milli: 3605000, Paged: true,
+		Page: 3, Type: 2})
+
+	s, err := h.PowerCycles()
+	if err != nil {
+		t.Fatal("PowerCycles:", err)
+	}
+	if want := "+5s.+1h0m5s"; s != want {
+		t.Errorf("PowerCycles: got %q, want %q", s, want)
+	}
+	if s, err = h.PowerCycles(); err != nil || s != "" {
+		t.Errorf("PowerCycles with no new faults: %q, %v", s, err)
+	}
+}
+
+func TestRunTimeClock(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	firstLog = 1
+	loggedFaultCount = 0
+	h := &I2cDev{Bus: 4, Addr: 0x34}
+
+	set := time.Date(2020, 9, 13, 12, 26, 40, 500e6, time.UTC)
+	b := runTimeClock(set)
+	milli := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 |
+		uint32(b[3])
+	days := uint32(b[4])<<24 | uint32(b[5])<<16 | uint32(b[6])<<8 |
+		uint32(b[7])
+	if days != 18518 || milli != 44800500 {
+		t.Errorf("RUN_TIME_CLOCK %d days %d ms", days, milli)
+	}
+	if err := h.SetRunTimeClock(set); err != nil {
+		t.Fatal("SetRunTimeClock:", err)
+	}
+	if got := sim.UCD9090.RunTimeClock(); !bytes.Equal(got, b[:]) {
+		t.Errorf("RUN_TIME_CLOCK written % x, want % x", got, b)
+	}
+
+	// A fault from before the clock was set, and one an hour after.
+	sim.UCD9090.LogFault(i2csim.Fault{Milli: 5000})
+	sim.UCD9090.LogFault(i2csim.Fault{Days: days, Milli: milli + 3600000,
+		Type: 1})
+	s, err := h.PowerCycles()
+	if err != nil {
+		t.Fatal("PowerCycles:", err)
+	}
+	if want := "+5s.2020-09-13T13:26:40Z"; s != want {
+		t.Errorf("PowerCycles: got %q, want %q", s, want)
+	}
+}
+
+func TestVout(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	h := &I2cDev{Bus: 4, Addr: 0x34}
+	sim.UCD9090.SetVout(0, 0x13, 0xa100) // vmon.5v.sb
+	sim.UCD9090.SetVout(9, 0x13, 0x2000) // vmon.1v0.tha
+	for i, want := range map[uint8]float64{1: 5.031, 10: 1} {
+		if v, err := h.Vout(i); err != nil || v != want {
+			t.Errorf("Vout(%d): %v, %v, want %v", i, v, err, want)
+		}
+	}
+	if _, err := h.Vout(0); err == nil {
+		t.Error("Vout(0) succeeded")
+	}
+}
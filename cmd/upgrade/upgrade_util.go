@@ -114,20 +114,12 @@ func printJSON() error {
 	}
 
 	fmt.Printf("Installed version is %s\n\n", iv)
-	b, err := getVer()
+	ImgInfo, err := GetImgInfo()
 	if err != nil {
 		return err
 	}
-	k := 0
-	for i, j := range b {
-		if j == ']' {
-			k = i
-		}
-	}
-	if k > 0 {
+	if len(ImgInfo) > 0 {
 		fmt.Println("")
-		var ImgInfo [5]IMGINFO
-		json.Unmarshal(b[JSON_OFFSET:k+1], &ImgInfo)
 		for i, _ := range ImgInfo {
 			fmt.Println("    Name  : ", ImgInfo[i].Name)
 			fmt.Println("    Build : ", ImgInfo[i].Build)
@@ -142,6 +134,26 @@ func printJSON() error {
 	return nil
 }
 
+// GetImgInfo returns the info of the installed images, as reported by
+// upgrade -r.
+func GetImgInfo() ([]IMGINFO, error) {
+	b, err := getVer()
+	if err != nil {
+		return nil, err
+	}
+	k := 0
+	for i, j := range b {
+		if j == ']' {
+			k = i
+		}
+	}
+	var ImgInfo []IMGINFO
+	if k > JSON_OFFSET {
+		json.Unmarshal(b[JSON_OFFSET:k+1], &ImgInfo)
+	}
+	return ImgInfo, nil
+}
+
 func GetVerArchive() (string, error) {
 	b, err := getVer()
 	if err != nil {
@@ -16,6 +16,10 @@ import (
 	"time"
 
 	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/sel"
 	"github.com/platinasystems/goes/cmd"
 	"github.com/platinasystems/goes/external/atsock"
 	"github.com/platinasystems/goes/external/log"
@@ -24,7 +28,6 @@ import (
 	"github.com/platinasystems/goes/external/redis/rpc/args"
 	"github.com/platinasystems/goes/external/redis/rpc/reply"
 	"github.com/platinasystems/goes/lang"
-	"github.com/platinasystems/gpio"
 )
 
 var (
@@ -55,6 +58,9 @@ var (
 
 	VpageByKey map[string]uint8
 
+	// Thresholds are the alarm limits of the published keys.
+	Thresholds map[string]alarm.Threshold
+
 	WrRegDv = make(map[string]string)
 )
 
@@ -65,11 +71,13 @@ type Command struct {
 }
 
 type Info struct {
-	mutex sync.Mutex
-	rpc   *atsock.RpcServer
-	pub   *publisher.Publisher
-	last  map[string]uint16
-	lasts map[string]string
+	mutex     sync.Mutex
+	rpc       *atsock.RpcServer
+	pub       *publisher.Publisher
+	i2cErrors i2creq.ErrorCount
+	alarms    *alarm.Engine
+	last      map[string]uint16
+	lasts     map[string]string
 }
 
 type I2cDev struct {
@@ -107,6 +115,8 @@ func (c *Command) Main(...string) error {
 	if c.pub, err = publisher.New(); err != nil {
 		return err
 	}
+	c.i2cErrors.Name = "w83795d"
+	c.alarms = alarm.New("w83795d", c.pub, Thresholds)
 
 	if err = syscall.Sysinfo(&si); err != nil {
 		return err
@@ -142,8 +152,8 @@ func (c *Command) update() error {
 	c.Info.mutex.Lock()
 	defer c.Info.mutex.Unlock()
 
-	stopped := readStopped()
-	if stopped == 1 {
+	c.i2cErrors.Publish(c.pub)
+	if i2creq.Stopped() {
 		return nil
 	}
 
@@ -175,6 +185,7 @@ func (c *Command) update() error {
 				c.pub.Print(k, ": ", v)
 				c.last[k] = v
 			}
+			c.alarms.Update(k, float64(v))
 		}
 		if strings.Contains(k, "fan_tray.speed") {
 			v := configuredSpeed
@@ -206,6 +217,7 @@ func (c *Command) update() error {
 				c.pub.Print(k, ": ", v)
 				c.lasts[k] = v
 			}
+			c.alarms.UpdateString(k, v)
 		}
 		if strings.Contains(k, "hwmon.rear.temp.units.C") {
 			v, err := Vdev.RearTemp()
@@ -216,6 +228,7 @@ func (c *Command) update() error {
 				c.pub.Print(k, ": ", v)
 				c.lasts[k] = v
 			}
+			c.alarms.UpdateString(k, v)
 		}
 		if strings.Contains(k, "host.temp.units.C") {
 			v := Vdev.CheckHostTemp()
@@ -276,15 +289,16 @@ func fanSpeed(countHi uint8, countLo uint8) uint16 {
 
 func (h *I2cDev) FrontTemp() (string, error) {
 	r := getRegsBank0()
-	r.BankSelect.set(h, 0x80)
-	r.FrontTemp.get(h)
-	r.FractionLSB.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.BankSelect.set(&tr, h, 0x80)
+	r.FrontTemp.get(&tr, h)
+	r.FractionLSB.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint8(s[1].D[0])
-	u := uint8(s[2].D[0])
+	t := tr.Byte(1)
+	u := tr.Byte(2)
 	v := float64(t) + ((float64(u >> 7)) * 0.25)
 	strconv.FormatFloat(v, 'f', 3, 64)
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
@@ -292,15 +306,16 @@ func (h *I2cDev) FrontTemp() (string, error) {
 
 func (h *I2cDev) RearTemp() (string, error) {
 	r := getRegsBank0()
-	r.BankSelect.set(h, 0x80)
-	r.RearTemp.get(h)
-	r.FractionLSB.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r.BankSelect.set(&tr, h, 0x80)
+	r.RearTemp.get(&tr, h)
+	r.FractionLSB.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "", err
 	}
-	t := uint8(s[1].D[0])
-	u := uint8(s[2].D[0])
+	t := tr.Byte(1)
+	u := tr.Byte(2)
 	v := float64(t) + ((float64(u >> 7)) * 0.25)
 	return strconv.FormatFloat(v, 'f', 3, 64), nil
 }
@@ -325,21 +340,22 @@ func (h *I2cDev) FanCount(i uint8) (uint16, error) {
 		//remap physical to logical, 0:7 -> 7:0
 		i = i + 7 - (2 * i)
 		r := getRegsBank0()
-		r.BankSelect.set(h, 0x80)
-		r.FanCount[i].get(h)
-		r.FractionLSB.get(h)
-		r.FanCount[i].get(h)
-		r.FractionLSB.get(h)
-		r.FanCount[i].get(h)
-		r.FractionLSB.get(h)
-		r.FanCount[i].get(h)
-		r.FractionLSB.get(h)
-		err := DoI2cRpc()
+		var tr i2creq.Trans
+		r.BankSelect.set(&tr, h, 0x80)
+		r.FanCount[i].get(&tr, h)
+		r.FractionLSB.get(&tr, h)
+		r.FanCount[i].get(&tr, h)
+		r.FractionLSB.get(&tr, h)
+		r.FanCount[i].get(&tr, h)
+		r.FractionLSB.get(&tr, h)
+		r.FanCount[i].get(&tr, h)
+		r.FractionLSB.get(&tr, h)
+		err := tr.Do()
 		if err != nil {
 			return 0, err
 		}
-		c := [4]byte{s[1].D[0], s[3].D[0], s[5].D[0], s[7].D[0]}
-		l := [4]byte{s[2].D[0], s[4].D[0], s[6].D[0], s[8].D[0]}
+		c := [4]byte{tr.Byte(1), tr.Byte(3), tr.Byte(5), tr.Byte(7)}
+		l := [4]byte{tr.Byte(2), tr.Byte(4), tr.Byte(6), tr.Byte(8)}
 
 		if c[0] == c[1] && l[0] == l[1] {
 			t = c[0]
@@ -364,21 +380,22 @@ func (h *I2cDev) FanInit() error {
 
 	//reset hwm to default values
 	r0 := getRegsBank0()
-	r0.BankSelect.set(h, 0x80)
-	r0.Configuration.set(h, 0x9c)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r0.BankSelect.set(&tr, h, 0x80)
+	r0.Configuration.set(&tr, h, 0x9c)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
 
 	r2 := getRegsBank2()
-	r2.BankSelect.set(h, 0x82)
+	r2.BankSelect.set(&tr, h, 0x82)
 	//set fan speed output to PWM mode
-	r2.FanOutputModeControl.set(h, 0x0)
+	r2.FanOutputModeControl.set(&tr, h, 0x0)
 	//set up clk frequency and dividers
-	r2.FanPwmPrescale1.set(h, 0x84)
-	r2.FanPwmPrescale2.set(h, 0x84)
-	err = DoI2cRpc()
+	r2.FanPwmPrescale1.set(&tr, h, 0x84)
+	r2.FanPwmPrescale2.set(&tr, h, 0x84)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
@@ -387,18 +404,18 @@ func (h *I2cDev) FanInit() error {
 	h.SetConfiguredSpeed()
 
 	//enable temperature monitoring
-	r0.BankSelect.set(h, 0x80)
-	r0.TempCntl2.set(h, tempCtrl2)
-	err = DoI2cRpc()
+	r0.BankSelect.set(&tr, h, 0x80)
+	r0.TempCntl2.set(&tr, h, tempCtrl2)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
 
 	//temperature monitoring requires a delay before readings are valid
 	time.Sleep(500 * time.Millisecond)
-	r0.BankSelect.set(h, 0x80)
-	r0.Configuration.set(h, 0x1d)
-	err = DoI2cRpc()
+	r0.BankSelect.set(&tr, h, 0x80)
+	r0.Configuration.set(&tr, h, 0x1d)
+	err = tr.Do()
 	if err != nil {
 		return err
 	}
@@ -423,12 +440,13 @@ func (h *I2cDev) SetFanDuty(d uint8) error {
 	}
 
 	r2 := getRegsBank2()
-	r2.BankSelect.set(h, 0x82)
-	r2.TempToFanMap1.set(h, 0x0)
-	r2.TempToFanMap2.set(h, 0x0)
-	r2.FanOutValue1.set(h, d)
-	r2.FanOutValue2.set(h, d)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r2.BankSelect.set(&tr, h, 0x82)
+	r2.TempToFanMap1.set(&tr, h, 0x0)
+	r2.TempToFanMap2.set(&tr, h, 0x0)
+	r2.FanOutValue1.set(&tr, h, d)
+	r2.FanOutValue2.set(&tr, h, d)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
@@ -438,6 +456,7 @@ func (h *I2cDev) SetFanDuty(d uint8) error {
 
 func (h *I2cDev) SetFanSpeed(w string) error {
 	r2 := getRegsBank2()
+	var tr i2creq.Trans
 
 	//if not all fan trays are ok, only allow high setting
 	for j := 1; j <= maxFanTrays; j++ {
@@ -452,53 +471,53 @@ func (h *I2cDev) SetFanSpeed(w string) error {
 	switch w {
 	case "auto":
 		if !hostCtrl {
-			r2.BankSelect.set(h, 0x82)
+			r2.BankSelect.set(&tr, h, 0x82)
 			//set thermal cruise
-			r2.FanControlModeSelect1.set(h, 0x00)
-			r2.FanControlModeSelect2.set(h, 0x00)
+			r2.FanControlModeSelect1.set(&tr, h, 0x00)
+			r2.FanControlModeSelect2.set(&tr, h, 0x00)
 			//set step up and down time to 1s
-			r2.FanStepUpTime.set(h, 0x0a)
-			r2.FanStepDownTime.set(h, 0x0a)
-			err := DoI2cRpc()
+			r2.FanStepUpTime.set(&tr, h, 0x0a)
+			r2.FanStepDownTime.set(&tr, h, 0x0a)
+			err := tr.Do()
 			if err != nil {
 				return err
 			}
 
-			r2.BankSelect.set(h, 0x82)
+			r2.BankSelect.set(&tr, h, 0x82)
 			//set fan start speed
-			r2.FanStartValue1.set(h, 0x30)
-			r2.FanStartValue2.set(h, 0x30)
+			r2.FanStartValue1.set(&tr, h, 0x30)
+			r2.FanStartValue2.set(&tr, h, 0x30)
 			//set fan stop speed
-			r2.FanStopValue1.set(h, 0x30)
-			r2.FanStopValue2.set(h, 0x30)
-			err = DoI2cRpc()
+			r2.FanStopValue1.set(&tr, h, 0x30)
+			r2.FanStopValue2.set(&tr, h, 0x30)
+			err = tr.Do()
 			if err != nil {
 				return err
 			}
 
-			r2.BankSelect.set(h, 0x82)
+			r2.BankSelect.set(&tr, h, 0x82)
 			//set fan stop time to never stop
-			r2.FanStopTime1.set(h, 0x0)
-			r2.FanStopTime2.set(h, 0x0)
+			r2.FanStopTime1.set(&tr, h, 0x0)
+			r2.FanStopTime2.set(&tr, h, 0x0)
 			//set target temps to 50°C
-			r2.TargetTemp1.set(h, 0x32)
-			r2.TargetTemp2.set(h, 0x32)
-			err = DoI2cRpc()
+			r2.TargetTemp1.set(&tr, h, 0x32)
+			r2.TargetTemp2.set(&tr, h, 0x32)
+			err = tr.Do()
 			if err != nil {
 				return err
 			}
 
-			r2.BankSelect.set(h, 0x82)
+			r2.BankSelect.set(&tr, h, 0x82)
 			//set critical temp to set 100% fan speed to 65°C
-			r2.FanCritTemp1.set(h, 0x41)
-			r2.FanCritTemp2.set(h, 0x41)
+			r2.FanCritTemp1.set(&tr, h, 0x41)
+			r2.FanCritTemp2.set(&tr, h, 0x41)
 			//set target temp hysteresis to +/- 5°C
-			r2.TempHyster1.set(h, 0x55)
-			r2.TempHyster2.set(h, 0x55)
+			r2.TempHyster1.set(&tr, h, 0x55)
+			r2.TempHyster2.set(&tr, h, 0x55)
 			//enable temp control of fans
-			r2.TempToFanMap1.set(h, 0xff)
-			r2.TempToFanMap2.set(h, 0xff)
-			err = DoI2cRpc()
+			r2.TempToFanMap1.set(&tr, h, 0xff)
+			r2.TempToFanMap2.set(&tr, h, 0xff)
+			err = tr.Do()
 			if err != nil {
 				return err
 			}
@@ -522,30 +541,32 @@ func (h *I2cDev) SetFanSpeed(w string) error {
 
 func (h *I2cDev) GetFanDuty() (uint8, error) {
 	r2 := getRegsBank2()
+	var tr i2creq.Trans
 
-	r2.BankSelect.set(h, 0x82)
-	r2.FanOutValue1.get(h)
-	err := DoI2cRpc()
+	r2.BankSelect.set(&tr, h, 0x82)
+	r2.FanOutValue1.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
-	m := uint8(s[1].D[0])
+	m := tr.Byte(1)
 	return m, nil
 
 }
 
 func (h *I2cDev) GetFanSpeed() (string, error) {
 	r2 := getRegsBank2()
+	var tr i2creq.Trans
 
-	r2.BankSelect.set(h, 0x82)
-	r2.TempToFanMap1.get(h)
-	r2.FanOutValue1.get(h)
-	err := DoI2cRpc()
+	r2.BankSelect.set(&tr, h, 0x82)
+	r2.TempToFanMap1.get(&tr, h)
+	r2.FanOutValue1.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return "error", err
 	}
-	t := uint8(s[1].D[0])
-	m := uint8(s[2].D[0])
+	t := tr.Byte(1)
+	m := tr.Byte(2)
 
 	if t == 0xff {
 		return "auto", nil
@@ -605,7 +626,8 @@ func (h *I2cDev) PollThermal() error {
 		}
 
 		h.SetFanDuty(uint8(dutyAtThermalEvent + dutyIncrement))
-		log.Print("thermal event: fan duty set to ",
+		sel.Print("w83795d", sel.Warning, "fan_tray.duty",
+			"thermal event: fan duty set to ",
 			dutyAtThermalEvent+dutyIncrement,
 			" (duty increment) ", dutyIncrement)
 	}
@@ -629,10 +651,11 @@ func (h *I2cDev) PollThermal() error {
 
 func (h *I2cDev) SetHwmTarget() error {
 	r2 := getRegsBank2()
-	r2.BankSelect.set(h, 0x82)
-	r2.TargetTemp1.set(h, hwmTarget)
-	r2.TargetTemp2.set(h, hwmTarget)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r2.BankSelect.set(&tr, h, 0x82)
+	r2.TargetTemp1.set(&tr, h, hwmTarget)
+	r2.TargetTemp2.set(&tr, h, hwmTarget)
+	err := tr.Do()
 	if err != nil {
 		return err
 	}
@@ -642,17 +665,18 @@ func (h *I2cDev) SetHwmTarget() error {
 
 func (h *I2cDev) GetHwmTarget() (uint16, error) {
 	r2 := getRegsBank2()
-	r2.BankSelect.set(h, 0x82)
-	r2.TargetTemp1.get(h)
-	r2.TargetTemp2.get(h)
-	err := DoI2cRpc()
+	var tr i2creq.Trans
+	r2.BankSelect.set(&tr, h, 0x82)
+	r2.TargetTemp1.get(&tr, h)
+	r2.TargetTemp2.get(&tr, h)
+	err := tr.Do()
 	if err != nil {
 		return 0, err
 	}
 
 	m := uint16(0)
-	if s[1].D[0] == s[2].D[0] {
-		m = uint16(s[1].D[0])
+	if tr.Byte(1) == tr.Byte(2) {
+		m = uint16(tr.Byte(1))
 	}
 	return m, nil
 }
@@ -676,15 +700,9 @@ func (h *I2cDev) GetQsfpTempTarget() string {
 func doHostReset() error {
 	// FIXME cmd.Init("gpio")
 	log.Print("notice: issue hard reset to host")
-	pin, found := gpio.FindPin("BMC_TO_HOST_RST_L")
-	if found {
-		pin.SetValue(false)
-	}
-	time.Sleep(50 * time.Millisecond)
-	if found {
-		pin.SetValue(true)
-	}
-	return nil
+	_, err := redis.Hset(redis.DefaultHash, chassisd.Field,
+		chassisd.Reset+" host.reset")
+	return err
 }
 
 func parseTemp(t string, low uint8, high uint8) (uint8, error) {
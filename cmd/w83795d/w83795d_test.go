@@ -0,0 +1,54 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package w83795d
+
+import (
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/i2csim"
+)
+
+func TestFanInit(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	h := &I2cDev{Bus: 11, Addr: 0x2f}
+	if err := h.FanInit(); err != nil {
+		t.Fatal("FanInit:", err)
+	}
+	for _, x := range []struct {
+		bank   int
+		offset uint8
+		v      byte
+	}{
+		{0, 0x01, 0x1d},      // Configuration
+		{0, 0x05, tempCtrl2}, // TempCntl2
+		{2, 0x02, 0xff},      // TempToFanMap1
+		{2, 0x18, 0x84},      // FanPwmPrescale1
+		{2, 0x60, 0x32},      // TargetTemp1
+		{2, 0x68, 0x41},      // FanCritTemp1
+	} {
+		if v := sim.W83795.Get(x.bank, x.offset); v != x.v {
+			t.Errorf("bank %d offset %#x: got %#x, want %#x",
+				x.bank, x.offset, v, x.v)
+		}
+	}
+	if s, err := h.GetFanSpeed(); err != nil || s != "auto" {
+		t.Errorf("GetFanSpeed: %q, %v", s, err)
+	}
+
+	sim.W83795.SetFanCount(7, 480)
+	sim.W83795.SetFanCount(6, 240)
+	if rpm, err := h.FanCount(1); err != nil || rpm != 2812 {
+		t.Errorf("FanCount(1): %d, %v", rpm, err)
+	}
+	if rpm, err := h.FanCount(2); err != nil || rpm != 5625 {
+		t.Errorf("FanCount(2): %d, %v", rpm, err)
+	}
+
+	sim.W83795.SetTemp(0x21, 32)
+	if s, err := h.FrontTemp(); err != nil || s != "32.000" {
+		t.Errorf("FrontTemp: %q, %v", s, err)
+	}
+}
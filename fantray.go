@@ -4,18 +4,16 @@
 
 package main
 
-import "github.com/platinasystems/goes-bmc/cmd/fantrayd"
+import (
+	"github.com/platinasystems/goes-bmc/cmd/fantrayd"
+	"github.com/platinasystems/goes-bmc/platform"
+)
 
 func fantraydInit() {
-	fantrayd.Vdev.Bus = 14
-	fantrayd.Vdev.Addr = 0x20
-
-	fantrayd.VpageByKey = map[string]uint8{
-		"fan_tray.1.status": 1,
-		"fan_tray.2.status": 2,
-		"fan_tray.3.status": 3,
-		"fan_tray.4.status": 4,
-	}
+	p := platform.Current()
+	fantrayd.Vdev.Bus = p.Fantrayd.Bus
+	fantrayd.Vdev.Addr = int(p.Fantrayd.Addr)
+	fantrayd.VpageByKey = p.Fantrayd.VpageByKey
 
 	fantrayd.WrRegDv["fantrayd"] = "fantrayd"
 	fantrayd.WrRegFn["fantrayd.example"] = "example"
@@ -0,0 +1,225 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package fru parses the board and product info areas of an IPMI Platform
+// Management FRU Information Storage Definition v1.0 EEPROM.
+package fru
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	headerLen     = 8
+	formatVersion = 1
+	endOfFields   = 0xc1
+)
+
+var (
+	ErrHeader   = errors.New("no FRU common header")
+	ErrChecksum = errors.New("FRU checksum mismatch")
+)
+
+// Epoch is that of the board manufacturing date, in minutes.
+var Epoch = time.Date(1996, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type Board struct {
+	MfgDate      time.Time // zero if unspecified
+	Manufacturer string
+	Product      string
+	Serial       string
+	PartNumber   string
+	FileId       string
+	Custom       []string
+}
+
+type Product struct {
+	Manufacturer string
+	Name         string
+	PartNumber   string
+	Version      string
+	Serial       string
+	AssetTag     string
+	FileId       string
+	Custom       []string
+}
+
+// Info is the parsed EEPROM; Board and Product are nil without those areas.
+type Info struct {
+	Board   *Board
+	Product *Product
+}
+
+// Parse returns the board and product info areas of the FRU EEPROM b after
+// validating the checksums of the common header and each area.
+func Parse(b []byte) (*Info, error) {
+	if len(b) < headerLen || b[0]&0xf != formatVersion {
+		return nil, ErrHeader
+	}
+	if sum(b[:headerLen]) != 0 {
+		return nil, fmt.Errorf("common header: %w", ErrChecksum)
+	}
+	info := new(Info)
+	if off := int(b[3]) * 8; off != 0 {
+		f, err := area(b, off, "board")
+		if err != nil {
+			return nil, err
+		}
+		// version, length, language, 3 byte manufacturing date
+		if len(f) < 3 {
+			return nil, fmt.Errorf("board area: truncated")
+		}
+		min := int(f[0]) | int(f[1])<<8 | int(f[2])<<16
+		fields, err := strs(f[3:], 5)
+		if err != nil {
+			return nil, fmt.Errorf("board area: %w", err)
+		}
+		info.Board = &Board{
+			Manufacturer: fields[0],
+			Product:      fields[1],
+			Serial:       fields[2],
+			PartNumber:   fields[3],
+			FileId:       fields[4],
+			Custom:       append([]string(nil), fields[5:]...),
+		}
+		if min != 0 {
+			info.Board.MfgDate = Epoch.Add(time.Duration(min) *
+				time.Minute)
+		}
+	}
+	if off := int(b[4]) * 8; off != 0 {
+		f, err := area(b, off, "product")
+		if err != nil {
+			return nil, err
+		}
+		fields, err := strs(f, 7)
+		if err != nil {
+			return nil, fmt.Errorf("product area: %w", err)
+		}
+		info.Product = &Product{
+			Manufacturer: fields[0],
+			Name:         fields[1],
+			PartNumber:   fields[2],
+			Version:      fields[3],
+			Serial:       fields[4],
+			AssetTag:     fields[5],
+			FileId:       fields[6],
+			Custom:       append([]string(nil), fields[7:]...),
+		}
+	}
+	return info, nil
+}
+
+// Fields returns the manufacturer, part_number, serial, version and
+// mfg_date of the product area or, lacking those, the board area. Empty
+// fields are omitted.
+func (info *Info) Fields() map[string]string {
+	m := make(map[string]string)
+	set := func(k string, v ...string) {
+		for _, s := range v {
+			if len(s) > 0 {
+				m[k] = s
+				return
+			}
+		}
+	}
+	var board Board
+	var product Product
+	if info.Board != nil {
+		board = *info.Board
+	}
+	if info.Product != nil {
+		product = *info.Product
+	}
+	set("manufacturer", product.Manufacturer, board.Manufacturer)
+	set("part_number", product.PartNumber, board.PartNumber)
+	set("serial", product.Serial, board.Serial)
+	set("version", product.Version)
+	if !board.MfgDate.IsZero() {
+		set("mfg_date", board.MfgDate.Format(time.RFC3339))
+	}
+	return m
+}
+
+// area returns the fields of the info area at off, less its version,
+// length, language (for board and product areas) and checksum.
+func area(b []byte, off int, name string) ([]byte, error) {
+	if off+2 > len(b) {
+		return nil, fmt.Errorf("%s area: offset %#x out of range", name,
+			off)
+	}
+	n := int(b[off+1]) * 8
+	if n < 3 || off+n > len(b) {
+		return nil, fmt.Errorf("%s area: length %d out of range", name,
+			n)
+	}
+	a := b[off : off+n]
+	if sum(a) != 0 {
+		return nil, fmt.Errorf("%s area: %w", name, ErrChecksum)
+	}
+	return a[3 : n-1], nil
+}
+
+// strs returns the type/length encoded fields of b up to the end marker,
+// of which there must be at least n.
+func strs(b []byte, n int) ([]string, error) {
+	var fields []string
+	for len(b) > 0 && b[0] != endOfFields {
+		l := int(b[0] & 0x3f)
+		if 1+l > len(b) {
+			return nil, fmt.Errorf("field %d: truncated", len(fields))
+		}
+		fields = append(fields, decode(b[0]>>6, b[1:1+l]))
+		b = b[1+l:]
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("no end of fields")
+	}
+	if len(fields) < n {
+		return nil, fmt.Errorf("%d of %d fields", len(fields), n)
+	}
+	return fields, nil
+}
+
+// decode returns the text of the field b of the type code t.
+func decode(t byte, b []byte) string {
+	switch t {
+	case 0: // binary
+		return hex.EncodeToString(b)
+	case 1: // BCD plus
+		const digits = "0123456789 -.???"
+		s := make([]byte, 0, 2*len(b))
+		for _, c := range b {
+			s = append(s, digits[c>>4], digits[c&0xf])
+		}
+		return strings.TrimSpace(string(s))
+	case 2: // 6-bit ASCII, 4 characters in 3 bytes
+		var s []byte
+		for i := 0; i < len(b); i += 3 {
+			var v uint32
+			for k := 0; k < 3 && i+k < len(b); k++ {
+				v |= uint32(b[i+k]) << (8 * uint(k))
+			}
+			for k := 0; k < 4; k++ {
+				s = append(s, byte(v>>(6*uint(k))&0x3f)+0x20)
+			}
+		}
+		return strings.TrimSpace(string(s))
+	}
+	// 8-bit ASCII, as for the English language code
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// sum is zero for an area with a valid zero checksum.
+func sum(b []byte) byte {
+	var s byte
+	for _, c := range b {
+		s += c
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package fru
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// field returns the 8-bit ASCII type/length encoding of s.
+func field(s string) []byte {
+	return append([]byte{0xc0 | byte(len(s))}, s...)
+}
+
+// newArea pads the fields f, after version, length and language, to a
+// multiple of 8 bytes with the end marker and checksum.
+func newArea(pre []byte, f ...[]byte) []byte {
+	a := append([]byte{1, 0}, pre...)
+	for _, b := range f {
+		a = append(a, b...)
+	}
+	a = append(a, endOfFields)
+	for (len(a)+1)%8 != 0 {
+		a = append(a, 0)
+	}
+	a[1] = byte((len(a) + 1) / 8)
+	return append(a, -sum(a))
+}
+
+func newFru() []byte {
+	min := 12345678 // 2019-06-22T09:18:00Z
+	board := newArea([]byte{0x19, byte(min), byte(min >> 8),
+		byte(min >> 16)},
+		field("Great Wall"),
+		field("CRPS550"),
+		[]byte{0x43, 0x12, 0x34, 0xa5},                   // BCD plus "1234 5"
+		[]byte{0x86, 0xa6, 0x5c, 0x37, 0x61, 0x2a, 0x37}, // "FRU-AIR-"
+		[]byte{0x02, 0xab, 0xcd},                         // binary
+	)
+	product := newArea([]byte{0x19},
+		field("Great Wall Power"),
+		field("CRPS550"),
+		field("GW-CRPS550N2"),
+		field("A02"),
+		field("G550A123456"),
+		field(""),
+		field(""),
+		field("B2F"),
+	)
+	b := []byte{1, 0, 0, 1, byte(1 + len(board)/8), 0, 0, 0}
+	b[7] = -sum(b)
+	b = append(b, board...)
+	b = append(b, product...)
+	for len(b) < 256 {
+		b = append(b, 0xff)
+	}
+	return b
+}
+
+func TestParse(t *testing.T) {
+	b := newFru()
+	info, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	board := Board{
+		MfgDate:      Epoch.Add(12345678 * time.Minute),
+		Manufacturer: "Great Wall",
+		Product:      "CRPS550",
+		Serial:       "1234 5",
+		PartNumber:   "FRU-AIR-",
+		FileId:       "abcd",
+	}
+	if !reflect.DeepEqual(*info.Board, board) {
+		t.Errorf("board: %+v", *info.Board)
+	}
+	product := Product{
+		Manufacturer: "Great Wall Power",
+		Name:         "CRPS550",
+		PartNumber:   "GW-CRPS550N2",
+		Version:      "A02",
+		Serial:       "G550A123456",
+		Custom:       []string{"B2F"},
+	}
+	if !reflect.DeepEqual(*info.Product, product) {
+		t.Errorf("product: %+v", *info.Product)
+	}
+	want := map[string]string{
+		"manufacturer": "Great Wall Power",
+		"part_number":  "GW-CRPS550N2",
+		"serial":       "G550A123456",
+		"version":      "A02",
+		"mfg_date":     "2019-06-22T09:18:00Z",
+	}
+	if got := info.Fields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields: %v", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, x := range []struct {
+		name   string
+		modify func(b []byte)
+		err    error
+	}{
+		{"blank", func(b []byte) {
+			for i := range b {
+				b[i] = 0xff
+			}
+		}, ErrHeader},
+		{"header", func(b []byte) { b[6] = 1 }, ErrChecksum},
+		{"board", func(b []byte) { b[12] ^= 0x20 }, ErrChecksum},
+		{"padding", func(b []byte) { b[len(b)-1] = 0 }, nil},
+	} {
+		b := newFru()
+		x.modify(b)
+		_, err := Parse(b)
+		if x.err == nil {
+			if err != nil {
+				t.Errorf("%s: %v", x.name, err)
+			}
+		} else if !errors.Is(err, x.err) {
+			t.Errorf("%s: %v, want %v", x.name, err, x.err)
+		}
+	}
+
+	b := newFru()
+	b[4] = 0x1f // product area past the end
+	b[7] = 0
+	b[7] = -sum(b[:8])
+	if _, err := Parse(b); err == nil {
+		t.Error("product area out of range")
+	}
+}
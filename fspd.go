@@ -4,71 +4,71 @@
 
 package main
 
-import "github.com/platinasystems/goes-bmc/cmd/fspd"
+import (
+	"strconv"
 
-func fspdInit() {
-	fspd.Vdev[0].Slot = 2
-	fspd.Vdev[0].Bus = 12
-	fspd.Vdev[0].Addr = 0x58
-	fspd.Vdev[0].AddrProm = 0x50
-	fspd.Vdev[0].GpioPwrok = "PSU0_PWROK"
-	fspd.Vdev[0].GpioPrsntL = "PSU0_PRSNT_L"
-	fspd.Vdev[0].GpioPwronL = "PSU0_PWRON_L"
-	fspd.Vdev[0].GpioIntL = "PSU0_INT_L"
-
-	fspd.Vdev[1].Slot = 1
-	fspd.Vdev[1].Bus = 13
-	fspd.Vdev[1].Addr = 0x58
-	fspd.Vdev[1].AddrProm = 0x50
-	fspd.Vdev[1].GpioPwrok = "PSU1_PWROK"
-	fspd.Vdev[1].GpioPrsntL = "PSU1_PRSNT_L"
-	fspd.Vdev[1].GpioPwronL = "PSU1_PWRON_L"
-	fspd.Vdev[1].GpioIntL = "PSU1_INT_L"
-
-	fspd.VpageByKey = map[string]uint8{
+	"github.com/platinasystems/goes-bmc/cmd/fspd"
+	"github.com/platinasystems/goes-bmc/platform"
+)
 
-		"psu1.eeprom":              1,
-		"psu1.fan_speed.units.rpm": 1,
-		"psu1.status":              1,
-		"psu1.admin.state":         1,
-		"psu1.mfg_id":              1,
-		"psu1.mfg_model":           1,
-		"psu1.i_out.units.A":       1,
-		"psu1.v_in.units.V":        1,
-		"psu1.v_out.units.V":       1,
-		"psu1.p_out.units.W":       1,
-		"psu1.p_in.units.W":        1,
-		"psu1.temp1.units.C":       1,
-		"psu1.temp2.units.C":       1,
-		"psu1.fan_direction":       1,
-		"psu1.sn":                  1,
-		"psu2.eeprom":              0,
-		"psu2.fan_speed.units.rpm": 0,
-		"psu2.status":              0,
-		"psu2.admin.state":         0,
-		"psu2.mfg_id":              0,
-		"psu2.mfg_model":           0,
-		"psu2.i_out.units.A":       0,
-		"psu2.v_in.units.V":        0,
-		"psu2.v_out.units.V":       0,
-		"psu2.p_out.units.W":       0,
-		"psu2.p_in.units.W":        0,
-		"psu2.temp1.units.C":       0,
-		"psu2.temp2.units.C":       0,
-		"psu2.fan_direction":       0,
-		"psu2.sn":                  0,
+func fspdInit() {
+	p := platform.Current()
+	fspd.Vdev = make([]fspd.I2cDev, len(p.Fspd.PSU))
+	fspd.VpageByKey = make(map[string]uint8)
+	fspd.WrRegDv["psu"] = "psu"
+	for i, psu := range p.Fspd.PSU {
+		fspd.Vdev[i] = fspd.I2cDev{
+			Slot:       psu.Slot,
+			Bus:        psu.Bus,
+			Addr:       int(psu.Addr),
+			AddrProm:   int(psu.AddrProm),
+			GpioPwrok:  psu.GpioPwrok,
+			GpioPrsntL: psu.GpioPrsntL,
+			GpioPwronL: psu.GpioPwronL,
+			GpioIntL:   psu.GpioIntL,
+		}
+		for _, k := range fspd.Keys(psu.Slot) {
+			fspd.VpageByKey[k] = uint8(i)
+		}
+		dv := "psu" + strconv.Itoa(psu.Slot)
+		fspd.WrRegDv[dv] = dv
+		fspd.WrRegFn[dv+".example"] = "example"
+		fspd.WrRegRng[dv+".example"] = []string{"true", "false"}
+		fspd.WrRegFn[dv+".admin.state"] = "admin.state"
+		fspd.WrRegRng[dv+".admin.state"] = []string{"disable", "enable"}
+		fspd.WrRegFn[dv+".clear_faults"] = "clear_faults"
+		fspd.WrRegRng[dv+".clear_faults"] = []string{"true"}
+		fspd.WrRegFn[dv+".operation"] = "operation"
+		fspd.WrRegRng[dv+".operation"] = fspd.Operations
+		fspd.WrRegFn[dv+".fan_override.percent"] = "fan_override"
+		fspd.WrRegRng[dv+".fan_override.percent"] =
+			fspd.FanOverrideRange
+	}
+	// e.g. the raw psuN.status_word
+	for k, i := range p.Fspd.VpageByKey {
+		fspd.VpageByKey[k] = i
 	}
+	fspd.Thresholds = p.Alarms
 
-	fspd.WrRegDv["psu1"] = "psu1"
-	fspd.WrRegDv["psu2"] = "psu2"
-	fspd.WrRegDv["psu"] = "psu"
-	fspd.WrRegFn["psu1.example"] = "example"
-	fspd.WrRegFn["psu1.admin.state"] = "admin.state"
-	fspd.WrRegRng["psu1.admin.state"] = []string{"disable", "enable"}
-	fspd.WrRegFn["psu2.example"] = "example"
-	fspd.WrRegFn["psu2.admin.state"] = "admin.state"
-	fspd.WrRegRng["psu2.admin.state"] = []string{"true", "false"}
 	fspd.WrRegFn["psu.powercycle"] = "powercycle"
 	fspd.WrRegRng["psu.powercycle"] = []string{"true"}
-	fspd.WrRegRng["psu1.example"] = []string{"true", "false"}
+}
+
+// fspdKeys returns the keys of the configured PSUs, as published by fspd,
+// with those of the platform's vpage_by_key.
+func fspdKeys(p *platform.Platform) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, psu := range p.Fspd.PSU {
+		for _, k := range fspd.Keys(psu.Slot) {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	for k := range p.Fspd.VpageByKey {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys
 }
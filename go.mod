@@ -1,18 +1,24 @@
 module github.com/platinasystems/goes-bmc
 
 require (
+	github.com/d2g/dhcp4 v0.0.0-20170904100407-a1d1b6c41b1c
+	github.com/d2g/dhcp4client v0.0.0-20180622102533-b7a004ff1a09
+	github.com/garyburd/redigo v1.6.0
+	github.com/jpillora/backoff v0.0.0-20180909062703-3050d21c67d7
 	github.com/platinasystems/atsock v1.1.0
 	github.com/platinasystems/eeprom v1.0.0
 	github.com/platinasystems/flags v1.0.1
 	github.com/platinasystems/goes v1.19.0
 	github.com/platinasystems/gpio v1.3.0
 	github.com/platinasystems/i2c v1.2.1
+	github.com/platinasystems/ioport v0.0.1
 	github.com/platinasystems/log v1.2.1
 	github.com/platinasystems/mtd v0.0.1
 	github.com/platinasystems/parms v1.0.0
 	github.com/platinasystems/ubi v1.0.0
 	github.com/platinasystems/url v1.1.1
 	github.com/tatsushid/go-fastping v0.0.0-20160109021039-d7bb493dee3e
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 )
 
 go 1.15
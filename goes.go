@@ -8,18 +8,31 @@ import (
 	"time"
 
 	"github.com/platinasystems/goes"
+	"github.com/platinasystems/goes-bmc/cmd/chassis"
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/cmd/dhcpcd"
 	"github.com/platinasystems/goes-bmc/cmd/diag"
 	"github.com/platinasystems/goes-bmc/cmd/fantrayd"
 	"github.com/platinasystems/goes-bmc/cmd/fspd"
+	"github.com/platinasystems/goes-bmc/cmd/i2cd"
 	"github.com/platinasystems/goes-bmc/cmd/ipcfg"
+	"github.com/platinasystems/goes-bmc/cmd/ipmid"
 	"github.com/platinasystems/goes-bmc/cmd/ledgpiod"
+	"github.com/platinasystems/goes-bmc/cmd/metricsd"
 	"github.com/platinasystems/goes-bmc/cmd/mmclog"
 	"github.com/platinasystems/goes-bmc/cmd/mmclogd"
+	"github.com/platinasystems/goes-bmc/cmd/psu"
 	"github.com/platinasystems/goes-bmc/cmd/qspi"
+	"github.com/platinasystems/goes-bmc/cmd/redfishd"
+	"github.com/platinasystems/goes-bmc/cmd/sel"
+	"github.com/platinasystems/goes-bmc/cmd/snmpd"
+	"github.com/platinasystems/goes-bmc/cmd/sntpd"
+	"github.com/platinasystems/goes-bmc/cmd/syslogd"
 	"github.com/platinasystems/goes-bmc/cmd/toggle"
 	"github.com/platinasystems/goes-bmc/cmd/ucd9090d"
 	"github.com/platinasystems/goes-bmc/cmd/upgrade"
 	"github.com/platinasystems/goes-bmc/cmd/w83795d"
+	"github.com/platinasystems/goes-bmc/platform"
 	"github.com/platinasystems/goes/cmd"
 	"github.com/platinasystems/goes/cmd/bang"
 	"github.com/platinasystems/goes/cmd/buildid"
@@ -31,7 +44,6 @@ import (
 	"github.com/platinasystems/goes/cmd/cmdline"
 	"github.com/platinasystems/goes/cmd/cp"
 	"github.com/platinasystems/goes/cmd/daemons"
-	"github.com/platinasystems/goes/cmd/dhcpcd"
 	"github.com/platinasystems/goes/cmd/dmesg"
 	"github.com/platinasystems/goes/cmd/echo"
 	eepromcmd "github.com/platinasystems/goes/cmd/eeprom"
@@ -57,7 +69,6 @@ import (
 	"github.com/platinasystems/goes/cmd/hkeys"
 	"github.com/platinasystems/goes/cmd/hset"
 	"github.com/platinasystems/goes/cmd/i2c"
-	"github.com/platinasystems/goes/cmd/i2cd"
 	"github.com/platinasystems/goes/cmd/ifcmd"
 	"github.com/platinasystems/goes/cmd/iminfo"
 	"github.com/platinasystems/goes/cmd/imx6d"
@@ -114,6 +125,29 @@ import (
 
 var Version = "(devel)"
 
+// daemonsInit are the daemons that goes-daemons starts.
+var daemonsInit = [][]string{
+	[]string{"redisd"},
+	[]string{"chassisd"},
+	[]string{"fantrayd"},
+	[]string{"fspd"},
+	[]string{"i2cd"},
+	[]string{"imx6d"},
+	[]string{"ipmid"},
+	[]string{"ledgpiod"},
+	[]string{"metricsd"},
+	[]string{"mmclogd"},
+	[]string{"redfishd"},
+	[]string{"snmpd"},
+	[]string{"sntpd"},
+	[]string{"sshd"},
+	[]string{"syslogd"},
+	[]string{"uptimed"},
+	[]string{"ucd9090d"},
+	[]string{"w83795d"},
+	[]string{"watchdog"},
+}
+
 var Goes = &goes.Goes{
 	NAME: "goes-" + name,
 	APROPOS: lang.Alt{
@@ -123,6 +157,10 @@ var Goes = &goes.Goes{
 		"!":       bang.Command{},
 		"cat":     cat.Command{},
 		"cd":      &cd.Command{},
+		"chassis": chassis.Command{},
+		"chassisd": &chassisd.Command{
+			Init: chassisdInit,
+		},
 		"chmod":   chmod.Command{},
 		"cli":     &cli.Command{},
 		"cp":      cp.Command{},
@@ -150,20 +188,7 @@ var Goes = &goes.Goes{
 		},
 		"function": &function.Command{},
 		"goes-daemons": &daemons.Server{
-			Init: [][]string{
-				[]string{"redisd"},
-				[]string{"fantrayd"},
-				[]string{"fspd"},
-				[]string{"i2cd"},
-				[]string{"imx6d"},
-				[]string{"ledgpiod"},
-				[]string{"mmclogd"},
-				[]string{"sshd"},
-				[]string{"uptimed"},
-				[]string{"ucd9090d"},
-				[]string{"w83795d"},
-				[]string{"watchdog"},
-			},
+			Init: daemonsInit,
 		},
 		"gpio":    gpio.Command{},
 		"grep":    grep.Command{},
@@ -188,6 +213,9 @@ var Goes = &goes.Goes{
 		},
 		"ip":    ip.Goes,
 		"ipcfg": ipcfg.Command{},
+		"ipmid": &ipmid.Command{
+			Init: ipmidInit,
+		},
 		"kexec": &kexec.Command{},
 		"keys":  keys.Command{},
 		"kill":  kill.Command{},
@@ -195,11 +223,14 @@ var Goes = &goes.Goes{
 		"ledgpiod": &ledgpiod.Command{
 			Init: ledgpiodInit,
 		},
-		"ln":      ln.Command{},
-		"log":     log.Command{},
-		"ls":      ls.Command{},
-		"lsmod":   lsmod.Command{},
-		"lsof":    lsof.Command{},
+		"ln":    ln.Command{},
+		"log":   log.Command{},
+		"ls":    ls.Command{},
+		"lsmod": lsmod.Command{},
+		"lsof":  lsof.Command{},
+		"metricsd": &metricsd.Command{
+			Init: metricsdInit,
+		},
 		"mkdir":   mkdir.Command{},
 		"mknod":   mknod.Command{},
 		"mmclog":  mmclog.Command{},
@@ -207,8 +238,12 @@ var Goes = &goes.Goes{
 		"mount":   mount.Command{},
 		"ping":    ping.Command{},
 		"ps":      ps.Command{},
+		"psu":     psu.Command{},
 		"pwd":     pwd.Command{},
 		"reboot":  &reboot.Command{},
+		"redfishd": &redfishd.Command{
+			Init: redfishdInit,
+		},
 		"redisd": &redisd.Command{
 			Devs:    []string{"lo", "eth0"},
 			Machine: "platina-mk1-bmc",
@@ -221,6 +256,7 @@ var Goes = &goes.Goes{
 					eeprom.OUI([3]byte{0x02, 0x46, 0x8a}),
 				)
 				eeprom.RedisdHook(pub)
+				platform.RedisdHook(pub)
 			},
 		},
 		"reload":  reload.Command{},
@@ -229,6 +265,7 @@ var Goes = &goes.Goes{
 		"rmmod":   rmmod.Command{},
 		"qspi":    qspi.Command{},
 		"scp":     scp.Command{},
+		"sel":     sel.Command{},
 		"show": &goes.Goes{
 			NAME:  "show",
 			USAGE: "show OBJECT",
@@ -248,8 +285,12 @@ var Goes = &goes.Goes{
 				"version":   &version.Command{V: Version},
 			},
 		},
-		"/init":  &slashinit.Command{FsHook: ubiSetup},
-		"sleep":  sleep.Command{},
+		"/init": &slashinit.Command{FsHook: ubiSetup},
+		"sleep": sleep.Command{},
+		"snmpd": &snmpd.Command{
+			Init: snmpdInit,
+		},
+		"sntpd":  &sntpd.Command{},
 		"source": &source.Command{},
 		"sshd":   &sshd.Command{FailSafe: false},
 		"start": &start.Command{
@@ -262,6 +303,7 @@ var Goes = &goes.Goes{
 		"stty":      stty.Command{},
 		"subscribe": subscribe.Command{},
 		"sync":      sync.Command{},
+		"syslogd":   &syslogd.Command{},
 		"[":         testcmd.Command{},
 		"then":      &thencmd.Command{},
 		"toggle":    toggle.Command{},
@@ -0,0 +1,189 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package gpio
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Transition is a change of the level of a Fake pin.
+type Transition struct {
+	Time  time.Time
+	Name  string
+	Value bool
+}
+
+func (t Transition) String() string {
+	return fmt.Sprintf("%s %s %t", t.Time.Format("15:04:05.000"), t.Name,
+		t.Value)
+}
+
+// Fake is an in-memory Backend that records every Transition of its pins.
+//
+//	f := gpio.NewFake()
+//	f.Add("PSU0_PWRON_L", "low")
+//	gpio.Default = f
+//	...
+//	for _, t := range f.Transitions("PSU0_PWRON_L") {
+type Fake struct {
+	mutex sync.Mutex
+	pins  map[string]*FakePin
+	log   []Transition
+	// watchers are notified of each transition
+	watchers []*fakeWatcher
+}
+
+// FakePin is a Pin of a Fake. Writes to an input pin are errors, as the
+// level of an input is only changed by Fake.Set.
+type FakePin struct {
+	f     *Fake
+	name  string
+	def   string
+	dir   string
+	value bool
+}
+
+func NewFake() *Fake {
+	return &Fake{pins: make(map[string]*FakePin)}
+}
+
+// Add creates the pin name with def as its device tree direction: "in",
+// "high" or "low". The pin starts out in that state; inputs read low.
+func (f *Fake) Add(name, def string) *FakePin {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	p := &FakePin{f: f, name: name, def: def, dir: def,
+		value: def == "high"}
+	f.pins[name] = p
+	return p
+}
+
+// Set drives the level of pin name from outside, e.g. a PSU pulling its
+// PRSNT_L low when plugged in, whatever the pin's direction.
+func (f *Fake) Set(name string, v bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if p, found := f.pins[name]; found {
+		p.set(v)
+	}
+}
+
+// Get returns the level of pin name.
+func (f *Fake) Get(name string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if p, found := f.pins[name]; found {
+		return p.value
+	}
+	return false
+}
+
+// Transitions returns the recorded transitions of the named pins in the
+// order they happened, or those of all pins if no names are given.
+func (f *Fake) Transitions(names ...string) []Transition {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var ts []Transition
+	for _, t := range f.log {
+		if len(names) == 0 {
+			ts = append(ts, t)
+			continue
+		}
+		for _, name := range names {
+			if t.Name == name {
+				ts = append(ts, t)
+				break
+			}
+		}
+	}
+	return ts
+}
+
+// ClearTransitions discards the recorded transitions.
+func (f *Fake) ClearTransitions() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.log = nil
+}
+
+func (f *Fake) FindPin(name string) (Pin, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	p, found := f.pins[name]
+	if !found {
+		return nil, false
+	}
+	return p, true
+}
+
+func (f *Fake) AllPins() PinMap {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	pm := make(PinMap)
+	for name, p := range f.pins {
+		pm[name] = p
+	}
+	return pm
+}
+
+// set changes the level with f locked.
+func (p *FakePin) set(v bool) {
+	if v == p.value {
+		return
+	}
+	p.value = v
+	p.f.log = append(p.f.log, Transition{
+		Time:  time.Now(),
+		Name:  p.name,
+		Value: v,
+	})
+	p.f.notify(p.name)
+}
+
+func (p *FakePin) setDirection(dir string) error {
+	switch dir {
+	case "in":
+	case "out", "low":
+		p.set(false)
+	case "high":
+		p.set(true)
+	default:
+		return fmt.Errorf("%s: invalid direction %q", p.name, dir)
+	}
+	p.dir = dir
+	return nil
+}
+
+func (p *FakePin) Value() (bool, error) {
+	p.f.mutex.Lock()
+	defer p.f.mutex.Unlock()
+	return p.value, nil
+}
+
+func (p *FakePin) SetValue(v bool) error {
+	p.f.mutex.Lock()
+	defer p.f.mutex.Unlock()
+	if p.dir == "in" {
+		return fmt.Errorf("%s: can't set an input", p.name)
+	}
+	p.set(v)
+	return nil
+}
+
+func (p *FakePin) SetDirection(dir string) error {
+	p.f.mutex.Lock()
+	defer p.f.mutex.Unlock()
+	return p.setDirection(dir)
+}
+
+func (p *FakePin) SetDefault() error {
+	p.f.mutex.Lock()
+	defer p.f.mutex.Unlock()
+	return p.setDirection(p.def)
+}
+
+func (p *FakePin) String() string { return "fake gpio " + p.name }
@@ -0,0 +1,56 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package gpio finds the named GPIO pins of the board through a Backend,
+// sysfs by default, so that the pin logic can also run against a Fake.
+package gpio
+
+import (
+	sysfs "github.com/platinasystems/gpio"
+)
+
+// Pin is a GPIO pin; *sysfs.Pin implements it.
+type Pin interface {
+	Value() (bool, error)
+	SetValue(v bool) error
+	// SetDirection takes "in", "out", "high" or "low", the latter two
+	// configure an output with that initial value.
+	SetDirection(dir string) error
+	// SetDefault sets the direction given by the device tree.
+	SetDefault() error
+}
+
+type PinMap map[string]Pin
+
+type Backend interface {
+	FindPin(name string) (Pin, bool)
+	AllPins() PinMap
+}
+
+// Default is the Backend of FindPin and AllPins.
+var Default Backend = Sysfs{}
+
+func FindPin(name string) (Pin, bool) { return Default.FindPin(name) }
+
+func AllPins() PinMap { return Default.AllPins() }
+
+// Sysfs is the Backend of the pins in the device tree, under
+// /sys/class/gpio.
+type Sysfs struct{}
+
+func (Sysfs) FindPin(name string) (Pin, bool) {
+	p, found := sysfs.FindPin(name)
+	if !found {
+		return nil, false
+	}
+	return p, true
+}
+
+func (Sysfs) AllPins() PinMap {
+	pm := make(PinMap)
+	for name, p := range sysfs.AllPins() {
+		pm[name] = p
+	}
+	return pm
+}
@@ -0,0 +1,187 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package gpio
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	sysfs "github.com/platinasystems/gpio"
+)
+
+// Watcher waits for edges of the pins given to Watch.
+type Watcher interface {
+	// Wait returns the names of the pins with an edge since the last
+	// Wait, waiting up to timeout for one. It returns none on timeout.
+	Wait(timeout time.Duration) ([]string, error)
+	Close() error
+}
+
+// Watch returns a Watcher of both edges of the named pins of the Default
+// Backend.
+func Watch(names ...string) (Watcher, error) {
+	if w, ok := Default.(interface {
+		Watch(names ...string) (Watcher, error)
+	}); ok {
+		return w.Watch(names...)
+	}
+	return nil, fmt.Errorf("gpio: %T can't watch", Default)
+}
+
+// Watch has epoll wait for the POLLPRI of the value files of the pins,
+// which sysfs raises on the edges selected by their edge files.
+func (Sysfs) Watch(names ...string) (Watcher, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	w := &sysfsWatcher{epfd: epfd, byFd: make(map[int32]*os.File),
+		names: make(map[int32]string)}
+	for _, name := range names {
+		if err = w.add(name); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return w, nil
+}
+
+type sysfsWatcher struct {
+	epfd  int
+	byFd  map[int32]*os.File
+	names map[int32]string
+}
+
+func (w *sysfsWatcher) add(name string) error {
+	p, found := sysfs.FindPin(name)
+	if !found {
+		return fmt.Errorf("not found")
+	}
+	edge, _, err := p.Open("edge")
+	if err != nil {
+		return err
+	}
+	_, err = edge.WriteString("both\n")
+	edge.Close()
+	if err != nil {
+		return err
+	}
+	f, _, err := p.Open("value")
+	if err != nil {
+		return err
+	}
+	fd := int32(f.Fd())
+	w.byFd[fd] = f
+	w.names[fd] = name
+	// the value must be read before the first wait
+	w.read(f)
+	event := syscall.EpollEvent{
+		Events: syscall.EPOLLPRI | syscall.EPOLLERR,
+		Fd:     fd,
+	}
+	return syscall.EpollCtl(w.epfd, syscall.EPOLL_CTL_ADD, int(fd), &event)
+}
+
+// read rearms the edge of the value file f.
+func (w *sysfsWatcher) read(f *os.File) {
+	var b [8]byte
+	f.ReadAt(b[:], 0)
+}
+
+func (w *sysfsWatcher) Wait(timeout time.Duration) ([]string, error) {
+	var events [8]syscall.EpollEvent
+	n, err := syscall.EpollWait(w.epfd, events[:],
+		int(timeout/time.Millisecond))
+	if err == syscall.EINTR {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, event := range events[:n] {
+		if f, found := w.byFd[event.Fd]; found {
+			w.read(f)
+			names = append(names, w.names[event.Fd])
+		}
+	}
+	return names, nil
+}
+
+func (w *sysfsWatcher) Close() error {
+	for _, f := range w.byFd {
+		f.Close()
+	}
+	return syscall.Close(w.epfd)
+}
+
+// Watch returns a Watcher of the pins changed by Set.
+func (f *Fake) Watch(names ...string) (Watcher, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	w := &fakeWatcher{f: f, pins: make(map[string]bool),
+		c: make(chan string, 64)}
+	for _, name := range names {
+		if _, found := f.pins[name]; !found {
+			return nil, fmt.Errorf("%s: not found", name)
+		}
+		w.pins[name] = true
+	}
+	f.watchers = append(f.watchers, w)
+	return w, nil
+}
+
+type fakeWatcher struct {
+	f    *Fake
+	pins map[string]bool
+	c    chan string
+}
+
+func (w *fakeWatcher) Wait(timeout time.Duration) ([]string, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	var names []string
+	select {
+	case name := <-w.c:
+		names = append(names, name)
+	case <-t.C:
+		return nil, nil
+	}
+	for {
+		select {
+		case name := <-w.c:
+			names = append(names, name)
+		default:
+			return names, nil
+		}
+	}
+}
+
+func (w *fakeWatcher) Close() error {
+	w.f.mutex.Lock()
+	defer w.f.mutex.Unlock()
+	for i, x := range w.f.watchers {
+		if x == w {
+			w.f.watchers = append(w.f.watchers[:i],
+				w.f.watchers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// notify queues the edge of pin name with f locked.
+func (f *Fake) notify(name string) {
+	for _, w := range f.watchers {
+		if w.pins[name] {
+			select {
+			case w.c <- name:
+			default:
+			}
+		}
+	}
+}
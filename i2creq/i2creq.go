@@ -0,0 +1,385 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package i2creq builds i2c transactions and sends them to i2cd.
+//
+// A transaction (Trans) is a batch of up to MAXOPS operations that i2cd
+// executes in order under its bus mutex. Each caller owns its Trans, so a
+// daemon's ticker loop and its redis Hset goroutines no longer share the op
+// and result arrays.
+package i2creq
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/platinasystems/i2c"
+	"github.com/platinasystems/log"
+)
+
+const MAXOPS = 30
+
+// i2cd treats these bus numbers as control requests rather than i2c traffic.
+const (
+	StatusBus    = 0x98 // returns the stopped flag in D[0]
+	StartStopBus = 0x99 // Addr 1 stops polling daemons, Addr 0 resumes
+)
+
+// I and R must match the i2cd I2cReq.ReadWrite argument and reply layout.
+type I struct {
+	InUse     bool
+	RW        i2c.RW
+	RegOffset uint8
+	BusSize   i2c.SMBusSize
+	Data      [i2c.BlockMax]byte
+	Bus       int
+	Addr      int
+	Delay     int
+}
+
+// R.E is unused, net/rpc discards the reply of a failed call; i2cd instead
+// returns an OpFailed error naming the failed operation.
+type R struct {
+	D [i2c.BlockMax]byte
+	E error
+}
+
+// opPrefix starts the error strings of OpFailed.
+const opPrefix = "i2cd op "
+
+// OpFailed returns the error i2cd replies with when operation n of a
+// transaction fails. net/rpc only transports its string, which Do decodes
+// into an OpError of operation n.
+func OpFailed(n int, err error) error {
+	return fmt.Errorf("%s%d: %v", opPrefix, n, err)
+}
+
+// parseOpFailed returns the operation index and error of a string made by
+// OpFailed.
+func parseOpFailed(s string) (int, error, bool) {
+	if !strings.HasPrefix(s, opPrefix) {
+		return 0, nil, false
+	}
+	s = s[len(opPrefix):]
+	i := strings.Index(s, ": ")
+	if i < 0 {
+		return 0, nil, false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, nil, false
+	}
+	return n, errors.New(s[i+2:]), true
+}
+
+// OpError reports the failure of one operation of a transaction. Op is the
+// index of the failed operation, or -1 when a server that doesn't use
+// OpFailed aborted a transaction of several operations.
+type OpError struct {
+	Op        int
+	RW        i2c.RW
+	Bus       int
+	Addr      int
+	RegOffset uint8
+	Err       error
+}
+
+func (e *OpError) Error() string {
+	if e.Op < 0 {
+		return fmt.Sprint("i2c transaction: ", e.Err)
+	}
+	rw := "write"
+	if e.RW == i2c.Read {
+		rw = "read"
+	}
+	return fmt.Sprintf("i2c op %d: %s bus %d addr %#x offset %#x: %v",
+		e.Op, rw, e.Bus, e.Addr, e.RegOffset, e.Err)
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// ErrNoResult is returned by Result for operations of a transaction that
+// has not completed successfully.
+var ErrNoResult = errors.New("no result")
+
+// Trans is a caller-owned i2c transaction. The zero value is ready to use.
+// The first Add after Do starts a new transaction, so a Trans may be reused
+// for a sequence of transactions while the results of the last one remain
+// readable until then.
+type Trans struct {
+	j     [MAXOPS]I
+	s     [MAXOPS]R
+	x     int
+	done  bool
+	valid bool
+}
+
+// Add appends op to the transaction and returns its index.
+func (t *Trans) Add(op I) int {
+	if t.done {
+		t.Reset()
+	}
+	if t.x >= MAXOPS {
+		panic("i2creq: too many operations in transaction")
+	}
+	op.InUse = true
+	t.j[t.x] = op
+	t.x++
+	t.valid = false
+	return t.x - 1
+}
+
+// Read appends an SMBus read of size at offset and returns its index.
+func (t *Trans) Read(bus, addr int, offset uint8, size i2c.SMBusSize) int {
+	return t.Add(I{
+		RW:        i2c.Read,
+		RegOffset: offset,
+		BusSize:   size,
+		Bus:       bus,
+		Addr:      addr,
+	})
+}
+
+// ReadBlock appends an i2c block read of n bytes at offset.
+func (t *Trans) ReadBlock(bus, addr int, offset uint8, n byte) int {
+	op := I{
+		RW:        i2c.Read,
+		RegOffset: offset,
+		BusSize:   i2c.I2CBlockData,
+		Bus:       bus,
+		Addr:      addr,
+	}
+	op.Data[0] = n
+	return t.Add(op)
+}
+
+// Write appends an SMBus write of size at offset with the given data bytes.
+func (t *Trans) Write(bus, addr int, offset uint8, size i2c.SMBusSize,
+	data ...byte) int {
+	op := I{
+		RW:        i2c.Write,
+		RegOffset: offset,
+		BusSize:   size,
+		Bus:       bus,
+		Addr:      addr,
+	}
+	copy(op.Data[:], data)
+	return t.Add(op)
+}
+
+// Delay sets the milliseconds i2cd waits after operation n.
+func (t *Trans) Delay(n, ms int) { t.j[n].Delay = ms }
+
+// Len returns the number of operations in the transaction.
+func (t *Trans) Len() int { return t.x }
+
+// Reset discards all operations and results.
+func (t *Trans) Reset() {
+	*t = Trans{}
+}
+
+// Do sends the transaction to i2cd with the DefaultClient.
+func (t *Trans) Do() error { return DefaultClient.Do(t) }
+
+// Result returns the data of operation n, or ErrNoResult if the
+// transaction hasn't completed.
+func (t *Trans) Result(n int) ([]byte, error) {
+	if !t.valid || n < 0 || n >= t.x {
+		return nil, ErrNoResult
+	}
+	return t.s[n].D[:], nil
+}
+
+// D returns the data of operation n. After a failed transaction it is all
+// zeros, never the data of some earlier transaction.
+func (t *Trans) D(n int) []byte { return t.s[n].D[:] }
+
+// Byte returns the first data byte of operation n.
+func (t *Trans) Byte(n int) byte { return t.s[n].D[0] }
+
+// Word returns the little-endian word data of operation n.
+func (t *Trans) Word(n int) uint16 {
+	return uint16(t.s[n].D[0]) | uint16(t.s[n].D[1])<<8
+}
+
+func (t *Trans) opError(n int, err error) *OpError {
+	if n < 0 {
+		return &OpError{Op: -1, Err: err}
+	}
+	return &OpError{
+		Op:        n,
+		RW:        t.j[n].RW,
+		Bus:       t.j[n].Bus,
+		Addr:      t.j[n].Addr,
+		RegOffset: t.j[n].RegOffset,
+		Err:       err,
+	}
+}
+
+// Client is a connection to an i2cd server that redials after the server
+// restarts. It may be used by concurrent goroutines.
+type Client struct {
+	Addr string
+
+	mutex  sync.Mutex
+	client *rpc.Client
+}
+
+var DefaultClient = &Client{Addr: "127.0.0.1:1233"}
+
+func (c *Client) get() (*rpc.Client, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client == nil {
+		client, err := rpc.DialHTTP("tcp", c.Addr)
+		if err != nil {
+			log.Print("dialing:", err)
+			return nil, err
+		}
+		c.client = client
+		time.Sleep(50 * time.Millisecond)
+	}
+	return c.client, nil
+}
+
+// drop discards a broken connection so that the next call redials.
+func (c *Client) drop(client *rpc.Client) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client == client {
+		c.client.Close()
+		c.client = nil
+	}
+}
+
+// Close closes the connection; a later Do redials.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Close()
+	c.client = nil
+	return err
+}
+
+// Do sends the transaction and waits for the results. Results of an earlier
+// run are cleared first, so a failed transaction never leaves stale data.
+//
+// If the connection was already shut down, e.g. because i2cd restarted,
+// Do redials and resends once since the request was never delivered. Any
+// other transport error drops the connection and is returned, as i2cd may
+// have executed some of the operations.
+func (c *Client) Do(t *Trans) error {
+	err := c.do(t)
+	if err != nil {
+		atomic.AddUint64(&failures, 1)
+	}
+	return err
+}
+
+func (c *Client) do(t *Trans) error {
+	for k := range t.s {
+		t.s[k] = R{}
+	}
+	t.valid = false
+	t.done = true
+	if t.x == 0 {
+		t.valid = true
+		return nil
+	}
+	for retry := 0; ; retry++ {
+		client, err := c.get()
+		if err != nil {
+			return err
+		}
+		err = client.Call("I2cReq.ReadWrite", &t.j, &t.s)
+		if err == nil {
+			break
+		}
+		if se, ok := err.(rpc.ServerError); ok {
+			for k := range t.s {
+				t.s[k] = R{}
+			}
+			if n, e, ok := parseOpFailed(string(se)); ok &&
+				n >= 0 && n < t.x {
+				return t.opError(n, e)
+			}
+			if t.x == 1 {
+				return t.opError(0, err)
+			}
+			return t.opError(-1, err)
+		}
+		c.drop(client)
+		if err == rpc.ErrShutdown && retry == 0 {
+			continue
+		}
+		return err
+	}
+	t.valid = true
+	return nil
+}
+
+var failures uint64
+
+// Errors returns the number of transactions of this process that failed.
+func Errors() uint64 { return atomic.LoadUint64(&failures) }
+
+// Printer is the part of a redis publisher that an ErrorCount uses.
+type Printer interface {
+	Print(a ...interface{}) (int, error)
+}
+
+// ErrorCount publishes the Errors of a daemon as "i2c.errors.NAME".
+type ErrorCount struct {
+	Name string
+
+	published bool
+	last      uint64
+}
+
+// Publish prints the count with pub if it changed since the last Publish.
+func (e *ErrorCount) Publish(pub Printer) {
+	n := Errors()
+	if e.published && n == e.last {
+		return
+	}
+	if _, err := pub.Print("i2c.errors.", e.Name, ": ", n); err == nil {
+		e.published = true
+		e.last = n
+	}
+}
+
+// Stopped reports whether i2c polling is stopped; it also returns true if
+// i2cd can't be reached.
+func Stopped() bool {
+	var t Trans
+	t.Write(StatusBus, 0, 0, i2c.ByteData)
+	if err := t.Do(); err != nil {
+		return true
+	}
+	return t.Byte(0) == 1
+}
+
+// Stop tells the polling daemons to leave the i2c busses alone, e.g. while
+// power cycling or switching QSPI.
+func Stop() error {
+	var t Trans
+	t.Write(StartStopBus, 1, 0, i2c.Quick)
+	return t.Do()
+}
+
+// Start resumes i2c polling after Stop.
+func Start() error {
+	var t Trans
+	t.Write(StartStopBus, 0, 0, i2c.Quick)
+	return t.Do()
+}
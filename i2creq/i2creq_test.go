@@ -0,0 +1,79 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package i2creq_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/goes-bmc/i2csim"
+	"github.com/platinasystems/i2c"
+)
+
+func TestDo(t *testing.T) {
+	sim := i2csim.New()
+	regs := new(i2csim.Regs)
+	regs.Set(0x10, 0x34, 0x12)
+	sim.Add(1, 0x20, regs)
+	addr, err := sim.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+	c := &i2creq.Client{Addr: addr}
+	defer c.Close()
+
+	var tr i2creq.Trans
+	tr.Write(1, 0x20, 0x12, i2c.ByteData, 0x56)
+	n := tr.Read(1, 0x20, 0x10, i2c.WordData)
+	if err = c.Do(&tr); err != nil {
+		t.Fatal(err)
+	}
+	if w := tr.Word(n); w != 0x1234 {
+		t.Errorf("Word: got %#x", w)
+	}
+	if v := regs.Get(0x12); v != 0x56 {
+		t.Errorf("write: got %#x", v)
+	}
+
+	// A NAK fails the transaction and leaves no stale data behind.
+	errs := i2creq.Errors()
+	tr.Read(1, 0x20, 0x10, i2c.WordData)
+	tr.Read(1, 0x21, 0x10, i2c.WordData)
+	err = c.Do(&tr)
+	if n := i2creq.Errors() - errs; n != 1 {
+		t.Errorf("Errors: counted %d failures, want 1", n)
+	}
+	var oe *i2creq.OpError
+	if !errors.As(err, &oe) {
+		t.Fatalf("got %v, want an OpError", err)
+	}
+	if oe.Op != 1 || oe.Addr != 0x21 ||
+		oe.Err.Error() != i2csim.ErrNak.Error() {
+		t.Errorf("got %#v, want a NAK of op 1", oe)
+	}
+	if w := tr.Word(0); w != 0 {
+		t.Errorf("stale data %#x after failed transaction", w)
+	}
+	if _, err = tr.Result(0); err != i2creq.ErrNoResult {
+		t.Errorf("Result after failed transaction: %v", err)
+	}
+
+	// The client redials after i2cd restarts.
+	sim.Close()
+	if addr, err = sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	c.Addr = addr
+	tr.Read(1, 0x20, 0x10, i2c.WordData)
+	if err = c.Do(&tr); err != nil {
+		// a call racing with the close fails, the next one redials
+		err = c.Do(&tr)
+	}
+	if err != nil || tr.Word(0) != 0x1234 {
+		t.Errorf("Do after redial: %#x, %v", tr.Word(0), err)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package i2csim
+
+import (
+	"sync"
+
+	"github.com/platinasystems/i2c"
+)
+
+// Regs is a plain 256 byte register file, which also serves as an EEPROM.
+type Regs struct {
+	mutex sync.Mutex
+	r     [256]byte
+}
+
+// NewEEPROM returns a Regs holding b from offset 0.
+func NewEEPROM(b []byte) *Regs {
+	r := new(Regs)
+	copy(r.r[:], b)
+	return r
+}
+
+func (r *Regs) Get(offset uint8) byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.r[offset]
+}
+
+// Set stores v starting at offset.
+func (r *Regs) Set(offset uint8, v ...byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	copy(r.r[offset:], v)
+}
+
+func (r *Regs) Do(rw i2c.RW, offset uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return doRegs(r.r[:], rw, offset, size, data)
+}
+
+// doRegs performs a transfer on a flat register file.
+func doRegs(r []byte, rw i2c.RW, offset uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	o := int(offset)
+	switch size {
+	case i2c.Quick:
+	case i2c.ByteData:
+		if rw == i2c.Read {
+			data[0] = r[o]
+		} else {
+			r[o] = data[0]
+		}
+	case i2c.WordData:
+		if rw == i2c.Read {
+			data[0] = r[o]
+			data[1] = r[(o+1)%len(r)]
+		} else {
+			r[o] = data[0]
+			r[(o+1)%len(r)] = data[1]
+		}
+	case i2c.I2CBlockData:
+		n := int(data[0])
+		if n > i2c.SMBusMax {
+			n = i2c.SMBusMax
+		}
+		for k := 0; k < n; k++ {
+			if rw == i2c.Read {
+				data[1+k] = r[(o+k)%len(r)]
+			} else {
+				r[(o+k)%len(r)] = data[1+k]
+			}
+		}
+		data[0] = byte(n)
+	default:
+		return ErrNak
+	}
+	return nil
+}
+
+// W83795 models the bank switched registers of the hardware monitor. The
+// fan count and temperature low bits are latched into the shared
+// FractionLSB register (bank 0, 0x3c) when the high byte is read.
+type W83795 struct {
+	mutex sync.Mutex
+	bank  [8][256]byte
+	lsb   [256]byte
+	latch [256]bool
+}
+
+const (
+	w83795BankSelect  = 0x00
+	w83795FanCount    = 0x2e
+	w83795FractionLSB = 0x3c
+)
+
+func NewW83795() *W83795 {
+	return new(W83795)
+}
+
+func (w *W83795) Get(bank int, offset uint8) byte {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.bank[bank][offset]
+}
+
+func (w *W83795) Set(bank int, offset uint8, v byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.bank[bank][offset] = v
+}
+
+// SetFanCount sets the 12 bit count of fan i, 0 through 13.
+func (w *W83795) SetFanCount(i int, count uint16) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.bank[0][w83795FanCount+i] = byte(count >> 4)
+	w.lsb[w83795FanCount+i] = byte(count << 4)
+	w.latch[w83795FanCount+i] = true
+}
+
+// SetTemp sets the temperature register at offset, e.g. 0x21 for the
+// front sensor, in quarter degrees.
+func (w *W83795) SetTemp(offset uint8, c float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	q := int(c * 4)
+	w.bank[0][offset] = byte(q >> 2)
+	w.lsb[offset] = byte(q << 6)
+	w.latch[offset] = true
+}
+
+func (w *W83795) Do(rw i2c.RW, offset uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	b := int(w.bank[0][w83795BankSelect] & 7)
+	if offset == w83795BankSelect {
+		if rw == i2c.Write && size == i2c.ByteData {
+			for k := range w.bank {
+				w.bank[k][w83795BankSelect] = data[0]
+			}
+			return nil
+		}
+	}
+	err := doRegs(w.bank[b][:], rw, offset, size, data)
+	if err == nil && b == 0 && rw == i2c.Read && size == i2c.ByteData &&
+		w.latch[offset] {
+		w.bank[0][w83795FractionLSB] = w.lsb[offset]
+	}
+	return err
+}
+
+// PCA9555 models the 16 bit GPIO expanders. Input reads return the output
+// latch for pins configured as outputs and the level set with SetInput for
+// inputs.
+type PCA9555 struct {
+	mutex sync.Mutex
+	in    [2]byte
+	r     [8]byte
+}
+
+const (
+	pca9555Input    = 0
+	pca9555Output   = 2
+	pca9555Polarity = 4
+	pca9555Config   = 6
+)
+
+// NewPCA9555 returns an expander in its power on state, all pins inputs
+// pulled high.
+func NewPCA9555() *PCA9555 {
+	p := &PCA9555{in: [2]byte{0xff, 0xff}}
+	p.r[pca9555Output] = 0xff
+	p.r[pca9555Output+1] = 0xff
+	p.r[pca9555Config] = 0xff
+	p.r[pca9555Config+1] = 0xff
+	return p
+}
+
+// SetInput sets the external level of the pins of port, 0 or 1.
+func (p *PCA9555) SetInput(port int, v byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.in[port] = v
+}
+
+// Output returns the output latch of port.
+func (p *PCA9555) Output(port int) byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.r[pca9555Output+port]
+}
+
+// Config returns the direction register of port, 1 bits are inputs.
+func (p *PCA9555) Config(port int) byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.r[pca9555Config+port]
+}
+
+func (p *PCA9555) Do(rw i2c.RW, offset uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if offset > 7 {
+		return ErrNak
+	}
+	for port := 0; port < 2; port++ {
+		c := p.r[pca9555Config+port]
+		p.r[pca9555Input+port] = (p.in[port]&c |
+			p.r[pca9555Output+port]&^c) ^ p.r[pca9555Polarity+port]
+	}
+	if rw == i2c.Write && offset < pca9555Output {
+		return nil
+	}
+	switch size {
+	case i2c.ByteData:
+		if rw == i2c.Read {
+			data[0] = p.r[offset]
+		} else {
+			p.r[offset] = data[0]
+		}
+	case i2c.WordData:
+		if rw == i2c.Read {
+			data[0] = p.r[offset]
+			data[1] = p.r[offset^1]
+		} else {
+			p.r[offset] = data[0]
+			p.r[offset^1] = data[1]
+		}
+	default:
+		return ErrNak
+	}
+	return nil
+}
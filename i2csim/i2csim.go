@@ -0,0 +1,174 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package i2csim is a stand-in for i2cd that serves I2cReq.ReadWrite from
+// simulated devices, so the daemons can be tested without a board.
+//
+//	sim := i2csim.NewMk1()
+//	addr, err := sim.Start()
+//	...
+//	i2creq.DefaultClient = &i2creq.Client{Addr: addr}
+//	defer sim.Close()
+//
+// or, in a test, sim := i2csim.StartTest(t).
+package i2csim
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/i2c"
+)
+
+// Device is an i2c slave. Do has the semantics of i2c.Bus.Do for one
+// SMBus transfer; an error stands for a NAK.
+type Device interface {
+	Do(rw i2c.RW, offset uint8, size i2c.SMBusSize, data *i2c.SMBusData) error
+}
+
+// ErrNak is returned for transfers the simulated device doesn't accept.
+var ErrNak = errors.New("no acknowledge")
+
+type key struct {
+	bus, addr int
+}
+
+// Server dispatches i2cd requests to the Devices added to it.
+type Server struct {
+	mutex    sync.Mutex
+	devs     map[key]Device
+	stopped  byte
+	listener *listener
+}
+
+func New() *Server {
+	return &Server{devs: make(map[key]Device)}
+}
+
+// Add places d at addr on bus, replacing any device already there.
+func (s *Server) Add(bus, addr int, d Device) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.devs[key{bus, addr}] = d
+}
+
+// Remove takes the device at addr on bus off the bus, e.g. to simulate an
+// unplugged PSU.
+func (s *Server) Remove(bus, addr int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.devs, key{bus, addr})
+}
+
+// Stopped reports whether a client sent an i2c STOP.
+func (s *Server) Stopped() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stopped != 0
+}
+
+// Start listens on a free loopback port and serves requests until Close.
+// It returns the address to set in an i2creq.Client.
+func (s *Server) Start() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	srv := rpc.NewServer()
+	if err = srv.RegisterName("I2cReq", &i2cReq{s}); err != nil {
+		l.Close()
+		return "", err
+	}
+	s.listener = &listener{Listener: l}
+	go http.Serve(s.listener, srv)
+	return l.Addr().String(), nil
+}
+
+// Close stops the server and drops its connections, as if i2cd exited.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// listener tracks the accepted connections so that Close can drop them.
+type listener struct {
+	net.Listener
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mutex.Lock()
+		l.conns = append(l.conns, c)
+		l.mutex.Unlock()
+	}
+	return c, err
+}
+
+func (l *listener) Close() error {
+	err := l.Listener.Close()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, c := range l.conns {
+		c.Close()
+	}
+	l.conns = nil
+	return err
+}
+
+type i2cReq struct {
+	s *Server
+}
+
+// ReadWrite mirrors i2cd: the pseudo busses are only honored in the first
+// op, and on error the whole transaction fails with the results of the
+// completed ops discarded and the failed op named with i2creq.OpFailed.
+func (r *i2cReq) ReadWrite(g *[i2creq.MAXOPS]i2creq.I,
+	f *[i2creq.MAXOPS]i2creq.R) error {
+	s := r.s
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if g[0].Bus == i2creq.StartStopBus {
+		s.stopped = byte(g[0].Addr)
+		return nil
+	}
+	if g[0].Bus == i2creq.StatusBus {
+		f[0].D[0] = s.stopped
+		return nil
+	}
+	for x := 0; x < i2creq.MAXOPS; x++ {
+		if !g[x].InUse {
+			continue
+		}
+		d, found := s.devs[key{g[x].Bus, g[x].Addr}]
+		if !found {
+			return i2creq.OpFailed(x, ErrNak)
+		}
+		var data i2c.SMBusData
+		copy(data[:], g[x].Data[:])
+		err := d.Do(g[x].RW, g[x].RegOffset, g[x].BusSize, &data)
+		if err != nil {
+			return i2creq.OpFailed(x, err)
+		}
+		f[x].D[0] = data[0]
+		f[x].D[1] = data[1]
+		if g[x].BusSize == i2c.I2CBlockData {
+			copy(f[x].D[2:], data[2:])
+		}
+		if g[x].Delay > 0 {
+			time.Sleep(time.Duration(g[x].Delay) * time.Millisecond)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package i2csim
+
+import (
+	"math"
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/i2creq"
+)
+
+// Mk1 is a Server populated with the devices of a Mk1 BMC at the
+// addresses set up by the goes-bmc Init functions.
+type Mk1 struct {
+	*Server
+	W83795  *W83795
+	FanTray *PCA9555
+	LedFp   *PCA9555
+	UCD9090 *UCD9090
+	// PSU[0] and PSUProm[0] are in slot 2 on bus 12, PSU[1] in slot 1
+	// on bus 13, matching fspd.Vdev.
+	PSU     [2]*PMBus
+	PSUProm [2]*Regs
+}
+
+func NewMk1() *Mk1 {
+	m := &Mk1{
+		Server:  New(),
+		W83795:  NewW83795(),
+		FanTray: NewPCA9555(),
+		LedFp:   NewPCA9555(),
+		UCD9090: NewUCD9090(),
+	}
+	m.Add(11, 0x2f, m.W83795)
+	m.Add(14, 0x20, m.FanTray)
+	m.Add(5, 0x75, m.LedFp)
+	m.Add(4, 0x34, m.UCD9090)
+	for i := range m.PSU {
+		m.PSU[i] = NewPSU()
+		m.PSUProm[i] = new(Regs)
+		m.Add(12+i, 0x58, m.PSU[i])
+		m.Add(12+i, 0x50, m.PSUProm[i])
+	}
+	return m
+}
+
+// StartTest starts a Mk1 as the i2creq.DefaultClient's server for the
+// test t, until its cleanup closes both and restores the client.
+func StartTest(t testing.TB) *Mk1 {
+	t.Helper()
+	m := NewMk1()
+	addr, err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := i2creq.DefaultClient
+	i2creq.DefaultClient = &i2creq.Client{Addr: addr}
+	t.Cleanup(func() {
+		i2creq.DefaultClient.Close()
+		i2creq.DefaultClient = client
+		m.Close()
+	})
+	return m
+}
+
+// NewPSU returns a PMBus PSU delivering 12V with LINEAR16 VOUT.
+func NewPSU() *PMBus {
+	p := NewPMBus()
+	p.SetByte(0x00, 0)    // PAGE
+	p.SetByte(0x01, 0x80) // OPERATION
+	p.SetByte(0x02, 0x17) // ON_OFF_CONFIG, by CONTROL only
+	p.Support(0x03)       // CLEAR_FAULTS
+	p.SetByte(0x20, 0x17) // VOUT_MODE, exponent -9
+	p.SetByte(0x3a, 0x90) // FAN_CONFIG_1_2, fan 1 by duty cycle
+	p.SetWord(0x3b, 0)    // FAN_COMMAND_1
+	p.SetWord(0x79, 0)    // STATUS_WORD
+	for _, cmd := range []uint8{0x7a, 0x7b, 0x7c, 0x7d, 0x81} {
+		p.SetByte(cmd, 0) // STATUS_VOUT ... STATUS_FANS_1_2
+	}
+	p.SetWord(0x88, Linear11(230))
+	p.SetWord(0x89, Linear11(1.5))
+	p.SetWord(0x8b, 12<<9) // READ_VOUT
+	p.SetWord(0x8c, Linear11(25))
+	p.SetWord(0x8d, Linear11(40))
+	p.SetWord(0x8e, Linear11(45))
+	p.SetWord(0x90, Linear11(7000))
+	p.SetWord(0x96, Linear11(300))
+	p.SetWord(0x97, Linear11(330))
+	p.SetByte(0x98, 0x22) // PMBUS_REVISION
+	p.SetBlock(0x99, []byte("Great Wall"))
+	p.SetBlock(0x9a, []byte("CRPS550"))
+	return p
+}
+
+// Linear11 encodes v in the PMBus LINEAR11 format with the most precision
+// that fits.
+func Linear11(v float64) uint16 {
+	n := -16
+	for n < 15 && math.Abs(v/math.Exp2(float64(n))) > 1023 {
+		n++
+	}
+	y := int(math.Round(v / math.Exp2(float64(n))))
+	return uint16(n&0x1f)<<11 | uint16(y&0x7ff)
+}
@@ -0,0 +1,303 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package i2csim
+
+import (
+	"sync"
+
+	"github.com/platinasystems/i2c"
+)
+
+// PMBus models a PMBus device as a set of supported commands. Commands
+// that were never set or Supported are NAKed, as are send byte commands
+// other than those Supported.
+type PMBus struct {
+	mutex sync.Mutex
+	cmds  map[uint8][]byte
+	sent  map[uint8]int
+	naks  map[uint8]int
+}
+
+func NewPMBus() *PMBus {
+	return &PMBus{
+		cmds: make(map[uint8][]byte),
+		sent: make(map[uint8]int),
+		naks: make(map[uint8]int),
+	}
+}
+
+// Support makes the device accept cmds, e.g. send byte commands such as
+// CLEAR_FAULTS (0x03); unset commands read as zero.
+func (p *PMBus) Support(cmds ...uint8) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, cmd := range cmds {
+		if _, found := p.cmds[cmd]; !found {
+			p.cmds[cmd] = []byte{}
+		}
+	}
+}
+
+// Unsupport makes the device NAK cmd.
+func (p *PMBus) Unsupport(cmd uint8) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.cmds, cmd)
+}
+
+func (p *PMBus) SetByte(cmd, v uint8) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cmds[cmd] = []byte{v}
+}
+
+func (p *PMBus) SetWord(cmd uint8, v uint16) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cmds[cmd] = []byte{byte(v), byte(v >> 8)}
+}
+
+// SetBlock sets a block read command; the device prefixes the byte count.
+func (p *PMBus) SetBlock(cmd uint8, b []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cmds[cmd] = append([]byte{byte(len(b))}, b...)
+}
+
+func (p *PMBus) Byte(cmd uint8) uint8 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.get(cmd, 0)
+}
+
+func (p *PMBus) Word(cmd uint8) uint16 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return uint16(p.get(cmd, 0)) | uint16(p.get(cmd, 1))<<8
+}
+
+// Sent returns the number of times the send byte command cmd was received.
+func (p *PMBus) Sent(cmd uint8) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.sent[cmd]
+}
+
+// Naks returns the number of times the unsupported command cmd was NAKed.
+func (p *PMBus) Naks(cmd uint8) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.naks[cmd]
+}
+
+func (p *PMBus) get(cmd uint8, k int) byte {
+	if k < len(p.cmds[cmd]) {
+		return p.cmds[cmd][k]
+	}
+	return 0
+}
+
+func (p *PMBus) Do(rw i2c.RW, offset uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.do(rw, offset, size, data)
+}
+
+func (p *PMBus) do(rw i2c.RW, cmd uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	if _, found := p.cmds[cmd]; !found {
+		p.naks[cmd]++
+		return ErrNak
+	}
+	switch size {
+	case i2c.Byte:
+		if rw == i2c.Read {
+			return ErrNak
+		}
+		p.sent[cmd]++
+	case i2c.ByteData:
+		if rw == i2c.Read {
+			data[0] = p.get(cmd, 0)
+		} else {
+			p.cmds[cmd] = []byte{data[0]}
+		}
+	case i2c.WordData:
+		if rw == i2c.Read {
+			data[0] = p.get(cmd, 0)
+			data[1] = p.get(cmd, 1)
+		} else {
+			p.cmds[cmd] = []byte{data[0], data[1]}
+		}
+	case i2c.I2CBlockData:
+		n := int(data[0])
+		if n > i2c.SMBusMax {
+			n = i2c.SMBusMax
+		}
+		if rw == i2c.Read {
+			for k := 0; k < n; k++ {
+				data[1+k] = p.get(cmd, k)
+			}
+		} else {
+			p.cmds[cmd] = append([]byte{}, data[1:1+n]...)
+		}
+		data[0] = byte(n)
+	default:
+		return ErrNak
+	}
+	return nil
+}
+
+// UCD9090 models the power sequencer's fault log on top of PMBus.
+// LOGGED_FAULT_DETAIL_INDEX reads the fault count in the high byte and the
+// index in the low byte; writing the low byte selects the record that
+// LOGGED_FAULT_DETAIL returns. PAGE selects the rail of the VOUT_MODE and
+// READ_VOUT set with SetVout.
+type UCD9090 struct {
+	*PMBus
+	faults [][10]byte
+	index  uint8
+	page   uint8
+	rails  map[uint8]rail
+	clock  []byte
+}
+
+// rail is the VOUT_MODE and READ_VOUT of a page.
+type rail struct {
+	mode uint8
+	vout uint16
+}
+
+const (
+	ucdPage                   = 0x00
+	ucdVoutMode               = 0x20
+	ucdReadVout               = 0x8b
+	ucdRunTimeClock           = 0xd7
+	ucdLoggedFaultDetailIndex = 0xeb
+	ucdLoggedFaultDetail      = 0xec
+)
+
+// Fault is a UCD9090 fault log record.
+type Fault struct {
+	Milli uint32 // since the run time clock was last set
+	Days  uint32 // of 23 bits
+	Paged bool
+	Page  uint8
+	Type  uint8
+}
+
+func NewUCD9090() *UCD9090 {
+	return &UCD9090{PMBus: NewPMBus(), rails: make(map[uint8]rail)}
+}
+
+// SetVout sets the VOUT_MODE and READ_VOUT of page.
+func (u *UCD9090) SetVout(page, mode uint8, vout uint16) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.rails[page] = rail{mode, vout}
+}
+
+// LogFault appends f to the fault log.
+func (u *UCD9090) LogFault(f Fault) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	var d [10]byte
+	d[0] = byte(f.Milli >> 24)
+	d[1] = byte(f.Milli >> 16)
+	d[2] = byte(f.Milli >> 8)
+	d[3] = byte(f.Milli)
+	d[4] = (f.Type & 0xf) << 3
+	if f.Paged {
+		d[4] |= 0x80
+	}
+	d[4] |= (f.Page >> 1) & 0x7
+	d[5] = (f.Page&1)<<7 | byte(f.Days>>16)&0x7f
+	d[6] = byte(f.Days >> 8)
+	d[7] = byte(f.Days)
+	u.faults = append(u.faults, d)
+}
+
+// RunTimeClock returns the last RUN_TIME_CLOCK block written, nil if none.
+func (u *UCD9090) RunTimeClock() []byte {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return append([]byte(nil), u.clock...)
+}
+
+// ClearFaultLog empties the fault log.
+func (u *UCD9090) ClearFaultLog() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.faults = nil
+	u.index = 0
+}
+
+func (u *UCD9090) Do(rw i2c.RW, offset uint8, size i2c.SMBusSize,
+	data *i2c.SMBusData) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	switch offset {
+	case ucdPage:
+		if size != i2c.ByteData {
+			return ErrNak
+		}
+		if rw == i2c.Read {
+			data[0] = u.page
+		} else {
+			u.page = data[0]
+		}
+		return nil
+	case ucdVoutMode, ucdReadVout:
+		r, found := u.rails[u.page]
+		if !found || rw != i2c.Read {
+			return ErrNak
+		}
+		if offset == ucdVoutMode && size == i2c.ByteData {
+			data[0] = r.mode
+		} else if offset == ucdReadVout && size == i2c.WordData {
+			data[0] = byte(r.vout)
+			data[1] = byte(r.vout >> 8)
+		} else {
+			return ErrNak
+		}
+		return nil
+	case ucdRunTimeClock:
+		if size != i2c.BlockData || rw != i2c.Write || data[0] != 8 {
+			return ErrNak
+		}
+		u.clock = append([]byte(nil), data[1:9]...)
+		return nil
+	case ucdLoggedFaultDetailIndex:
+		if size != i2c.WordData {
+			return ErrNak
+		}
+		if rw == i2c.Read {
+			data[0] = u.index
+			data[1] = byte(len(u.faults))
+		} else {
+			u.index = data[0]
+		}
+		return nil
+	case ucdLoggedFaultDetail:
+		if size != i2c.I2CBlockData || rw != i2c.Read ||
+			int(u.index) >= len(u.faults) {
+			return ErrNak
+		}
+		n := int(data[0])
+		if n > i2c.SMBusMax {
+			n = i2c.SMBusMax
+		}
+		b := append([]byte{10}, u.faults[u.index][:]...)
+		for k := 0; k < n; k++ {
+			data[1+k] = 0
+			if k < len(b) {
+				data[1+k] = b[k]
+			}
+		}
+		data[0] = byte(n)
+		return nil
+	}
+	return u.do(rw, offset, size, data)
+}
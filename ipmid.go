@@ -0,0 +1,28 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package main
+
+import (
+	"github.com/platinasystems/goes-bmc/cmd/ipmid"
+	"github.com/platinasystems/goes-bmc/platform"
+)
+
+func ipmidInit() { ipmidConfig(platform.Current()) }
+
+func ipmidConfig(p *platform.Platform) {
+	ipmid.Keys = fspdKeys(p)
+	for _, m := range []map[string]uint8{
+		p.Fantrayd.VpageByKey,
+		p.W83795d.VpageByKey,
+		p.Ucd9090d.VpageByKey,
+		p.Ledgpiod.VpageByKey,
+	} {
+		for k := range m {
+			ipmid.Keys = append(ipmid.Keys, k)
+		}
+	}
+	ipmid.Thresholds = p.Alarms
+	ipmid.Version = Version
+}
@@ -0,0 +1,46 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/cmd/ipmid"
+	"github.com/platinasystems/goes-bmc/cmd/snmpd"
+	"github.com/platinasystems/goes-bmc/platform"
+)
+
+func TestPsuSensorKeys(t *testing.T) {
+	p, err := platform.Default().Select(platform.UnknownIdent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(i, s []string) { ipmid.Keys, snmpd.Keys = i, s }(ipmid.Keys,
+		snmpd.Keys)
+	ipmidConfig(p)
+	snmpdConfig(p)
+	for name, keys := range map[string][]string{
+		"ipmid": ipmid.Keys,
+		"snmpd": snmpd.Keys,
+	} {
+		has := make(map[string]bool)
+		for _, k := range keys {
+			if has[k] {
+				t.Errorf("%s: duplicate %s", name, k)
+			}
+			has[k] = true
+		}
+		for _, k := range []string{
+			"psu1.v_out.units.V",
+			"psu2.v_out.units.V",
+			"psu1.p_in.units.W",
+			"psu2.temp1.units.C",
+		} {
+			if !has[k] {
+				t.Errorf("%s: no %s", name, k)
+			}
+		}
+	}
+}
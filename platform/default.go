@@ -0,0 +1,156 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package platform
+
+// DefaultJSON describes the Mk1 boards. The last entry matches any board,
+// so a Description built from it always selects a platform.
+//
+// Proto and alpha boards, device version 0x00 or 0xff, have the power
+// sequencer and front panel LED expander at other addresses. The CH1
+// management card moves the power sequencer too.
+//
+// The alarm thresholds warn outside of the ranges that diag checks.
+const DefaultJSON = `{
+	"version": 1,
+	"platforms": [
+		{
+			"name": "mk1-tor1-proto",
+			"inherit": "mk1-tor1",
+			"match": {
+				"device_version": [ "0x00", "0xff" ]
+			},
+			"ucd9090d": { "addr": "0x7e" },
+			"ledgpiod": { "addr": "0x22" }
+		},
+		{
+			"name": "ch1-mc",
+			"inherit": "mk1-tor1",
+			"match": {
+				"chassis_type": [ "0x01" ],
+				"board_type": [ "0x04" ]
+			},
+			"ucd9090d": { "addr": "0x7e" }
+		},
+		{
+			"name": "mk1-tor1",
+			"match": {},
+			"fspd": {
+				"psu": [
+					{
+						"slot": 2,
+						"bus": 12,
+						"addr": "0x58",
+						"addr_prom": "0x50",
+						"gpio_pwrok": "PSU0_PWROK",
+						"gpio_prsnt_l": "PSU0_PRSNT_L",
+						"gpio_pwron_l": "PSU0_PWRON_L",
+						"gpio_int_l": "PSU0_INT_L"
+					},
+					{
+						"slot": 1,
+						"bus": 13,
+						"addr": "0x58",
+						"addr_prom": "0x50",
+						"gpio_pwrok": "PSU1_PWROK",
+						"gpio_prsnt_l": "PSU1_PRSNT_L",
+						"gpio_pwron_l": "PSU1_PWRON_L",
+						"gpio_int_l": "PSU1_INT_L"
+					}
+				]
+			},
+			"fantrayd": {
+				"bus": 14,
+				"addr": "0x20",
+				"vpage_by_key": {
+					"fan_tray.1.status": 1,
+					"fan_tray.2.status": 2,
+					"fan_tray.3.status": 3,
+					"fan_tray.4.status": 4
+				},
+				"reinit": {
+					"bus": 1,
+					"addr": "0x20",
+					"mux": { "bus": 1, "addr": "0x72", "value": "0x04" }
+				}
+			},
+			"w83795d": {
+				"bus": 11,
+				"addr": "0x2f",
+				"vpage_by_key": {
+					"fan_tray.1.1.speed.units.rpm": 1,
+					"fan_tray.1.2.speed.units.rpm": 2,
+					"fan_tray.2.1.speed.units.rpm": 3,
+					"fan_tray.2.2.speed.units.rpm": 4,
+					"fan_tray.3.1.speed.units.rpm": 5,
+					"fan_tray.3.2.speed.units.rpm": 6,
+					"fan_tray.4.1.speed.units.rpm": 7,
+					"fan_tray.4.2.speed.units.rpm": 8,
+					"fan_tray.speed": 0,
+					"fan_tray.duty": 0,
+					"hwmon.front.temp.units.C": 0,
+					"hwmon.rear.temp.units.C": 0,
+					"hwmon.target.units.C": 0,
+					"host.temp.units.C": 0,
+					"host.temp.target.units.C": 0,
+					"qsfp.temp.units.C": 0,
+					"qsfp.temp.target.units.C": 0
+				}
+			},
+			"ucd9090d": {
+				"bus": 4,
+				"addr": "0x34",
+				"vpage_by_key": {
+					"vmon.5v.sb.units.V": 1,
+					"vmon.3v8.bmc.units.V": 2,
+					"vmon.3v3.sys.units.V": 3,
+					"vmon.3v3.bmc.units.V": 4,
+					"vmon.3v3.sb.units.V": 5,
+					"vmon.1v0.thc.units.V": 6,
+					"vmon.1v8.sys.units.V": 7,
+					"vmon.1v25.sys.units.V": 8,
+					"vmon.1v2.ethx.units.V": 9,
+					"vmon.1v0.tha.units.V": 10,
+					"vmon.poweroff.events": 0
+				}
+			},
+			"ledgpiod": {
+				"bus": 5,
+				"addr": "0x75",
+				"vpage_by_key": {
+					"system.fan_direction": 0
+				}
+			},
+			"alarms": {
+				"vmon.5v.sb.units.V": { "low_critical": 4.5, "low_warning": 4.75, "high_warning": 5.25, "high_critical": 5.5, "hysteresis": 0.05 },
+				"vmon.3v8.bmc.units.V": { "low_critical": 3.42, "low_warning": 3.61, "high_warning": 3.99, "high_critical": 4.18, "hysteresis": 0.04 },
+				"vmon.3v3.sys.units.V": { "low_critical": 2.97, "low_warning": 3.135, "high_warning": 3.465, "high_critical": 3.63, "hysteresis": 0.03 },
+				"vmon.3v3.bmc.units.V": { "low_critical": 2.97, "low_warning": 3.135, "high_warning": 3.465, "high_critical": 3.63, "hysteresis": 0.03 },
+				"vmon.3v3.sb.units.V": { "low_critical": 2.97, "low_warning": 3.135, "high_warning": 3.465, "high_critical": 3.63, "hysteresis": 0.03 },
+				"vmon.1v0.thc.units.V": { "low_critical": 0.9, "low_warning": 0.95, "high_warning": 1.05, "high_critical": 1.1, "hysteresis": 0.01 },
+				"vmon.1v8.sys.units.V": { "low_critical": 1.62, "low_warning": 1.71, "high_warning": 1.89, "high_critical": 1.98, "hysteresis": 0.02 },
+				"vmon.1v25.sys.units.V": { "low_critical": 1.125, "low_warning": 1.187, "high_warning": 1.312, "high_critical": 1.375, "hysteresis": 0.01 },
+				"vmon.1v2.ethx.units.V": { "low_critical": 1.08, "low_warning": 1.114, "high_warning": 1.26, "high_critical": 1.32, "hysteresis": 0.01 },
+				"vmon.1v0.tha.units.V": { "low_critical": 0.9, "low_warning": 0.983, "high_warning": 1.087, "high_critical": 1.1, "hysteresis": 0.01 },
+				"hwmon.front.temp.units.C": { "high_warning": 70, "high_critical": 80, "hysteresis": 2 },
+				"hwmon.rear.temp.units.C": { "high_warning": 70, "high_critical": 80, "hysteresis": 2 },
+				"fan_tray.1.1.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.1.2.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.2.1.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.2.2.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.3.1.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.3.2.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.4.1.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"fan_tray.4.2.speed.units.rpm": { "low_critical": 1000, "low_warning": 4000, "hysteresis": 200 },
+				"psu1.v_out.units.V": { "low_critical": 10.8, "low_warning": 11.4, "high_warning": 12.6, "high_critical": 13.2, "hysteresis": 0.1 },
+				"psu1.temp1.units.C": { "high_warning": 70, "high_critical": 85, "hysteresis": 2 },
+				"psu1.temp2.units.C": { "high_warning": 70, "high_critical": 85, "hysteresis": 2 },
+				"psu2.v_out.units.V": { "low_critical": 10.8, "low_warning": 11.4, "high_warning": 12.6, "high_critical": 13.2, "hysteresis": 0.1 },
+				"psu2.temp1.units.C": { "high_warning": 70, "high_critical": 85, "hysteresis": 2 },
+				"psu2.temp2.units.C": { "high_warning": 70, "high_critical": 85, "hysteresis": 2 }
+			}
+		}
+	]
+}
+`
@@ -0,0 +1,203 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package platform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	eeprom "github.com/platinasystems/goes/cmd/eeprom/platina_eeprom"
+	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes/external/redis/publisher"
+	"github.com/platinasystems/log"
+)
+
+// Unknown is the value of an identity field the EEPROM doesn't have. It is
+// published as "unknown".
+const Unknown = -1
+
+// Ident identifies a board by the fields of its EEPROM.
+type Ident struct {
+	ChassisType   int
+	BoardType     int
+	DeviceVersion int
+}
+
+var UnknownIdent = Ident{Unknown, Unknown, Unknown}
+
+func (id Ident) String() string {
+	return fmt.Sprint("chassis type ", hexOrUnknown(id.ChassisType),
+		", board type ", hexOrUnknown(id.BoardType),
+		", device version ", hexOrUnknown(id.DeviceVersion))
+}
+
+// Proto reports whether the board is a proto or alpha build, device
+// version 0x00 or 0xff. An unknown version is taken as production.
+func (id Ident) Proto() bool {
+	return id.DeviceVersion == 0x00 || id.DeviceVersion == 0xff
+}
+
+// Info is the board identity decoded from the EEPROM.
+type Info struct {
+	Ident
+	SubType int
+	PPN     string // PCBA part number, "" if unknown
+}
+
+var UnknownInfo = Info{Ident: UnknownIdent, SubType: Unknown}
+
+// The Platina vendor extension, as written by diag prom, is the IANA
+// enterprise number followed by these TLVs.
+const (
+	PEN = 0x0000bc65
+
+	chassisTypeType = 0x50
+	boardTypeType   = 0x51
+	subTypeType     = 0x52
+	ppnType         = 0x53
+	serialType      = 0x54
+)
+
+// ONIE TlvInfo layout.
+const (
+	onieMagic         = "TlvInfo\x00"
+	onieHeaderSz      = len(onieMagic) + 1 + 2
+	deviceVersionType = 0x26
+	vendorExtType     = 0xfd
+)
+
+// Decode returns the identity of a raw ONIE EEPROM image. Fields missing
+// from the image are Unknown.
+func Decode(buf []byte) (Info, error) {
+	info := UnknownInfo
+	if len(buf) < onieHeaderSz || string(buf[:len(onieMagic)]) != onieMagic {
+		return info, fmt.Errorf("not an ONIE TlvInfo EEPROM")
+	}
+	n := int(binary.BigEndian.Uint16(buf[onieHeaderSz-2:]))
+	buf = buf[onieHeaderSz:]
+	if n < len(buf) {
+		buf = buf[:n]
+	}
+	for len(buf) >= 2 {
+		t, l := buf[0], int(buf[1])
+		buf = buf[2:]
+		if l > len(buf) {
+			return info, fmt.Errorf("TLV %#x: truncated", t)
+		}
+		v := buf[:l]
+		buf = buf[l:]
+		switch t {
+		case deviceVersionType:
+			if l > 0 {
+				info.DeviceVersion = int(v[0])
+			}
+		case vendorExtType:
+			info.decodeVendorExtension(v)
+		}
+	}
+	return info, nil
+}
+
+// decodeVendorExtension decodes the TLVs of a Platina vendor extension,
+// with or without its enterprise number, ignoring other vendors'.
+func (info *Info) decodeVendorExtension(v []byte) {
+	if len(v) >= 4 && binary.BigEndian.Uint32(v) == PEN {
+		v = v[4:]
+	}
+	for len(v) >= 2 {
+		t, l := v[0], int(v[1])
+		if t < chassisTypeType || t > serialType || l > len(v)-2 {
+			return
+		}
+		ev := v[2 : 2+l]
+		v = v[2+l:]
+		if l == 0 {
+			continue
+		}
+		switch t {
+		case chassisTypeType:
+			info.ChassisType = int(ev[0])
+		case boardTypeType:
+			info.BoardType = int(ev[0])
+		case subTypeType:
+			info.SubType = int(ev[0])
+		case ppnType:
+			info.PPN = string(ev)
+		}
+	}
+}
+
+// String returns the platform.* redis keys and values of info, one
+// "key: value" per line.
+func (info *Info) String() string {
+	ppn := info.PPN
+	if len(ppn) == 0 {
+		ppn = "unknown"
+	}
+	buf := new(bytes.Buffer)
+	fmt.Fprintln(buf, "platform.chassis_type:", hexOrUnknown(info.ChassisType))
+	fmt.Fprintln(buf, "platform.board_type:", hexOrUnknown(info.BoardType))
+	fmt.Fprintln(buf, "platform.sub_type:", hexOrUnknown(info.SubType))
+	fmt.Fprintln(buf, "platform.ppn:", ppn)
+	fmt.Fprintln(buf, "platform.device_version:",
+		hexOrUnknown(info.DeviceVersion))
+	return buf.String()
+}
+
+// RedisdHook reads the EEPROM and publishes the board identity and the
+// name of its platform for the daemons to read with IdentFromRedis.
+func RedisdHook(pub *publisher.Publisher) {
+	info := UnknownInfo
+	buf, err := eeprom.ReadBytes()
+	if err == nil {
+		info, err = Decode(buf)
+	}
+	if err != nil {
+		log.Print("platform: ", err)
+	}
+	for _, s := range strings.Split(info.String(), "\n") {
+		if len(s) > 0 {
+			pub.Write([]byte(s))
+		}
+	}
+	pub.Print("platform.name: ", Get(info.Ident).Name)
+}
+
+// IdentFromRedis returns the Ident published by RedisdHook; fields that
+// are unknown or unpublished are Unknown.
+func IdentFromRedis() Ident {
+	return Ident{
+		ChassisType:   hgetHex("platform.chassis_type"),
+		BoardType:     hgetHex("platform.board_type"),
+		DeviceVersion: hgetHex("platform.device_version"),
+	}
+}
+
+// Current returns the platform of this BMC.
+func Current() *Platform {
+	return Get(IdentFromRedis())
+}
+
+func hexOrUnknown(v int) string {
+	if v == Unknown {
+		return "unknown"
+	}
+	return fmt.Sprintf("0x%02x", v)
+}
+
+func hgetHex(key string) int {
+	s, err := redis.Hget(redis.DefaultHash, key)
+	if err != nil {
+		return Unknown
+	}
+	v, err := strconv.ParseInt(s, 0, 0)
+	if err != nil {
+		return Unknown
+	}
+	return int(v)
+}
@@ -0,0 +1,71 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+// onie returns an EEPROM image of the given TLVs.
+func onie(tlvs ...[]byte) []byte {
+	var data []byte
+	for _, tlv := range tlvs {
+		data = append(data, tlv[0], byte(len(tlv)-1))
+		data = append(data, tlv[1:]...)
+	}
+	b := append([]byte(onieMagic), 1, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+func TestDecode(t *testing.T) {
+	ext := []byte{vendorExtType, 0x00, 0x00, 0xbc, 0x65,
+		chassisTypeType, 1, 0x01,
+		boardTypeType, 1, 0x04,
+		subTypeType, 1, 0x0a,
+		ppnType, 14}
+	ext = append(ext, "900-000005-000"...)
+	for _, x := range []struct {
+		name string
+		buf  []byte
+		info Info
+		err  bool
+	}{
+		{"blank", make([]byte, 256), UnknownInfo, true},
+		{"no vendor extension", onie([]byte{deviceVersionType, 0x00}),
+			Info{Ident{Unknown, Unknown, 0}, Unknown, ""}, false},
+		{"diag prom", onie([]byte{deviceVersionType, 0x0b}, ext),
+			Info{Ident{1, 4, 0x0b}, 0x0a, "900-000005-000"}, false},
+		{"no enterprise number", onie([]byte{vendorExtType,
+			boardTypeType, 1, 0x00, chassisTypeType, 1, 0x00}),
+			Info{Ident{0, 0, Unknown}, Unknown, ""}, false},
+		{"other vendor", onie([]byte{vendorExtType,
+			0x00, 0x00, 0x01, 0x37, 0x01, 0x02}),
+			UnknownInfo, false},
+		{"truncated", onie([]byte{deviceVersionType, 1})[:13],
+			UnknownInfo, true},
+	} {
+		info, err := Decode(x.buf)
+		if (err != nil) != x.err {
+			t.Errorf("%s: error %v", x.name, err)
+		}
+		if !reflect.DeepEqual(info, x.info) {
+			t.Errorf("%s: got %+v, want %+v", x.name, info, x.info)
+		}
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	info := Info{Ident{0, 0, 0xff}, Unknown, ""}
+	want := `platform.chassis_type: 0x00
+platform.board_type: 0x00
+platform.sub_type: unknown
+platform.ppn: unknown
+platform.device_version: 0xff
+`
+	if s := info.String(); s != want {
+		t.Errorf("got\n%swant\n%s", s, want)
+	}
+}
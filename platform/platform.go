@@ -0,0 +1,297 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package platform describes the i2c busses, addresses, GPIO names and
+// vpages of each BMC board revision, so that a new revision needs a new
+// entry in the platform description rather than a Go change.
+//
+// The description is a JSON document,
+//
+//	{
+//		"version": 1,
+//		"platforms": [
+//			{
+//				"name": "mk1-tor1-proto",
+//				"inherit": "mk1-tor1",
+//				"match": { "device_version": [ "0x00", "0xff" ] },
+//				"ucd9090d": { "addr": "0x7e" }
+//			},
+//			...
+//		]
+//	}
+//
+// read from File, or the built-in DefaultJSON if there is no such file. The
+// first platform whose match accepts the board's Ident is selected; an
+// empty match accepts any board. A platform that names another in inherit
+// starts with a copy of it and overrides what it sets, merging maps such as
+// vpage_by_key and alarms entry by entry.
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/alarm"
+	"github.com/platinasystems/log"
+)
+
+// Version is the description format understood by this package.
+const Version = 1
+
+// File is the platform description that overrides DefaultJSON.
+const File = "/etc/goes/platform.json"
+
+// Hex is an integer that may be written as a JSON number or as a string
+// with a base prefix, e.g. "0x7e".
+type Hex int
+
+func (h *Hex) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if s, err = strconv.Unquote(s); err != nil {
+			return err
+		}
+	}
+	v, err := strconv.ParseInt(s, 0, 0)
+	if err != nil {
+		return fmt.Errorf("invalid number %s", b)
+	}
+	*h = Hex(v)
+	return nil
+}
+
+// Match lists the accepted values of each Ident field; an empty list
+// accepts any value, including Unknown.
+type Match struct {
+	ChassisType   []Hex `json:"chassis_type,omitempty"`
+	BoardType     []Hex `json:"board_type,omitempty"`
+	DeviceVersion []Hex `json:"device_version,omitempty"`
+}
+
+func (m *Match) Matches(id Ident) bool {
+	in := func(l []Hex, v int) bool {
+		if len(l) == 0 {
+			return true
+		}
+		for _, h := range l {
+			if int(h) == v {
+				return true
+			}
+		}
+		return false
+	}
+	return in(m.ChassisType, id.ChassisType) &&
+		in(m.BoardType, id.BoardType) &&
+		in(m.DeviceVersion, id.DeviceVersion)
+}
+
+// Dev is an i2c device and the vpage of each redis key its daemon
+// publishes.
+type Dev struct {
+	Bus        int              `json:"bus"`
+	Addr       Hex              `json:"addr"`
+	VpageByKey map[string]uint8 `json:"vpage_by_key,omitempty"`
+}
+
+// Mux is the upstream i2c mux and channel that reach a device.
+type Mux struct {
+	Bus   int `json:"bus"`
+	Addr  Hex `json:"addr"`
+	Value Hex `json:"value"`
+}
+
+// FanTray is the fan board GPIO expander. Reinit is the path to it
+// through the fan board mux, which fantrayd uses after a power event.
+type FanTray struct {
+	Dev
+	Reinit struct {
+		Bus  int `json:"bus"`
+		Addr Hex `json:"addr"`
+		Mux  Mux `json:"mux"`
+	} `json:"reinit"`
+}
+
+// PSU is a power supply slot.
+type PSU struct {
+	Slot       int    `json:"slot"`
+	Bus        int    `json:"bus"`
+	Addr       Hex    `json:"addr"`
+	AddrProm   Hex    `json:"addr_prom"`
+	GpioPwrok  string `json:"gpio_pwrok"`
+	GpioPrsntL string `json:"gpio_prsnt_l"`
+	GpioPwronL string `json:"gpio_pwron_l"`
+	GpioIntL   string `json:"gpio_int_l"`
+}
+
+// Fspd has the PSU slots. fspd publishes the fspd.Keys of each; VpageByKey
+// adds others, e.g. psu1.status_word, by index of PSU.
+type Fspd struct {
+	PSU        []PSU            `json:"psu"`
+	VpageByKey map[string]uint8 `json:"vpage_by_key,omitempty"`
+}
+
+// Platform is the description of one board revision.
+type Platform struct {
+	Name     string  `json:"name"`
+	Inherit  string  `json:"inherit,omitempty"`
+	Match    Match   `json:"match"`
+	Fspd     Fspd    `json:"fspd"`
+	Fantrayd FanTray `json:"fantrayd"`
+	W83795d  Dev     `json:"w83795d"`
+	Ucd9090d Dev     `json:"ucd9090d"`
+	Ledgpiod Dev     `json:"ledgpiod"`
+
+	// Alarms are the sensor thresholds by published key. A platform
+	// that overrides a sensor's threshold replaces all of its limits.
+	Alarms map[string]alarm.Threshold `json:"alarms,omitempty"`
+}
+
+// Description is a parsed platform description with inheritance resolved.
+type Description struct {
+	Version   int
+	Platforms []*Platform
+}
+
+// Parse decodes a platform description.
+func Parse(b []byte) (*Description, error) {
+	var doc struct {
+		Version   int               `json:"version"`
+		Platforms []json.RawMessage `json:"platforms"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version != Version {
+		return nil, fmt.Errorf("version %d unsupported, want %d",
+			doc.Version, Version)
+	}
+	raw := make(map[string]json.RawMessage)
+	names := make([]string, len(doc.Platforms))
+	for i, m := range doc.Platforms {
+		var hdr struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(m, &hdr); err != nil {
+			return nil, fmt.Errorf("platform %d: %v", i, err)
+		}
+		if hdr.Name == "" {
+			return nil, fmt.Errorf("platform %d: no name", i)
+		}
+		if _, found := raw[hdr.Name]; found {
+			return nil, fmt.Errorf("%s: duplicate platform", hdr.Name)
+		}
+		raw[hdr.Name] = m
+		names[i] = hdr.Name
+	}
+	d := &Description{Version: doc.Version}
+	for _, name := range names {
+		p, err := resolve(raw, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		d.Platforms = append(d.Platforms, p)
+	}
+	return d, nil
+}
+
+// resolve decodes the named platform over a copy of the one it inherits.
+func resolve(raw map[string]json.RawMessage, name string,
+	seen []string) (*Platform, error) {
+	for _, s := range seen {
+		if s == name {
+			return nil, fmt.Errorf("%s: inherit loop: %s", name,
+				strings.Join(append(seen, name), " -> "))
+		}
+	}
+	m, found := raw[name]
+	if !found {
+		return nil, fmt.Errorf("%s: no such platform", name)
+	}
+	var hdr struct {
+		Inherit string `json:"inherit"`
+	}
+	if err := json.Unmarshal(m, &hdr); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	p := new(Platform)
+	if hdr.Inherit != "" {
+		parent, err := resolve(raw, hdr.Inherit, append(seen, name))
+		if err != nil {
+			return nil, err
+		}
+		// Copy through JSON so that the parent's maps and slices
+		// aren't shared.
+		b, err := json.Marshal(parent)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, p); err != nil {
+			return nil, err
+		}
+		p.Match = Match{}
+	}
+	if err := json.Unmarshal(m, p); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+// Load reads and parses a platform description file.
+func Load(fn string) (*Description, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	d, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return d, nil
+}
+
+// Default returns the built-in description.
+func Default() *Description {
+	d, err := Parse([]byte(DefaultJSON))
+	if err != nil {
+		panic(fmt.Errorf("platform: default description: %v", err))
+	}
+	return d
+}
+
+// Select returns the first platform matching id.
+func (d *Description) Select(id Ident) (*Platform, error) {
+	for _, p := range d.Platforms {
+		if p.Match.Matches(id) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no platform for %v", id)
+}
+
+// Get returns the platform of id from File, falling back to the built-in
+// description if File is missing, invalid, or has no platform for id.
+// It never fails since the built-in description matches any board.
+func Get(id Ident) *Platform {
+	d, err := Load(File)
+	if err == nil {
+		var p *Platform
+		if p, err = d.Select(id); err == nil {
+			return p
+		}
+		err = fmt.Errorf("%s: %v", File, err)
+	}
+	if !os.IsNotExist(err) {
+		log.Print("platform: ", err, "; using built-in description")
+	}
+	p, err := Default().Select(id)
+	if err != nil {
+		panic(fmt.Errorf("platform: default description: %v", err))
+	}
+	return p
+}
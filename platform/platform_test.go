@@ -0,0 +1,106 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package platform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefault(t *testing.T) {
+	d := Default()
+	for _, x := range []struct {
+		id       Ident
+		name     string
+		ucd, led int
+	}{
+		{UnknownIdent, "mk1-tor1", 0x34, 0x75},
+		{Ident{0, 0, 1}, "mk1-tor1", 0x34, 0x75},
+		{Ident{Unknown, Unknown, 0}, "mk1-tor1-proto", 0x7e, 0x22},
+		{Ident{0, 0, 0xff}, "mk1-tor1-proto", 0x7e, 0x22},
+		{Ident{1, 4, 1}, "ch1-mc", 0x7e, 0x75},
+		{Ident{1, 5, 1}, "mk1-tor1", 0x34, 0x75},
+		{Ident{2, 4, 1}, "mk1-tor1", 0x34, 0x75},
+		{Ident{Unknown, 4, 1}, "mk1-tor1", 0x34, 0x75},
+	} {
+		p, err := d.Select(x.id)
+		if err != nil {
+			t.Fatal(x.id, ": ", err)
+		}
+		if p.Name != x.name || int(p.Ucd9090d.Addr) != x.ucd ||
+			int(p.Ledgpiod.Addr) != x.led {
+			t.Errorf("%v: got %s ucd %#x led %#x, want %s %#x %#x",
+				x.id, p.Name, p.Ucd9090d.Addr, p.Ledgpiod.Addr,
+				x.name, x.ucd, x.led)
+		}
+		if p.Ucd9090d.Bus != 4 || len(p.Fspd.PSU) != 2 ||
+			p.Ucd9090d.VpageByKey["vmon.1v0.tha.units.V"] != 10 ||
+			p.Alarms["vmon.5v.sb.units.V"].HighWarning == nil {
+			t.Errorf("%v: %s didn't inherit mk1-tor1", x.id, p.Name)
+		}
+	}
+}
+
+func TestInherit(t *testing.T) {
+	d, err := Parse([]byte(`{
+		"version": 1,
+		"platforms": [
+			{
+				"name": "rev2",
+				"inherit": "rev1",
+				"match": { "device_version": [ 2 ] },
+				"w83795d": {
+					"addr": 47,
+					"vpage_by_key": { "b": 3 }
+				}
+			},
+			{
+				"name": "rev1",
+				"w83795d": {
+					"bus": 11,
+					"addr": "0x2e",
+					"vpage_by_key": { "a": 1, "b": 2 }
+				}
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev2, rev1 := d.Platforms[0], d.Platforms[1]
+	if w := rev2.W83795d; w.Bus != 11 || w.Addr != 0x2f ||
+		w.VpageByKey["a"] != 1 || w.VpageByKey["b"] != 3 {
+		t.Errorf("rev2: %+v", w)
+	}
+	if w := rev1.W83795d; w.Addr != 0x2e || w.VpageByKey["b"] != 2 {
+		t.Errorf("rev1 changed by rev2: %+v", w)
+	}
+	if p, _ := d.Select(Ident{0, 0, 1}); p != rev1 {
+		t.Error("rev1 inherited the match of rev2")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, x := range []struct {
+		doc, err string
+	}{
+		{`{"version": 2, "platforms": []}`, "version 2"},
+		{`{"version": 1, "platforms": [{"name": "a", "inherit": "b"}]}`,
+			"b: no such platform"},
+		{`{"version": 1, "platforms": [
+			{"name": "a", "inherit": "b"},
+			{"name": "b", "inherit": "a"}]}`, "inherit loop"},
+		{`{"version": 1, "platforms": [{"name": "a"}, {"name": "a"}]}`,
+			"duplicate"},
+		{`{"version": 1, "platforms": [
+			{"name": "a", "ucd9090d": {"addr": "0xzz"}}]}`,
+			"invalid number"},
+	} {
+		_, err := Parse([]byte(x.doc))
+		if err == nil || !strings.Contains(err.Error(), x.err) {
+			t.Errorf("%s: got %v, want %q", x.doc, err, x.err)
+		}
+	}
+}
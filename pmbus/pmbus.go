@@ -0,0 +1,305 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package pmbus converts the data formats of PMBus part II and accesses
+// PMBus devices through i2cd.
+//
+// READ_VIN, READ_IOUT, READ_TEMPERATURE_1 and most other commands return
+// LINEAR11 words, a 5 bit exponent and an 11 bit mantissa. READ_VOUT and
+// VOUT_COMMAND return LINEAR16 words whose exponent is that of VOUT_MODE.
+// Devices may instead use the DIRECT format of m, b and R coefficients.
+package pmbus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/platinasystems/goes-bmc/i2creq"
+	"github.com/platinasystems/i2c"
+)
+
+// Standard commands
+const (
+	Page           = 0x00
+	Operation      = 0x01
+	OnOffConfig    = 0x02
+	ClearFaults    = 0x03
+	Capability     = 0x19
+	VoutMode       = 0x20
+	VoutCommand    = 0x21
+	Coefficients   = 0x30
+	FanConfig12    = 0x3a
+	FanCommand1    = 0x3b
+	FanCommand2    = 0x3c
+	StatusByte     = 0x78
+	StatusWord     = 0x79
+	StatusVout     = 0x7a
+	StatusIout     = 0x7b
+	StatusInput    = 0x7c
+	StatusTemp     = 0x7d
+	StatusCml      = 0x7e
+	StatusOther    = 0x7f
+	StatusMfr      = 0x80
+	StatusFans12   = 0x81
+	StatusFans34   = 0x82
+	ReadEin        = 0x86
+	ReadEout       = 0x87
+	ReadVin        = 0x88
+	ReadIin        = 0x89
+	ReadVcap       = 0x8a
+	ReadVout       = 0x8b
+	ReadIout       = 0x8c
+	ReadTemp1      = 0x8d
+	ReadTemp2      = 0x8e
+	ReadTemp3      = 0x8f
+	ReadFanSpeed1  = 0x90
+	ReadFanSpeed2  = 0x91
+	ReadPout       = 0x96
+	ReadPin        = 0x97
+	Revision       = 0x98
+	MfrId          = 0x99
+	MfrModel       = 0x9a
+	MfrRevision    = 0x9b
+	MfrLocation    = 0x9c
+	MfrDate        = 0x9d
+	MfrSerial      = 0x9e
+	MfrVinMin      = 0xa0
+	MfrVinMax      = 0xa1
+	MfrIinMax      = 0xa2
+	MfrPinMax      = 0xa3
+	MfrVoutMin     = 0xa4
+	MfrVoutMax     = 0xa5
+	MfrIoutMax     = 0xa6
+	MfrPoutMax     = 0xa7
+	MfrTambientMax = 0xa8
+)
+
+// VOUT_MODE modes, of bits 7:5.
+const (
+	ModeLinear = 0 << 5
+	ModeVid    = 1 << 5
+	ModeDirect = 2 << 5
+	modeMask   = 7 << 5
+)
+
+var ErrMode = errors.New("unsupported VOUT_MODE")
+
+// Linear11 returns the value of the LINEAR11 word v.
+func Linear11(v uint16) float64 {
+	n := int(int16(v) >> 11)
+	y := int(int16(v<<5) >> 5)
+	return float64(y) * math.Exp2(float64(n))
+}
+
+// ToLinear11 returns the LINEAR11 word of f with the most precision that
+// fits.
+func ToLinear11(f float64) uint16 {
+	n := -16
+	for n < 15 && math.Abs(f/math.Exp2(float64(n))) > 1023 {
+		n++
+	}
+	y := int(math.Round(f / math.Exp2(float64(n))))
+	return uint16(n&0x1f)<<11 | uint16(y&0x7ff)
+}
+
+// Linear16 returns the value of the LINEAR16 word v with the exponent of
+// VOUT_MODE mode.
+func Linear16(v uint16, mode uint8) float64 {
+	n := int(int8(mode<<3) >> 3)
+	return float64(v) * math.Exp2(float64(n))
+}
+
+// Vout returns the value of the READ_VOUT or VOUT_COMMAND word v in the
+// format of VOUT_MODE mode, with d the coefficients of a DIRECT mode.
+func Vout(v uint16, mode uint8, d Direct) (float64, error) {
+	switch mode & modeMask {
+	case ModeLinear:
+		return Linear16(v, mode), nil
+	case ModeDirect:
+		if d.M != 0 {
+			return d.Value(v), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %#x", ErrMode, mode)
+}
+
+// Direct has the coefficients of the DIRECT format, of which the value of
+// the word Y is (Y * 10^-R - b) / m.
+type Direct struct {
+	M, B, R int
+}
+
+// Value returns the value of the DIRECT word v, a two's complement integer.
+func (d Direct) Value(v uint16) float64 {
+	return d.value(float64(int16(v)))
+}
+
+func (d Direct) value(y float64) float64 {
+	return (y*math.Pow10(-d.R) - float64(d.B)) / float64(d.M)
+}
+
+// Raw returns the DIRECT word of f.
+func (d Direct) Raw(f float64) uint16 {
+	x := math.Round((float64(d.M)*f + float64(d.B)) * math.Pow10(d.R))
+	return uint16(int16(x))
+}
+
+// EnergyRollover is the value at which the READ_EIN and READ_EOUT
+// accumulator rolls over to 0, incrementing the rollover count.
+const EnergyRollover = 0x8000
+
+// Energy is a READ_EIN or READ_EOUT reading: the sum of power samples in the
+// DIRECT format of the command, as the accumulator and its rollovers, and
+// the number of samples.
+type Energy struct {
+	Accumulator uint16
+	Rollovers   uint8
+	Samples     uint32 // of 24 bits
+}
+
+// ParseEnergy returns the Energy of the 6 byte block b.
+func ParseEnergy(b []byte) (Energy, error) {
+	if len(b) < 6 {
+		return Energy{}, fmt.Errorf("energy: %d byte block", len(b))
+	}
+	e := Energy{
+		Accumulator: uint16(b[0]) | uint16(b[1])<<8,
+		Rollovers:   b[2],
+		Samples:     uint32(b[3]) | uint32(b[4])<<8 | uint32(b[5])<<16,
+	}
+	if e.Accumulator >= EnergyRollover {
+		return Energy{}, fmt.Errorf("energy: accumulator %#x",
+			e.Accumulator)
+	}
+	return e, nil
+}
+
+// Power returns the average power of the samples since prev, of which
+// there may have been 255 rollovers, in the DIRECT format d. It's false
+// without new samples.
+func (e Energy) Power(prev Energy, d Direct) (float64, bool) {
+	const accMod = 256 * EnergyRollover
+	const samplesMod = 1 << 24
+	acc := func(e Energy) uint32 {
+		return uint32(e.Rollovers)*EnergyRollover +
+			uint32(e.Accumulator)
+	}
+	samples := (e.Samples - prev.Samples) % samplesMod
+	if samples == 0 {
+		return 0, false
+	}
+	sum := (acc(e) + accMod - acc(prev)) % accMod
+	return d.value(float64(sum) / float64(samples)), true
+}
+
+// Dev is a PMBus device accessed through i2cd.
+type Dev struct {
+	Bus, Addr int
+}
+
+func (d Dev) Byte(cmd uint8) (uint8, error) {
+	var tr i2creq.Trans
+	tr.Read(d.Bus, d.Addr, cmd, i2c.ByteData)
+	if err := tr.Do(); err != nil {
+		return 0, err
+	}
+	return tr.Byte(0), nil
+}
+
+func (d Dev) Word(cmd uint8) (uint16, error) {
+	var tr i2creq.Trans
+	tr.Read(d.Bus, d.Addr, cmd, i2c.WordData)
+	if err := tr.Do(); err != nil {
+		return 0, err
+	}
+	return tr.Word(0), nil
+}
+
+// Block returns the data of the block read cmd, less its byte count.
+func (d Dev) Block(cmd uint8) ([]byte, error) {
+	var tr i2creq.Trans
+	tr.ReadBlock(d.Bus, d.Addr, cmd, i2c.SMBusMax)
+	if err := tr.Do(); err != nil {
+		return nil, err
+	}
+	b := tr.D(0)
+	n := int(b[1])
+	if n > i2c.SMBusMax-1 {
+		n = i2c.SMBusMax - 1
+	}
+	return append([]byte{}, b[2:2+n]...), nil
+}
+
+// String returns the text of the block read cmd, e.g. MFR_ID, less
+// padding.
+func (d Dev) String(cmd uint8) (string, error) {
+	b, err := d.Block(cmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(b), "# \x00\xff"), nil
+}
+
+// Send sends the command cmd without data, e.g. CLEAR_FAULTS.
+func (d Dev) Send(cmd uint8) error {
+	var tr i2creq.Trans
+	tr.Write(d.Bus, d.Addr, cmd, i2c.Byte)
+	return tr.Do()
+}
+
+func (d Dev) SetByte(cmd, v uint8) error {
+	var tr i2creq.Trans
+	tr.Write(d.Bus, d.Addr, cmd, i2c.ByteData, v)
+	return tr.Do()
+}
+
+func (d Dev) SetWord(cmd uint8, v uint16) error {
+	var tr i2creq.Trans
+	tr.Write(d.Bus, d.Addr, cmd, i2c.WordData, uint8(v), uint8(v>>8))
+	return tr.Do()
+}
+
+// Linear11 returns the value of the LINEAR11 command cmd.
+func (d Dev) Linear11(cmd uint8) (float64, error) {
+	v, err := d.Word(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return Linear11(v), nil
+}
+
+// Energy returns the READ_EIN or READ_EOUT reading of cmd.
+func (d Dev) Energy(cmd uint8) (Energy, error) {
+	b, err := d.Block(cmd)
+	if err != nil {
+		return Energy{}, err
+	}
+	return ParseEnergy(b)
+}
+
+// Vout returns READ_VOUT in the LINEAR16 format of VOUT_MODE.
+func (d Dev) Vout() (float64, error) {
+	var tr i2creq.Trans
+	tr.Read(d.Bus, d.Addr, VoutMode, i2c.ByteData)
+	tr.Read(d.Bus, d.Addr, ReadVout, i2c.WordData)
+	if err := tr.Do(); err != nil {
+		return 0, err
+	}
+	return Vout(tr.Word(1), tr.Byte(0), Direct{})
+}
+
+// PageVout selects page and returns its READ_VOUT in one transaction, so
+// that no other access of the device changes the page in between.
+func (d Dev) PageVout(page uint8) (float64, error) {
+	var tr i2creq.Trans
+	tr.Write(d.Bus, d.Addr, Page, i2c.ByteData, page)
+	tr.Read(d.Bus, d.Addr, VoutMode, i2c.ByteData)
+	tr.Read(d.Bus, d.Addr, ReadVout, i2c.WordData)
+	if err := tr.Do(); err != nil {
+		return 0, err
+	}
+	return Vout(tr.Word(2), tr.Byte(1), Direct{})
+}
@@ -0,0 +1,183 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package pmbus
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/platinasystems/goes-bmc/i2csim"
+)
+
+func TestLinear11(t *testing.T) {
+	for _, x := range []struct {
+		v    uint16
+		want float64
+	}{
+		{0x0000, 0},
+		{0x0001, 1},
+		{0x07ff, -1},
+		{0xf398, 230},
+		{0xe8b0, 22},
+		{0x0a00, 1024},
+		{0xfbff, 511.5},
+		{0xfc00, -512},
+		{0xd3c0, 15},
+		{0x8001, 1.0 / 65536},
+	} {
+		if got := Linear11(x.v); got != x.want {
+			t.Errorf("Linear11(%#04x): got %v, want %v", x.v, got,
+				x.want)
+		}
+	}
+	for _, f := range []float64{0, 1.5, 12, 25, 230, 7000, -40, 0.02} {
+		got := Linear11(ToLinear11(f))
+		if math.Abs(got-f) > math.Abs(f)/1000 {
+			t.Errorf("Linear11(ToLinear11(%v)) = %v", f, got)
+		}
+	}
+}
+
+func TestVout(t *testing.T) {
+	milli := Direct{M: 1, B: 0, R: 3}
+	for _, x := range []struct {
+		v    uint16
+		mode uint8
+		d    Direct
+		want float64
+		err  bool
+	}{
+		{0x1800, 0x17, Direct{}, 12, false},
+		{0x1833, 0x17, Direct{}, 12.099609375, false},
+		{0x2000, 0x13, Direct{}, 1, false},
+		{0x0a00, 0x14, Direct{}, 0.625, false},
+		{0x0003, 0x01, Direct{}, 6, false},
+		{3300, ModeDirect, milli, 3.3, false},
+		{0x0400, ModeDirect, Direct{}, 0, true},
+		{0x0400, ModeVid | 1, Direct{}, 0, true},
+	} {
+		got, err := Vout(x.v, x.mode, x.d)
+		if x.err {
+			if !errors.Is(err, ErrMode) {
+				t.Errorf("Vout(%#04x, %#02x): %v, %v", x.v, x.mode,
+					got, err)
+			}
+			continue
+		}
+		if err != nil || math.Abs(got-x.want) > 1e-9 {
+			t.Errorf("Vout(%#04x, %#02x): got %v, %v, want %v",
+				x.v, x.mode, got, err, x.want)
+		}
+	}
+}
+
+func TestDirect(t *testing.T) {
+	for _, x := range []struct {
+		d    Direct
+		v    uint16
+		want float64
+	}{
+		{Direct{M: 1, B: 0, R: 0}, 100, 100},
+		{Direct{M: 1, B: 0, R: 0}, 0xfff6, -10},
+		{Direct{M: 2, B: 100, R: -1}, 400, 1950},
+		{Direct{M: 20, B: 0, R: 2}, 5000, 2.5},
+		{Direct{M: 1, B: 0, R: 3}, 12000, 12},
+	} {
+		got := x.d.Value(x.v)
+		if math.Abs(got-x.want) > 1e-9 {
+			t.Errorf("%+v Value(%d): got %v, want %v", x.d, x.v, got,
+				x.want)
+		}
+		if raw := x.d.Raw(x.want); raw != x.v {
+			t.Errorf("%+v Raw(%v): got %d, want %d", x.d, x.want,
+				raw, x.v)
+		}
+	}
+}
+
+func TestEnergy(t *testing.T) {
+	e, err := ParseEnergy([]byte{0x34, 0x12, 0x05, 0x01, 0x02, 0x03})
+	if err != nil || e != (Energy{0x1234, 5, 0x030201}) {
+		t.Errorf("ParseEnergy: %+v, %v", e, err)
+	}
+	for _, b := range [][]byte{{1, 2, 3}, {0, 0x80, 0, 0, 0, 0}} {
+		if _, err = ParseEnergy(b); err == nil {
+			t.Errorf("ParseEnergy(% x) succeeded", b)
+		}
+	}
+
+	watts := Direct{M: 1}
+	for _, x := range []struct {
+		name      string
+		prev, cur Energy
+		want      float64
+		ok        bool
+	}{
+		{"simple", Energy{1000, 0, 10}, Energy{4000, 0, 20}, 300, true},
+		{"rollover", Energy{32000, 3, 100}, Energy{1232, 4, 104},
+			500, true},
+		{"rollover count wraps", Energy{32568, 255, 0xfffffe},
+			Energy{400, 0, 2}, 150, true},
+		{"no samples", Energy{1000, 0, 10}, Energy{1000, 0, 10}, 0,
+			false},
+	} {
+		got, ok := x.cur.Power(x.prev, watts)
+		if ok != x.ok || math.Abs(got-x.want) > 1e-9 {
+			t.Errorf("%s: got %v, %t, want %v", x.name, got, ok,
+				x.want)
+		}
+	}
+	if got, _ := (Energy{1000, 0, 20}).Power(Energy{0, 0, 10},
+		Direct{M: 2, R: -1}); got != 500 {
+		t.Errorf("direct: got %v", got)
+	}
+}
+
+func TestDev(t *testing.T) {
+	sim := i2csim.StartTest(t)
+
+	d := Dev{Bus: 12, Addr: 0x58}
+	if v, err := d.Vout(); err != nil || v != 12 {
+		t.Errorf("Vout: %v, %v", v, err)
+	}
+	if v, err := d.Linear11(ReadVin); err != nil || v != 230 {
+		t.Errorf("READ_VIN: %v, %v", v, err)
+	}
+	if s, err := d.String(MfrId); err != nil || s != "Great Wall" {
+		t.Errorf("MFR_ID: %q, %v", s, err)
+	}
+	if err := d.Send(ClearFaults); err != nil ||
+		sim.PSU[0].Sent(ClearFaults) != 1 {
+		t.Errorf("CLEAR_FAULTS: %v", err)
+	}
+	sim.PSU[0].Support(VoutCommand)
+	if err := d.SetWord(VoutCommand, 0x1800); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Word(VoutCommand); err != nil || v != 0x1800 {
+		t.Errorf("VOUT_COMMAND: %#x, %v", v, err)
+	}
+	sim.PSU[0].SetBlock(ReadEin, []byte{0x10, 0x27, 1, 100, 0, 0})
+	if e, err := d.Energy(ReadEin); err != nil ||
+		e != (Energy{10000, 1, 100}) {
+		t.Errorf("READ_EIN: %+v, %v", e, err)
+	}
+	if _, err := d.Byte(StatusCml); err == nil {
+		t.Error("unsupported STATUS_CML read")
+	}
+
+	ucd := Dev{Bus: 4, Addr: 0x34}
+	sim.UCD9090.SetVout(0, 0x13, 0xa000) // 5V
+	sim.UCD9090.SetVout(9, 0x13, 0x2000) // 1V
+	for page, want := range map[uint8]float64{0: 5, 9: 1} {
+		if v, err := ucd.PageVout(page); err != nil || v != want {
+			t.Errorf("PAGE %d Vout: %v, %v", page, v, err)
+		}
+	}
+	if _, err := ucd.PageVout(3); err == nil {
+		t.Error("Vout of page without a rail")
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package main
+
+import (
+	"github.com/platinasystems/goes-bmc/cmd/redfishd"
+	"github.com/platinasystems/goes-bmc/platform"
+)
+
+func redfishdInit() {
+	redfishd.Thresholds = platform.Current().Alarms
+}
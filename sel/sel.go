@@ -0,0 +1,223 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package sel is the system event log, a bounded log of the events that the
+// daemons detect, e.g. PSU removal or a power fault, that is kept on /perm
+// so that it survives a reboot.
+//
+// Daemons record an event with Print, which also logs it. The log is a file
+// of JSON entries, one per line, shared by all goes processes; writers take
+// an flock on File+".lock".
+package sel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/platinasystems/log"
+)
+
+const (
+	File = "/perm/var/sel/sel.json"
+	Max  = 1024
+)
+
+// Severity uses the names of the log priorities of the same messages.
+type Severity string
+
+const (
+	Info     Severity = "info"
+	Notice   Severity = "notice"
+	Warning  Severity = "warning"
+	Critical Severity = "critical"
+)
+
+type Entry struct {
+	Id       uint64    `json:"id"`
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Severity Severity  `json:"severity"`
+	Key      string    `json:"key,omitempty"` // redis key, e.g. psu1.status
+	Message  string    `json:"message"`
+}
+
+func (e *Entry) String() string {
+	return fmt.Sprint(e.Id, " ", e.Time.Format(time.RFC3339), " ",
+		e.Source, " ", e.Severity, ": ", e.Message)
+}
+
+// Log is a SEL of at most Max entries, or unbounded if Max is 0; Add drops
+// the oldest entries beyond that.
+type Log struct {
+	File string
+	Max  int
+}
+
+var Default = &Log{File: File, Max: Max}
+
+// Print logs the message made of a and adds it to the Default SEL.
+func Print(source string, severity Severity, key string, a ...interface{}) {
+	msg := fmt.Sprint(a...)
+	log.Print(severity, ": ", msg)
+	err := Default.Add(Entry{
+		Source:   source,
+		Severity: severity,
+		Key:      key,
+		Message:  msg,
+	})
+	if err != nil {
+		log.Print("warning: sel: ", err)
+	}
+}
+
+// Add appends e to the log, setting its Id and, if zero, its Time.
+func (l *Log) Add(e Entry) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, clean, err := l.read()
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		e.Id = entries[len(entries)-1].Id + 1
+	} else {
+		e.Id = 1
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if clean && (l.Max <= 0 || len(entries) < l.Max) {
+		return l.append(&e)
+	}
+	if l.Max > 0 && len(entries) >= l.Max {
+		entries = entries[len(entries)-l.Max+1:]
+	}
+	entries = append(entries, e)
+	return l.write(entries)
+}
+
+// Entries returns the entries of the log, oldest first.
+func (l *Log) Entries() ([]Entry, error) {
+	unlock, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, _, err := l.read()
+	return entries, err
+}
+
+// Clear removes all entries. The ids of new entries start over at 1.
+func (l *Log) Clear() error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return l.write(nil)
+}
+
+func (l *Log) lock() (unlock func(), err error) {
+	if err = os.MkdirAll(filepath.Dir(l.File), 0755); err != nil {
+		return
+	}
+	f, err := os.OpenFile(l.File+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return
+	}
+	unlock = func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}
+	return
+}
+
+// read skips lines that don't decode, e.g. one cut short by a power loss,
+// in which case the log isn't clean and must be rewritten rather than
+// appended to.
+func (l *Log) read() (entries []Entry, clean bool, err error) {
+	b, err := ioutil.ReadFile(l.File)
+	if os.IsNotExist(err) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	clean = len(b) == 0 || b[len(b)-1] == '\n'
+	scan := bufio.NewScanner(bytes.NewReader(b))
+	for scan.Scan() {
+		var e Entry
+		if json.Unmarshal(scan.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		} else {
+			clean = false
+		}
+	}
+	return entries, clean, scan.Err()
+}
+
+func (l *Log) append(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// write replaces the log with entries by way of a temporary file so that
+// a power loss leaves either the old or the new log.
+func (l *Log) write(entries []Entry) error {
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	tmp := l.File + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, l.File)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+package sel
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l := &Log{File: filepath.Join(dir, "var", "sel.json"), Max: 3}
+	if entries, err := l.Entries(); err != nil || len(entries) != 0 {
+		t.Fatal("new log: ", entries, err)
+	}
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		err := l.Add(Entry{
+			Time:     t0.Add(time.Duration(i) * time.Second),
+			Source:   "fspd",
+			Severity: Warning,
+			Key:      "psu1.status",
+			Message:  fmt.Sprint("event ", i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a torn write is skipped
+	f, err := os.OpenFile(l.File, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id": 6, "tim`)
+	f.Close()
+	err = l.Add(Entry{Source: "fspd", Severity: Notice, Message: "event 6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("%d entries, want 3", len(entries))
+	}
+	for i, e := range entries[:2] {
+		n := i + 4
+		if e.Id != uint64(n) || e.Message != fmt.Sprint("event ", n) ||
+			!e.Time.Equal(t0.Add(time.Duration(n)*time.Second)) {
+			t.Errorf("entry %d: %+v", i, e)
+		}
+	}
+	if e := entries[2]; e.Id != 6 || e.Message != "event 6" {
+		t.Errorf("entry after torn write: %+v", e)
+	}
+	if err = l.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Add(Entry{Source: "ucd9090d", Severity: Critical}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = l.Entries()
+	if err != nil || len(entries) != 1 || entries[0].Id != 1 ||
+		entries[0].Time.IsZero() {
+		t.Error("after clear: ", entries, err)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/platinasystems/goes-bmc/cmd/chassisd"
+	"github.com/platinasystems/goes-bmc/gpio"
+	"github.com/platinasystems/goes-bmc/platform"
 	"github.com/platinasystems/goes/external/redis"
-	"github.com/platinasystems/gpio"
 	"github.com/platinasystems/log"
 )
 
@@ -19,8 +21,6 @@ const (
 )
 
 func startConfGpioHook() error {
-	var deviceVer byte
-
 	pin, found := gpio.FindPin("QSPI_MUX_SEL")
 	if found {
 		r, _ := pin.Value()
@@ -58,9 +58,14 @@ func startConfGpioHook() error {
 	redis.Hwait(redis.DefaultHash, "redis.ready", "true",
 		10*time.Second)
 
-	ss, _ := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-	_, _ = fmt.Sscan(ss, &deviceVer)
-	if deviceVer == 0x0 || deviceVer == 0xff {
+	go func() {
+		// ucd9090d tells of an AC loss on its first poll
+		if err := chassisd.Restore(time.Minute); err != nil {
+			log.Print("warning: power restore: ", err)
+		}
+	}()
+
+	if platform.IdentFromRedis().Proto() {
 		pin, found = gpio.FindPin("FP_BTN_UARTSEL_EN_L")
 		if found {
 			pin.SetValue(false)
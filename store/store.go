@@ -0,0 +1,131 @@
+// Copyright © 2015-2020 Platina Systems, Inc. All rights reserved.
+// Use of this source code is governed by the GPL-2 license described in the
+// LICENSE file.
+
+// Package store is the view of the redis hash of the daemons that the
+// network services, e.g. redfishd and ipmid, use so that they may be tested
+// with a Map instead of redisd.
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/platinasystems/goes/external/redis"
+)
+
+type Store interface {
+	// Hgetall returns all fields of the hash.
+	Hgetall() (map[string]string, error)
+	// Hset sets field through the daemon that owns it.
+	Hset(field, value string) error
+}
+
+// Redis is the Store of redis.DefaultHash.
+type Redis struct{}
+
+func (Redis) Hgetall() (map[string]string, error) {
+	conn, err := redis.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	v, err := conn.Do("HGETALL", redis.DefaultHash)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("HGETALL: unexpected %T", v)
+	}
+	keys := make(map[string]string, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		k, _ := list[i].([]byte)
+		v, _ := list[i+1].([]byte)
+		keys[string(k)] = string(v)
+	}
+	return keys, nil
+}
+
+func (Redis) Hset(field, value string) error {
+	_, err := redis.Hset(redis.DefaultHash, field, value)
+	return err
+}
+
+// Map is an in-memory Store.
+type Map struct {
+	mutex sync.Mutex
+	keys  map[string]string
+}
+
+func NewMap(keys map[string]string) *Map {
+	m := &Map{keys: make(map[string]string)}
+	for k, v := range keys {
+		m.keys[k] = v
+	}
+	return m
+}
+
+func (m *Map) Hgetall() (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make(map[string]string, len(m.keys))
+	for k, v := range m.keys {
+		keys[k] = v
+	}
+	return keys, nil
+}
+
+func (m *Map) Hset(field, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.keys[field] = value
+	return nil
+}
+
+// Hget returns the value of field, "" if it isn't set.
+func (m *Map) Hget(field string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.keys[field]
+}
+
+func (m *Map) Hdel(field string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.keys, field)
+}
+
+// Indexes returns the sorted N of the keys prefix+N+suffix, e.g. the PSU
+// slots of "psu", ".status".
+func Indexes(keys map[string]string, prefix, suffix string) []int {
+	var ns []int
+	for k := range keys {
+		if strings.HasPrefix(k, prefix) && strings.HasSuffix(k, suffix) {
+			s := strings.TrimSuffix(strings.TrimPrefix(k, prefix),
+				suffix)
+			if n, err := strconv.Atoi(s); err == nil {
+				ns = append(ns, n)
+			}
+		}
+	}
+	sort.Ints(ns)
+	return ns
+}
+
+// PoweredOn reports whether the chassis is in S0 or, without a chassis
+// power state, whether any PSU is powered on.
+func PoweredOn(keys map[string]string) bool {
+	if s, found := keys["chassis.power.state"]; found {
+		return s == "S0"
+	}
+	for _, n := range Indexes(keys, "psu", ".status") {
+		if keys[fmt.Sprint("psu", n, ".status")] == "powered_on" {
+			return true
+		}
+	}
+	return false
+}
@@ -16,7 +16,7 @@ import (
 	"syscall"
 	"unsafe"
 
-	"github.com/platinasystems/gpio"
+	"github.com/platinasystems/goes-bmc/gpio"
 	"github.com/platinasystems/mtd"
 	"github.com/platinasystems/ubi"
 )
@@ -5,43 +5,16 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/platinasystems/goes-bmc/cmd/ucd9090d"
-	"github.com/platinasystems/goes/external/redis"
+	"github.com/platinasystems/goes-bmc/platform"
 )
 
 func ucd9090dInit() {
-	ver := 0
-	ucd9090d.Vdev.Bus = 4
-	ucd9090d.Vdev.Addr = 0x0 //update after eeprom read
-	s, err := redis.Hget(redis.DefaultHash, "eeprom.DeviceVersion")
-	if err != nil {
-		ucd9090d.Vdev.Addr = 0x34
-	} else {
-		_, _ = fmt.Sscan(s, &ver)
-		switch ver {
-		case 0xff:
-			ucd9090d.Vdev.Addr = 0x7e
-		case 0x00:
-			ucd9090d.Vdev.Addr = 0x7e
-		default:
-			ucd9090d.Vdev.Addr = 0x34
-		}
-	}
-	ucd9090d.VpageByKey = map[string]uint8{
-		"vmon.5v.sb.units.V":    1,
-		"vmon.3v8.bmc.units.V":  2,
-		"vmon.3v3.sys.units.V":  3,
-		"vmon.3v3.bmc.units.V":  4,
-		"vmon.3v3.sb.units.V":   5,
-		"vmon.1v0.thc.units.V":  6,
-		"vmon.1v8.sys.units.V":  7,
-		"vmon.1v25.sys.units.V": 8,
-		"vmon.1v2.ethx.units.V": 9,
-		"vmon.1v0.tha.units.V":  10,
-		"vmon.poweroff.events":  0,
-	}
+	p := platform.Current()
+	ucd9090d.Vdev.Bus = p.Ucd9090d.Bus
+	ucd9090d.Vdev.Addr = int(p.Ucd9090d.Addr)
+	ucd9090d.VpageByKey = p.Ucd9090d.VpageByKey
+	ucd9090d.Thresholds = p.Alarms
 
 	ucd9090d.WrRegDv["vmon"] = "vmon"
 	ucd9090d.WrRegFn["vmon.example"] = "example"